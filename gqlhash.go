@@ -176,3 +176,66 @@ func AppendHash[S string | []byte](
 	}
 	return h.Sum(buffer), Result{}
 }
+
+// AppendHashes reads the document s once and appends its hash under every one
+// of hashes to buffer, in the order given, applying options and resetting each.
+// The sums are back to back: the one of hashes[i] starts where those of the
+// ones before it end, [Hash.Size] bytes each.
+// A rejected document leaves buffer as it was, as the AppendX convention promises.
+//
+// It's for computing several digests of a document at once, as a migration from
+// one function to another does: the parse, which is what hashing costs, runs once
+// and its canonical form is written to every hash, see [MultiHash].
+func AppendHashes[S string | []byte](
+	buffer []byte, hashes []Hash, options Options, s S,
+) ([]byte, Result) {
+	m := MultiHash(hashes)
+	m.Reset()
+	if err := parser.Parse(m, options, s); err.Err != nil {
+		return buffer, err
+	}
+	return m.Sum(buffer), Result{}
+}
+
+// MultiHash is a [Hash] writing to every one of its hashes, a fan-out writer.
+// Its sum is theirs back to back, in order, and its size theirs together, so
+// it takes the place of one hash wherever this package takes a [Hash]:
+// a [Hasher] over a MultiHash computes several digests per parse, allocation-free.
+type MultiHash []Hash
+
+var _ Hash = MultiHash(nil)
+
+// Reset resets every hash.
+func (m MultiHash) Reset() {
+	for _, h := range m {
+		h.Reset()
+	}
+}
+
+// Size is the size of every sum together.
+func (m MultiHash) Size() int {
+	n := 0
+	for _, h := range m {
+		n += h.Size()
+	}
+	return n
+}
+
+// Sum appends every sum to b, in order.
+func (m MultiHash) Sum(b []byte) []byte {
+	for _, h := range m {
+		b = h.Sum(b)
+	}
+	return b
+}
+
+// Write writes p to every hash. A [hash.Hash] never fails a write, and one that
+// does anyway stops it there, as [io.MultiWriter] does.
+func (m MultiHash) Write(p []byte) (int, error) {
+	for _, h := range m {
+		if n, err := h.Write(p); err != nil {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
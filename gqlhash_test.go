@@ -961,3 +961,38 @@ func TestAppendKeepsTheBuffer(t *testing.T) {
 		t.Errorf("expected the hash appended to what was there; received %q", got)
 	}
 }
+
+// TestAppendHashes covers hashing into several functions in one parse: every
+// sum is the one AppendHash makes with that function alone, back to back in the
+// order given, and a rejected document leaves the buffer as it was.
+func TestAppendHashes(t *testing.T) {
+	const doc = `query Q { f(x: 1) { g } }`
+	hashes := []gqlhash.Hash{sha1.New(), sha256.New(), md5.New()}
+
+	got, err := gqlhash.AppendHashes([]byte("kept"), hashes, gqlhash.Options{}, doc)
+	if err.IsErr() {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := []byte("kept")
+	for _, h := range []gqlhash.Hash{sha1.New(), sha256.New(), md5.New()} {
+		expect, _ = gqlhash.AppendHash(expect, h, gqlhash.Options{}, doc)
+	}
+	if !bytes.Equal(got, expect) {
+		t.Errorf("expected %x; received %x", expect, got)
+	}
+	if n := gqlhash.MultiHash(hashes).Size(); n != 20+32+16 {
+		t.Errorf("expected the sizes summed; received %d", n)
+	}
+
+	got, err = gqlhash.AppendHashes([]byte("kept"), hashes, gqlhash.Options{}, "{")
+	if !err.IsErr() || string(got) != "kept" {
+		t.Errorf("expected the document rejected and the buffer kept; received %q, %v",
+			got, err)
+	}
+
+	// A Hasher takes a MultiHash as it takes any other.
+	hasher := gqlhash.NewHasher[string](gqlhash.MultiHash(hashes), gqlhash.Options{})
+	if got, _ := hasher.Append(nil, doc); !bytes.Equal(got, expect[len("kept"):]) {
+		t.Errorf("Hasher: expected %x; received %x", expect[len("kept"):], got)
+	}
+}
@@ -441,10 +441,14 @@ func TestBatchElementWithoutDocument(t *testing.T) {
 // TestNamedOperations covers documents holding more than one named operation,
 // where the request picks which to run with operationName.
 //
-// What's allowed is the document, so the pick is the API's to resolve. That
-// cuts both ways, and both ways are what this covers: every operation of an
-// allowed file is allowed, and an allowed operation carries nothing else in
-// with it, since the document it arrived in is what was hashed.
+// What's allowed is the document, and the pick is one of the operations it
+// holds. That cuts both ways, and both ways are what this covers: every
+// operation of an allowed file is allowed, and an allowed operation carries
+// nothing else in with it, since the document it arrived in is what was hashed.
+// A document of several run without a name is refused: the API would refuse it
+// too, and which operation it would run is nothing the allowlist can check.
+// Each operation of an allowed file is allowed alone too, as a client sends it
+// and as gen-go generates it, with the fragments it spreads and nothing else.
 func TestNamedOperations(t *testing.T) {
 	const (
 		getUser  = "query GetUser {\n  user { name }\n}"
@@ -463,7 +467,7 @@ func TestNamedOperations(t *testing.T) {
 			e := shared(t, tgt)
 			e.allow(t, both)
 
-			for _, name := range []string{"GetUser", "GetPosts", ""} {
+			for _, name := range []string{"GetUser", "GetPosts"} {
 				code, answer := post(t, e.server, body(name, both))
 				if code != http.StatusOK {
 					t.Errorf("operationName %q: expected 200; received %d: %s",
@@ -471,8 +475,7 @@ func TestNamedOperations(t *testing.T) {
 					continue
 				}
 				// The name is forwarded untouched, since resolving it is the
-				// API's job. An empty one against a document of two operations
-				// is an error there, which the proxy has no opinion about.
+				// API's job.
 				got := e.api.last(t)
 				if !strings.Contains(got.body, `"operationName":`+strconv.Quote(name)) {
 					t.Errorf("operationName %q: expected it forwarded; received %s",
@@ -480,11 +483,17 @@ func TestNamedOperations(t *testing.T) {
 				}
 			}
 
-			// Only the operation the client wants, lifted out of the allowed file.
-			if code, _ := post(t, e.server, body("GetUser", getUser)); code !=
-				http.StatusForbidden {
-				t.Errorf("expected one operation on its own to be refused; received %d",
-					code)
+			// No name at all, which leaves the API to pick.
+			if code, _ := post(t, e.server, body("", both)); code != http.StatusForbidden {
+				t.Errorf("expected no operationName refused; received %d", code)
+			}
+
+			// Only the operation the client wants, lifted out of the allowed
+			// file, which is an entry of its own.
+			if code, answer := post(t, e.server, body("GetUser", getUser)); code !=
+				http.StatusOK {
+				t.Errorf("expected one operation on its own allowed; received %d: %s",
+					code, answer)
 			}
 			// The same two the other way round is another document too.
 			if code, _ := post(
@@ -4,24 +4,57 @@
 //
 // A .graphqls file in that directory is read as a schema,
 // and every document is then checked against it.
+//
+// The entries of the persisted-query manifests in the directory are documents
+// too, and with [Config.Sources] it also reads the documents embedded in
+// TypeScript, JavaScript and Go files, see [embedded.Extract].
+//
+// A snapshot written by [Allowlist.WriteSnapshot] is read in place of a
+// directory, without parsing a document: the hashes it holds were computed
+// when it was written.
+//
+// So is a bundle, a tar or a zip archive of a directory, signed with a key of
+// [Config.BundleKeys], see [IsBundle]. With such keys, nothing else is read.
+//
+// With [Config.GitRef], a directory is read from the Git repository it's in,
+// as a commit holds it rather than as it's checked out.
+//
+// What is fetched rather than read from disk, over HTTP say, is read from
+// memory as the file it's named like would be, see [Fetched].
+//
+// With [Config.Schemes], every document is hashed under other hash functions
+// and options too, and found by the hash of any of them, which is how one is
+// moved to another without a flag day.
 package allowlist
 
 import (
+	"bytes"
+	"cmp"
+	"crypto/ed25519"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io/fs"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	gqlparser "github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
-	"github.com/vektah/gqlparser/v2/validator/rules"
 
 	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/embedded"
+	"github.com/romshark/gqlhash/v2/internal/operations"
+	"github.com/romshark/gqlhash/v2/internal/schema"
 	"github.com/romshark/gqlhash/v2/parser"
 )
 
@@ -31,6 +64,7 @@ import (
 type Allowlist struct {
 	newHash func() hash.Hash
 	options gqlhash.Options
+	config  Config
 
 	// current is nil until the first Reload: no allowlist rather than an empty one.
 	current atomic.Pointer[list]
@@ -42,28 +76,234 @@ type Allowlist struct {
 // list is one published set of hashes and when it was published.
 // Immutable: a reload swaps a whole one.
 type list struct {
-	docs     map[string]struct{}
+	docs     *digests
 	loadedAt time.Time
+
+	// ref and commit are the revision docs were read at, see [Config.GitRef].
+	ref, commit string
+
+	// schemes are the documents by their hash under each of [Config.Schemes],
+	// in its order.
+	schemes []*digests
+
+	// pairs are the owners and clients docs name, whose labels it holds, see
+	// [holdLabels].
+	pairs [][2]string
 }
 
+// Config is what an allowlist reads besides the .graphql, .gql and .graphqls files
+// of its directory. The zero value reads nothing besides them.
+type Config struct {
+	// Sources also reads the documents embedded in TypeScript, JavaScript and Go
+	// files, which is how an allowlist is built from a client codebase as it is.
+	// Each one is an entry of its own, named by its file, line and column,
+	// see [embedded.Extract].
+	Sources bool
+
+	// Manifests also reads the persisted-query manifests a client build
+	// writes, the .json and .jsonl files [embedded.IsManifest] takes. Each
+	// document of one is an entry of its own, as one a source file embeds is.
+	// An entry carrying a hash and no document is its hash alone, an
+	// [Entry.HashOnly]: the hash the allowlist computes, in any -format, and
+	// not one a client computed over the text as it is, which no request
+	// hashes to. Off, those files are left out as any other is, so a directory may hold
+	// a package.json beside its documents.
+	Manifests bool
+
+	// Function names the hash function newHash returns, as -hash does.
+	// A snapshot records it, and one recording another is refused,
+	// see [Allowlist.WriteSnapshot].
+	Function string
+
+	// SunsetWindow is how long before it expires a document is in its sunset:
+	// still allowed, but reported as [StatusSunset] and in [Result.Expiring],
+	// so the clients still sending it are found before they break.
+	// 0 leaves no window, see [Entry.Expires].
+	SunsetWindow time.Duration
+
+	// Now is the clock expiry is read against, nil for [time.Now].
+	Now func() time.Time
+
+	// Workers is how many files a reload reads, hashes and checks at once,
+	// 0 for GOMAXPROCS. What the files hold is published as one and in the
+	// same order whatever it is.
+	Workers int
+
+	// Policy is what a reload has to meet to be published. The zero value
+	// publishes whatever was read.
+	Policy Policy
+
+	// BundleKeys are the keys a bundle is signed with, any one of them, see
+	// [IsBundle]. Where there are any, nothing but a bundle signed with one is
+	// read: a directory or a snapshot is refused with [ErrNotBundle]. Where
+	// there are none, a bundle is refused, since it can't be verified.
+	BundleKeys []ed25519.PublicKey
+
+	// GitRef reads a directory from the Git repository it's in, the commit
+	// this names, a tag or a branch or a commit itself: the files checked out
+	// are no part of it. [Allowlist.ReloadAt] reads another ref, which every
+	// reload after reads, so an allowlist serves what one commit holds until
+	// it's moved to another. Empty reads the files as they are.
+	GitRef string
+
+	// Schemes are the hash functions and the options every document is hashed
+	// under besides the allowlist's own, each found by [Allowlist.FindScheme].
+	// A snapshot holds the hashes of one alone, so it's refused where there
+	// are any.
+	Schemes []Scheme
+
+	// Published is called after every reload that publishes, nil for none: what
+	// the caller keeps of the documents before is stale then.
+	Published func()
+}
+
+// Policy is what a reload has to meet to be published, see [Config.Policy].
+// A reload it refuses fails with [ErrRefused], and the allowlist holds what
+// it held, even where that's nothing.
+type Policy struct {
+	// Strict refuses a reload that skips a file or can't read the schema:
+	// every document is served as it's meant to be, or nothing changes.
+	Strict bool
+
+	// MaxRemoved refuses a reload removing more than this many of the
+	// documents the allowlist holds, and MaxRemovedPercent one removing more
+	// than this share of them, in percent. 0 leaves either unbounded. A
+	// directory emptied by a botched deployment removes every document, and
+	// none of them is skipped.
+	MaxRemoved        int
+	MaxRemovedPercent float64
+}
+
+// ErrRefused is a reload the [Policy] refused.
+var ErrRefused = errors.New("refused by the reload policy")
+
+// errManifest is a manifest named as the allowlist where [Config.Manifests]
+// is off.
+var errManifest = errors.New("a persisted-query manifest, and manifests aren't read")
+
+// check returns an [ErrRefused] naming what of r p refuses, nil where it
+// refuses nothing. held is how many documents the allowlist holds.
+func (p Policy) check(held int, r Result) error {
+	var reasons []string
+	if p.Strict && len(r.Skipped) > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d skipped", len(r.Skipped)))
+	}
+	if p.Strict && r.SchemaErr != nil {
+		reasons = append(reasons, "the schema can't be read")
+	}
+	removed := len(r.Removed)
+	if p.MaxRemoved > 0 && removed > p.MaxRemoved {
+		reasons = append(reasons, fmt.Sprintf(
+			"%d documents removed, more than %d", removed, p.MaxRemoved))
+	}
+	if p.MaxRemovedPercent > 0 && float64(removed)*100 > p.MaxRemovedPercent*float64(held) {
+		reasons = append(reasons, fmt.Sprintf(
+			"%d of %d documents removed, more than %g%%", removed, held, p.MaxRemovedPercent))
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrRefused, strings.Join(reasons, ", "))
+}
+
+// Status is what the allowlist makes of a document, see [Allowlist.Find].
+type Status uint8
+
+const (
+	// StatusUnknown is a document that isn't on the allowlist.
+	StatusUnknown Status = iota
+
+	// StatusAllowed is a document on the allowlist.
+	StatusAllowed
+
+	// StatusSunset is a document on the allowlist that expires within
+	// [Config.SunsetWindow]. It's allowed.
+	StatusSunset
+
+	// StatusExpired is a document on the allowlist past its expiry.
+	// It isn't allowed.
+	StatusExpired
+)
+
+// Allows reports whether a request may carry a document of status s.
+func (s Status) Allows() bool { return s == StatusAllowed || s == StatusSunset }
+
 // New returns an empty allowlist. It allows nothing until the first
 // [Allowlist.Reload], which is what reads a directory.
 func New(newHash func() hash.Hash, options gqlhash.Options) *Allowlist {
-	return &Allowlist{newHash: newHash, options: options}
+	return NewWithConfig(newHash, options, Config{})
+}
+
+// NewWithConfig is [New] reading what config adds.
+func NewWithConfig(
+	newHash func() hash.Hash, options gqlhash.Options, config Config,
+) *Allowlist {
+	return &Allowlist{newHash: newHash, options: options, config: config}
 }
 
 // Allowed reports whether a request may carry the document with key,
 // the hash of its canonical form, so formatting makes no difference.
 // The lookup allocates nothing, so key may be a subslice of a request body.
 //
-// Nothing is allowed before the first [Allowlist.Reload].
+// Nothing is allowed before the first [Allowlist.Reload], and a document
+// isn't once it has expired, see [Entry.Expires].
 func (a *Allowlist) Allowed(key []byte) bool {
+	_, ok := a.Lookup(key)
+	return ok
+}
+
+// Lookup is [Allowlist.Allowed] returning the entry of the document too,
+// which allocates nothing either.
+func (a *Allowlist) Lookup(key []byte) (Entry, bool) {
+	e, status := a.Find(key)
+	if !status.Allows() {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Find is what the allowlist makes of the document with key, and its entry
+// where it's on the allowlist, expired or not. Allocates nothing, and reads the
+// clock only for a document that expires.
+func (a *Allowlist) Find(key []byte) (Entry, Status) {
 	l := a.current.Load()
 	if l == nil {
-		return false
+		return Entry{}, StatusUnknown
 	}
-	_, ok := l.docs[string(key)]
-	return ok
+	i, ok := l.docs.find(key)
+	if !ok {
+		return Entry{}, StatusUnknown
+	}
+	return a.at(l, i)
+}
+
+// at is [Allowlist.Find] of the i-th document of l.
+func (a *Allowlist) at(l *list, i int) (Entry, Status) {
+	e := l.docs.entries.entry(i)
+	if e.Expires.IsZero() {
+		return e, StatusAllowed
+	}
+	return e, a.status(&e, a.now())
+}
+
+// status is the status of e, which is on the allowlist, at now.
+func (a *Allowlist) status(e *Entry, now time.Time) Status {
+	switch {
+	case e.Expires.IsZero():
+		return StatusAllowed
+	case !now.Before(e.Expires):
+		return StatusExpired
+	case now.Add(a.config.SunsetWindow).After(e.Expires):
+		return StatusSunset
+	}
+	return StatusAllowed
+}
+
+func (a *Allowlist) now() time.Time {
+	if a.config.Now != nil {
+		return a.config.Now()
+	}
+	return time.Now()
 }
 
 func (a *Allowlist) Len() int {
@@ -71,7 +311,7 @@ func (a *Allowlist) Len() int {
 	if l == nil {
 		return 0
 	}
-	return len(l.docs)
+	return l.docs.len()
 }
 
 // Stats returns what the allowlist holds and when it was loaded, both from the
@@ -84,19 +324,23 @@ func (a *Allowlist) Stats() (documents int, loadedAt time.Time) {
 	if l == nil {
 		return 0, time.Time{}
 	}
-	return len(l.docs), l.loadedAt
+	return l.docs.len(), l.loadedAt
 }
 
-// Result is what an [Allowlist.Reload] published. Reporting it is the caller's:
+// Result is what an [Allowlist.Reload] published, or an [Allowlist.Check]
+// would have, and what that changed. Reporting it is the caller's:
 // which of it deserves an event, and at which level.
 type Result struct {
 	// Files is the file of every document on the allowlist, in the order they
-	// were read. Empty rather than nil where a load took nothing.
+	// were read. The operations of a document of several, entries of their
+	// own, are no files of it. Empty rather than nil where a load took nothing.
 	Files []string
 
 	// Skipped is one error per file left out: a document that can't be read,
 	// doesn't parse, isn't taken by the schema, or shares a hash with another.
 	// Each one names the file, and a syntax error names the line and the column.
+	// A document sharing a hash with another under one of [Config.Schemes] is
+	// left out of that scheme alone, and is one of them too.
 	Skipped []error
 
 	// SchemaErr is set where the .graphqls files hold no readable schema,
@@ -104,12 +348,36 @@ type Result struct {
 	// No file was left out, so it isn't one of Skipped.
 	SchemaErr error
 
-	// Added and Removed count the documents against the list this one replaced.
-	Added, Removed int
+	// Added are the entries of the hashes the list this one replaced didn't
+	// hold, every one where there was none, and Removed those of the hashes it
+	// held that this one doesn't. Each is sorted by name.
+	Added, Removed []*Entry
+
+	// Renamed are the documents both lists hold under another name: the same
+	// hash at a new path, or of an operation now listed on its own. Sorted by
+	// the new name.
+	Renamed []Rename
+
+	// Expiring are the entries in their sunset at the load, see
+	// [Config.SunsetWindow], and Expired those past their expiry, which are
+	// loaded and refused. Each is sorted by expiry.
+	Expiring, Expired []*Entry
+
+	// Ref and Commit are the revision read, see [Config.GitRef]: the ref as
+	// given, and the commit it named then. Empty where nothing is read from
+	// Git.
+	Ref, Commit string
+}
+
+// Rename is a document both lists of a [Result] hold, by its entry in each.
+type Rename struct {
+	From, To *Entry
 }
 
 // Reload reads dir and publishes what it holds, replacing what the allowlist
 // held before. Nothing remembers the last dir; concurrent callers queue.
+// A document in place of dir is read as a directory holding it alone would
+// be, and any other file as a snapshot, see [Allowlist.WriteSnapshot].
 //
 // A document is skipped where it can't be read, doesn't parse, or the schema
 // doesn't take it, so one broken file doesn't keep the rest from being served.
@@ -119,86 +387,516 @@ type Result struct {
 // A directory holding no usable document publishes an empty allowlist, which
 // rejects every request. A schema that can't be read leaves the documents
 // unchecked rather than unserved, reported as [Result.SchemaErr].
+//
+// [Config.Policy] refuses a reload short of it instead, with an error wrapping
+// [ErrRefused] and the Result that would have been published.
 func (a *Allowlist) Reload(dir string) (Result, error) {
+	return a.load("", true, a.reader(dir))
+}
+
+// Check is [Allowlist.Reload] publishing nothing, a dry run: its [Result] is
+// what a reload of dir would publish and change, and the allowlist holds what
+// it did. It answers whether a reload would succeed before one is made: a
+// Result with nothing Skipped and no SchemaErr publishes every document as it's
+// meant to, and an [ErrRefused] is what the [Policy] would refuse. What the
+// allowlist makes of one document is [Allowlist.Find].
+func (a *Allowlist) Check(dir string) (Result, error) {
+	return a.load("", false, a.reader(dir))
+}
+
+// ReloadAt is [Allowlist.Reload] of dir as the commit ref names holds it,
+// and moves the allowlist to ref: a reload after reads ref too. An empty ref
+// is the one it's at. The documents
+// and the revision they were read at are published as one, so a request is
+// never checked against a mix of two. Where ref can't be read or is refused,
+// nothing moves.
+//
+// It fails for an allowlist that isn't read from Git, see [Config.GitRef],
+// and with [ErrNoRevision] where ref names no commit.
+func (a *Allowlist) ReloadAt(dir, ref string) (Result, error) {
+	return a.load(ref, true, a.reader(dir))
+}
+
+// CheckAt is [Allowlist.Check] of dir at ref: what [Allowlist.ReloadAt]
+// would publish and change. The allowlist stays at the ref it's at.
+func (a *Allowlist) CheckAt(dir, ref string) (Result, error) {
+	return a.load(ref, false, a.reader(dir))
+}
+
+// Revision is the ref the allowlist was last published at and the commit it
+// named then, see [Config.GitRef]. Both are empty where it isn't read from Git,
+// and before the first reload.
+func (a *Allowlist) Revision() (ref, commit string) {
+	l := a.current.Load()
+	if l == nil {
+		return "", ""
+	}
+	return l.ref, l.commit
+}
+
+// load reads what read does, at ref where it's read from Git, and publishes
+// it where publish is set. An empty ref is the one the allowlist is at.
+// A dry run queues with the reloads too, so what it's compared with is what
+// the allowlist holds when it's done.
+func (a *Allowlist) load(
+	ref string, publish bool, read func(ref string) (map[string]*Entry, Result, error),
+) (Result, error) {
 	a.loading.Lock()
 	defer a.loading.Unlock()
 
-	var skipped []error
+	previous := a.current.Load()
+	switch {
+	case a.config.GitRef != "" && ref == "" && previous != nil:
+		ref = previous.ref
+	case a.config.GitRef != "" && ref == "":
+		ref = a.config.GitRef
+	}
+	docs, r, err := read(ref)
+	if err != nil {
+		return Result{}, err
+	}
+	var pairs [][2]string
+	if publish {
+		pairs = holdLabels(docs)
+	}
+	next := newDigests(a.newHash().Size(), docs)
+	schemes, skipped := a.schemeDigests(docs, next)
+	result := a.compare(previous, next)
+	result.Files, result.SchemaErr = r.Files, r.SchemaErr
+	result.Skipped = append(r.Skipped, skipped...)
+	result.Ref, result.Commit = r.Ref, r.Commit
+
+	held := 0
+	if previous != nil {
+		held = previous.docs.len()
+	}
+	if err := a.config.Policy.check(held, result); err != nil {
+		releaseLabels(pairs)
+		return result, err
+	}
+	if publish {
+		a.current.Store(&list{
+			docs: next, loadedAt: time.Now(), ref: result.Ref, commit: result.Commit,
+			schemes: schemes, pairs: pairs,
+		})
+		if previous != nil {
+			releaseLabels(previous.pairs)
+		}
+		if a.config.Published != nil {
+			a.config.Published()
+		}
+	}
+	return result, nil
+}
 
-	files, schemaFiles, err := scanDir(dir)
+// reader is what [Allowlist.load] reads of dir, see [Allowlist.read].
+func (a *Allowlist) reader(dir string) func(ref string) (map[string]*Entry, Result, error) {
+	return func(ref string) (map[string]*Entry, Result, error) { return a.read(dir, ref) }
+}
+
+// read reads what dir holds under the hash of each document, the bundle or
+// the snapshot where it's a file and the commit ref names where it's read from
+// Git, and reports it in Files, Skipped and SchemaErr.
+func (a *Allowlist) read(dir, ref string) (map[string]*Entry, Result, error) {
+	info, err := os.Stat(dir)
+	file := err == nil && info.Mode().IsRegular()
+	if doc, _ := a.classify(filepath.Base(dir)); file && doc {
+		// A document named on its own is read as a directory holding it
+		// alone would be, which scanDir does of a file. Any other file is a
+		// snapshot or a bundle.
+		file = false
+	}
+	switch {
+	case a.config.GitRef == "" && ref != "":
+		return nil, Result{}, fmt.Errorf(
+			"%s: a ref, %s, for an allowlist not read from Git", dir, ref)
+	case ref != "" && len(a.config.BundleKeys) > 0:
+		// A commit is no bundle: what it holds no key has signed.
+		return nil, Result{}, fmt.Errorf("%s: %w", dir, ErrNotBundle)
+	case ref != "":
+		return a.readGit(dir, ref)
+	case file && IsBundle(dir):
+		return a.loadBundle(dir)
+	case len(a.config.BundleKeys) > 0:
+		// The keys are what an allowlist is trusted by. A directory or a
+		// snapshot carries no signature, so it's refused rather than trusted
+		// unchecked.
+		return nil, Result{}, fmt.Errorf("%s: %w", dir, ErrNotBundle)
+	case file && embedded.IsManifest(dir):
+		// Named on its own, it's no snapshot, and saying so would mislead.
+		return nil, Result{}, fmt.Errorf("%s: %w", dir, errManifest)
+	case file:
+		return a.loadSnapshot(dir)
+	}
+
+	files, schemaFiles, err := scanDir(dir, a.classify)
 	if err != nil {
-		return Result{}, fmt.Errorf("scanning directory %s: %w", dir, err)
+		return nil, Result{}, fmt.Errorf("scanning directory %s: %w", dir, err)
 	}
 
 	// A directory holding no schema is checked against none:
 	// the documents are hashed as they are.
-	schema, schemaErr := loadSchema(schemaFiles)
+	typeSystem, schemaErr := schema.Load(schemaFiles...)
 	if schemaErr != nil {
 		schemaErr = fmt.Errorf("%s: %w", strings.Join(schemaFiles, ", "), schemaErr)
 	}
+	docs, result := a.readFiles(files, os.ReadFile, typeSystem)
+	result.SchemaErr = schemaErr
+	return docs, result, nil
+}
 
-	// byHash gathers the files under their document's hash,
+// readFiles reads the documents of files, each read by readFile, and checks
+// them against typeSystem, nil for none. It reports them in Files and Skipped.
+func (a *Allowlist) readFiles(
+	files []string, readFile func(string) ([]byte, error), typeSystem *ast.Schema,
+) (map[string]*Entry, Result) {
+	var skipped []error
+
+	// Every file is read, hashed and checked on its own, by as many workers
+	// as Config.Workers allows, each with a hash and a parser of its own.
+	// Checking against a schema is what a reload spends its time on, and the
+	// schema is only read. What the files hold is gathered in their order
+	// below, so the result is the same whichever worker read what.
+	reads := make([]fileRead, len(files))
+	next := make(chan int, len(files))
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	var wg sync.WaitGroup
+	for range min(a.workers(), len(files)) {
+		wg.Go(func() {
+			r := reader{
+				allowlist: a, typeSystem: typeSystem, readFile: readFile,
+				h: a.newHash(), p: parser.NewParser[[]byte](0),
+			}
+			for _, s := range a.config.Schemes {
+				r.schemes = append(r.schemes, s.NewHash())
+			}
+			for i := range next {
+				reads[i] = r.file(files[i])
+			}
+		})
+	}
+	wg.Wait()
+
+	// byHash gathers the entries under their document's hash,
 	// so a shared one is seen before anything is published.
-	byHash := make(map[string][]string, len(files))
+	byHash := make(map[string][]*Entry, len(files))
 	order := make([]string, 0, len(files))
-	h := a.newHash()
-	p := parser.NewParser[[]byte](0)
 
-	for _, name := range files {
-		src, err := os.ReadFile(name)
-		if err != nil {
-			skipped = append(skipped, fmt.Errorf("%s: %w", name, err))
-			continue
+	// byOperation holds the entry of every operation of a document of several
+	// under the hash of that operation alone, see [operations.Split].
+	byOperation := map[string]*Entry{}
+	var operationOrder []string
+
+	for _, r := range reads {
+		skipped = append(skipped, r.skipped...)
+		for _, d := range r.documents {
+			if _, seen := byHash[d.key]; !seen {
+				order = append(order, d.key)
+			}
+			byHash[d.key] = append(byHash[d.key], d.entry)
+			for _, o := range d.operations {
+				if _, seen := byOperation[o.key]; !seen {
+					byOperation[o.key] = o.entry
+					operationOrder = append(operationOrder, o.key)
+				}
+			}
 		}
+	}
 
-		h.Reset()
-		if e := p.Parse(h, a.options, src); e.IsErr() {
-			skipped = append(skipped, documentError(name, src, e))
+	docs := make(map[string]*Entry, len(byHash))
+	loaded := make([]string, 0, len(byHash))
+	for _, key := range order {
+		entries := byHash[key]
+		if len(entries) > 1 {
+			// Which a request meant is unknowable, so none is served:
+			// allowing the wrong one is worse than allowing neither.
+			for i, e := range entries {
+				var others []string
+				for j, other := range entries {
+					if j != i {
+						others = append(others, other.Name)
+					}
+				}
+				skipped = append(skipped, fmt.Errorf(
+					"%s: the same hash as %s, none of them is served",
+					e.Name, strings.Join(others, ", ")))
+			}
 			continue
 		}
-		if err := validate(schema, name, src); err != nil {
-			skipped = append(skipped, err)
+		docs[key] = entries[0]
+		loaded = append(loaded, entries[0].Name)
+	}
+	// An operation yields to a document hashing alike, which is the same
+	// operation listed on its own, and to documents sharing a hash, which
+	// serve nothing. Operations alike in several documents are one: they run
+	// the same, so the first is served and no one is left wondering which.
+	for _, key := range operationOrder {
+		if _, taken := byHash[key]; taken {
 			continue
 		}
+		docs[key] = byOperation[key]
+	}
 
-		key := string(h.Sum(nil))
+	return docs, Result{Files: loaded, Skipped: skipped}
+}
 
-		if _, seen := byHash[key]; !seen {
-			order = append(order, key)
+// readTree reads files, by their slash-separated path, as a directory holding
+// them would be read: the hidden ones left out, the schema files read as the
+// schema. Every file is named by nameOf.
+func (a *Allowlist) readTree(
+	files map[string][]byte, nameOf func(file string) string,
+) (map[string]*Entry, Result) {
+	named := make(map[string][]byte, len(files))
+	var docs, schemaFiles []string
+	var schemas []*ast.Source
+	for _, file := range slices.Sorted(maps.Keys(files)) {
+		if hidden(file) {
+			continue
+		}
+		n := nameOf(file)
+		switch doc, schemaFile := a.classify(path.Base(file)); {
+		case schemaFile:
+			schemaFiles = append(schemaFiles, n)
+			schemas = append(schemas, &ast.Source{Name: n, Input: string(files[file])})
+		case doc:
+			docs = append(docs, n)
+			named[n] = files[file]
 		}
-		byHash[key] = append(byHash[key], name)
 	}
 
-	docs := make(map[string]struct{}, len(byHash))
-	loaded := make([]string, 0, len(byHash))
-	for _, key := range order {
-		names := byHash[key]
-		if len(names) > 1 {
-			// Which a request meant is unknowable, so none is served:
-			// allowing the wrong one is worse than allowing neither.
-			for i, name := range names {
-				others := append(append([]string{}, names[:i]...), names[i+1:]...)
-				skipped = append(skipped, fmt.Errorf(
-					"%s: the same hash as %s, none of them is served",
-					name, strings.Join(others, ", ")))
+	typeSystem, schemaErr := schema.LoadSources(schemas...)
+	if schemaErr != nil {
+		schemaErr = fmt.Errorf("%s: %w", strings.Join(schemaFiles, ", "), schemaErr)
+	}
+	readFile := func(file string) ([]byte, error) {
+		if src, ok := named[file]; ok {
+			return src, nil
+		}
+		return nil, fs.ErrNotExist
+	}
+	entries, result := a.readFiles(docs, readFile, typeSystem)
+	result.SchemaErr = schemaErr
+	return entries, result
+}
+
+// hidden reports whether file, a slash-separated path under the root of a
+// tree, is in a hidden directory or is a hidden file, which a directory of an
+// allowlist leaves out too.
+func hidden(file string) bool {
+	for part := range strings.SplitSeq(file, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// fileRead is what a file of the allowlist holds: its documents, hashed and
+// checked, and an error for each of what it holds that can't be served, in the
+// order the file holds them.
+type fileRead struct {
+	documents []hashed
+	skipped   []error
+}
+
+// hashed is a document and its hash, and for a document of several
+// operations each of them alone.
+type hashed struct {
+	key        string
+	entry      *Entry
+	operations []hashed
+}
+
+// reader reads the files of a reload, one at a time: its hash and its
+// parser are its own, so a reader is a worker's.
+type reader struct {
+	allowlist  *Allowlist
+	typeSystem *ast.Schema
+	readFile   func(name string) ([]byte, error)
+	h          hash.Hash
+	p          *parser.Parser[[]byte]
+
+	// schemes hash under each of [Config.Schemes], in its order.
+	schemes []hash.Hash
+}
+
+// file reads the file name, a document or one holding several.
+func (r *reader) file(name string) (read fileRead) {
+	src, err := r.readFile(name)
+	if err != nil {
+		read.skipped = append(read.skipped, fmt.Errorf("%s: %w", name, err))
+		return read
+	}
+	take := func(d document) {
+		h, err := r.document(d)
+		if err != nil {
+			read.skipped = append(read.skipped, err)
+			return
+		}
+		read.documents = append(read.documents, h)
+	}
+	if !isDocument(name) {
+		// A manifest, or a source file, which scanDir takes only under
+		// Config.Sources.
+		found, errs := embedded.Extract(name, src)
+		read.skipped = append(read.skipped, errs...)
+		for _, e := range found {
+			if e.Text == "" && e.Hash != "" {
+				h, err := r.hashOnly(name, e)
+				if err != nil {
+					read.skipped = append(read.skipped, err)
+					continue
+				}
+				read.documents = append(read.documents, h)
+				continue
 			}
+			take(document{
+				name: fmt.Sprintf("%s:%d:%d", name, e.Line, e.Column),
+				file: name, src: []byte(e.Text), position: e.Position,
+			})
+		}
+		return read
+	}
+	take(document{name: name, file: name, src: src,
+		position: func(offset int) (int, int) { return gqlhash.Position(src, offset) }})
+	return read
+}
+
+// document hashes and checks d, or says why it can't be served.
+func (r *reader) document(d document) (hashed, error) {
+	r.h.Reset()
+	if e := r.p.Parse(r.h, r.allowlist.options, d.src); e.IsErr() {
+		return hashed{}, d.syntaxError(e)
+	}
+	if err := validate(r.typeSystem, d); err != nil {
+		return hashed{}, err
+	}
+	ops, err := operations.Split(string(d.src))
+	if err != nil {
+		return hashed{}, fmt.Errorf("%s: %w", d.file, err)
+	}
+	e, err := entryOf(d.name, d.src, ops)
+	if err != nil {
+		return hashed{}, d.metadataError(err)
+	}
+	doc := hashed{key: string(r.h.Sum(nil)), entry: e}
+	e.schemeKeys = r.schemeKeys(d.src)
+
+	// A document of several operations is no request a client sends, where
+	// each of its operations alone is: that is what the language server
+	// shows the hash of, and what gen-go generates. Each is an entry of its
+	// own, named by the line and the column it begins at, with the
+	// metadata of its document.
+	if len(ops) < 2 {
+		return doc, nil
+	}
+	for _, o := range ops {
+		r.h.Reset()
+		if r.p.Parse(r.h, r.allowlist.options, []byte(o.Document)).IsErr() {
 			continue
 		}
-		docs[key] = struct{}{}
-		loaded = append(loaded, names[0])
+		line, column := d.position(o.Offset)
+		oe := *e
+		oe.Name = fmt.Sprintf("%s:%d:%d", d.file, line, column)
+		oe.schemeKeys = r.schemeKeys([]byte(o.Document))
+		oe.Operations = nil
+		if o.Name != "" {
+			oe.Operations = []string{o.Name}
+		}
+		doc.operations = append(doc.operations,
+			hashed{key: string(r.h.Sum(nil)), entry: &oe})
 	}
+	return doc, nil
+}
 
-	previous := a.current.Load()
-	a.current.Store(&list{docs: docs, loadedAt: time.Now()})
+// hashOnly is the entry of the manifest file name that carries the hash of e
+// and no document, see [Entry.HashOnly].
+func (r *reader) hashOnly(file string, e embedded.Document) (hashed, error) {
+	name := fmt.Sprintf("%s:%d:%d", file, e.Line, e.Column)
+	key, ok := decodeHash(e.Hash, r.h.Size())
+	if !ok {
+		return hashed{}, fmt.Errorf("%s: %q is no hash of %d bytes, "+
+			"as hex, base32, base64 or base64url", name, e.Hash, r.h.Size())
+	}
+	return hashed{key: string(key), entry: &Entry{Name: name, HashOnly: true}}, nil
+}
+
+// decodeHash decodes s, a hash of size bytes in any of the encodings -format
+// writes, padded or not.
+func decodeHash(s string, size int) ([]byte, bool) {
+	for _, decode := range []func(string) ([]byte, error){
+		hex.DecodeString,
+		base32.StdEncoding.DecodeString,
+		base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.RawStdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	} {
+		if b, err := decode(s); err == nil && len(b) == size {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// workers is how many files a reload reads at once, see [Config.Workers].
+func (a *Allowlist) workers() int {
+	if a.config.Workers > 0 {
+		return a.config.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Files lists what a [Allowlist.Reload] of dir reads: the snapshot where dir
+// is a file, a bundle and its signature, and otherwise its documents and its
+// schema files, named as the reload names them. What a watch polls, to tell a
+// change by. None where dir is read from Git: no file changes a commit.
+func (a *Allowlist) Files(dir string) ([]string, error) {
+	if a.config.GitRef != "" {
+		return nil, nil
+	}
+	if info, err := os.Stat(dir); err == nil && info.Mode().IsRegular() {
+		if IsBundle(dir) {
+			return []string{dir, dir + SignatureExt}, nil
+		}
+		return []string{dir}, nil
+	}
+	docs, schemas, err := scanDir(dir, a.classify)
+	if err != nil {
+		return nil, fmt.Errorf("scanning directory %s: %w", dir, err)
+	}
+	return append(docs, schemas...), nil
+}
+
+// compare reports what replacing previous with next changes and what of next
+// expires.
+func (a *Allowlist) compare(previous *list, next *digests) Result {
+	result := diff(previous, next)
 
-	added, removed := diff(previous, docs)
-	return Result{
-		Files: loaded, Skipped: skipped, SchemaErr: schemaErr,
-		Added: added, Removed: removed,
-	}, nil
+	now := a.now()
+	for i := range next.len() {
+		e := next.entries.entry(i)
+		switch a.status(&e, now) {
+		case StatusSunset:
+			result.Expiring = append(result.Expiring, &e)
+		case StatusExpired:
+			result.Expired = append(result.Expired, &e)
+		}
+	}
+	byExpiry := func(a, b *Entry) int {
+		return cmp.Or(a.Expires.Compare(b.Expires), strings.Compare(a.Name, b.Name))
+	}
+	slices.SortFunc(result.Expiring, byExpiry)
+	slices.SortFunc(result.Expired, byExpiry)
+	return result
 }
 
-// scanDir returns the documents and the schema files under dir, sorted.
+// scanDir returns the documents and the schema files under dir, as classify
+// tells them apart, sorted.
 //
 // The root is resolved through symlinks first, since -allowlist commonly names one:
 // a deploy swaps an allowlist atomically by pointing a link at the new
@@ -209,7 +907,9 @@ func (a *Allowlist) Reload(dir string) (Result, error) {
 // Only the root is resolved: what's reported is still the path as it was given,
 // so a swap doesn't rewrite every entry of documents.files, and a symlinked
 // directory inside the allowlist stays unwalked — following those invites a loop.
-func scanDir(dir string) (docs, schemas []string, err error) {
+func scanDir(
+	dir string, classify func(name string) (doc, schemaFile bool),
+) (docs, schemas []string, err error) {
 	root := dir
 	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
 		root = resolved
@@ -238,13 +938,13 @@ func scanDir(dir string) (docs, schemas []string, err error) {
 			}
 			return nil
 		}
-		if d.IsDir() || strings.HasSuffix(name, "~") {
+		if d.IsDir() {
 			return nil
 		}
-		switch {
-		case strings.HasSuffix(name, schemaExt):
+		switch doc, schemaFile := classify(name); {
+		case schemaFile:
 			schemas = append(schemas, given(path))
-		case isDocument(name):
+		case doc:
 			docs = append(docs, given(path))
 		}
 		return nil
@@ -255,73 +955,110 @@ func scanDir(dir string) (docs, schemas []string, err error) {
 	return docs, schemas, nil
 }
 
-// schemaExt names a file holding the schema.
-// A directory without one is checked against no schema.
-const schemaExt = ".graphqls"
+// classify reports whether the file name is a document the allowlist reads,
+// a source file under [Config.Sources] and a manifest under
+// [Config.Manifests] included, or a schema file. A backup file, ending in ~,
+// is neither, and neither is a hidden one, which the caller leaves out.
+func (a *Allowlist) classify(name string) (doc, schemaFile bool) {
+	switch {
+	case strings.HasSuffix(name, "~"):
+		return false, false
+	case strings.HasSuffix(name, schema.Ext):
+		return false, true
+	}
+	return isDocument(name) || a.config.Manifests && embedded.IsManifest(name) ||
+		a.config.Sources && embedded.IsSource(name), false
+}
 
 func isDocument(name string) bool {
 	return strings.HasSuffix(name, ".graphql") || strings.HasSuffix(name, ".gql")
 }
 
-// loadSchema reads the schema files as one schema: nil where there is none,
-// an error where they hold no valid one.
-func loadSchema(files []string) (*ast.Schema, error) {
-	if len(files) == 0 {
-		return nil, nil
-	}
-	sources := make([]*ast.Source, 0, len(files))
-	for _, name := range files {
-		src, err := os.ReadFile(name)
-		if err != nil {
-			return nil, fmt.Errorf("%s: %w", name, err)
-		}
-		sources = append(sources, &ast.Source{Name: name, Input: string(src)})
-	}
-	schema, err := gqlparser.LoadSchema(sources...)
-	if err != nil {
-		return nil, err
+// document is one document to hash: a file, or one embedded in a source file.
+type document struct {
+	// name is what [Result] calls it: the file, and for an embedded one the
+	// line and the column it begins at too.
+	name string
+
+	// file is what an error names, which the position follows.
+	file string
+	src  []byte
+
+	// position maps an offset of src onto the line and the column of file.
+	position func(offset int) (line, column int)
+}
+
+// syntaxError points at the syntax error of a document in the allowlist.
+func (d document) syntaxError(e gqlhash.Result) error {
+	line, column := d.position(e.ErrOffset)
+	return fmt.Errorf("%s:%d:%d: %w", d.file, line, column, e.Err)
+}
+
+// metadataError points at the metadata comment of a document in the allowlist
+// that err says can't be read, see [entryOf].
+func (d document) metadataError(err error) error {
+	var e *metadataError
+	if errors.As(err, &e) {
+		line, column := d.position(e.offset)
+		return fmt.Errorf("%s:%d:%d: %w", d.file, line, column, e.err)
 	}
-	return schema, nil
+	return fmt.Errorf("%s: %w", d.file, err)
 }
 
-// validate reports what the schema makes of the document in src,
+// validate reports what the type system makes of the document,
 // or nil if it takes it. The message names the file, the line and the column.
-func validate(schema *ast.Schema, name string, src []byte) error {
-	if schema == nil {
+func validate(typeSystem *ast.Schema, d document) error {
+	if typeSystem == nil {
 		return nil
 	}
-	if _, errs := gqlparser.LoadQueryWithRules(
-		schema, string(src), rules.NewDefaultRules(),
-	); len(errs) > 0 {
+	if _, errs := schema.Validate(typeSystem, string(d.src)); len(errs) > 0 {
 		e := errs[0]
-		if len(e.Locations) > 0 {
-			return fmt.Errorf("%s:%d:%d: %s",
-				name, e.Locations[0].Line, e.Locations[0].Column, e.Message)
+		if e.Line > 0 {
+			line, column := d.position(schema.OffsetOf(d.src, e.Line, e.Column))
+			return fmt.Errorf("%s:%d:%d: %s", d.file, line, column, e.Message)
 		}
-		return fmt.Errorf("%s: %s", name, e.Message)
+		return fmt.Errorf("%s: %s", d.file, e.Message)
 	}
 	return nil
 }
 
-func diff(previous *list, docs map[string]struct{}) (added, removed int) {
+// diff finds the hashes of docs previous didn't hold, those it held that docs
+// doesn't, and those both hold under another name, in one pass over both:
+// each is sorted. It sets Added, Removed and Renamed of its result.
+func diff(previous *list, docs *digests) Result {
+	var r Result
 	if previous == nil {
-		return len(docs), 0
+		previous = &list{docs: &digests{size: docs.size}}
 	}
-	for key := range docs {
-		if _, ok := previous.docs[key]; !ok {
-			added++
+	old := previous.docs
+	i, j := 0, 0
+	for i < old.len() || j < docs.len() {
+		c := 1
+		switch {
+		case i == old.len():
+		case j == docs.len():
+			c = -1
+		default:
+			c = bytes.Compare(old.key(i), docs.key(j))
 		}
-	}
-	for key := range previous.docs {
-		if _, ok := docs[key]; !ok {
-			removed++
+		switch {
+		case c == 0:
+			from, to := old.entries.entry(i), docs.entries.entry(j)
+			if from.Name != to.Name {
+				r.Renamed = append(r.Renamed, Rename{From: &from, To: &to})
+			}
+			i, j = i+1, j+1
+		case c < 0:
+			e := old.entries.entry(i)
+			r.Removed, i = append(r.Removed, &e), i+1
+		default:
+			e := docs.entries.entry(j)
+			r.Added, j = append(r.Added, &e), j+1
 		}
 	}
-	return added, removed
-}
-
-// documentError points at the syntax error of a document in the allowlist.
-func documentError(name string, src []byte, e gqlhash.Result) error {
-	line, column := gqlhash.Position(src, e.ErrOffset)
-	return fmt.Errorf("%s:%d:%d: %w", name, line, column, e.Err)
+	byName := func(a, b *Entry) int { return strings.Compare(a.Name, b.Name) }
+	slices.SortFunc(r.Added, byName)
+	slices.SortFunc(r.Removed, byName)
+	slices.SortFunc(r.Renamed, func(a, b Rename) int { return byName(a.To, b.To) })
+	return r
 }
@@ -2,8 +2,13 @@ package allowlist_test
 
 import (
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -599,6 +604,203 @@ func TestAllowlistReload(t *testing.T) {
 	}
 }
 
+// TestAllowlistChanges covers what a reload reports changed, by name: the
+// documents added, removed and found under a new name, against the list it
+// replaced.
+func TestAllowlistChanges(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	writeDoc(t, dir, "b.graphql", "query B { b }")
+	_, reload := newAllowlist(t, dir)
+
+	namesOf := func(entries []*allowlist.Entry) []string {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+
+	// The first load adds every document.
+	r, err := reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	every := []string{filepath.Join(dir, "a.graphql"), filepath.Join(dir, "b.graphql")}
+	if got := namesOf(r.Added); !slices.Equal(got, every) ||
+		len(r.Removed) != 0 || len(r.Renamed) != 0 {
+		t.Fatalf("expected every document added; received %v, %v, %v",
+			got, r.Removed, r.Renamed)
+	}
+
+	// A document moved is the same hash at a new path, renamed rather than
+	// removed and added. Its operations come along.
+	writeDoc(t, dir, filepath.Join("queries", "b.graphql"), "query B { b }")
+	if err := os.Remove(filepath.Join(dir, "b.graphql")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "a.graphql")); err != nil {
+		t.Fatal(err)
+	}
+	writeDoc(t, dir, "c.graphql", "{ c }")
+	r, err = reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, "queries", "b.graphql")
+	switch {
+	case !slices.Equal(namesOf(r.Added), []string{filepath.Join(dir, "c.graphql")}):
+		t.Errorf("expected c.graphql added; received %v", namesOf(r.Added))
+	case !slices.Equal(namesOf(r.Removed), []string{filepath.Join(dir, "a.graphql")}):
+		t.Errorf("expected a.graphql removed; received %v", namesOf(r.Removed))
+	case len(r.Renamed) != 1 || r.Renamed[0].From.Name != filepath.Join(dir, "b.graphql") ||
+		r.Renamed[0].To.Name != moved ||
+		!slices.Equal(r.Renamed[0].To.Operations, []string{"B"}):
+		t.Errorf("expected b.graphql renamed to %s; received %+v", moved, r.Renamed)
+	}
+
+	// Nothing changed reports nothing.
+	r, err = reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Added)+len(r.Removed)+len(r.Renamed) != 0 {
+		t.Errorf("expected no change; received %v, %v, %v", r.Added, r.Removed, r.Renamed)
+	}
+}
+
+// TestAllowlistCheck covers a dry run: the result of a reload, against what
+// the allowlist holds, which it still holds afterwards.
+func TestAllowlistCheck(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	list, reload := newAllowlist(t, dir)
+
+	// Before the first reload, there's nothing to compare with, and nothing
+	// is published.
+	r, err := list.Check(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Files) != 1 || len(r.Added) != 1 || list.Len() != 0 {
+		t.Fatalf("expected a.graphql added and nothing published; received %+v, %d",
+			r, list.Len())
+	}
+	if _, err := reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.graphql")); err != nil {
+		t.Fatal(err)
+	}
+	writeDoc(t, dir, "b.graphql", "{ b }")
+	writeDoc(t, dir, "c.graphql", "{ broken")
+	r, err = list.Check(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Added) != 1 || len(r.Removed) != 1 || len(r.Skipped) != 1 {
+		t.Errorf("expected one document added, one removed and one skipped; "+
+			"received %+v", r)
+	}
+	if !list.Allowed(hashOf(t, "{ a }")) || list.Allowed(hashOf(t, "{ b }")) {
+		t.Error("expected the allowlist to hold what it held")
+	}
+
+	// A dry run changes nothing a reload then reports.
+	r, err = reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Added) != 1 || len(r.Removed) != 1 {
+		t.Errorf("expected the reload to report the change; received %+v", r)
+	}
+
+	// A directory that can't be read fails a dry run as it fails a reload.
+	if _, err := list.Check(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// TestAllowlistPolicy covers a reload the policy refuses: it fails with
+// ErrRefused, reports what it would have published, and the allowlist holds
+// what it held.
+func TestAllowlistPolicy(t *testing.T) {
+	load := func(t *testing.T, p allowlist.Policy) (*allowlist.Allowlist, string) {
+		t.Helper()
+		dir := t.TempDir()
+		for _, name := range []string{"a", "b", "c", "d"} {
+			writeDoc(t, dir, name+".graphql", "{ "+name+" }")
+		}
+		list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+			allowlist.Config{Policy: p})
+		if _, err := list.Reload(dir); err != nil {
+			t.Fatal(err)
+		}
+		return list, dir
+	}
+	refused := func(t *testing.T, list *allowlist.Allowlist, dir, reason string) {
+		t.Helper()
+		r, err := list.Reload(dir)
+		if !errors.Is(err, allowlist.ErrRefused) || !strings.Contains(err.Error(), reason) {
+			t.Fatalf("expected a refusal for %q; received %v", reason, err)
+		}
+		if len(r.Files) == 0 {
+			t.Error("expected the result that was refused")
+		}
+		if list.Len() != 4 || !list.Allowed(hashOf(t, "{ a }")) {
+			t.Error("expected the allowlist to hold what it held")
+		}
+	}
+
+	t.Run("strict skipped", func(t *testing.T) {
+		list, dir := load(t, allowlist.Policy{Strict: true})
+		writeDoc(t, dir, "e.graphql", "{ broken")
+		refused(t, list, dir, "1 skipped")
+		// A dry run is refused alike, which is how one asks ahead.
+		if _, err := list.Check(dir); !errors.Is(err, allowlist.ErrRefused) {
+			t.Errorf("expected the dry run refused; received %v", err)
+		}
+	})
+	t.Run("strict schema", func(t *testing.T) {
+		list, dir := load(t, allowlist.Policy{Strict: true})
+		writeDoc(t, dir, "schema.graphqls", "type Query {")
+		refused(t, list, dir, "the schema can't be read")
+	})
+	t.Run("max removed", func(t *testing.T) {
+		list, dir := load(t, allowlist.Policy{MaxRemoved: 1})
+		for _, name := range []string{"a", "b"} {
+			if err := os.Remove(filepath.Join(dir, name+".graphql")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		refused(t, list, dir, "2 documents removed, more than 1")
+	})
+	t.Run("max removed percent", func(t *testing.T) {
+		list, dir := load(t, allowlist.Policy{MaxRemovedPercent: 50})
+		for _, name := range []string{"a", "b", "c"} {
+			if err := os.Remove(filepath.Join(dir, name+".graphql")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		refused(t, list, dir, "3 of 4 documents removed, more than 50%")
+	})
+
+	// What the policy allows is published, and a broken file is skipped as
+	// ever where the policy isn't strict.
+	list, dir := load(t, allowlist.Policy{MaxRemoved: 1, MaxRemovedPercent: 25})
+	if err := os.Remove(filepath.Join(dir, "a.graphql")); err != nil {
+		t.Fatal(err)
+	}
+	writeDoc(t, dir, "e.graphql", "{ broken")
+	if r, err := list.Reload(dir); err != nil || len(r.Removed) != 1 || len(r.Skipped) != 1 {
+		t.Fatalf("expected the reload published; received %+v, %v", r, err)
+	}
+	if list.Allowed(hashOf(t, "{ a }")) {
+		t.Error("expected the removed document gone")
+	}
+}
+
 // TestAllowlistConcurrentLoad pins that Load serializes its callers.
 // Several requests to the control endpoint may reach it at once.
 func TestAllowlistConcurrentLoad(t *testing.T) {
@@ -622,6 +824,176 @@ func TestAllowlistConcurrentLoad(t *testing.T) {
 }
 
 // hashOf returns the allowlist key of a document under the default options.
+// TestAllowlistSources covers the documents of a client codebase: under
+// Config.Sources each one embedded in a source file is an entry named by where
+// it begins, and a syntax error of one points into the file it was written in.
+// Without it a source file is no document at all.
+func TestAllowlistSources(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	writeDoc(t, dir, "src/user.ts", "const Fields = gql`fragment F on User { id }`\n"+
+		"export const GetUser = gql`{ user { ...F } } ${Fields}`\n"+
+		"export const Broken = gql`{\n  user(id: 01) }`\n")
+	writeDoc(t, dir, "queries.go", "package q\n\nconst Viewer = `{ viewer { id } }`\n")
+
+	list := allowlist.New(sha256.New, gqlhash.Options{})
+	result, err := list.Reload(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Files) != 1 || len(result.Skipped) != 0 {
+		t.Errorf("expected a.graphql alone without Sources; received %v, %v",
+			result.Files, result.Skipped)
+	}
+
+	list = allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+		allowlist.Config{Sources: true})
+	if result, err = list.Reload(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := []string{
+		filepath.Join(dir, "a.graphql"),
+		filepath.Join(dir, "queries.go") + ":3:17",
+		filepath.Join(dir, "src/user.ts") + ":2:28",
+	}
+	if strings.Join(result.Files, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expected %v; received %v", expect, result.Files)
+	}
+	if !list.Allowed(hashOf(t, "{ user { ...F } } fragment F on User { id }")) {
+		t.Error("expected the query with its fragment resolved to be served")
+	}
+	if !list.Allowed(hashOf(t, "{ viewer { id } }")) {
+		t.Error("expected the Go constant to be served")
+	}
+	if len(result.Skipped) != 1 || !strings.HasPrefix(result.Skipped[0].Error(),
+		filepath.Join(dir, "src/user.ts")+":4:13: ") {
+		t.Errorf("expected the syntax error at src/user.ts:4:13; received %v",
+			result.Skipped)
+	}
+}
+
+// TestAllowlistManifests covers the persisted-query manifests of a client
+// build under Config.Manifests: every entry is a document of its own, named by
+// where it begins, and an entry that can't be served is skipped alone, named
+// where it's written. An entry of a hash alone is that hash, which runs any
+// operation. Without it they're left out, as any other JSON file is.
+func TestAllowlistManifests(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "apollo.json", `{"format":"apollo-persisted-query-manifest",`+
+		`"version":1,"operations":[`+"\n"+
+		`{"id":"1","body":"query A { a }"},`+"\n"+
+		`{"id":"2","body":"query B {\n  b(x: 01) }"},`+"\n"+
+		`{"id":"3"}]}`)
+	writeDoc(t, dir, "relay.json", `{"f00":"query C { c }"}`)
+	writeDoc(t, dir, "requests.jsonl", `{"query":"query D { d }"}`+"\n"+
+		`{"extensions":{"persistedQuery":{"sha256Hash":"`+
+		hex.EncodeToString(hashOf(t, "query F { f }"))+`"}}}`+"\n"+
+		`{"id":"`+base64.RawURLEncoding.EncodeToString(hashOf(t, "{ g }"))+`"}`+"\n")
+	writeDoc(t, dir, "e.graphql", "query E { e }")
+
+	_, reload := newAllowlist(t, dir)
+	result, err := reload()
+	if err != nil || len(result.Files) != 1 || len(result.Skipped) != 0 {
+		t.Errorf("expected the manifests left out; received %+v, %v", result, err)
+	}
+
+	list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+		allowlist.Config{Manifests: true})
+	result, err = list.Reload(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := []string{
+		filepath.Join(dir, "apollo.json") + ":2:19",
+		filepath.Join(dir, "e.graphql"),
+		filepath.Join(dir, "relay.json") + ":1:9",
+		filepath.Join(dir, "requests.jsonl") + ":1:11",
+		filepath.Join(dir, "requests.jsonl") + ":2:48",
+		filepath.Join(dir, "requests.jsonl") + ":3:8",
+	}
+	if strings.Join(result.Files, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expected %v; received %v", expect, result.Files)
+	}
+	for _, d := range []string{"query A { a }", "query C { c }", "query D { d }"} {
+		if !list.Allowed(hashOf(t, d)) {
+			t.Errorf("expected %q to be served", d)
+		}
+	}
+	if e, ok := list.Lookup(hashOf(t, "query A { a }")); !ok ||
+		!slices.Equal(e.Operations, []string{"A"}) {
+		t.Errorf("expected the operations of the entry; received %+v", e)
+	}
+	for _, d := range []string{"query F { f }", "{ g }"} {
+		if e, ok := list.Lookup(hashOf(t, d)); !ok || !e.HashOnly ||
+			!e.Runs([]byte("Any")) || !e.Runs(nil) {
+			t.Errorf("expected %q served by its hash alone; received %+v", d, e)
+		}
+	}
+
+	// The syntax error points into the manifest, past the escaped newline,
+	// and an id that is no hash is skipped.
+	var skipped []string
+	for _, err := range result.Skipped {
+		skipped = append(skipped,
+			strings.TrimPrefix(err.Error(), dir+string(filepath.Separator)))
+	}
+	if len(skipped) != 2 || !strings.HasPrefix(skipped[0], "apollo.json:3:38: ") ||
+		!strings.HasPrefix(skipped[1], `apollo.json:4:8: "3" is no hash`) {
+		t.Errorf("expected the entries 2 and 3 skipped; received %q", skipped)
+	}
+}
+
+// TestAllowlistWorkers covers a reload across workers: what it publishes and
+// reports is what one worker's would be, in the same order, shared hashes,
+// broken documents and operations of several included.
+func TestAllowlistWorkers(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "schema.graphqls", "type Query { a(x: Int): Int b: Int }")
+	for i := range 200 {
+		name := fmt.Sprintf("q%03d.graphql", i)
+		switch i % 10 {
+		case 0:
+			writeDoc(t, dir, name, "{ b }") // Every tenth shares a hash.
+		case 1:
+			writeDoc(t, dir, name, "{ c }") // Not in the schema.
+		case 2:
+			writeDoc(t, dir, name, "{ a(x: 01) }") // Doesn't parse.
+		case 3:
+			writeDoc(t, dir, name, fmt.Sprintf("query A%d { a(x: %d) } query B { b }", i, i))
+		default:
+			writeDoc(t, dir, name, fmt.Sprintf("{ a(x: %d) }", i))
+		}
+	}
+
+	reload := func(workers int) ([]string, []string) {
+		list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+			allowlist.Config{Workers: workers})
+		r, err := list.Reload(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var skipped []string
+		for _, err := range r.Skipped {
+			skipped = append(skipped, err.Error())
+		}
+		return r.Files, skipped
+	}
+	expectFiles, expectSkipped := reload(1)
+	if len(expectFiles) == 0 || len(expectSkipped) == 0 {
+		t.Fatalf("expected documents served and skipped; received %v, %v",
+			expectFiles, expectSkipped)
+	}
+	for range 5 {
+		files, skipped := reload(8)
+		if !slices.Equal(files, expectFiles) {
+			t.Fatalf("expected the files of one worker; received %v", files)
+		}
+		if !slices.Equal(skipped, expectSkipped) {
+			t.Fatalf("expected the errors of one worker; received %v", skipped)
+		}
+	}
+}
+
 func hashOf(t *testing.T, document string) []byte {
 	t.Helper()
 	h := sha256.New()
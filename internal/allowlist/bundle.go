@@ -0,0 +1,212 @@
+package allowlist
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SignatureExt follows the name of a bundle to name its signature:
+// allowlist.tar.gz is signed by allowlist.tar.gz.sig.
+const SignatureExt = ".sig"
+
+var (
+	// ErrUnsigned is a bundle without a signature beside it.
+	ErrUnsigned = errors.New("unsigned: no " + SignatureExt + " file beside it")
+
+	// ErrBadSignature is a bundle whose signature no key verifies: it was
+	// signed with another key, or changed since it was signed.
+	ErrBadSignature = errors.New("the signature verifies against none of the keys")
+
+	// ErrNotBundle is anything but a bundle where [Config.BundleKeys] asks for
+	// one.
+	ErrNotBundle = errors.New("not a signed bundle, which the bundle keys require")
+)
+
+// IsBundle reports whether name is a bundle, by its extension: a tar archive,
+// .tar, .tar.gz or .tgz, or a zip one, .zip, of what a directory of an
+// allowlist holds. Its signature is the file beside it named by
+// [SignatureExt]: an ed25519 signature of the archive as it is, its 64 bytes
+// or their base64.
+func IsBundle(name string) bool { return bundleFormat(name) != "" }
+
+// bundleFormat is the archive format of the bundle name, empty for none.
+func bundleFormat(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// loadBundle reads the bundle name as a directory holding its files would be
+// read, every one of them named by the bundle and its path in it.
+func (a *Allowlist) loadBundle(name string) (map[string]*Entry, Result, error) {
+	files, err := openBundle(name, a.config.BundleKeys)
+	if err != nil {
+		return nil, Result{}, fmt.Errorf("bundle %s: %w", name, err)
+	}
+	docs, result := a.readTree(files, func(file string) string {
+		return filepath.Join(name, filepath.FromSlash(file))
+	})
+	return docs, result, nil
+}
+
+// openBundle returns the files of the bundle name by their path in it. Its
+// signature is verified against keys before anything of it is parsed, so what
+// no key signed isn't even decompressed.
+func openBundle(name string, keys []ed25519.PublicKey) (map[string][]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no key to verify it against")
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := os.ReadFile(name + SignatureExt)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, ErrUnsigned
+	case err != nil:
+		return nil, err
+	}
+	return verifyBundle(bundleFormat(name), data, encoded, keys)
+}
+
+// verifyBundle returns the files of the bundle data, of format, by their path
+// in it, where encoded is a signature of it by one of keys, see [openBundle].
+func verifyBundle(
+	format string, data, encoded []byte, keys []ed25519.PublicKey,
+) (map[string][]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no key to verify it against")
+	}
+	if encoded == nil {
+		return nil, ErrUnsigned
+	}
+	signature, err := decodeSignature(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(keys, func(key ed25519.PublicKey) bool {
+		return ed25519.Verify(key, data, signature)
+	}) {
+		return nil, ErrBadSignature
+	}
+
+	return unpack(format, data)
+}
+
+// decodeSignature is the signature of a signature file: its 64 bytes as they
+// are, or their base64 with the space around it trimmed.
+func decodeSignature(encoded []byte) ([]byte, error) {
+	if len(encoded) == ed25519.SignatureSize {
+		return encoded, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("the signature is neither %d bytes nor their base64",
+			ed25519.SignatureSize)
+	}
+	return signature, nil
+}
+
+// unpack returns the regular files of the archive data, of format, by their
+// path in it. A file of any other type, a link included, fails the bundle:
+// what it points to is outside what was signed.
+func unpack(format string, data []byte) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	add := func(name string, src []byte) error {
+		clean := path.Clean(name)
+		if clean == "." || !fs.ValidPath(clean) {
+			return fmt.Errorf("%s: a path outside the bundle", name)
+		}
+		if _, twice := files[clean]; twice {
+			return fmt.Errorf("%s: in the bundle twice", name)
+		}
+		files[clean] = src
+		return nil
+	}
+
+	if format == "zip" {
+		z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range z.File {
+			switch mode := f.Mode(); {
+			case mode.IsDir():
+				continue
+			case !mode.IsRegular():
+				return nil, fmt.Errorf("%s: not a regular file", f.Name)
+			}
+			src, err := readZipped(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			if err := add(f.Name, src); err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if format == "tgz" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	}
+	t := tar.NewReader(r)
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			// A global header is what git archive writes the commit into.
+			continue
+		case tar.TypeReg:
+		default:
+			return nil, fmt.Errorf("%s: not a regular file", h.Name)
+		}
+		src, err := io.ReadAll(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", h.Name, err)
+		}
+		if err := add(h.Name, src); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readZipped(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
+}
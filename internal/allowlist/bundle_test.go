@@ -0,0 +1,244 @@
+package allowlist_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+)
+
+// archive returns the archive of files, by their path in it, as format writes
+// it: tar, tgz or zip. A file whose content is "->target" is a symlink.
+func archive(t *testing.T, format string, files map[string]string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if format == "zip" {
+		z := zip.NewWriter(&b)
+		for _, name := range names {
+			w, err := z.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(files[name])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&b)
+	if format == "tgz" {
+		gz = gzip.NewWriter(&b)
+		tw = tar.NewWriter(gz)
+	}
+	for _, name := range names {
+		h := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name]))}
+		body := files[name]
+		if target, ok := strings.CutPrefix(body, "->"); ok {
+			h.Typeflag, h.Linkname, h.Size, body = tar.TypeSymlink, target, 0, ""
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.Bytes()
+}
+
+// writeBundle writes data as dir/name, signed with key where it's set.
+func writeBundle(t *testing.T, dir, name string, data []byte, key ed25519.PrivateKey) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		signature := ed25519.Sign(key, data)
+		err := os.WriteFile(path+allowlist.SignatureExt, signature, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+func bundleAllowlist(keys ...ed25519.PublicKey) *allowlist.Allowlist {
+	return allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+		allowlist.Config{BundleKeys: keys})
+}
+
+// TestAllowlistBundle covers a signed bundle of every format read as the
+// directory it was archived from is: its documents, its schema and what their
+// comments say, each named by the bundle and its path in it.
+func TestAllowlistBundle(t *testing.T) {
+	public, private := newKey(t)
+	files := map[string]string{
+		"queries/a.graphql": "# gqlhash: owner: team-a\n{ a }",
+		"./b.graphql":       "{ b }",
+		"schema.graphqls":   "type Query { a: Int b: Int }",
+		".hidden/c.graphql": "{ a b }",
+		"README.md":         "not a document",
+		"broken.graphql":    "{ c }",
+	}
+	for _, format := range []string{"tar", "tgz", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			name := map[string]string{
+				"tar": "allowlist.tar", "tgz": "allowlist.tar.gz", "zip": "allowlist.zip",
+			}[format]
+			bundle := writeBundle(t, dir, name, archive(t, format, files), private)
+			list := bundleAllowlist(public)
+			r, err := list.Reload(bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect := []string{
+				filepath.Join(bundle, "b.graphql"), filepath.Join(bundle, "queries", "a.graphql"),
+			}
+			if !slices.Equal(r.Files, expect) || r.SchemaErr != nil {
+				t.Errorf("expected %v; received %v, %v", expect, r.Files, r.SchemaErr)
+			}
+			// The schema was read: it takes no field c.
+			if len(r.Skipped) != 1 ||
+				!strings.Contains(r.Skipped[0].Error(), filepath.Join(bundle, "broken.graphql")) {
+				t.Errorf("expected broken.graphql skipped by the schema; received %v", r.Skipped)
+			}
+			e, ok := list.Lookup(hashOf(t, "{ a }"))
+			if !ok || e.Owner != "team-a" {
+				t.Errorf("expected { a } owned by team-a; received %+v", e)
+			}
+			files, err := list.Files(bundle)
+			if err != nil || !slices.Equal(files,
+				[]string{bundle, bundle + allowlist.SignatureExt}) {
+				t.Errorf("expected the bundle and its signature watched; received %v", files)
+			}
+		})
+	}
+
+	// A signature in base64, as a pipeline commonly stores one.
+	dir := t.TempDir()
+	data := archive(t, "tgz", map[string]string{"a.graphql": "{ a }"})
+	bundle := writeBundle(t, dir, "a.tgz", data, nil)
+	encoded := base64.StdEncoding.EncodeToString(ed25519.Sign(private, data)) + "\n"
+	if err := os.WriteFile(bundle+allowlist.SignatureExt, []byte(encoded), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := newKey(t)
+	if r, err := bundleAllowlist(other, public).Reload(bundle); err != nil || len(r.Files) != 1 {
+		t.Errorf("expected the base64 signature verified by either key; received %v", err)
+	}
+}
+
+// TestAllowlistBundleRefused covers what is refused before anything of a
+// bundle is read, and that a refused reload keeps what the allowlist held.
+func TestAllowlistBundleRefused(t *testing.T) {
+	public, private := newKey(t)
+	_, otherPrivate := newKey(t)
+	data := archive(t, "tgz", map[string]string{"a.graphql": "{ a }"})
+
+	f := func(t *testing.T, list *allowlist.Allowlist, path string, expect error, msg string) {
+		t.Helper()
+		_, err := list.Reload(path)
+		if err == nil || (expect != nil && !errors.Is(err, expect)) ||
+			!strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q; received %v", msg, err)
+		}
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		bundle := writeBundle(t, t.TempDir(), "a.tgz", data, nil)
+		f(t, bundleAllowlist(public), bundle, allowlist.ErrUnsigned, "unsigned")
+	})
+	t.Run("another key", func(t *testing.T) {
+		bundle := writeBundle(t, t.TempDir(), "a.tgz", data, otherPrivate)
+		f(t, bundleAllowlist(public), bundle, allowlist.ErrBadSignature, "none of the keys")
+	})
+	t.Run("tampered", func(t *testing.T) {
+		dir := t.TempDir()
+		bundle := writeBundle(t, dir, "a.tgz", data, private)
+		list := bundleAllowlist(public)
+		if _, err := list.Reload(bundle); err != nil {
+			t.Fatal(err)
+		}
+		tampered := archive(t, "tgz", map[string]string{"a.graphql": "{ a }", "b.graphql": "{ b }"})
+		if err := os.WriteFile(bundle, tampered, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f(t, list, bundle, allowlist.ErrBadSignature, "none of the keys")
+		if !list.Allowed(hashOf(t, "{ a }")) || list.Allowed(hashOf(t, "{ b }")) {
+			t.Error("expected the allowlist to hold what it held")
+		}
+	})
+	t.Run("garbled signature", func(t *testing.T) {
+		bundle := writeBundle(t, t.TempDir(), "a.tgz", data, nil)
+		if err := os.WriteFile(bundle+allowlist.SignatureExt, []byte("nope"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f(t, bundleAllowlist(public), bundle, nil, "neither 64 bytes nor their base64")
+	})
+	t.Run("no key", func(t *testing.T) {
+		bundle := writeBundle(t, t.TempDir(), "a.tgz", data, private)
+		f(t, bundleAllowlist(), bundle, nil, "no key to verify it against")
+	})
+	t.Run("directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeDoc(t, dir, "a.graphql", "{ a }")
+		f(t, bundleAllowlist(public), dir, allowlist.ErrNotBundle, "not a signed bundle")
+	})
+	t.Run("symlink", func(t *testing.T) {
+		linked := archive(t, "tar", map[string]string{"a.graphql": "->/etc/passwd"})
+		bundle := writeBundle(t, t.TempDir(), "a.tar", linked, private)
+		f(t, bundleAllowlist(public), bundle, nil, "a.graphql: not a regular file")
+	})
+	t.Run("outside", func(t *testing.T) {
+		outside := archive(t, "tar", map[string]string{"../a.graphql": "{ a }"})
+		bundle := writeBundle(t, t.TempDir(), "a.tar", outside, private)
+		f(t, bundleAllowlist(public), bundle, nil, "a path outside the bundle")
+	})
+	t.Run("twice", func(t *testing.T) {
+		twice := archive(t, "tar", map[string]string{"a.graphql": "{ a }", "./a.graphql": "{ b }"})
+		bundle := writeBundle(t, t.TempDir(), "a.tar", twice, private)
+		f(t, bundleAllowlist(public), bundle, nil, "in the bundle twice")
+	})
+}
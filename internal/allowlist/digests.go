@@ -0,0 +1,214 @@
+package allowlist
+
+import (
+	"bytes"
+	"maps"
+	"math/bits"
+	"slices"
+	"unsafe"
+)
+
+// digests is the published set of hashes, sorted and packed end to end in
+// one array of size bytes each, the entry of the i-th the i-th of entries.
+//
+// A map keyed by string holds a header and an allocation per hash, which the
+// collector scans on every cycle; at millions of documents that's most of
+// what it scans. keys and buckets hold no pointer, so neither is scanned at
+// all, and a lookup reads them where they lie rather than chasing one. What
+// entries holds is scanned as little, see [entryTable].
+//
+// A hash is evenly spread over its range, so its leading bits pick it out
+// among about as many buckets as there are hashes: buckets[b] is the index of
+// the first hash whose leading bits are b or more, and a lookup reads two
+// adjacent numbers there and compares a hash or two. See [digests.find].
+type digests struct {
+	size int
+	keys []byte
+
+	// entries are the entries of the hashes. Empty for the hashes of a
+	// scheme, whose entries are those of the allowlist's own hashes, the
+	// i-th the at[i]-th of them.
+	entries entryTable
+	at      []uint32
+
+	buckets []uint32
+	shift   uint
+}
+
+// newDigests packs docs, whose keys are size bytes each.
+func newDigests(size int, docs map[string]*Entry) *digests {
+	d := packKeys(size, slices.Sorted(maps.Keys(docs)))
+	names, operations := 0, 0
+	for _, e := range docs {
+		names += len(e.Name)
+		for _, o := range e.Operations {
+			operations += len(o)
+		}
+	}
+	d.entries.grow(len(docs), names, operations)
+	for i := range d.len() {
+		d.entries.add(docs[string(d.key(i))])
+	}
+	return d
+}
+
+// packKeys packs the sorted keys, of size bytes each, with no entries.
+func packKeys(size int, sorted []string) *digests {
+	// As many buckets as the power of two past the count, so about one hash
+	// each, a number of four bytes apiece.
+	width := bits.Len(uint(len(sorted)))
+	d := &digests{
+		size:    size,
+		keys:    make([]byte, 0, len(sorted)*size),
+		buckets: make([]uint32, 1<<width+1),
+		shift:   uint(64 - width),
+	}
+	b := 0
+	for i, key := range sorted {
+		d.keys = append(d.keys, key...)
+		for bucket := d.bucket([]byte(key)); b <= bucket; b++ {
+			d.buckets[b] = uint32(i)
+		}
+	}
+	for ; b < len(d.buckets); b++ {
+		d.buckets[b] = uint32(len(sorted))
+	}
+	return d
+}
+
+func (d *digests) len() int {
+	if d.size == 0 {
+		return 0
+	}
+	return len(d.keys) / d.size
+}
+
+// key is the i-th hash.
+func (d *digests) key(i int) []byte { return d.keys[i*d.size : (i+1)*d.size] }
+
+// bucket is the bucket of key, its leading bits.
+func (d *digests) bucket(key []byte) int {
+	if d.shift >= 64 {
+		return 0
+	}
+	return int(prefix(key) >> d.shift)
+}
+
+// find is the index of key, and false where it's none of them.
+// Allocates nothing.
+func (d *digests) find(key []byte) (int, bool) {
+	if len(key) != d.size || len(d.keys) == 0 {
+		return 0, false
+	}
+	b := d.bucket(key)
+	// The hashes of a bucket are sorted too. A hash function spreading them
+	// unevenly makes a bucket long, which is searched by halves all the same.
+	lo, hi := int(d.buckets[b]), int(d.buckets[b+1])
+	for lo < hi {
+		i := int(uint(lo+hi) >> 1)
+		switch c := bytes.Compare(d.key(i), key); {
+		case c == 0:
+			return i, true
+		case c < 0:
+			lo = i + 1
+		default:
+			hi = i
+		}
+	}
+	return 0, false
+}
+
+// prefix is the first eight bytes of key as a number ordered as key is,
+// shorter keys padded with zeros.
+func prefix(key []byte) uint64 {
+	var p uint64
+	for i := range 8 {
+		p <<= 8
+		if i < len(key) {
+			p |= uint64(key[i])
+		}
+	}
+	return p
+}
+
+// entryTable is the entries of the hashes of a [digests], by index, held so
+// the collector has little to scan at millions of them. Every entry has a
+// name, and most name an operation: the names are packed end to end in an
+// array that holds no pointer, the operations in one whose strings all point
+// into another. What else an entry says, its metadata, few do, so it's kept
+// for those alone.
+type entryTable struct {
+	// names is every name end to end, the i-th ending at nameEnds[i] where
+	// the one before ends.
+	names    []byte
+	nameEnds []uint32
+
+	// operations are the operations of every entry, the i-th's from
+	// opEnds[i-1] to opEnds[i], each a string of opText, which holds them
+	// end to end.
+	opText     []byte
+	operations []string
+	opEnds     []uint32
+
+	// more[i] is one past the index in meta of what else the i-th entry
+	// says, its metadata and whether it's hash-only, 0 where it says nothing
+	// else. An entry of meta has no name and no operations of its own.
+	more []uint32
+	meta []Entry
+}
+
+// grow makes room for n entries whose names take names bytes and whose
+// operations take operations.
+func (t *entryTable) grow(n, names, operations int) {
+	t.names = make([]byte, 0, names)
+	t.nameEnds = make([]uint32, 0, n)
+	t.opText = make([]byte, 0, operations)
+	t.opEnds = make([]uint32, 0, n)
+	t.more = make([]uint32, 0, n)
+}
+
+// add appends e. A byte of opText is never written twice, so a string of it
+// stays what it was whichever array it ends up in.
+func (t *entryTable) add(e *Entry) {
+	t.names = append(t.names, e.Name...)
+	t.nameEnds = append(t.nameEnds, uint32(len(t.names)))
+	for _, o := range e.Operations {
+		t.opText = append(t.opText, o...)
+		t.operations = append(t.operations, stringOf(t.opText[len(t.opText)-len(o):]))
+	}
+	t.opEnds = append(t.opEnds, uint32(len(t.operations)))
+	if !e.hasMetadata() && !e.HashOnly {
+		t.more = append(t.more, 0)
+		return
+	}
+	m := *e
+	m.Name, m.Operations, m.schemeKeys = "", nil, nil
+	t.meta = append(t.meta, m)
+	t.more = append(t.more, uint32(len(t.meta)))
+}
+
+// entry is the i-th entry. Allocates nothing: its name is a string of names,
+// and its operations a slice of operations.
+func (t *entryTable) entry(i int) Entry {
+	var e Entry
+	if m := t.more[i]; m != 0 {
+		e = t.meta[m-1]
+	}
+	var nameStart, opStart uint32
+	if i > 0 {
+		nameStart, opStart = t.nameEnds[i-1], t.opEnds[i-1]
+	}
+	e.Name = stringOf(t.names[nameStart:t.nameEnds[i]])
+	if opEnd := t.opEnds[i]; opStart < opEnd {
+		e.Operations = t.operations[opStart:opEnd:opEnd]
+	}
+	return e
+}
+
+// stringOf views b as a string without copying. Nothing writes b again.
+func stringOf(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
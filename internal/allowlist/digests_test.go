@@ -0,0 +1,167 @@
+package allowlist
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// randomDocs returns n random hashes of size bytes, each with an entry named
+// as a file of a large allowlist is: most name an operation, and every tenth
+// says who owns it and when it expires.
+func randomDocs(n, size int) map[string]*Entry {
+	docs := make(map[string]*Entry, n)
+	key := make([]byte, size)
+	for i := 0; len(docs) < n; i++ {
+		_, _ = rand.Read(key)
+		e := &Entry{Name: fmt.Sprintf("queries/q%07d.graphql", i)}
+		if i%3 != 0 {
+			e.Operations = []string{fmt.Sprintf("Q%07d", i)}
+		}
+		if i%10 == 0 {
+			e.Owner, e.Client = "payments", "ios"
+			e.Expires = time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC)
+		}
+		docs[string(key)] = e
+	}
+	return docs
+}
+
+// TestDigestsFind covers a lookup among hashes of every size a hash function
+// has, found where they are and not found where they aren't.
+func TestDigestsFind(t *testing.T) {
+	for _, size := range []int{4, 8, 16, 32, 64} {
+		for _, n := range []int{0, 1, 2, 17, 1000} {
+			docs := randomDocs(n, size)
+			d := newDigests(size, docs)
+			if d.len() != n {
+				t.Fatalf("size %d: expected %d hashes; received %d", size, n, d.len())
+			}
+			for key := range docs {
+				i, ok := d.find([]byte(key))
+				if !ok {
+					t.Fatalf("size %d, %d hashes: expected %x found", size, n, key)
+				}
+				if e := d.entries.entry(i); !reflect.DeepEqual(e, *docs[key]) {
+					t.Fatalf("size %d: expected %+v; received %+v", size, *docs[key], e)
+				}
+			}
+			missing := randomDocs(100, size)
+			for key := range missing {
+				if _, ok := docs[key]; ok {
+					continue
+				}
+				if _, ok := d.find([]byte(key)); ok {
+					t.Fatalf("size %d, %d hashes: %x isn't one of them", size, n, key)
+				}
+			}
+			// A key of another size is none of them, its prefix included.
+			for key := range docs {
+				if _, ok := d.find([]byte(key)[:size-1]); ok {
+					t.Fatalf("size %d: expected a shorter key not found", size)
+				}
+				break
+			}
+		}
+	}
+
+	// Hashes bunched in a bucket or a few are found all the same.
+	docs := map[string]*Entry{}
+	for i := range 10_000 {
+		key := make([]byte, 8)
+		key[7] = byte(i)
+		key[6] = byte(i >> 8)
+		if i == 9_999 {
+			key[0] = 0xff
+		}
+		docs[string(key)] = &Entry{}
+	}
+	d := newDigests(8, docs)
+	for key := range docs {
+		if _, ok := d.find([]byte(key)); !ok {
+			t.Fatalf("expected %x found", key)
+		}
+	}
+}
+
+// TestDigestsFindZeroAlloc asserts that a lookup allocates nothing.
+func TestDigestsFindZeroAlloc(t *testing.T) {
+	d := newDigests(sha256.Size, randomDocs(1000, sha256.Size))
+	key := d.key(500)
+	if n := testing.AllocsPerRun(100, func() { _, _ = d.find(key) }); n != 0 {
+		t.Errorf("expected no allocations; received %v", n)
+	}
+}
+
+// BenchmarkLookup compares the published set with the map it replaced, at
+// 10k, 1M and 10M hashes of SHA-256: a lookup that finds its hash and one
+// that doesn't, the heap each holds per hash, and how long a collection
+// takes with it alive. 10M takes some 4 GB and is left out under -short.
+func BenchmarkLookup(b *testing.B) {
+	for _, n := range []int{10_000, 1_000_000, 10_000_000} {
+		if n > 1_000_000 && testing.Short() {
+			continue
+		}
+		docs := randomDocs(n, sha256.Size)
+		var hit []byte
+		for key := range docs {
+			hit = []byte(key)
+			break
+		}
+		miss := make([]byte, sha256.Size)
+		_, _ = rand.Read(miss)
+
+		b.Run(fmt.Sprintf("map/%d", n), func(b *testing.B) {
+			b.Run("hit", func(b *testing.B) {
+				for b.Loop() {
+					_ = docs[string(hit)]
+				}
+			})
+			b.Run("miss", func(b *testing.B) {
+				for b.Loop() {
+					_ = docs[string(miss)]
+				}
+			})
+			b.Run("gc", func(b *testing.B) { benchmarkGC(b, n, docs) })
+		})
+
+		d := newDigests(sha256.Size, docs)
+		docs = nil
+		b.Run(fmt.Sprintf("digests/%d", n), func(b *testing.B) {
+			b.Run("hit", func(b *testing.B) {
+				for b.Loop() {
+					i, _ := d.find(hit)
+					_ = d.entries.entry(i)
+				}
+			})
+			b.Run("miss", func(b *testing.B) {
+				for b.Loop() {
+					_, _ = d.find(miss)
+				}
+			})
+			b.Run("gc", func(b *testing.B) { benchmarkGC(b, n, d) })
+		})
+		d = nil
+		runtime.GC()
+	}
+}
+
+// benchmarkGC reports how long a collection takes with set alive, and the
+// heap left per hash of it, most of which it holds.
+func benchmarkGC(b *testing.B, n int, set any) {
+	var m runtime.MemStats
+	var took time.Duration
+	for b.Loop() {
+		start := time.Now()
+		runtime.GC()
+		took += time.Since(start)
+	}
+	runtime.ReadMemStats(&m)
+	b.ReportMetric(float64(took.Nanoseconds())/float64(b.N), "ns/gc")
+	b.ReportMetric(float64(m.HeapAlloc)/float64(n), "heap-B/hash")
+	runtime.KeepAlive(set)
+}
@@ -0,0 +1,71 @@
+package allowlist
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/romshark/gqlhash/v2/internal/embedded"
+)
+
+// Fetched is an allowlist fetched rather than read from a file, such as over
+// HTTP: what a file of its name would hold, see [Allowlist.ReloadFetched].
+type Fetched struct {
+	// Name is what it's called, such as the URL it was fetched from. Its
+	// extension says what it is, as a file's does: a bundle, a manifest under
+	// [Config.Manifests] or a document, and otherwise a snapshot.
+	Name string
+	Data []byte
+
+	// Signature is the signature of a bundle, fetched beside it, see
+	// [SignatureExt]. Nil where there's none.
+	Signature []byte
+}
+
+// ReloadFetched is [Allowlist.Reload] of f, read as a file named like it
+// would be. The documents of a bundle are named by f.Name and their path in
+// it, and the documents of a manifest or of a document by f.Name.
+func (a *Allowlist) ReloadFetched(f Fetched) (Result, error) {
+	return a.load("", true, a.fetchedReader(f))
+}
+
+// CheckFetched is [Allowlist.Check] of f: what [Allowlist.ReloadFetched]
+// would publish and change.
+func (a *Allowlist) CheckFetched(f Fetched) (Result, error) {
+	return a.load("", false, a.fetchedReader(f))
+}
+
+// fetchedReader is what [Allowlist.load] reads of f.
+func (a *Allowlist) fetchedReader(f Fetched) func(ref string) (map[string]*Entry, Result, error) {
+	return func(ref string) (map[string]*Entry, Result, error) {
+		base := path.Base(f.Name)
+		switch {
+		case ref != "":
+			return nil, Result{}, fmt.Errorf(
+				"%s: fetched, so it can't be read from Git at %s", f.Name, ref)
+		case IsBundle(base):
+			files, err := verifyBundle(bundleFormat(base), f.Data, f.Signature,
+				a.config.BundleKeys)
+			if err != nil {
+				return nil, Result{}, fmt.Errorf("bundle %s: %w", f.Name, err)
+			}
+			docs, result := a.readTree(files, func(file string) string {
+				return f.Name + "/" + file
+			})
+			return docs, result, nil
+		case len(a.config.BundleKeys) > 0:
+			return nil, Result{}, fmt.Errorf("%s: %w", f.Name, ErrNotBundle)
+		case !a.config.Manifests && embedded.IsManifest(base):
+			return nil, Result{}, fmt.Errorf("%s: %w", f.Name, errManifest)
+		}
+		if doc, _ := a.classify(base); doc {
+			docs, result := a.readTree(map[string][]byte{base: f.Data},
+				func(string) string { return f.Name })
+			return docs, result, nil
+		}
+		docs, files, err := a.readSnapshot(f.Data)
+		if err != nil {
+			return nil, Result{}, fmt.Errorf("snapshot %s: %w", f.Name, err)
+		}
+		return docs, Result{Files: files}, nil
+	}
+}
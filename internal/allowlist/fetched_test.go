@@ -0,0 +1,92 @@
+package allowlist_test
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+)
+
+// TestAllowlistFetched covers an allowlist fetched rather than read from a
+// file, read as what its name says: a manifest, a document, a bundle or a
+// snapshot, each named by the name it was fetched by.
+func TestAllowlistFetched(t *testing.T) {
+	const url = "https://allowlists.example.com/web"
+	public, private := newKey(t)
+
+	t.Run("manifest", func(t *testing.T) {
+		list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+			allowlist.Config{Manifests: true})
+		r, err := list.ReloadFetched(allowlist.Fetched{
+			Name: url + "/requests.jsonl", Data: []byte(`{"query":"query D { d }"}` + "\n"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := []string{url + "/requests.jsonl:1:11"}; !slices.Equal(r.Files, expect) {
+			t.Errorf("expected %v; received %v", expect, r.Files)
+		}
+		_, err = allowlist.New(sha256.New, gqlhash.Options{}).ReloadFetched(allowlist.Fetched{
+			Name: url + "/requests.jsonl", Data: []byte(`{"query":"query D { d }"}` + "\n"),
+		})
+		if err == nil || !strings.Contains(err.Error(), "manifests aren't read") {
+			t.Errorf("expected a manifest refused without Manifests; received %v", err)
+		}
+	})
+	t.Run("document", func(t *testing.T) {
+		list := allowlist.New(sha256.New, gqlhash.Options{})
+		r, err := list.ReloadFetched(allowlist.Fetched{
+			Name: url + "/a.graphql", Data: []byte("{ a }"),
+		})
+		if err != nil || !slices.Equal(r.Files, []string{url + "/a.graphql"}) ||
+			!list.Allowed(hashOf(t, "{ a }")) {
+			t.Errorf("expected { a } allowed; received %v, %v", r.Files, err)
+		}
+	})
+	t.Run("bundle", func(t *testing.T) {
+		data := archive(t, "tgz", map[string]string{"queries/a.graphql": "{ a }"})
+		list := bundleAllowlist(public)
+		r, err := list.ReloadFetched(allowlist.Fetched{
+			Name: url + "/q.tgz", Data: data, Signature: ed25519.Sign(private, data),
+		})
+		if err != nil || !slices.Equal(r.Files, []string{url + "/q.tgz/queries/a.graphql"}) {
+			t.Errorf("expected the bundle read; received %v, %v", r.Files, err)
+		}
+		_, err = list.ReloadFetched(allowlist.Fetched{Name: url + "/q.tgz", Data: data})
+		if !errors.Is(err, allowlist.ErrUnsigned) {
+			t.Errorf("expected ErrUnsigned; received %v", err)
+		}
+		_, err = list.ReloadFetched(allowlist.Fetched{Name: url + "/a.graphql"})
+		if !errors.Is(err, allowlist.ErrNotBundle) {
+			t.Errorf("expected ErrNotBundle; received %v", err)
+		}
+	})
+	t.Run("snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		writeDoc(t, dir, "a.graphql", "{ a }")
+		data, err := os.ReadFile(snapshotOf(t, dir, gqlhash.Options{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+			allowlist.Config{Function: "sha2"})
+		if _, err := list.ReloadFetched(allowlist.Fetched{
+			Name: url + "/allowlist.snapshot", Data: data,
+		}); err != nil || !list.Allowed(hashOf(t, "{ a }")) {
+			t.Errorf("expected the snapshot read; received %v", err)
+		}
+		// Anything else is no snapshot.
+		_, err = list.CheckFetched(allowlist.Fetched{
+			Name: url + "/index.html", Data: []byte("<html>"),
+		})
+		if err == nil || !strings.Contains(err.Error(), "not an allowlist snapshot") {
+			t.Errorf("expected no snapshot; received %v", err)
+		}
+	})
+}
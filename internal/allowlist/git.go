@@ -0,0 +1,137 @@
+package allowlist
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNoRevision is a ref that names no commit of the repository an allowlist
+// is read from, see [Config.GitRef].
+var ErrNoRevision = errors.New("no such commit")
+
+// readGit reads dir, the root or a directory of a Git repository, as the
+// commit ref names holds it. It's named as a directory of the files checked
+// out would be, so moving an allowlist from one to the other renames nothing.
+func (a *Allowlist) readGit(dir, ref string) (map[string]*Entry, Result, error) {
+	commit, files, err := readRevision(dir, ref, func(file string) bool {
+		doc, schemaFile := a.classify(path.Base(file))
+		return !hidden(file) && (doc || schemaFile)
+	})
+	if err != nil {
+		return nil, Result{}, fmt.Errorf("%s at %s: %w", dir, ref, err)
+	}
+	docs, result := a.readTree(files, func(file string) string {
+		return filepath.Join(dir, filepath.FromSlash(file))
+	})
+	result.Ref, result.Commit = ref, commit
+	return docs, result, nil
+}
+
+// readRevision returns the commit ref names in the repository of dir, and the
+// files under dir in it that want takes, by their path under dir. It reads
+// what the repository holds with the plumbing of git: the working tree, the
+// index and what's checked out make no difference.
+//
+// A link among the files fails the read, as it does a bundle's: where it
+// points is no file of the commit.
+func readRevision(
+	dir, ref string, want func(file string) bool,
+) (commit string, files map[string][]byte, err error) {
+	// Where dir is a directory of a working tree, the path to it from the
+	// root; empty for the root and for a bare repository.
+	prefix, err := git(dir, nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", nil, err
+	}
+	named, err := git(dir, nil,
+		"rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		return "", nil, ErrNoRevision
+	}
+	commit = strings.TrimSpace(string(named))
+
+	tree := commit + ":" + strings.TrimSuffix(strings.TrimSpace(string(prefix)), "/")
+	// Without --full-tree, ls-tree run in a directory of a working tree lists
+	// only what of the tree lies under that same path.
+	listing, err := git(dir, nil,
+		"ls-tree", "--full-tree", "-r", "-z", "--end-of-options", tree)
+	if err != nil {
+		return "", nil, err
+	}
+	var objects, paths []string
+	for record := range strings.SplitSeq(string(listing), "\x00") {
+		if record == "" {
+			// What follows the last one.
+			continue
+		}
+		// <mode> SP <type> SP <object> TAB <path>
+		meta, file, _ := strings.Cut(record, "\t")
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "blob" || !want(file) {
+			// A submodule is a commit of another repository, and no file.
+			continue
+		}
+		if fields[0] != "100644" && fields[0] != "100755" {
+			return "", nil, fmt.Errorf("%s: not a regular file", file)
+		}
+		objects, paths = append(objects, fields[2]), append(paths, file)
+	}
+
+	files = make(map[string][]byte, len(paths))
+	if len(objects) == 0 {
+		return commit, files, nil
+	}
+	// One process reads every blob, rather than one per file.
+	out, err := git(dir, []byte(strings.Join(objects, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return "", nil, err
+	}
+	r := bufio.NewReader(bytes.NewReader(out))
+	for _, file := range paths {
+		// <object> SP <type> SP <size> LF <contents> LF
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: reading the blob: %w", file, err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return "", nil, fmt.Errorf("%s: reading the blob: %s", file, header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: reading the blob: %s", file, header)
+		}
+		src := make([]byte, size+1)
+		if _, err := io.ReadFull(r, src); err != nil {
+			return "", nil, fmt.Errorf("%s: reading the blob: %w", file, err)
+		}
+		files[file] = src[:size]
+	}
+	return commit, files, nil
+}
+
+// git runs git in dir with args, stdin its input, and returns what it wrote
+// to stdout. The error of one that fails is what it wrote to stderr.
+func git(dir string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && len(bytes.TrimSpace(exit.Stderr)) > 0 {
+		return nil, fmt.Errorf("git %s: %s", args[0], bytes.TrimSpace(exit.Stderr))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
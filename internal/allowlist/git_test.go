@@ -0,0 +1,174 @@
+package allowlist_test
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+)
+
+// runGit runs git in dir and returns what it printed, trimmed.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newRepo returns an empty repository, skipping the test without git.
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git on PATH")
+	}
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	return dir
+}
+
+// commitAll commits everything in repo, tags it tag, and returns the commit.
+func commitAll(t *testing.T, repo, tag string) string {
+	t.Helper()
+	runGit(t, repo, "add", "-A")
+	runGit(t, repo, "commit", "-q", "--allow-empty", "-m", tag)
+	runGit(t, repo, "tag", tag)
+	return runGit(t, repo, "rev-parse", "HEAD")
+}
+
+func gitAllowlist(ref string) *allowlist.Allowlist {
+	return allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+		allowlist.Config{GitRef: ref})
+}
+
+// TestAllowlistGit covers an allowlist read from a directory of a repository
+// as a tag holds it, whatever is checked out or changed since, and moved to
+// another tag by a reload at it.
+func TestAllowlistGit(t *testing.T) {
+	repo := newRepo(t)
+	dir := filepath.Join(repo, "queries")
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	writeDoc(t, dir, "schema.graphqls", "type Query { a: Int b: Int }")
+	writeDoc(t, dir, ".hidden/c.graphql", "{ a b }")
+	writeDoc(t, repo, "outside.graphql", "{ b }")
+	v1 := commitAll(t, repo, "v1")
+	writeDoc(t, dir, "b.graphql", "{ b }")
+	if err := os.Remove(filepath.Join(dir, "a.graphql")); err != nil {
+		t.Fatal(err)
+	}
+	v2 := commitAll(t, repo, "v2")
+	// Neither committed nor a document of either tag.
+	writeDoc(t, dir, "c.graphql", "{ c }")
+
+	list := gitAllowlist("v1")
+	r, err := list.Reload(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{filepath.Join(dir, "a.graphql")}
+	if !slices.Equal(r.Files, expect) || r.Ref != "v1" || r.Commit != v1 {
+		t.Errorf("expected %v at v1 %s; received %v at %s %s",
+			expect, v1, r.Files, r.Ref, r.Commit)
+	}
+	if r.SchemaErr != nil || len(r.Skipped) != 0 {
+		t.Errorf("expected the schema of v1 read; received %v, %v", r.SchemaErr, r.Skipped)
+	}
+	if ref, commit := list.Revision(); ref != "v1" || commit != v1 {
+		t.Errorf("expected v1 %s; received %s %s", v1, ref, commit)
+	}
+
+	// A dry run at v2 moves nothing.
+	r, err = list.CheckAt(dir, "v2")
+	if err != nil || r.Commit != v2 || len(r.Added) != 1 || len(r.Removed) != 1 {
+		t.Errorf("expected v2 to add b and remove a; received %+v, %v", r, err)
+	}
+	if ref, _ := list.Revision(); ref != "v1" || !list.Allowed(hashOf(t, "{ a }")) {
+		t.Errorf("expected the dry run to leave the allowlist at v1; received %s", ref)
+	}
+
+	if _, err := list.ReloadAt(dir, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if !list.Allowed(hashOf(t, "{ b }")) || list.Allowed(hashOf(t, "{ a }")) {
+		t.Error("expected the documents of v2")
+	}
+	// A reload rereads the ref it was moved to.
+	if r, err := list.Reload(dir); err != nil || r.Ref != "v2" || r.Commit != v2 {
+		t.Errorf("expected a reload at v2; received %s %s, %v", r.Ref, r.Commit, err)
+	}
+
+	// A ref naming nothing moves nothing.
+	_, err = list.ReloadAt(dir, "v3")
+	if !errors.Is(err, allowlist.ErrNoRevision) {
+		t.Errorf("expected ErrNoRevision; received %v", err)
+	}
+	if ref, commit := list.Revision(); ref != "v2" || commit != v2 {
+		t.Errorf("expected the allowlist at v2; received %s %s", ref, commit)
+	}
+
+	// Nothing changes where a watch could see it.
+	if files, err := list.Files(dir); err != nil || len(files) != 0 {
+		t.Errorf("expected no files to watch; received %v, %v", files, err)
+	}
+}
+
+// TestAllowlistGitRefused covers what a Git allowlist can't read.
+func TestAllowlistGitRefused(t *testing.T) {
+	repo := newRepo(t)
+	writeDoc(t, repo, "a.graphql", "{ a }")
+	commitAll(t, repo, "v1")
+
+	t.Run("missing directory", func(t *testing.T) {
+		dir := filepath.Join(repo, "later")
+		writeDoc(t, dir, "b.graphql", "{ b }")
+		_, err := gitAllowlist("v1").Reload(dir)
+		if err == nil || !strings.Contains(err.Error(), "at v1") {
+			t.Errorf("expected the directory missing at v1; received %v", err)
+		}
+	})
+	t.Run("not a repository", func(t *testing.T) {
+		dir := t.TempDir()
+		writeDoc(t, dir, "a.graphql", "{ a }")
+		if _, err := gitAllowlist("v1").Reload(dir); err == nil {
+			t.Error("expected an error")
+		}
+	})
+	t.Run("symlink", func(t *testing.T) {
+		repo := newRepo(t)
+		if err := os.Symlink("/etc/passwd", filepath.Join(repo, "a.graphql")); err != nil {
+			t.Skip(err)
+		}
+		commitAll(t, repo, "v1")
+		_, err := gitAllowlist("v1").Reload(repo)
+		if err == nil || !strings.Contains(err.Error(), "a.graphql: not a regular file") {
+			t.Errorf("expected the link refused; received %v", err)
+		}
+	})
+	t.Run("bundle keys", func(t *testing.T) {
+		public, _ := newKey(t)
+		list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+			allowlist.Config{GitRef: "v1", BundleKeys: []ed25519.PublicKey{public}})
+		if _, err := list.Reload(repo); !errors.Is(err, allowlist.ErrNotBundle) {
+			t.Errorf("expected ErrNotBundle; received %v", err)
+		}
+	})
+	t.Run("not from Git", func(t *testing.T) {
+		list := allowlist.New(sha256.New, gqlhash.Options{})
+		if _, err := list.ReloadAt(repo, "v1"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package allowlist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romshark/gqlhash/v2/internal/operations"
+)
+
+// Entry is what's known of a document on the allowlist besides its hash: where
+// it's from, and what its leading comment block says of it, see [MetadataPrefix].
+// A lookup returns a copy whose Name and Operations are shared with every other
+// lookup of it, so they're read and never written.
+type Entry struct {
+	// Name is what [Result.Files] calls the document: the file, and for one
+	// embedded in a source file the line and the column it begins at too.
+	Name string
+
+	// Operations are the names of the operations of the document, in its
+	// order, an anonymous one leaving none. A request runs one of them, and
+	// names it where there are several, see [Entry.Runs].
+	Operations []string
+
+	// Owner is who answers for the document, Client what sends it, and Notes
+	// what else is said of it, every line of notes joined by a newline.
+	Owner, Client, Notes string
+
+	// Added is when the document was put on the allowlist, and Expires when
+	// it's no longer meant to be on it. The zero time where not said.
+	Added, Expires time.Time
+
+	// HashOnly is set for an entry a manifest gives as a hash and no
+	// document, see [Config.Manifests]: what it runs is unknown, so it runs
+	// whatever operation a request names, and no other scheme finds it.
+	HashOnly bool
+
+	// Label numbers the pair of Owner and Client, from 0 for neither up: every
+	// entry naming the same pair has the same, in any allowlist and across
+	// reloads, so a count per pair is a slot of a slice. A label no published
+	// allowlist names anymore goes to the next pair new to them, so the labels
+	// are as many as the pairs named at once. 0 in what [Allowlist.Check] reads.
+	Label int
+
+	// schemeKeys are the hashes of the document under each of
+	// [Config.Schemes] while it's read, nil once it's published.
+	schemeKeys []string
+}
+
+// labels is every [Entry.Label] the published allowlists hold, by the pair it
+// numbers, with how many of them name it, see [holdLabels].
+var labels = struct {
+	mu    sync.Mutex
+	index map[[2]string]int
+	// uses is how many published lists name the pair of each label, and free
+	// the labels none does. The one of neither is never freed.
+	uses []int
+	free []int
+}{index: map[[2]string]int{{"", ""}: 0}, uses: []int{1}}
+
+// holdLabels sets the [Entry.Label] of every entry of docs, numbering a pair
+// that's new, and holds the label of each pair for a list about to be
+// published. It returns the pairs, which [releaseLabels] gives back once the
+// list is replaced or isn't published after all.
+func holdLabels(docs map[string]*Entry) [][2]string {
+	labels.mu.Lock()
+	defer labels.mu.Unlock()
+	held := make(map[[2]string]int)
+	var pairs [][2]string
+	for _, e := range docs {
+		pair := [2]string{e.Owner, e.Client}
+		i, ok := held[pair]
+		if !ok {
+			i = labelOf(pair)
+			labels.uses[i]++
+			held[pair] = i
+			pairs = append(pairs, pair)
+		}
+		e.Label = i
+	}
+	return pairs
+}
+
+// labelOf is the label of pair, numbering it where it's new: a freed label
+// first, the next one else. labels.mu is held.
+func labelOf(pair [2]string) int {
+	if i, ok := labels.index[pair]; ok {
+		return i
+	}
+	i := len(labels.uses)
+	if n := len(labels.free); n > 0 {
+		i, labels.free = labels.free[n-1], labels.free[:n-1]
+	} else {
+		labels.uses = append(labels.uses, 0)
+	}
+	labels.index[pair] = i
+	return i
+}
+
+// releaseLabels gives back what [holdLabels] held for pairs, freeing the label
+// of a pair no published list names anymore.
+func releaseLabels(pairs [][2]string) {
+	labels.mu.Lock()
+	defer labels.mu.Unlock()
+	for _, pair := range pairs {
+		i := labels.index[pair]
+		if labels.uses[i]--; labels.uses[i] == 0 {
+			delete(labels.index, pair)
+			labels.free = append(labels.free, i)
+		}
+	}
+}
+
+// Runs reports whether a request carrying the document of e may run the
+// operation it names, operation, empty where it names none: one of
+// [Entry.Operations], and without a name the document's only operation.
+// A document of several operations runs none unnamed, which a GraphQL server
+// refuses anyway. A [Entry.HashOnly] one runs any.
+func (e *Entry) Runs(operation []byte) bool {
+	switch {
+	case e.HashOnly:
+		return true
+	case len(operation) == 0:
+		return len(e.Operations) < 2
+	}
+	for _, o := range e.Operations {
+		if o == string(operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMetadata reports whether the leading comment block of e's document said
+// anything of it, see [MetadataPrefix].
+func (e *Entry) hasMetadata() bool {
+	return e.Owner != "" || e.Client != "" || e.Notes != "" ||
+		!e.Added.IsZero() || !e.Expires.IsZero()
+}
+
+// MetadataPrefix begins a comment of the leading comment block of a document
+// that says something of its entry, a key and a value:
+//
+//	# gqlhash: owner: payments
+//	# gqlhash: client: ios
+//	# gqlhash: added: 2026-01-02
+//	# gqlhash: expires: 2027-06-30T12:00:00Z
+//	# gqlhash: notes: the checkout of the old app
+//	query Checkout { … }
+//
+// A time is a date, midnight UTC, or an RFC 3339 time. notes may be given on
+// several lines. A comment is left out of the hash, so metadata changes nothing
+// of what a request matches.
+const MetadataPrefix = "gqlhash:"
+
+// metadataKeys are the keys a metadata comment may carry.
+var metadataKeys = []string{"owner", "client", "added", "expires", "notes"}
+
+// metadataError is a metadata comment that can't be read, at the byte offset
+// of the document the comment begins at.
+type metadataError struct {
+	offset int
+	err    error
+}
+
+func (e *metadataError) Error() string { return e.err.Error() }
+
+// entryOf is the entry of the document src called name, which holds ops, its
+// metadata read off the leading comment block of src. A comment that can't be
+// read fails it, a key given twice or one nobody reads among them: the metadata
+// it was meant to carry would be missing or wrong without anyone noticing.
+func entryOf(name string, src []byte, ops []operations.Operation) (*Entry, error) {
+	e := &Entry{Name: name}
+	for _, o := range ops {
+		if o.Name != "" {
+			e.Operations = append(e.Operations, o.Name)
+		}
+	}
+
+	var seen []string
+	for offset := 0; offset < len(src); {
+		line := src[offset:]
+		next := len(src)
+		if i := bytes.IndexAny(line, "\r\n"); i >= 0 {
+			line, next = line[:i], offset+i+1
+		}
+		at := offset
+		offset = next
+
+		trimmed := bytes.TrimLeft(line, " \t\uFEFF")
+		if len(trimmed) == 0 {
+			continue
+		}
+		if trimmed[0] != '#' {
+			// The first definition ends the leading comment block.
+			break
+		}
+		comment := strings.TrimSpace(string(trimmed[1:]))
+		text, ok := strings.CutPrefix(comment, MetadataPrefix)
+		if !ok {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		at += len(line) - len(trimmed)
+		key, value, ok := strings.Cut(text, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case !ok:
+			return nil, &metadataError{at,
+				fmt.Errorf("metadata %q: expected key: value", text)}
+		case !slices.Contains(metadataKeys, key):
+			return nil, &metadataError{at,
+				fmt.Errorf("unknown metadata key %q, expected one of %s",
+					key, strings.Join(metadataKeys, ", "))}
+		case key != "notes" && slices.Contains(seen, key):
+			return nil, &metadataError{at,
+				fmt.Errorf("metadata key %q given twice", key)}
+		}
+		seen = append(seen, key)
+
+		var err error
+		switch key {
+		case "owner":
+			e.Owner = value
+		case "client":
+			e.Client = value
+		case "notes":
+			if e.Notes != "" {
+				e.Notes += "\n"
+			}
+			e.Notes += value
+		case "added":
+			e.Added, err = parseTime(value)
+		case "expires":
+			e.Expires, err = parseTime(value)
+		}
+		if err != nil {
+			return nil, &metadataError{at, fmt.Errorf("metadata %s: %w", key, err)}
+		}
+	}
+	return e, nil
+}
+
+// parseTime reads a time of a metadata comment, see [MetadataPrefix].
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("expected a date, 2006-01-02, or an RFC 3339 time")
+	}
+	return t, nil
+}
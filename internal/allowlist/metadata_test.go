@@ -0,0 +1,332 @@
+package allowlist_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+)
+
+// TestMetadata covers the entry a lookup finds: the metadata of the leading
+// comment block of its document, and the names of its operations.
+func TestMetadata(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "checkout.graphql", "# The checkout.\n"+
+		"# gqlhash: owner: payments\n"+
+		"#gqlhash:client:ios\n"+
+		"\n"+
+		"# gqlhash: added: 2026-01-02\n"+
+		"# gqlhash: expires: 2126-06-30T12:00:00+02:00\n"+
+		"# gqlhash: notes: the old app\n"+
+		"# gqlhash: notes: see PAY-12\n"+
+		"query Checkout { cart }\n"+
+		"# gqlhash: owner: nobody, past the first definition\n")
+	writeDoc(t, dir, "plain.graphql", "{ a }")
+
+	list, reload := newAllowlist(t, dir)
+	if r, err := reload(); err != nil || len(r.Skipped) != 0 {
+		t.Fatalf("unexpected result: %+v, %v", r, err)
+	}
+
+	e, ok := list.Lookup(hashOf(t, "query Checkout { cart }"))
+	if !ok {
+		t.Fatal("expected the document to be allowed")
+	}
+	expect := allowlist.Entry{
+		Name:       filepath.Join(dir, "checkout.graphql"),
+		Operations: []string{"Checkout"},
+		Owner:      "payments", Client: "ios", Notes: "the old app\nsee PAY-12",
+		Added:   time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		Expires: time.Date(2126, 6, 30, 10, 0, 0, 0, time.UTC),
+	}
+	if e.Name != expect.Name || !slices.Equal(e.Operations, expect.Operations) ||
+		e.Owner != expect.Owner || e.Client != expect.Client ||
+		e.Notes != expect.Notes || !e.Added.Equal(expect.Added) ||
+		!e.Expires.Equal(expect.Expires) {
+		t.Errorf("expected %+v; received %+v", expect, e)
+	}
+
+	// A document without metadata has an entry all the same.
+	if e, ok := list.Lookup(hashOf(t, "{ a }")); !ok ||
+		e.Name != filepath.Join(dir, "plain.graphql") || e.Owner != "" ||
+		!e.Expires.IsZero() || len(e.Operations) != 0 {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+
+	// A snapshot carries it.
+	snapshot := allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+		allowlist.Config{Function: "sha2"})
+	if _, err := snapshot.Reload(snapshotOf(t, dir, gqlhash.Options{})); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := snapshot.Lookup(hashOf(t, "query Checkout { cart }")); !ok ||
+		e.Owner != expect.Owner || e.Notes != expect.Notes ||
+		!e.Expires.Equal(expect.Expires) || !slices.Equal(e.Operations, expect.Operations) {
+		t.Errorf("expected the snapshot to carry the metadata; received %+v", e)
+	}
+}
+
+// TestMetadataLabels covers the labels of owners and clients across reloads:
+// a pair keeps its label while it's named, and one no allowlist names anymore
+// gives its label to the next, so reloads naming new pairs don't add labels.
+func TestMetadataLabels(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "kept.graphql", "# gqlhash: owner: kept\n{ kept }")
+	list, reload := newAllowlist(t, dir)
+	labelOf := func(document string) int {
+		t.Helper()
+		e, ok := list.Lookup(hashOf(t, document))
+		if !ok {
+			t.Fatalf("expected %q to be allowed", document)
+		}
+		return e.Label
+	}
+
+	used := make(map[int]bool)
+	var kept int
+	for i := range 20 {
+		writeDoc(t, dir, "team.graphql", fmt.Sprintf("# gqlhash: owner: team-%d\n{ team }", i))
+		if _, err := reload(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			kept = labelOf("{ kept }")
+		} else if l := labelOf("{ kept }"); l != kept {
+			t.Fatalf("expected the label %d kept; received %d", kept, l)
+		}
+		used[labelOf("{ team }")] = true
+	}
+	// The next list holds its labels before the one it replaces gives its
+	// own back, so two take turns.
+	if len(used) > 2 {
+		t.Errorf("expected the labels of owners gone reused; received %v", used)
+	}
+}
+
+// TestMetadataInvalid covers a metadata comment that can't be read: the
+// document is skipped, named at the comment, and the rest are served.
+func TestMetadataInvalid(t *testing.T) {
+	for _, td := range []struct {
+		name, src, expect string
+	}{
+		{
+			"unknown key", "# gqlhash: team: payments\n{ a }",
+			`:1:1: unknown metadata key "team"`,
+		},
+		{
+			"no value", "\n  # gqlhash: owner\n{ a }",
+			`:2:3: metadata "owner": expected key: value`,
+		},
+		{
+			"twice", "# gqlhash: owner: a\n# gqlhash: owner: b\n{ a }",
+			`:2:1: metadata key "owner" given twice`,
+		},
+		{
+			"time", "# gqlhash: expires: next week\n{ a }",
+			":1:1: metadata expires: expected a date",
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeDoc(t, dir, "a.graphql", td.src)
+			writeDoc(t, dir, "b.graphql", "{ b }")
+			list, reload := newAllowlist(t, dir)
+			r, err := reload()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect := filepath.Join(dir, "a.graphql") + td.expect
+			if len(r.Skipped) != 1 || !strings.Contains(r.Skipped[0].Error(), expect) {
+				t.Errorf("expected %q skipped; received %v", td.expect, r.Skipped)
+			}
+			if !list.Allowed(hashOf(t, "{ b }")) || list.Len() != 1 {
+				t.Error("expected the rest to be served")
+			}
+		})
+	}
+}
+
+// TestExpiry covers a document with an expiry as the clock passes it: allowed,
+// then in its sunset and still allowed, then refused, with every load
+// reporting the documents in their sunset and past it.
+func TestExpiry(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "old.graphql", "# gqlhash: expires: 2030-01-10\n{ old }")
+	writeDoc(t, dir, "older.graphql", "# gqlhash: expires: 2030-01-05\n{ older }")
+	writeDoc(t, dir, "kept.graphql", "{ kept }")
+
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{}, allowlist.Config{
+		SunsetWindow: 7 * 24 * time.Hour,
+		Now:          func() time.Time { return now },
+	})
+	names := func(entries []*allowlist.Entry) []string {
+		var n []string
+		for _, e := range entries {
+			n = append(n, filepath.Base(e.Name))
+		}
+		return n
+	}
+
+	for _, td := range []struct {
+		now                    time.Time
+		expectOld, expectOlder allowlist.Status
+		expectExpiring         []string
+		expectExpired          []string
+	}{
+		{
+			now:       time.Date(2029, 12, 1, 0, 0, 0, 0, time.UTC),
+			expectOld: allowlist.StatusAllowed, expectOlder: allowlist.StatusAllowed,
+		},
+		{
+			now:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+			expectOld: allowlist.StatusAllowed, expectOlder: allowlist.StatusSunset,
+			expectExpiring: []string{"older.graphql"},
+		},
+		{
+			now:       time.Date(2030, 1, 4, 0, 0, 0, 0, time.UTC),
+			expectOld: allowlist.StatusSunset, expectOlder: allowlist.StatusSunset,
+			expectExpiring: []string{"older.graphql", "old.graphql"},
+		},
+		{
+			now:       time.Date(2030, 1, 5, 0, 0, 0, 0, time.UTC),
+			expectOld: allowlist.StatusSunset, expectOlder: allowlist.StatusExpired,
+			expectExpiring: []string{"old.graphql"},
+			expectExpired:  []string{"older.graphql"},
+		},
+	} {
+		now = td.now
+		r, err := list.Reload(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(names(r.Expiring), td.expectExpiring) ||
+			!slices.Equal(names(r.Expired), td.expectExpired) {
+			t.Errorf("%v: expected expiring %v and expired %v; received %v and %v",
+				now, td.expectExpiring, td.expectExpired,
+				names(r.Expiring), names(r.Expired))
+		}
+		if _, s := list.Find(hashOf(t, "{ old }")); s != td.expectOld {
+			t.Errorf("%v: expected old.graphql %d; received %d", now, td.expectOld, s)
+		}
+		if _, s := list.Find(hashOf(t, "{ older }")); s != td.expectOlder {
+			t.Errorf("%v: expected older.graphql %d; received %d", now, td.expectOlder, s)
+		}
+		if list.Allowed(hashOf(t, "{ older }")) != td.expectOlder.Allows() {
+			t.Errorf("%v: expected Allowed to follow the status of older.graphql", now)
+		}
+		if _, s := list.Find(hashOf(t, "{ kept }")); s != allowlist.StatusAllowed {
+			t.Errorf("%v: expected a document without expiry allowed; received %d", now, s)
+		}
+	}
+
+	// The clock is read at the lookup, not at the load.
+	now = time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC)
+	if e, s := list.Find(hashOf(t, "{ old }")); s != allowlist.StatusExpired ||
+		e.Expires.IsZero() || list.Allowed(hashOf(t, "{ old }")) {
+		t.Errorf("expected old.graphql expired without a reload; received %d", s)
+	}
+	if _, s := list.Find(hashOf(t, "{ unknown }")); s != allowlist.StatusUnknown {
+		t.Errorf("expected a document not on the allowlist unknown; received %d", s)
+	}
+}
+
+// TestOperations covers a document of several operations: allowed whole and
+// each operation alone, named by where it begins, yielding to the same
+// operation in a file of its own, and running an operation it names.
+func TestOperations(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "# gqlhash: owner: accounts\n"+
+		"query A { a }\n"+
+		"query B { b }\n"+
+		"mutation C { c }\n")
+	writeDoc(t, dir, "b.graphql", "query B { b }")
+
+	list, reload := newAllowlist(t, dir)
+	r, err := reload()
+	if err != nil || len(r.Skipped) != 0 {
+		t.Fatalf("unexpected result: %+v, %v", r, err)
+	}
+	a, b := filepath.Join(dir, "a.graphql"), filepath.Join(dir, "b.graphql")
+	// The operations of a.graphql are entries of it, and no files.
+	if expectFiles := []string{a, b}; !slices.Equal(r.Files, expectFiles) ||
+		list.Len() != 4 {
+		t.Errorf("expected files %v and 4 entries; received %v, %d",
+			expectFiles, r.Files, list.Len())
+	}
+
+	whole, ok := list.Lookup(hashOf(t, "query A { a } query B { b } mutation C { c }"))
+	if !ok || whole.Name != a || !slices.Equal(whole.Operations, []string{"A", "B", "C"}) {
+		t.Fatalf("unexpected entry of the whole document: %+v", whole)
+	}
+	for _, td := range []struct {
+		document, expectName, expectOperation string
+	}{
+		{"query A { a }", a + ":2:1", "A"},
+		{"query B { b }", b, "B"},
+		{"mutation C { c }", a + ":4:1", "C"},
+	} {
+		e, ok := list.Lookup(hashOf(t, td.document))
+		if !ok {
+			t.Errorf("expected %q allowed", td.document)
+			continue
+		}
+		if e.Name != td.expectName ||
+			!slices.Equal(e.Operations, []string{td.expectOperation}) {
+			t.Errorf("%q: unexpected entry %+v", td.document, e)
+		}
+		if e.Name != b && e.Owner != "accounts" {
+			t.Errorf("%q: expected the metadata of its document; received %+v",
+				td.document, e)
+		}
+	}
+
+	// A document of several runs the one named and none unnamed, one of a
+	// single operation runs it either way.
+	for _, td := range []struct {
+		entry     *allowlist.Entry
+		operation string
+		expect    bool
+	}{
+		{&whole, "B", true},
+		{&whole, "D", false},
+		{&whole, "", false},
+		{&allowlist.Entry{Operations: []string{"A"}}, "A", true},
+		{&allowlist.Entry{Operations: []string{"A"}}, "", true},
+		{&allowlist.Entry{Operations: []string{"A"}}, "B", false},
+		{&allowlist.Entry{}, "", true},
+		{&allowlist.Entry{}, "A", false},
+	} {
+		if td.entry.Runs([]byte(td.operation)) != td.expect {
+			t.Errorf("%v running %q: expected %t", td.entry.Operations,
+				td.operation, td.expect)
+		}
+	}
+}
+
+// TestOperationsDescribed covers a document of several operations carrying
+// descriptions, which the hash leaves out: its operations are recorded all
+// the same, so it runs the one named and none unnamed.
+func TestOperationsDescribed(t *testing.T) {
+	dir := t.TempDir()
+	const doc = "\"desc\"\nquery A { a }\n\"d2\"\nquery B { b }"
+	writeDoc(t, dir, "a.graphql", doc)
+
+	list, reload := newAllowlist(t, dir)
+	if r, err := reload(); err != nil || len(r.Skipped) != 0 {
+		t.Fatalf("unexpected result: %+v, %v", r, err)
+	}
+	e, ok := list.Lookup(hashOf(t, doc))
+	if !ok || !slices.Equal(e.Operations, []string{"A", "B"}) {
+		t.Fatalf("expected the operations A and B; received %+v", e)
+	}
+	if e.Runs(nil) || !e.Runs([]byte("A")) || !e.Runs([]byte("B")) || e.Runs([]byte("C")) {
+		t.Error("expected A and B run by name alone")
+	}
+}
@@ -0,0 +1,128 @@
+package allowlist
+
+import (
+	"fmt"
+	"hash"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/romshark/gqlhash/v2"
+)
+
+// Scheme is a hash function and the options of the canonical form it hashes,
+// which an allowlist hashes every document under besides its own, see
+// [Config.Schemes]. Moving from one to another changes every hash at once;
+// an allowlist keyed by both finds a document by either while the clients
+// and the caches computing them move over.
+type Scheme struct {
+	// Name is what the scheme is reported by, such as the flag it was given as.
+	Name string
+
+	NewHash func() hash.Hash
+	Options gqlhash.Options
+}
+
+// FindScheme is [Allowlist.Find] of key hashed under the i-th of
+// [Config.Schemes]: the same entries, found by another hash. A document is
+// found by its hash under each scheme, so a request is checked against each
+// in turn until one finds it.
+func (a *Allowlist) FindScheme(i int, key []byte) (Entry, Status) {
+	l := a.current.Load()
+	if l == nil || i < 0 || i >= len(l.schemes) {
+		return Entry{}, StatusUnknown
+	}
+	s := l.schemes[i]
+	j, ok := s.find(key)
+	if !ok {
+		return Entry{}, StatusUnknown
+	}
+	return a.at(l, int(s.at[j]))
+}
+
+// schemeDigests packs docs by their hash under each of [Config.Schemes],
+// each hash pointing at the entry of main, which packs docs by their own,
+// and leaves the hashes off them. Documents hashing alike under a scheme
+// are left out of it, and reported: which a request meant is unknowable,
+// as it is where they hash alike under the allowlist's own, but each is
+// still found by the hashes that tell it apart.
+func (a *Allowlist) schemeDigests(
+	docs map[string]*Entry, main *digests,
+) ([]*digests, []error) {
+	if len(a.config.Schemes) == 0 {
+		return nil, nil
+	}
+	// By name, so what's reported is in the same order on every reload.
+	entries := slices.SortedFunc(maps.Values(docs), func(a, b *Entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	var skipped []error
+	keyed := make([]map[string]*Entry, len(a.config.Schemes))
+	for i, s := range a.config.Schemes {
+		keyOf := func(e *Entry) string {
+			if i < len(e.schemeKeys) {
+				return e.schemeKeys[i]
+			}
+			return ""
+		}
+		byHash := make(map[string][]*Entry, len(entries))
+		for _, e := range entries {
+			// Empty where the document doesn't hash under it.
+			if key := keyOf(e); key != "" {
+				byHash[key] = append(byHash[key], e)
+			}
+		}
+		keyed[i] = make(map[string]*Entry, len(byHash))
+		for _, e := range entries {
+			alike := byHash[keyOf(e)]
+			switch {
+			case len(alike) == 1:
+				keyed[i][keyOf(e)] = e
+			case len(alike) > 1:
+				var others []string
+				for _, other := range alike {
+					if other != e {
+						others = append(others, other.Name)
+					}
+				}
+				skipped = append(skipped, fmt.Errorf(
+					"%s: the same hash as %s under %s, which finds none of them",
+					e.Name, strings.Join(others, ", "), s.Name))
+			}
+		}
+	}
+	for _, e := range entries {
+		e.schemeKeys = nil
+	}
+	index := make(map[*Entry]uint32, main.len())
+	for i := range main.len() {
+		index[docs[string(main.key(i))]] = uint32(i)
+	}
+	schemes := make([]*digests, len(a.config.Schemes))
+	for i, s := range a.config.Schemes {
+		sorted := slices.Sorted(maps.Keys(keyed[i]))
+		schemes[i] = packKeys(s.NewHash().Size(), sorted)
+		schemes[i].at = make([]uint32, len(sorted))
+		for j, key := range sorted {
+			schemes[i].at[j] = index[keyed[i][key]]
+		}
+	}
+	return schemes, skipped
+}
+
+// schemeKeys hashes src under each of [Config.Schemes], empty where it doesn't
+// parse under one, which leaves the document out of that scheme alone.
+func (r *reader) schemeKeys(src []byte) []string {
+	if len(r.schemes) == 0 {
+		return nil
+	}
+	keys := make([]string, len(r.schemes))
+	for i, h := range r.schemes {
+		h.Reset()
+		if r.p.Parse(h, r.allowlist.config.Schemes[i].Options, src).IsErr() {
+			continue
+		}
+		keys[i] = string(h.Sum(nil))
+	}
+	return keys
+}
@@ -0,0 +1,79 @@
+package allowlist_test
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"strings"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+)
+
+// TestAllowlistSchemes covers an allowlist hashing under another hash
+// function and canonical form besides its own: every document is found by
+// either hash, and documents the other hashes alike are found by their own
+// alone.
+func TestAllowlistSchemes(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a(x: 1) }")
+	writeDoc(t, dir, "b.graphql", "{ a(x: 2) }")
+	writeDoc(t, dir, "c.graphql", "query C { c(x: 1) }")
+	writeDoc(t, dir, "d.graphql", "query D1 { d } query D2 { d(x: 1) }")
+
+	inputs := gqlhash.Options{Ignore: gqlhash.IgnoreInputs}
+	list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{}, allowlist.Config{
+		Schemes: []allowlist.Scheme{{Name: "sha512:inputs", NewHash: sha512.New, Options: inputs}},
+	})
+	r, err := list.Reload(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := func(document string) []byte {
+		t.Helper()
+		sum, err := gqlhash.AppendHash(nil, sha512.New(), inputs, document)
+		if err.IsErr() {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	if e, status := list.FindScheme(0, old("query C { c(x: 5) }")); !status.Allows() ||
+		!strings.HasSuffix(e.Name, "c.graphql") {
+		t.Errorf("expected C under the other scheme; received %v %v", e, status)
+	}
+	if e, status := list.FindScheme(0, old("query D2 { d(x: 3) }")); !status.Allows() ||
+		!strings.HasSuffix(e.Name, "d.graphql:1:16") {
+		t.Errorf("expected the operation D2 under the other scheme; received %v %v", e, status)
+	}
+	// Its own hash isn't the other's.
+	if _, status := list.FindScheme(0, hashOf(t, "query C { c(x: 1) }")); status.Allows() {
+		t.Error("expected the own hash unknown under the other scheme")
+	}
+
+	// a and b hash alike leaving out inputs, so neither is found by it, and
+	// both are by their own hash.
+	if len(r.Skipped) != 2 || !strings.Contains(r.Skipped[0].Error(),
+		"the same hash as "+dir+"/b.graphql under sha512:inputs") {
+		t.Errorf("expected a and b skipped under the other scheme; received %v", r.Skipped)
+	}
+	if _, status := list.FindScheme(0, old("{ a(x: 1) }")); status.Allows() {
+		t.Error("expected { a } unknown under the other scheme")
+	}
+	if !list.Allowed(hashOf(t, "{ a(x: 1) }")) || !list.Allowed(hashOf(t, "{ a(x: 2) }")) {
+		t.Error("expected a and b found by their own hash")
+	}
+	if len(r.Files) != 4 {
+		t.Errorf("expected every document served; received %v", r.Files)
+	}
+
+	if _, status := list.FindScheme(1, old("query C { c }")); status != allowlist.StatusUnknown {
+		t.Errorf("expected no second scheme; received %v", status)
+	}
+
+	// A snapshot holds no document to hash under it.
+	_, err = list.Reload(snapshotOf(t, dir, gqlhash.Options{}))
+	if err == nil || !strings.Contains(err.Error(), "under several") {
+		t.Errorf("expected the snapshot refused; received %v", err)
+	}
+}
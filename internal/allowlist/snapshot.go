@@ -0,0 +1,271 @@
+package allowlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/parser"
+)
+
+// A snapshot is an allowlist as it was loaded: the hash of every document, and
+// its [Entry], under a header naming what the hashes were computed with.
+// Every integer is an unsigned varint, every string its length and its bytes:
+//
+//	magic      snapshotMagic
+//	version    snapshotVersion
+//	function   string, Config.Function
+//	ignore     Options.Ignore
+//	depth      Options.DepthLimit, the limit in force
+//	size       bytes per hash
+//	count      entries
+//	entries    count times: the hash, size bytes, then the entry:
+//	             name, owner, client, notes   strings
+//	             added, expires               strings, RFC 3339, empty for none
+//	             operations                   a count, then as many strings
+//	             hash only                    1 for [Entry.HashOnly], else 0
+//	checksum   4 bytes, the CRC-32 (IEEE) of everything before it, big endian
+//
+// Reading one is a pass over its bytes, parsing no document, so a start or
+// a reload takes as long as the file takes to read.
+const (
+	snapshotMagic   = "gqlhash-allowlist\x00"
+	snapshotVersion = 3
+)
+
+// WriteSnapshot writes to w what the allowlist holds, for a [Allowlist.Reload]
+// to read in place of the directory it was read from, by an allowlist hashing
+// with the same function and options: another refuses it, its hashes being
+// none a request of its has.
+//
+// The entries are sorted by name, so the same allowlist writes the same bytes.
+// An allowlist not yet loaded has nothing to write, which fails.
+func (a *Allowlist) WriteSnapshot(w io.Writer) error {
+	l := a.current.Load()
+	if l == nil {
+		return errors.New("the allowlist isn't loaded")
+	}
+	// The hashes are sorted already, which breaks a tie of names.
+	order := make([]int, l.docs.len())
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return strings.Compare(l.docs.entries.entry(a).Name, l.docs.entries.entry(b).Name)
+	})
+
+	var b []byte
+	b = append(b, snapshotMagic...)
+	b = binary.AppendUvarint(b, snapshotVersion)
+	b = appendString(b, a.config.Function)
+	b = binary.AppendUvarint(b, uint64(a.options.Ignore))
+	b = binary.AppendUvarint(b, uint64(depthLimit(a.options)))
+	b = binary.AppendUvarint(b, uint64(a.newHash().Size()))
+	b = binary.AppendUvarint(b, uint64(len(order)))
+	for _, i := range order {
+		e := l.docs.entries.entry(i)
+		b = append(b, l.docs.key(i)...)
+		b = appendString(b, e.Name)
+		b = appendString(b, e.Owner)
+		b = appendString(b, e.Client)
+		b = appendString(b, e.Notes)
+		b = appendString(b, formatTime(e.Added))
+		b = appendString(b, formatTime(e.Expires))
+		b = binary.AppendUvarint(b, uint64(len(e.Operations)))
+		for _, o := range e.Operations {
+			b = appendString(b, o)
+		}
+		b = binary.AppendUvarint(b, uint64(boolByte(e.HashOnly)))
+	}
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	_, err := w.Write(b)
+	return err
+}
+
+// loadSnapshot reads the snapshot file name, see [Allowlist.WriteSnapshot].
+// A snapshot that can't be read, is damaged or was written with another
+// function or other options fails, and the allowlist holds what it held.
+func (a *Allowlist) loadSnapshot(name string) (map[string]*Entry, Result, error) {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		return nil, Result{}, err
+	}
+	docs, files, err := a.readSnapshot(src)
+	if err != nil {
+		return nil, Result{}, fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	return docs, Result{Files: files}, nil
+}
+
+// readSnapshot reads the entries of the snapshot src, and their names in
+// order.
+func (a *Allowlist) readSnapshot(src []byte) (map[string]*Entry, []string, error) {
+	if len(a.config.Schemes) > 0 {
+		// The documents aren't in it, so there's nothing to hash under the
+		// other schemes.
+		return nil, nil, errors.New(
+			"holds the hashes of one scheme, and the allowlist hashes under several")
+	}
+	if !bytes.HasPrefix(src, []byte(snapshotMagic)) {
+		return nil, nil, errors.New("not an allowlist snapshot")
+	}
+	if len(src) < len(snapshotMagic)+4 {
+		return nil, nil, errors.New("truncated")
+	}
+	body, sum := src[:len(src)-4], src[len(src)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, nil, errors.New("damaged: the checksum doesn't match")
+	}
+
+	r := snapshotReader{b: body[len(snapshotMagic):]}
+	if v := r.uvarint(); r.err == nil && v != snapshotVersion {
+		return nil, nil, fmt.Errorf("version %d, this reads version %d",
+			v, snapshotVersion)
+	}
+	function := r.string()
+	ignore, depth, size := r.uvarint(), r.uvarint(), r.uvarint()
+	count := r.uvarint()
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	switch {
+	case function != a.config.Function:
+		return nil, nil, fmt.Errorf("hashed with %q, the allowlist hashes with %q",
+			function, a.config.Function)
+	case ignore != uint64(a.options.Ignore):
+		return nil, nil, fmt.Errorf("hashed ignoring %s, the allowlist ignores %s",
+			gqlhash.Ignore(ignore), a.options.Ignore)
+	case depth != uint64(depthLimit(a.options)):
+		return nil, nil, fmt.Errorf("hashed with depth limit %d, the allowlist's is %d",
+			depth, depthLimit(a.options))
+	case size != uint64(a.newHash().Size()):
+		return nil, nil, fmt.Errorf("hashes of %d bytes, the allowlist's are %d",
+			size, a.newHash().Size())
+	}
+	// Every entry is a byte at least, so a count past that is damage rather
+	// than a map to allocate.
+	if count > uint64(len(r.b)) {
+		return nil, nil, errors.New("truncated")
+	}
+
+	docs := make(map[string]*Entry, count)
+	files := make([]string, 0, count)
+	for range count {
+		key := string(r.bytes(size))
+		e := &Entry{Name: r.string(), Owner: r.string(), Client: r.string(),
+			Notes: r.string(), Added: r.time(), Expires: r.time()}
+		operations := r.uvarint()
+		if operations > uint64(len(r.b)) {
+			return nil, nil, errors.New("truncated")
+		}
+		for range operations {
+			e.Operations = append(e.Operations, r.string())
+		}
+		switch r.uvarint() {
+		case 0:
+		case 1:
+			e.HashOnly = true
+		default:
+			if r.err == nil {
+				r.err = errors.New("damaged: a hash-only flag that's neither 0 nor 1")
+			}
+		}
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+		if _, ok := docs[key]; ok {
+			return nil, nil, fmt.Errorf("%s: a hash the snapshot holds twice", e.Name)
+		}
+		docs[key] = e
+		files = append(files, e.Name)
+	}
+	if len(r.b) > 0 {
+		return nil, nil, errors.New("damaged: bytes past the last entry")
+	}
+	return docs, files, nil
+}
+
+// snapshotReader reads the fields of a snapshot off b. The first one that
+// can't be read sets err, after which every read is empty.
+type snapshotReader struct {
+	b   []byte
+	err error
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errors.New("truncated")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *snapshotReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.b)) {
+		r.err = errors.New("truncated")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *snapshotReader) string() string {
+	return string(r.bytes(r.uvarint()))
+}
+
+func (r *snapshotReader) time() time.Time {
+	s := r.string()
+	if s == "" || r.err != nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		r.err = fmt.Errorf("damaged: %w", err)
+	}
+	return t
+}
+
+// formatTime is t as a snapshot holds it, see [snapshotReader.time].
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+// depthLimit is the limit options hash with, see [gqlhash.Options.DepthLimit],
+// which is what a snapshot records: an option below 1 is the default.
+func depthLimit(options gqlhash.Options) int {
+	if options.DepthLimit < 1 {
+		return parser.DefaultDepthLimit
+	}
+	return options.DepthLimit
+}
@@ -0,0 +1,186 @@
+package allowlist_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+)
+
+// snapshotOf writes the snapshot of the allowlist of dir, read with options,
+// to a file and returns its name.
+func snapshotOf(t *testing.T, dir string, options gqlhash.Options) string {
+	t.Helper()
+	list := allowlist.NewWithConfig(sha256.New, options,
+		allowlist.Config{Function: "sha2"})
+	if _, err := list.Reload(dir); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := list.WriteSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "allowlist.snapshot")
+	if err := os.WriteFile(name, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// TestSnapshot covers a snapshot read in place of its directory: the same
+// documents allowed, named as they were, and the same bytes written again.
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "b.graphql", "{ bar }")
+	writeDoc(t, dir, "a.graphql", "{ foo }")
+	writeDoc(t, dir, "broken.graphql", "{ broken")
+	snapshot := snapshotOf(t, dir, gqlhash.Options{})
+
+	list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+		allowlist.Config{Function: "sha2"})
+	result, err := list.Reload(snapshot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectFiles := []string{
+		filepath.Join(dir, "a.graphql"), filepath.Join(dir, "b.graphql"),
+	}
+	if !slices.Equal(result.Files, expectFiles) || len(result.Added) != 2 ||
+		len(result.Skipped) != 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if !list.Allowed(hashOf(t, "{ foo }")) || !list.Allowed(hashOf(t, "{bar}")) {
+		t.Error("expected the documents of the directory to be allowed")
+	}
+	if list.Allowed(hashOf(t, "{ baz }")) {
+		t.Error("expected no other document to be allowed")
+	}
+
+	var again bytes.Buffer
+	if err := list.WriteSnapshot(&again); err != nil {
+		t.Fatal(err)
+	}
+	if written, _ := os.ReadFile(snapshot); !bytes.Equal(again.Bytes(), written) {
+		t.Error("expected a snapshot of a snapshot to be the same bytes")
+	}
+}
+
+// TestSnapshotHashOnly covers a snapshot of an entry a manifest gives as a
+// hash alone: it's read as hash-only, running any operation.
+func TestSnapshotHashOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "q.jsonl", `{"id":"`+hex.EncodeToString(hashOf(t, "{ a }"))+`"}`)
+	config := allowlist.Config{Function: "sha2", Manifests: true}
+	list := allowlist.NewWithConfig(sha256.New, gqlhash.Options{}, config)
+	if _, err := list.Reload(dir); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := list.WriteSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(t.TempDir(), "allowlist.snapshot")
+	if err := os.WriteFile(snapshot, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	list = allowlist.NewWithConfig(sha256.New, gqlhash.Options{}, config)
+	if _, err := list.Reload(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := list.Lookup(hashOf(t, "{ a }")); !ok || !e.HashOnly || !e.Runs([]byte("A")) {
+		t.Errorf("expected the hash-only entry; received %+v", e)
+	}
+}
+
+// TestSnapshotRefused covers a snapshot that can't be what the allowlist
+// hashes with, or can't be read at all: each fails the reload, and what was
+// loaded before stays.
+func TestSnapshotRefused(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ foo }")
+	snapshot := snapshotOf(t, dir, gqlhash.Options{})
+	src, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(b []byte) string {
+		t.Helper()
+		name := filepath.Join(t.TempDir(), "allowlist.snapshot")
+		if err := os.WriteFile(name, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	flipped := slices.Clone(src)
+	flipped[len(flipped)/2] ^= 0xff
+
+	for _, td := range []struct {
+		name     string
+		function string
+		options  gqlhash.Options
+		file     string
+		expect   string
+	}{
+		{"function", "blake3", gqlhash.Options{}, snapshot, `hashed with "sha2"`},
+		{
+			"ignore", "sha2", gqlhash.Options{Ignore: gqlhash.IgnoreInputs},
+			snapshot, "hashed ignoring nothing, the allowlist ignores inputs",
+		},
+		{
+			"depth limit", "sha2", gqlhash.Options{DepthLimit: 8},
+			snapshot, "hashed with depth limit 128",
+		},
+		{"damaged", "sha2", gqlhash.Options{}, write(flipped), "checksum"},
+		{"truncated", "sha2", gqlhash.Options{}, write(src[:len(src)-8]), "checksum"},
+		{"not a snapshot", "sha2", gqlhash.Options{}, write([]byte("{ foo }")),
+			"not an allowlist snapshot"},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			other := t.TempDir()
+			writeDoc(t, other, "b.graphql", "{ bar }")
+			list := allowlist.NewWithConfig(sha256.New, td.options,
+				allowlist.Config{Function: td.function})
+			if _, err := list.Reload(other); err != nil {
+				t.Fatal(err)
+			}
+			_, err := list.Reload(td.file)
+			if err == nil || !strings.Contains(err.Error(), td.expect) {
+				t.Errorf("expected an error with %q; received %v", td.expect, err)
+			}
+			if !list.Allowed(hashOf(t, "{ bar }")) || list.Len() != 1 {
+				t.Error("expected what was loaded before to stay")
+			}
+		})
+	}
+}
+
+// TestSnapshotRootDocument covers a document named in place of a directory:
+// it's read as a document, not as a snapshot, as a directory holding it alone
+// would be.
+func TestSnapshotRootDocument(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ foo }")
+	list := allowlist.New(sha256.New, gqlhash.Options{})
+	r, err := list.Reload(filepath.Join(dir, "a.graphql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Allowed(hashOf(t, "{ foo }")) || len(r.Files) != 1 {
+		t.Errorf("expected the document read; received %v", r.Files)
+	}
+}
+
+func TestSnapshotBeforeFirstReload(t *testing.T) {
+	list := allowlist.New(sha256.New, gqlhash.Options{})
+	if err := list.WriteSnapshot(new(bytes.Buffer)); err == nil {
+		t.Error("expected an allowlist not yet loaded to have no snapshot")
+	}
+}
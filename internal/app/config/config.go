@@ -11,6 +11,9 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha3"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"hash/crc32"
@@ -97,23 +100,41 @@ var hashFunctions = []struct {
 }
 
 // ignoreModes and outputFormats are the same table for the other two flags.
-var ignoreModes = []struct {
+// An ignore mode names itself, see [gqlhash.Ignore.String].
+var ignoreModes = []gqlhash.Ignore{
+	gqlhash.IgnoreNothing, gqlhash.IgnoreInputs, gqlhash.IgnoreVariables,
+}
+
+var outputFormats = []struct {
+	name   string
+	value  Format
+	encode func([]byte) string
+}{
+	{"hex", FormatHex, hex.EncodeToString},
+	{"base32", FormatBase32, base32.StdEncoding.EncodeToString},
+	{"base64", FormatBase64, base64.StdEncoding.EncodeToString},
+	{"base64url", FormatBase64URL, base64.URLEncoding.EncodeToString},
+}
+
+// outputs are the shapes the hashing command writes its results in.
+var outputs = []struct {
 	name  string
-	value gqlhash.Ignore
+	value Output
 }{
-	{"nothing", gqlhash.IgnoreNothing},
-	{"inputs", gqlhash.IgnoreInputs},
-	{"variables", gqlhash.IgnoreVariables},
+	{"text", OutputText},
+	{"json", OutputJSON},
+	{"sarif", OutputSARIF},
 }
 
-var outputFormats = []struct {
+// inputs are what the hashing command reads a document out of.
+var inputs = []struct {
 	name  string
-	value Format
+	value Input
 }{
-	{"hex", FormatHex},
-	{"base32", FormatBase32},
-	{"base64", FormatBase64},
-	{"base64url", FormatBase64URL},
+	{"graphql", InputGraphQL},
+	{"json", InputJSON},
+	{"jsonl", InputJSONL},
+	{"har", InputHAR},
 }
 
 // The values a flag takes, in table order. They read as one line of help,
@@ -128,7 +149,11 @@ var (
 	SupportedOutputFormats = names(outputFormats,
 		func(i int) (string, bool) { return outputFormats[i].name, true })
 	SupportedIgnoreModes = names(ignoreModes,
-		func(i int) (string, bool) { return ignoreModes[i].name, true })
+		func(i int) (string, bool) { return ignoreModes[i].String(), true })
+	SupportedOutputs = names(outputs,
+		func(i int) (string, bool) { return outputs[i].name, true })
+	SupportedInputs = names(inputs,
+		func(i int) (string, bool) { return inputs[i].name, true })
 )
 
 // names lists the names take reports for the entries of table.
@@ -197,22 +222,24 @@ func HashName(f HashFunction) string {
 // Unlike [ParseFormat] and [ParseHashFunction] it needs the second return:
 // the zero value of [gqlhash.Ignore] is the valid IgnoreNothing.
 func ParseIgnore(s string) (gqlhash.Ignore, bool) {
-	for _, e := range ignoreModes {
-		if strings.EqualFold(s, e.name) {
-			return e.value, true
+	for _, i := range ignoreModes {
+		if strings.EqualFold(s, i.String()) {
+			return i, true
 		}
 	}
 	return 0, false
 }
 
-// IgnoreName returns the flag value that names i.
-func IgnoreName(i gqlhash.Ignore) string {
-	for _, e := range ignoreModes {
-		if e.value == i {
-			return e.name
+// Encode returns sum in the format f, and false if f names none.
+// Like [NewHasher] it says so rather than answering "" for a format added to
+// the vocabulary and not to the table.
+func Encode(f Format, sum []byte) (string, bool) {
+	for _, e := range outputFormats {
+		if e.value == f {
+			return e.encode(sum), true
 		}
 	}
-	return ""
+	return "", false
 }
 
 // ParseFormat returns the output format s names, and 0 for every name that is
@@ -226,6 +253,38 @@ func ParseFormat(s string) Format {
 	return 0
 }
 
+// FormatName returns the flag value that names f, or "" for the zero value.
+func FormatName(f Format) string {
+	for _, e := range outputFormats {
+		if e.value == f {
+			return e.name
+		}
+	}
+	return ""
+}
+
+// ParseOutput returns the output s names, and 0 for every name that is none
+// of them.
+func ParseOutput(s string) Output {
+	for _, e := range outputs {
+		if strings.EqualFold(s, e.name) {
+			return e.value
+		}
+	}
+	return 0
+}
+
+// ParseInput returns the input s names, and 0 for every name that is none
+// of them.
+func ParseInput(s string) Input {
+	for _, e := range inputs {
+		if strings.EqualFold(s, e.name) {
+			return e.value
+		}
+	}
+	return 0
+}
+
 type Format int8
 
 const (
@@ -236,6 +295,29 @@ const (
 	FormatBase64URL
 )
 
+// Output is what the hashing command writes: the hashes as lines of text,
+// a JSON record per document, or a SARIF log of what failed.
+type Output int8
+
+const (
+	_ Output = iota
+	OutputText
+	OutputJSON
+	OutputSARIF
+)
+
+// Input is what the hashing command reads: a document, a GraphQL-over-HTTP
+// request body, a request body per line, or the HAR log a browser records.
+type Input int8
+
+const (
+	_ Input = iota
+	InputGraphQL
+	InputJSON
+	InputJSONL
+	InputHAR
+)
+
 type HashFunction int8
 
 const (
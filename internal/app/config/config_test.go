@@ -180,6 +180,8 @@ func TestTablesAgree(t *testing.T) {
 			{config.SupportedProxyHashFunctions, "sha2, sha3, blake2b, blake2s, blake3"},
 			{config.SupportedOutputFormats, "hex, base32, base64, base64url"},
 			{config.SupportedIgnoreModes, "nothing, inputs, variables"},
+			{config.SupportedOutputs, "text, json, sarif"},
+			{config.SupportedInputs, "graphql, json, jsonl, har"},
 		} {
 			if td.got != td.want {
 				t.Errorf("expected %q; received %q", td.want, td.got)
@@ -229,7 +231,7 @@ func TestTablesAgree(t *testing.T) {
 				t.Errorf("%q is offered and parses to nothing", name)
 				continue
 			}
-			if got := config.IgnoreName(i); got != name {
+			if got := i.String(); got != name {
 				t.Errorf("%q names %v, which names %q", name, i, got)
 			}
 		}
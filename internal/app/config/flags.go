@@ -1,15 +1,21 @@
 package config
 
 import (
+	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
+	"go/token"
 	"io"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,14 +24,44 @@ import (
 )
 
 type Hasher struct {
-	// File is the document to read, or empty for stdin.
+	// File is the document to read, or empty for stdin. A source file is read
+	// for the documents it embeds instead.
 	File string
 
-	// Format is the encoding of the hash, Hash the function it's made with and
-	// Ignore what to leave out of it.
-	Format Format
-	Hash   HashFunction
-	Ignore gqlhash.Ignore
+	// Dir is a directory whose every document is hashed, empty for none.
+	// Sources takes the documents its source files embed along with them.
+	Dir     string
+	Sources bool
+
+	// Schema are the files and directories of the schema every document is
+	// checked against before it's hashed, none for no check. WarnDeprecated
+	// also warns of every field the schema marks @deprecated.
+	Schema         []string
+	WarnDeprecated bool
+
+	// Input is what File or stdin holds, see [Input].
+	Input Input
+
+	// Output is the shape the results are written in, see [Output].
+	// Count writes each hash once, with how many documents have it.
+	Output Output
+	Count  bool
+
+	// Out is the file the results are written to, replaced whole once they
+	// are, or empty for stdout.
+	Out string
+
+	// Watch hashes Dir again whenever a file under it changes, polling it every
+	// WatchInterval, and rewrites Out, until interrupted.
+	Watch         bool
+	WatchInterval time.Duration
+
+	// Formats are the encodings of the hash, Hashes the functions it's made
+	// with and Ignore what to leave out of it. Never empty: one of each is the
+	// common case, and several make a hash per function and encoding, labelled.
+	Formats []Format
+	Hashes  []HashFunction
+	Ignore  gqlhash.Ignore
 
 	// DepthLimit is how deeply a document may nest before it's refused,
 	// see [gqlhash.Options]. Always the limit in force: a flag below 1 is the
@@ -37,11 +73,136 @@ type Hasher struct {
 	CmdPrintVersion bool
 }
 
+// Learn is what the learn command was asked to do: write an allowlist out of
+// the requests of a traffic capture.
+type Learn struct {
+	// File is the capture to read, or empty for stdin, and Input what it holds,
+	// anything but a bare document.
+	File  string
+	Input Input
+
+	// Out is the allowlist directory a document per hash is written to.
+	Out string
+
+	// Hashing is what the proxy serving the allowlist runs with, which
+	// decides what counts as the same document.
+	Hashing
+}
+
+// LSP is what the language server was asked to do: show the hash the proxy
+// computes for every operation an editor opens, and whether it's allowed.
+type LSP struct {
+	// AllowlistDir is the allowlist the operations are checked against, empty
+	// for none. AllowlistSources and AllowlistManifests read it as the proxy's
+	// -allowlist.sources and -allowlist.manifests do.
+	AllowlistDir       string
+	AllowlistSources   bool
+	AllowlistManifests bool
+
+	// Hashing is what the proxy runs with, and the encoding a hash is shown in.
+	Hashing
+}
+
+// GenGo is what gen-go was asked to do: write a Go file of the operations of a
+// directory, with their hashes.
+type GenGo struct {
+	// Dir is the directory whose .graphql and .gql files are read.
+	Dir string
+
+	// Package is the package clause of the file, and Out where it's written.
+	Package string
+	Out     string
+
+	// Watch generates the file again whenever a file under Dir changes,
+	// polling it every WatchInterval, until interrupted.
+	Watch         bool
+	WatchInterval time.Duration
+
+	// Hashing is what the proxy runs with, and the encoding a hash is written
+	// in, as the clients send it.
+	Hashing
+}
+
+// CompileAllowlist is what compile-allowlist was asked to do: write the
+// snapshot of an allowlist directory the proxy loads in its place.
+type CompileAllowlist struct {
+	// AllowlistDir is the directory read, as the proxy reads -allowlist, and
+	// AllowlistSources and AllowlistManifests what its -allowlist.sources and
+	// -allowlist.manifests read besides.
+	AllowlistDir       string
+	AllowlistSources   bool
+	AllowlistManifests bool
+
+	// Out is the snapshot file written.
+	Out string
+
+	// Hashing is what the proxy loading the snapshot runs with, which it's
+	// recorded with and refused under any other.
+	Hashing
+}
+
+// Hashing is how a subcommand of the hashing command hashes a document: as
+// the proxy it works for does, with HashFunc, leaving out Ignore and refusing
+// what nests deeper than DepthLimit, see [gqlhash.Options]. DepthLimit is
+// always the limit in force, see [depthLimit]. Format is the encoding a hash
+// is written in, 0 for a command that writes none.
+type Hashing struct {
+	HashFunc   HashFunction
+	Ignore     gqlhash.Ignore
+	DepthLimit int
+	Format     Format
+}
+
 // Proxy is what the proxy command was asked to do.
 type Proxy struct {
-	// AllowlistDir is the directory the allowed documents are read from.
+	// AllowlistDir is the directory the allowed documents are read from, or
+	// the URL they're fetched from, see [IsAllowlistURL].
 	AllowlistDir string
 
+	// AllowlistSources also reads the documents its source files embed,
+	// AllowlistManifests its persisted-query manifests, and
+	// AllowlistSunsetWindow is how long before its expiry a document is
+	// reported, see [allowlist.Config].
+	AllowlistSources      bool
+	AllowlistManifests    bool
+	AllowlistSunsetWindow time.Duration
+
+	// AllowlistWatch is how often the files of every allowlist are polled for
+	// a change, which reloads the allowlist changed, and how often one at a
+	// URL is fetched. 0 where only a POST /reload does.
+	AllowlistWatch time.Duration
+
+	// AllowlistStrict refuses a reload that skips a file or can't read the
+	// schema, and AllowlistMaxRemoved and AllowlistMaxRemovedPercent one that
+	// removes more documents than they allow, 0 leaving either unbounded.
+	// A refused reload keeps what the allowlist held, see [allowlist.Policy].
+	AllowlistStrict            bool
+	AllowlistMaxRemoved        int
+	AllowlistMaxRemovedPercent float64
+
+	// AllowlistBundleKeys are the keys an allowlist bundle may be signed with.
+	// Where there are any, every allowlist has to be a bundle signed with one,
+	// see [allowlist.Config.BundleKeys].
+	AllowlistBundleKeys []ed25519.PublicKey
+
+	// AllowlistGitRef reads every allowlist from the Git repository it's in,
+	// as the commit this names holds it, see [allowlist.Config.GitRef].
+	// Empty reads the files as they are.
+	AllowlistGitRef string
+
+	// AllowlistClientHeader names the request header that picks the allowlist
+	// of a request among AllowlistClients. A request without it, or naming a
+	// client that has none, is checked against AllowlistDir. Empty where every
+	// request is, and AllowlistClients is then empty too.
+	AllowlistClientHeader string
+	AllowlistClients      []ProxyClient
+
+	// AllowlistSchemes are the hash functions and ignore modes every allowlist
+	// is keyed by besides HashFunc and Ignore, a request being allowed by any
+	// of them, see [allowlist.Config.Schemes]. Empty where it's keyed by those
+	// alone.
+	AllowlistSchemes []ProxyScheme
+
 	// HashFunc is one of the collision-resistant functions,
 	// see [SupportedProxyHashFunctions].
 	HashFunc HashFunction
@@ -68,6 +229,31 @@ type Proxy struct {
 	// CmdPrintVersion means the caller prints the version and returns instead
 	// of serving.
 	CmdPrintVersion bool
+
+	// CmdCheckAllowlist is the allowlist the caller reads as a reload would,
+	// reports on and returns instead of serving, see -check-allowlist.
+	// Only what the hashing takes is set besides it.
+	CmdCheckAllowlist string
+}
+
+// ProxyClient is the allowlist of one client, see [Proxy.AllowlistClientHeader].
+type ProxyClient struct {
+	// Name is the value of the header that picks Dir.
+	Name string
+	Dir  string
+}
+
+// ProxyScheme is a hash function and an ignore mode, see
+// [Proxy.AllowlistSchemes].
+type ProxyScheme struct {
+	HashFunc HashFunction
+	Ignore   gqlhash.Ignore
+}
+
+// String is the scheme as -allowlist.also-scheme takes it, such as
+// sha2:nothing, which is also what the metrics call it.
+func (s ProxyScheme) String() string {
+	return HashName(s.HashFunc) + ":" + s.Ignore.String()
 }
 
 // ProxyServer is the listener that takes the traffic. Its timeouts bound what a
@@ -191,9 +377,48 @@ func ParseHasher(
 	cli := flag.NewFlagSet(name, flag.ContinueOnError)
 	cli.SetOutput(stderr)
 	var (
-		fFile   = cli.String("file", "", "Path to a file holding the document")
+		fFile = cli.String("file", "", "Path to a file holding the document.\n"+
+			"A .ts, .tsx, .js, .jsx or .go file is read for the documents it\n"+
+			"embeds, each printed with where it begins.")
+		fDir = cli.String("dir", "",
+			"Hash every .graphql and .gql file under this directory,\n"+
+				"each printed with its name")
+		fSources = cli.Bool("sources", false,
+			"With -dir, also hash the documents embedded in .ts, .tsx, .js, .jsx\n"+
+				"and .go files: gql and graphql tagged templates, and Go string\n"+
+				"constants")
+		fWarnDeprecated = cli.Bool("warn-deprecated", false,
+			"With -schema, warn of every field the schema marks @deprecated.\n"+
+				"A warning fails nothing: the document is still hashed.")
 		fFormat = cli.String("format", "hex",
-			"Hash format ("+SupportedOutputFormats+")")
+			"Hash format ("+SupportedOutputFormats+").\n"+
+				"Several, comma-separated, print the hash in each, labelled.")
+		fInput = cli.String("input", "graphql",
+			"What -file or stdin holds ("+SupportedInputs+").\n"+
+				"graphql is a document.\n"+
+				"json is a GraphQL-over-HTTP request body, {\"query\": …} or a batch\n"+
+				"of them, every document of which is hashed.\n"+
+				"jsonl is a request body per line, as a traffic capture holds them,\n"+
+				"each reported with its line.\n"+
+				"har is the HAR log of a browser, each request reported with the line\n"+
+				"of its entry. Entries carrying no GraphQL request are skipped.")
+		fCount = cli.Bool("count", false,
+			"Write every hash once, with how many documents have it, most first")
+		fOut = cli.String("out", "",
+			"Write the output to this file instead of stdout, replacing it whole\n"+
+				"once it's written, so a reader never sees it half-written")
+		fWatch = cli.Bool("watch", false,
+			"With -dir and -out, hash again whenever a file under -dir changes,\n"+
+				"rehashing only the files that did, until interrupted.\n"+
+				"Errors are written to stderr and the watch goes on.")
+		fWatchInterval = cli.Duration("watch.interval", time.Second,
+			"How often -watch polls -dir for changes")
+		fOutput = cli.String("output", "text",
+			"What to write ("+SupportedOutputs+").\n"+
+				"text writes the hash, with where the document is for several.\n"+
+				"json writes a JSON record per document, on a line of its own.\n"+
+				"sarif writes a SARIF 2.1.0 log of the documents that failed,\n"+
+				"for code scanning to annotate.")
 		fHash = cli.String("hash", "sha2",
 			"Selects the hash function ("+SupportedHashFunctions+").\n"+
 				"sha2 is SHA-256.\n"+
@@ -205,7 +430,9 @@ func ParseHasher(
 				"fnv1a is FNV-1a, 64 bits wide.\n"+
 				"xxh64 is XXH64, unseeded.\n"+
 				"crc32 uses the IEEE polynomial.\n"+
-				"crc64 uses ISO polynomial, defined in ISO 3309 and used in HDLC.")
+				"crc64 uses ISO polynomial, defined in ISO 3309 and used in HDLC.\n"+
+				"Several, comma-separated, print a hash of each from one parse,\n"+
+				"labelled.")
 		fIgnore = cli.String("ignore", "nothing",
 			"Selects what to leave out of the hash ("+SupportedIgnoreModes+").\n"+
 				"nothing leaves out formatting and comments only.\n"+
@@ -219,24 +446,67 @@ func ParseHasher(
 			"How deeply a document may nest before it's refused.\n"+
 				"Below 1 takes the default.")
 	)
+	cli.Func("schema",
+		"A .graphqls file or a directory of them, read as one schema that every\n"+
+			"document is checked against before it's hashed, with the rules the\n"+
+			"proxy checks its allowlist with. Repeat it for several.",
+		func(s string) error {
+			cfg.Schema = append(cfg.Schema, s)
+			return nil
+		})
 	if code, ok := parse(cli, args, stderr, ProxyCommand); !ok {
 		return cfg, code, false
 	}
 
 	cfg.File, cfg.CmdPrintVersion = *fFile, *fVersion
+	cfg.WarnDeprecated, cfg.Count = *fWarnDeprecated, *fCount
+	cfg.Dir, cfg.Sources = *fDir, *fSources
+	cfg.Out, cfg.Watch, cfg.WatchInterval = *fOut, *fWatch, *fWatchInterval
 	if cfg.CmdPrintVersion {
 		// The caller prints the version, so nothing else has to be valid.
 		return cfg, 0, true
 	}
-	if cfg.Format = ParseFormat(*fFormat); cfg.Format == 0 {
+	if cfg.File != "" && cfg.Dir != "" {
+		_, _ = fmt.Fprintln(stderr, "-file and -dir go apart: give one or the other")
+		return cfg, 2, false
+	}
+	if cfg.Input = ParseInput(*fInput); cfg.Input == 0 {
+		return cfg, unsupported(stderr, "input", *fInput, SupportedInputs), false
+	}
+	if cfg.Input != InputGraphQL && cfg.Dir != "" {
+		_, _ = fmt.Fprintln(stderr, "-input json, jsonl and har read -file or stdin, not -dir")
+		return cfg, 2, false
+	}
+	if cfg.WarnDeprecated && len(cfg.Schema) == 0 {
+		_, _ = fmt.Fprintln(stderr, "-warn-deprecated needs a -schema to read")
+		return cfg, 2, false
+	}
+	if cfg.Watch {
+		switch {
+		case cfg.Dir == "":
+			_, _ = fmt.Fprintln(stderr, "-watch needs a -dir to watch")
+			return cfg, 2, false
+		case cfg.Out == "":
+			// Stdout can't be rewritten: every round would follow the last.
+			_, _ = fmt.Fprintln(stderr, "-watch needs an -out to rewrite")
+			return cfg, 2, false
+		case cfg.WatchInterval <= 0:
+			_, _ = fmt.Fprintln(stderr, "-watch.interval must be above 0")
+			return cfg, 2, false
+		}
+	}
+	var ok bool
+	if cfg.Formats, ok = parseList(*fFormat, ParseFormat); !ok {
 		return cfg, unsupported(stderr, "format", *fFormat, SupportedOutputFormats),
 			false
 	}
-	if cfg.Hash = ParseHashFunction(*fHash); cfg.Hash == 0 {
+	if cfg.Output = ParseOutput(*fOutput); cfg.Output == 0 {
+		return cfg, unsupported(stderr, "output", *fOutput, SupportedOutputs), false
+	}
+	if cfg.Hashes, ok = parseList(*fHash, ParseHashFunction); !ok {
 		return cfg, unsupported(stderr, "hash function", *fHash,
 			SupportedHashFunctions), false
 	}
-	var ok bool
 	if cfg.Ignore, ok = ParseIgnore(*fIgnore); !ok {
 		return cfg, unsupported(stderr, "ignore mode", *fIgnore,
 			SupportedIgnoreModes), false
@@ -245,6 +515,249 @@ func ParseHasher(
 	return cfg, 0, true
 }
 
+// LearnCommand is the subcommand of the hashing command that writes an
+// allowlist out of a traffic capture, see [ParseLearn].
+const LearnCommand = "learn"
+
+// ParseLearn reads the flags of the learn command. name is the command as
+// invoked and args[0] the subcommand, whose flags follow it.
+// run is false when the caller is done and must return exitCode.
+func ParseLearn(
+	name string, args []string, stderr io.Writer,
+) (cfg Learn, exitCode int, run bool) {
+	cli := flag.NewFlagSet(name+" "+LearnCommand, flag.ContinueOnError)
+	cli.SetOutput(stderr)
+	var (
+		fFile = cli.String("file", "",
+			"Path to the capture to read, stdin when empty")
+		fInput = cli.String("input", "jsonl",
+			"What -file or stdin holds ("+SupportedInputs+"), as the hashing\n"+
+				"command's -input reads it. graphql is refused: a capture is requests.")
+		fOut = cli.String("out", "",
+			"The allowlist directory to write a .graphql file per document to,\n"+
+				"created where missing. Required.")
+	)
+	hashing := hashingFlags(cli,
+		"Documents hashing alike are one entry, written as the first seen.", "")
+	if code, ok := parse(cli, args, stderr, ""); !ok {
+		return cfg, code, false
+	}
+
+	cfg.File, cfg.Out = *fFile, *fOut
+	if cfg.Out == "" {
+		_, _ = fmt.Fprintln(stderr, "-out names the allowlist directory to write")
+		return cfg, 2, false
+	}
+	if cfg.Input = ParseInput(*fInput); cfg.Input == 0 {
+		return cfg, unsupported(stderr, "input", *fInput, SupportedInputs), false
+	}
+	if cfg.Input == InputGraphQL {
+		_, _ = fmt.Fprintln(stderr, "-input graphql is a document, not a capture")
+		return cfg, 2, false
+	}
+	cfg.Hashing, exitCode, run = hashing(stderr)
+	return cfg, exitCode, run
+}
+
+// LSPCommand is the subcommand of the hashing command that serves the
+// Language Server Protocol over stdio, see [ParseLSP].
+const LSPCommand = "lsp"
+
+// ParseLSP reads the flags of the language server, as [ParseLearn] does those
+// of the learn command.
+func ParseLSP(
+	name string, args []string, stderr io.Writer,
+) (cfg LSP, exitCode int, run bool) {
+	cli := flag.NewFlagSet(name+" "+LSPCommand, flag.ContinueOnError)
+	cli.SetOutput(stderr)
+	var (
+		fAllowlist = cli.String("allowlist", "",
+			"The allowlist directory of the proxy. An operation not on it is\n"+
+				"warned of. Reread whenever the editor saves a file in it.")
+		fAllowlistSources = cli.Bool("allowlist.sources", false,
+			"Also read the documents embedded in the source files of -allowlist,\n"+
+				"as the proxy's -allowlist.sources does")
+		fAllowlistManifests = cli.Bool("allowlist.manifests", false,
+			"Also read the persisted-query manifests of -allowlist,\n"+
+				"as the proxy's -allowlist.manifests does")
+	)
+	hashing := hashingFlags(cli, "", "shown in")
+	if code, ok := parse(cli, args, stderr, ""); !ok {
+		return cfg, code, false
+	}
+
+	cfg.AllowlistDir, cfg.AllowlistSources = *fAllowlist, *fAllowlistSources
+	cfg.AllowlistManifests = *fAllowlistManifests
+	switch {
+	case cfg.AllowlistSources && cfg.AllowlistDir == "":
+		_, _ = fmt.Fprintln(stderr, "-allowlist.sources needs an -allowlist to read")
+		return cfg, 2, false
+	case cfg.AllowlistManifests && cfg.AllowlistDir == "":
+		_, _ = fmt.Fprintln(stderr, "-allowlist.manifests needs an -allowlist to read")
+		return cfg, 2, false
+	}
+	cfg.Hashing, exitCode, run = hashing(stderr)
+	return cfg, exitCode, run
+}
+
+// GenGoCommand is the subcommand of the hashing command that generates Go
+// constants of the operations of a directory, see [ParseGenGo].
+const GenGoCommand = "gen-go"
+
+// ParseGenGo reads the flags of gen-go, as [ParseLearn] does those of the learn
+// command.
+func ParseGenGo(
+	name string, args []string, stderr io.Writer,
+) (cfg GenGo, exitCode int, run bool) {
+	cli := flag.NewFlagSet(name+" "+GenGoCommand, flag.ContinueOnError)
+	cli.SetOutput(stderr)
+	var (
+		fDir = cli.String("dir", "",
+			"The directory whose .graphql and .gql files are read. Required.")
+		fPackage = cli.String("package", "",
+			"The package of the generated file. Required: under go:generate,\n"+
+				"$GOPACKAGE.")
+		fOut = cli.String("out", "gqlhash_gen.go",
+			"The file to write")
+		fWatch = cli.Bool("watch", false,
+			"Generate again whenever a file under -dir changes, rehashing only\n"+
+				"the files that did, until interrupted. Errors are written to stderr\n"+
+				"and the watch goes on.")
+		fWatchInterval = cli.Duration("watch.interval", time.Second,
+			"How often -watch polls -dir for changes")
+	)
+	hashing := hashingFlags(cli, "", "written in")
+	if code, ok := parse(cli, args, stderr, ""); !ok {
+		return cfg, code, false
+	}
+
+	cfg.Dir, cfg.Package, cfg.Out = *fDir, *fPackage, *fOut
+	cfg.Watch, cfg.WatchInterval = *fWatch, *fWatchInterval
+	switch {
+	case cfg.Dir == "":
+		_, _ = fmt.Fprintln(stderr, "-dir names the directory of the documents")
+		return cfg, 2, false
+	case !token.IsIdentifier(cfg.Package):
+		_, _ = fmt.Fprintf(stderr, "-package %q is no Go package name\n", cfg.Package)
+		return cfg, 2, false
+	case cfg.Out == "":
+		_, _ = fmt.Fprintln(stderr, "-out names the file to write")
+		return cfg, 2, false
+	case cfg.Watch && cfg.WatchInterval <= 0:
+		_, _ = fmt.Fprintln(stderr, "-watch.interval must be above 0")
+		return cfg, 2, false
+	}
+	cfg.Hashing, exitCode, run = hashing(stderr)
+	return cfg, exitCode, run
+}
+
+// CompileAllowlistCommand is the subcommand of the hashing command that writes
+// the snapshot of an allowlist, see [ParseCompileAllowlist].
+const CompileAllowlistCommand = "compile-allowlist"
+
+// ParseCompileAllowlist reads the flags of compile-allowlist, as [ParseLearn]
+// does those of the learn command.
+func ParseCompileAllowlist(
+	name string, args []string, stderr io.Writer,
+) (cfg CompileAllowlist, exitCode int, run bool) {
+	cli := flag.NewFlagSet(name+" "+CompileAllowlistCommand, flag.ContinueOnError)
+	cli.SetOutput(stderr)
+	var (
+		fAllowlist = cli.String("allowlist", "",
+			"The allowlist directory to compile, as the proxy's -allowlist.\n"+
+				"Required.")
+		fAllowlistSources = cli.Bool("allowlist.sources", false,
+			"Also read the documents embedded in the source files of -allowlist,\n"+
+				"as the proxy's -allowlist.sources does")
+		fAllowlistManifests = cli.Bool("allowlist.manifests", false,
+			"Also read the persisted-query manifests of -allowlist,\n"+
+				"as the proxy's -allowlist.manifests does")
+		fOut = cli.String("out", "",
+			"The snapshot file to write, for the proxy's -allowlist. Required.")
+	)
+	hashing := hashingFlags(cli, "", "")
+	if code, ok := parse(cli, args, stderr, ""); !ok {
+		return cfg, code, false
+	}
+
+	cfg.AllowlistDir, cfg.AllowlistSources = *fAllowlist, *fAllowlistSources
+	cfg.AllowlistManifests = *fAllowlistManifests
+	cfg.Out = *fOut
+	switch {
+	case cfg.AllowlistDir == "":
+		_, _ = fmt.Fprintln(stderr, "-allowlist names the directory to compile")
+		return cfg, 2, false
+	case cfg.Out == "":
+		_, _ = fmt.Fprintln(stderr, "-out names the snapshot file to write")
+		return cfg, 2, false
+	}
+	cfg.Hashing, exitCode, run = hashing(stderr)
+	return cfg, exitCode, run
+}
+
+// hashingFlags declares on cli the flags of a subcommand of the hashing
+// command that say what the proxy it works for runs with, see [Hashing]:
+// -hash, -ignore and -depth-limit, and -format where format says what it's
+// the encoding of, empty for a command that writes no hash. ignore is said of
+// -ignore besides what it takes. The function returned reads them once cli
+// is parsed, and ok is false where one names nothing.
+func hashingFlags(
+	cli *flag.FlagSet, ignore, format string,
+) func(stderr io.Writer) (h Hashing, exitCode int, ok bool) {
+	ignoreUsage := "What the proxy leaves out of the hash (" + SupportedIgnoreModes + ")"
+	if ignore != "" {
+		ignoreUsage += ".\n" + ignore
+	}
+	var (
+		fHash = cli.String("hash", "sha2",
+			"The hash function the proxy runs with ("+
+				SupportedProxyHashFunctions+")")
+		fIgnore     = cli.String("ignore", "nothing", ignoreUsage)
+		fFormat     *string
+		fDepthLimit = cli.Int("depth-limit", parser.DefaultDepthLimit,
+			"How deeply a document may nest before it's refused.\n"+
+				"Below 1 takes the default.")
+	)
+	if format != "" {
+		fFormat = cli.String("format", "hex",
+			"The encoding a hash is "+format+" ("+SupportedOutputFormats+")")
+	}
+	return func(stderr io.Writer) (h Hashing, exitCode int, ok bool) {
+		if h.HashFunc = ParseProxyHashFunction(*fHash); h.HashFunc == 0 {
+			return h, unsupported(stderr, "hash function", *fHash,
+				SupportedProxyHashFunctions), false
+		}
+		if h.Ignore, ok = ParseIgnore(*fIgnore); !ok {
+			return h, unsupported(stderr, "ignore mode", *fIgnore,
+				SupportedIgnoreModes), false
+		}
+		if fFormat != nil {
+			if h.Format = ParseFormat(*fFormat); h.Format == 0 {
+				return h, unsupported(stderr, "format", *fFormat,
+					SupportedOutputFormats), false
+			}
+		}
+		h.DepthLimit = depthLimit(*fDepthLimit)
+		return h, 0, true
+	}
+}
+
+// parseList reads the comma-separated values of s with parse, which returns 0
+// for none. It fails on an empty list, a value that parses to none and one given
+// twice, which would print the same hash twice.
+func parseList[T comparable](s string, parse func(string) T) ([]T, bool) {
+	var zero T
+	var list []T
+	for v := range strings.SplitSeq(s, ",") {
+		value := parse(strings.TrimSpace(v))
+		if value == zero || slices.Contains(list, value) {
+			return nil, false
+		}
+		list = append(list, value)
+	}
+	return list, true
+}
+
 // EnvPrefix is what the environment form of a proxy flag starts with, see [EnvName].
 const EnvPrefix = "GQLHASH_PROXY_"
 
@@ -304,7 +817,41 @@ func ParseProxyFor(
 				"Set this only behind a trusted load balancer: a client that\n"+
 				"reaches the proxy directly can otherwise claim any address.")
 		fAllowlist = cli.String("allowlist", "",
-			"Directory holding the allowed documents as .graphql and .gql files")
+			"Directory holding the allowed documents as .graphql and .gql files,\n"+
+				"the snapshot of one that gqlhash compile-allowlist wrote, or a\n"+
+				"signed bundle of one, see -allowlist.bundle-key. An http:// or\n"+
+				"https:// URL of a file is fetched, see -allowlist.watch.")
+		fAllowlistSources = cli.Bool("allowlist.sources", false,
+			"Also allow the documents embedded in the .ts, .tsx, .js, .jsx and\n"+
+				".go files of -allowlist: gql and graphql tagged templates, and Go\n"+
+				"string constants. Each is an entry of its own.")
+		fAllowlistManifests = cli.Bool("allowlist.manifests", false,
+			"Also allow the documents of the persisted-query manifests of\n"+
+				"-allowlist: Apollo and Relay .json manifests, and .jsonl files of\n"+
+				"{\"query\": ...} lines. Each is an entry of its own. Off, those\n"+
+				"files are left out as any other is.")
+		fAllowlistClientHeader = cli.String("allowlist.client-header", "",
+			"The request header naming the client that sends a request, such as\n"+
+				"apollographql-client-name. A request is checked against the\n"+
+				"-allowlist.client of its client alone, and against -allowlist\n"+
+				"where it names none or one that has none.")
+		fAllowlistSunset = cli.Duration("allowlist.sunset-window", 0,
+			"How long before it expires a document is in its sunset: still\n"+
+				"allowed, but counted and logged as one. A document expires where\n"+
+				"its leading comments say \"# gqlhash: expires: 2027-06-30\".\n"+
+				"0 leaves no window.")
+		fAllowlistWatch = cli.Duration("allowlist.watch", 0,
+			"How often to poll -allowlist and every -allowlist.client for\n"+
+				"added, removed or changed files, a swapped symlink included,\n"+
+				"and reload the one that changed once it has held still for as\n"+
+				"long. An allowlist at a URL is fetched as often instead, with\n"+
+				"If-None-Match, and reloaded where it changed; a fetch that fails\n"+
+				"keeps what it held. 0 reloads on POST /reload alone.")
+		fAllowlistGitRef = cli.String("allowlist.git-ref", "",
+			"Read -allowlist and every -allowlist.client from the Git repository\n"+
+				"each is in, as the commit this names holds them: a tag, a branch\n"+
+				"or a commit. What's checked out makes no difference.\n"+
+				"POST /reload?ref= moves them to another. Needs git on the PATH.")
 
 		fControl = cli.String("control.listen", "127.0.0.1:9090",
 			"Address to serve the control server on. It answers Prometheus\n"+
@@ -378,7 +925,102 @@ func ParseProxyFor(
 			"PEM file of the private key for -server.tls.cert")
 
 		fVersion = cli.Bool("version", false, "Print the version to stdout and exit")
+		fCheck   = cli.String("check-allowlist", "",
+			"Read this allowlist as a reload would, with -hash, -ignore,\n"+
+				"-depth-limit, -allowlist.sources and -allowlist.manifests, report\n"+
+				"what it holds and exit, 1 where a file would be skipped or the\n"+
+				"schema can't be read. Nothing is served, so no other flag is required.")
 	)
+	fAllowlistStrict := cli.Bool("allowlist.strict", false,
+		"Refuse a reload that would skip a file or can't read the schema,\n"+
+			"and keep serving what the allowlist held. At startup, refuse to\n"+
+			"start. Applies to -allowlist and every -allowlist.client.")
+	var maxRemoved int
+	var maxRemovedPercent float64
+	cli.Func("allowlist.max-removed",
+		"Refuse a reload that would remove more documents than this, as a\n"+
+			"count such as 100 or a share of those held such as 10%, and keep\n"+
+			"serving what the allowlist held. Give it twice for both.",
+		func(s string) error {
+			if n, ok := strings.CutSuffix(s, "%"); ok {
+				p, err := strconv.ParseFloat(n, 64)
+				if err != nil || !(p >= 0 && p <= 100) {
+					return fmt.Errorf("%q is no percentage from 0%% to 100%%", s)
+				}
+				maxRemovedPercent = p
+				return nil
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return fmt.Errorf("%q is no count of 0 or more, nor a percentage", s)
+			}
+			maxRemoved = n
+			return nil
+		})
+	var bundleKeys []ed25519.PublicKey
+	cli.Func("allowlist.bundle-key",
+		"A file holding an ed25519 public key, as PEM or as the base64 of its\n"+
+			"32 bytes, that an allowlist bundle may be signed with. Repeat it for\n"+
+			"several. Given, -allowlist and every -allowlist.client must be a\n"+
+			".tar, .tar.gz, .tgz or .zip bundle beside its .sig signature, and\n"+
+			"anything unsigned or changed since it was signed is refused.",
+		func(file string) error {
+			key, err := readPublicKey(file)
+			if err != nil {
+				return err
+			}
+			bundleKeys = append(bundleKeys, key)
+			return nil
+		})
+	var clients []ProxyClient
+	cli.Func("allowlist.client",
+		"The allowlist of a client, as name=dir, read as -allowlist is and\n"+
+			"picked by -allowlist.client-header. Repeat it, or separate several\n"+
+			"with commas, for several clients.",
+		func(s string) error {
+			for c := range strings.SplitSeq(s, ",") {
+				name, dir, ok := strings.Cut(strings.TrimSpace(c), "=")
+				if !ok || name == "" || dir == "" {
+					return fmt.Errorf("%q is no name=dir", c)
+				}
+				if slices.ContainsFunc(clients, func(c ProxyClient) bool {
+					return c.Name == name
+				}) {
+					return fmt.Errorf("client %q given twice", name)
+				}
+				clients = append(clients, ProxyClient{Name: name, Dir: dir})
+			}
+			return nil
+		})
+	var schemes []ProxyScheme
+	cli.Func("allowlist.also-scheme",
+		"Also key every allowlist by this hash function and ignore mode,\n"+
+			"as hash:ignore such as sha2:nothing, and allow a request whose\n"+
+			"document is found by either. What moves from one -hash or -ignore\n"+
+			"to another, counted by the scheme that found it. Repeat it, or\n"+
+			"separate several with commas, for several.",
+		func(s string) error {
+			for v := range strings.SplitSeq(s, ",") {
+				hashName, ignoreName, ok := strings.Cut(strings.TrimSpace(v), ":")
+				if !ok {
+					return fmt.Errorf("%q is no hash:ignore", v)
+				}
+				var scheme ProxyScheme
+				if scheme.HashFunc = ParseProxyHashFunction(hashName); scheme.HashFunc == 0 {
+					return fmt.Errorf("unsupported hash function %q, expected one of: %s",
+						hashName, SupportedProxyHashFunctions)
+				}
+				if scheme.Ignore, ok = ParseIgnore(ignoreName); !ok {
+					return fmt.Errorf("unsupported ignore mode %q, expected one of: %s",
+						ignoreName, SupportedIgnoreModes)
+				}
+				if slices.Contains(schemes, scheme) {
+					return fmt.Errorf("scheme %s given twice", scheme)
+				}
+				schemes = append(schemes, scheme)
+			}
+			return nil
+		})
 	cli.Usage = func() {
 		_, _ = fmt.Fprintf(cli.Output(), "Usage of %s:\n", name)
 		cli.PrintDefaults()
@@ -398,9 +1040,21 @@ func ParseProxyFor(
 	}
 
 	cfg = Proxy{
-		AllowlistDir:   *fAllowlist,
-		OpaqueErrors:   *fOpaqueErrors,
-		TrustForwarded: *fTrustForwarded,
+		AllowlistDir:               *fAllowlist,
+		AllowlistSources:           *fAllowlistSources,
+		AllowlistManifests:         *fAllowlistManifests,
+		AllowlistSunsetWindow:      *fAllowlistSunset,
+		AllowlistWatch:             *fAllowlistWatch,
+		AllowlistStrict:            *fAllowlistStrict,
+		AllowlistMaxRemoved:        maxRemoved,
+		AllowlistMaxRemovedPercent: maxRemovedPercent,
+		AllowlistBundleKeys:        bundleKeys,
+		AllowlistGitRef:            *fAllowlistGitRef,
+		AllowlistClientHeader:      *fAllowlistClientHeader,
+		AllowlistClients:           clients,
+		AllowlistSchemes:           schemes,
+		OpaqueErrors:               *fOpaqueErrors,
+		TrustForwarded:             *fTrustForwarded,
 		Server: ProxyServer{
 			Listen:            *fListen,
 			MaxBody:           *fMaxBody,
@@ -422,13 +1076,59 @@ func ParseProxyFor(
 		Log: ProxyLog{
 			Level: *fLogLevel, JSON: *fLogJSON, Requests: *fLogRequests,
 		},
-		CmdPrintVersion: *fVersion,
+		CmdPrintVersion:   *fVersion,
+		CmdCheckAllowlist: *fCheck,
 	}
 
 	if cfg.CmdPrintVersion {
 		// The caller prints the version, so nothing else has to be given.
 		return cfg, 0, true
 	}
+	if cfg.HashFunc = ParseProxyHashFunction(*fHash); cfg.HashFunc == 0 {
+		return cfg, unsupported(stderr, "hash function", *fHash,
+			SupportedProxyHashFunctions), false
+	}
+	var ok bool
+	if cfg.Ignore, ok = ParseIgnore(*fIgnore); !ok {
+		return cfg, unsupported(stderr, "ignore mode", *fIgnore,
+			SupportedIgnoreModes), false
+	}
+	cfg.DepthLimit = depthLimit(*fDepthLimit)
+	if own := (ProxyScheme{cfg.HashFunc, cfg.Ignore}); slices.Contains(cfg.AllowlistSchemes, own) {
+		_, _ = fmt.Fprintf(stderr,
+			"-allowlist.also-scheme %s is what -hash and -ignore key by already\n", own)
+		return cfg, 2, false
+	}
+	if cfg.AllowlistGitRef != "" && len(cfg.AllowlistBundleKeys) > 0 {
+		// What a commit holds no key has signed, so every read would be refused.
+		_, _ = fmt.Fprintln(stderr,
+			"-allowlist.git-ref and -allowlist.bundle-key exclude each other: "+
+				"a commit is no signed bundle")
+		return cfg, 2, false
+	}
+	dirs := []string{cfg.AllowlistDir, cfg.CmdCheckAllowlist}
+	for _, c := range cfg.AllowlistClients {
+		dirs = append(dirs, c.Dir)
+	}
+	for _, dir := range dirs {
+		if !IsAllowlistURL(dir) {
+			continue
+		}
+		if u, err := url.Parse(dir); err != nil || u.Host == "" {
+			_, _ = fmt.Fprintf(stderr, "allowlist %q is no absolute URL\n", dir)
+			return cfg, 2, false
+		}
+		if cfg.AllowlistGitRef != "" {
+			_, _ = fmt.Fprintln(stderr,
+				"-allowlist.git-ref reads no allowlist fetched from a URL")
+			return cfg, 2, false
+		}
+	}
+	if cfg.CmdCheckAllowlist != "" {
+		// A check hashes as a proxy serving the allowlist would, and serves
+		// nothing, so what the serving takes isn't required.
+		return cfg, 0, true
+	}
 
 	if *fUpstream == "" {
 		_, _ = fmt.Fprintln(stderr, "-upstream.url is required")
@@ -526,16 +1226,6 @@ func ParseProxyFor(
 		_, _ = fmt.Fprintln(stderr, "-control.listen must name an address")
 		return cfg, 2, false
 	}
-	if cfg.HashFunc = ParseProxyHashFunction(*fHash); cfg.HashFunc == 0 {
-		return cfg, unsupported(stderr, "hash function", *fHash,
-			SupportedProxyHashFunctions), false
-	}
-	var ok bool
-	if cfg.Ignore, ok = ParseIgnore(*fIgnore); !ok {
-		return cfg, unsupported(stderr, "ignore mode", *fIgnore,
-			SupportedIgnoreModes), false
-	}
-	cfg.DepthLimit = depthLimit(*fDepthLimit)
 
 	// Every timeout is a duration to wait, so a negative one asks for nothing this
 	// can do. Refused rather than read as 0 — the two mean opposite things here,
@@ -620,6 +1310,35 @@ func ParseProxyFor(
 		cfg.Upstream.HTTP2 = false
 	}
 
+	// Either alone does nothing a run could notice, which is worse than refusing:
+	// a deployment meaning to split its clients would serve them one allowlist.
+	switch {
+	case cfg.AllowlistClientHeader != "" && len(cfg.AllowlistClients) == 0:
+		_, _ = fmt.Fprintln(stderr,
+			"-allowlist.client-header needs an -allowlist.client to pick")
+		return cfg, 2, false
+	case cfg.AllowlistClientHeader == "" && len(cfg.AllowlistClients) > 0:
+		_, _ = fmt.Fprintln(stderr,
+			"-allowlist.client needs an -allowlist.client-header to be picked by")
+		return cfg, 2, false
+	}
+
+	if cfg.AllowlistSunsetWindow < 0 {
+		_, _ = fmt.Fprintln(stderr, "-allowlist.sunset-window must be 0 or more")
+		return cfg, 2, false
+	}
+	if cfg.AllowlistWatch < 0 {
+		_, _ = fmt.Fprintln(stderr, "-allowlist.watch must be 0 or more")
+		return cfg, 2, false
+	}
+	if cfg.AllowlistWatch > 0 && cfg.AllowlistGitRef != "" {
+		// A poll would find nothing ever changed, which is worse than refusing.
+		_, _ = fmt.Fprintln(stderr,
+			"-allowlist.watch has nothing to poll under -allowlist.git-ref: "+
+				"a commit doesn't change, POST /reload?ref= moves to another")
+		return cfg, 2, false
+	}
+
 	if cfg.Upstream.MaxConnLifetime < 0 {
 		_, _ = fmt.Fprintln(stderr,
 			"-upstream.max-conn-lifetime must be 0 or more")
@@ -643,6 +1362,39 @@ func ParseProxyFor(
 	return cfg, 0, true
 }
 
+// IsAllowlistURL reports whether dir, an allowlist, is fetched over HTTP
+// rather than read from disk: an http:// or https:// URL.
+func IsAllowlistURL(dir string) bool {
+	return strings.HasPrefix(dir, "http://") || strings.HasPrefix(dir, "https://")
+}
+
+// readPublicKey reads the ed25519 public key file holds: a PEM PUBLIC KEY
+// block, as openssl pkey -pubout writes one, or the base64 of its 32 bytes.
+// Read at startup, so a key that can't be used is a flag error rather than
+// every bundle refused.
+func readPublicKey(file string) (ed25519.PublicKey, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(src); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if k, ok := key.(ed25519.PublicKey); ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("%s: a %T, not an ed25519 key", file, key)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(src)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s holds no PEM public key, nor the base64 of %d bytes",
+			file, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
 // depthLimit is the nesting a document may reach, given what -depth-limit was set to.
 // Below 1 is [parser.DefaultDepthLimit]: no value turns the limit off.
 //
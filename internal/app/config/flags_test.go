@@ -2,15 +2,19 @@ package config_test
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/pem"
 	"math/big"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -36,13 +40,16 @@ func TestParseHasher(t *testing.T) {
 	if cfg.File != "" || cfg.CmdPrintVersion {
 		t.Errorf("unexpected defaults: %+v", cfg)
 	}
-	if cfg.Format != config.FormatHex {
-		t.Errorf("expected hex by default; received %v", cfg.Format)
+	if !slices.Equal(cfg.Formats, []config.Format{config.FormatHex}) {
+		t.Errorf("expected hex by default; received %v", cfg.Formats)
+	}
+	if cfg.Output != config.OutputText {
+		t.Errorf("expected text by default; received %v", cfg.Output)
 	}
 	// sha2 by default, the same function the proxy defaults to and the narrowest
 	// thing an allowlist needs of a hash. TestParseProxy pins the proxy's.
-	if cfg.Hash != config.HashFunctionSHA2 {
-		t.Errorf("expected sha2 by default; received %v", cfg.Hash)
+	if !slices.Equal(cfg.Hashes, []config.HashFunction{config.HashFunctionSHA2}) {
+		t.Errorf("expected sha2 by default; received %v", cfg.Hashes)
 	}
 	if cfg.Ignore != gqlhash.IgnoreNothing {
 		t.Errorf("expected nothing ignored by default; received %v", cfg.Ignore)
@@ -52,18 +59,40 @@ func TestParseHasher(t *testing.T) {
 	errOut.Reset()
 	cfg, code, run = config.ParseHasher("gqlhash", hasherArgs(
 		"-file", "q.graphql", "-format", "base64url", "-hash", "blake3",
-		"-ignore", "variables",
+		"-ignore", "variables", "-output", "sarif",
+		"-schema", "a.graphqls", "-schema", "schema", "-warn-deprecated",
+		"-input", "jsonl", "-count",
 	), &errOut)
 	if !run || code != 0 {
 		t.Fatalf("expected these flags to parse; code %d, stderr: %s",
 			code, errOut.String())
 	}
-	if cfg.File != "q.graphql" || cfg.Format != config.FormatBase64URL ||
-		cfg.Hash != config.HashFunctionBLAKE3 ||
-		cfg.Ignore != gqlhash.IgnoreVariables {
+	if cfg.File != "q.graphql" ||
+		!slices.Equal(cfg.Formats, []config.Format{config.FormatBase64URL}) ||
+		!slices.Equal(cfg.Hashes, []config.HashFunction{config.HashFunctionBLAKE3}) ||
+		cfg.Ignore != gqlhash.IgnoreVariables || cfg.Output != config.OutputSARIF ||
+		!slices.Equal(cfg.Schema, []string{"a.graphqls", "schema"}) ||
+		!cfg.WarnDeprecated || cfg.Input != config.InputJSONL || !cfg.Count {
 		t.Errorf("unexpected config: %+v", cfg)
 	}
 
+	// Several functions and formats, in the order given.
+	errOut.Reset()
+	cfg, code, run = config.ParseHasher("gqlhash", hasherArgs(
+		"-hash", "sha2, blake3", "-format", "hex,base64url",
+	), &errOut)
+	if !run || code != 0 {
+		t.Fatalf("expected the lists to parse; code %d, stderr: %s",
+			code, errOut.String())
+	}
+	if !slices.Equal(cfg.Hashes, []config.HashFunction{
+		config.HashFunctionSHA2, config.HashFunctionBLAKE3,
+	}) || !slices.Equal(cfg.Formats, []config.Format{
+		config.FormatHex, config.FormatBase64URL,
+	}) {
+		t.Errorf("unexpected lists: %v, %v", cfg.Hashes, cfg.Formats)
+	}
+
 	// A depth limit below 1 is the default, and the config carries that rather
 	// than what was typed: it's the limit in force, and the proxy logs it.
 	for _, given := range []string{"0", "-5"} {
@@ -104,6 +133,19 @@ func TestParseHasherErrors(t *testing.T) {
 	f(t, 2, "unsupported format", "-format", "rot13")
 	f(t, 2, "unsupported hash function", "-hash", "sha9")
 	f(t, 2, "unsupported ignore mode", "-ignore", "everything")
+	f(t, 2, "unsupported output", "-output", "xml")
+	// A list fails on any value it can't take, a repeated one included.
+	f(t, 2, "unsupported hash function", "-hash", "sha2,sha9")
+	f(t, 2, "unsupported hash function", "-hash", "sha2,sha2")
+	f(t, 2, "unsupported format", "-format", "hex,")
+	f(t, 2, "-warn-deprecated needs a -schema", "-warn-deprecated")
+	f(t, 2, "unsupported input", "-input", "yaml")
+	f(t, 2, "not -dir", "-input", "jsonl", "-dir", "logs")
+	f(t, 2, "-file and -dir go apart", "-file", "q.graphql", "-dir", "queries")
+	f(t, 2, "-watch needs a -dir", "-watch", "-out", "hashes.txt")
+	f(t, 2, "-watch needs an -out", "-watch", "-dir", "queries")
+	f(t, 2, "-watch.interval must be above 0",
+		"-watch", "-dir", "queries", "-out", "hashes.txt", "-watch.interval", "0s")
 
 	// A positional argument is rejected instead of being ignored,
 	// and asking the hashing command for the proxy names the command that has it.
@@ -111,6 +153,169 @@ func TestParseHasherErrors(t *testing.T) {
 	f(t, 2, "the proxy is the "+config.ProxyCommand+" command", "proxy")
 }
 
+func TestParseLearn(t *testing.T) {
+	var errOut strings.Builder
+	cfg, code, run := config.ParseLearn("gqlhash",
+		[]string{config.LearnCommand, "-out", "allowlist"}, &errOut)
+	if !run || code != 0 {
+		t.Fatalf("expected -out alone to parse; code %d, stderr: %s",
+			code, errOut.String())
+	}
+	// A body per line by default, hashed as the proxy hashes by default.
+	if cfg.Out != "allowlist" || cfg.File != "" || cfg.Input != config.InputJSONL ||
+		cfg.HashFunc != config.HashFunctionSHA2 || cfg.Ignore != gqlhash.IgnoreNothing ||
+		cfg.DepthLimit != parser.DefaultDepthLimit {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+
+	cfg, _, run = config.ParseLearn("gqlhash", []string{
+		config.LearnCommand, "-out", "allowlist", "-file", "capture.har",
+		"-input", "har", "-hash", "blake3", "-ignore", "inputs", "-depth-limit", "9",
+	}, &errOut)
+	if !run || cfg.File != "capture.har" || cfg.Input != config.InputHAR ||
+		cfg.HashFunc != config.HashFunctionBLAKE3 || cfg.Ignore != gqlhash.IgnoreInputs ||
+		cfg.DepthLimit != 9 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	for _, td := range []struct {
+		args   []string
+		stderr string
+	}{
+		{nil, "-out names the allowlist directory"},
+		{[]string{"-out", "a", "-input", "graphql"}, "not a capture"},
+		{[]string{"-out", "a", "-input", "yaml"}, "unsupported input"},
+		// The proxy refuses the rest, so an allowlist of them is one it can't serve.
+		{[]string{"-out", "a", "-hash", "md5"}, "unsupported hash function"},
+		{[]string{"-out", "a", "-ignore", "everything"}, "unsupported ignore mode"},
+		{[]string{"-out", "a", "capture.jsonl"}, `unexpected argument "capture.jsonl"`},
+	} {
+		errOut.Reset()
+		_, code, run := config.ParseLearn("gqlhash",
+			append([]string{config.LearnCommand}, td.args...), &errOut)
+		if run || code != 2 || !strings.Contains(errOut.String(), td.stderr) {
+			t.Errorf("%v: expected code 2 and %q; received %d, %q",
+				td.args, td.stderr, code, errOut.String())
+		}
+	}
+}
+
+func TestParseLSP(t *testing.T) {
+	var errOut strings.Builder
+	cfg, code, run := config.ParseLSP("gqlhash", []string{config.LSPCommand}, &errOut)
+	if !run || code != 0 {
+		t.Fatalf("expected no flags to parse; code %d, stderr: %s", code, errOut.String())
+	}
+	// No allowlist, and hashes as the proxy hashes by default.
+	if cfg.AllowlistDir != "" || cfg.HashFunc != config.HashFunctionSHA2 ||
+		cfg.Format != config.FormatHex || cfg.Ignore != gqlhash.IgnoreNothing {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+
+	cfg, _, run = config.ParseLSP("gqlhash", []string{
+		config.LSPCommand, "-allowlist", "queries", "-allowlist.sources", "-allowlist.manifests",
+		"-hash", "sha3", "-format", "base64", "-ignore", "variables",
+	}, &errOut)
+	if !run || cfg.AllowlistDir != "queries" || !cfg.AllowlistSources || !cfg.AllowlistManifests ||
+		cfg.HashFunc != config.HashFunctionSHA3 || cfg.Format != config.FormatBase64 ||
+		cfg.Ignore != gqlhash.IgnoreVariables {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	for _, td := range []struct {
+		args   []string
+		stderr string
+	}{
+		{[]string{"-allowlist.sources"}, "needs an -allowlist"},
+		{[]string{"-allowlist.manifests"}, "needs an -allowlist"},
+		{[]string{"-hash", "crc32"}, "unsupported hash function"},
+		{[]string{"-format", "rot13"}, "unsupported format"},
+		{[]string{"-ignore", "everything"}, "unsupported ignore mode"},
+	} {
+		errOut.Reset()
+		_, code, run := config.ParseLSP("gqlhash",
+			append([]string{config.LSPCommand}, td.args...), &errOut)
+		if run || code != 2 || !strings.Contains(errOut.String(), td.stderr) {
+			t.Errorf("%v: expected code 2 and %q; received %d, %q",
+				td.args, td.stderr, code, errOut.String())
+		}
+	}
+}
+
+func TestParseGenGo(t *testing.T) {
+	var errOut strings.Builder
+	cfg, code, run := config.ParseGenGo("gqlhash", []string{
+		config.GenGoCommand, "-dir", "queries", "-package", "queries",
+	}, &errOut)
+	if !run || code != 0 {
+		t.Fatalf("expected -dir and -package to parse; code %d, stderr: %s",
+			code, errOut.String())
+	}
+	if cfg.Dir != "queries" || cfg.Package != "queries" || cfg.Out != "gqlhash_gen.go" ||
+		cfg.HashFunc != config.HashFunctionSHA2 || cfg.Format != config.FormatHex ||
+		cfg.Ignore != gqlhash.IgnoreNothing || cfg.DepthLimit != parser.DefaultDepthLimit {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+
+	for _, td := range []struct {
+		args   []string
+		stderr string
+	}{
+		{[]string{"-package", "queries"}, "-dir names the directory"},
+		{[]string{"-dir", "q"}, `-package "" is no Go package name`},
+		{[]string{"-dir", "q", "-package", "my-queries"}, "is no Go package name"},
+		{[]string{"-dir", "q", "-package", "q", "-out", ""}, "-out names the file"},
+		{[]string{"-dir", "q", "-package", "q", "-hash", "fnv"}, "unsupported hash function"},
+		{[]string{"-dir", "q", "-package", "q", "-format", "hex,"}, "unsupported format"},
+		{
+			[]string{"-dir", "q", "-package", "q", "-watch", "-watch.interval", "-1s"},
+			"-watch.interval must be above 0",
+		},
+	} {
+		errOut.Reset()
+		_, code, run := config.ParseGenGo("gqlhash",
+			append([]string{config.GenGoCommand}, td.args...), &errOut)
+		if run || code != 2 || !strings.Contains(errOut.String(), td.stderr) {
+			t.Errorf("%v: expected code 2 and %q; received %d, %q",
+				td.args, td.stderr, code, errOut.String())
+		}
+	}
+}
+
+func TestParseCompileAllowlist(t *testing.T) {
+	var errOut strings.Builder
+	cfg, code, run := config.ParseCompileAllowlist("gqlhash", []string{
+		config.CompileAllowlistCommand, "-allowlist", "queries", "-out", "queries.snapshot",
+	}, &errOut)
+	if !run || code != 0 {
+		t.Fatalf("expected -allowlist and -out to parse; code %d, stderr: %s",
+			code, errOut.String())
+	}
+	if cfg.AllowlistDir != "queries" || cfg.Out != "queries.snapshot" ||
+		cfg.AllowlistSources || cfg.HashFunc != config.HashFunctionSHA2 ||
+		cfg.Ignore != gqlhash.IgnoreNothing || cfg.DepthLimit != parser.DefaultDepthLimit {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+
+	for _, td := range []struct {
+		args   []string
+		stderr string
+	}{
+		{[]string{"-out", "s"}, "-allowlist names the directory"},
+		{[]string{"-allowlist", "q"}, "-out names the snapshot file"},
+		{[]string{"-allowlist", "q", "-out", "s", "-hash", "crc32"}, "unsupported hash function"},
+		{[]string{"-allowlist", "q", "-out", "s", "-ignore", "all"}, "unsupported ignore mode"},
+	} {
+		errOut.Reset()
+		_, code, run := config.ParseCompileAllowlist("gqlhash",
+			append([]string{config.CompileAllowlistCommand}, td.args...), &errOut)
+		if run || code != 2 || !strings.Contains(errOut.String(), td.stderr) {
+			t.Errorf("%v: expected code 2 and %q; received %d, %q",
+				td.args, td.stderr, code, errOut.String())
+		}
+	}
+}
+
 func TestParseProxy(t *testing.T) {
 	var errOut strings.Builder
 	cfg, code, run := config.ParseProxy("gqlhash-proxy", proxyArgs(
@@ -212,7 +417,7 @@ func TestParseProxy(t *testing.T) {
 		},
 	}
 	want.Upstream.URL = cfg.Upstream.URL // Compared separately, it's a pointer.
-	if cfg != want {
+	if !reflect.DeepEqual(cfg, want) {
 		t.Errorf("expected %+v; received %+v", want, cfg)
 	}
 	if cfg.Upstream.URL.String() != "https://api/graphql" {
@@ -444,8 +649,8 @@ func TestParseProxyEnv(t *testing.T) {
 	if !run || code != 0 {
 		t.Fatalf("code %d, stderr: %s", code, errOut.String())
 	}
-	if hasher.Hash != config.HashFunctionSHA2 {
-		t.Errorf("expected the default to stand; received %v", hasher.Hash)
+	if !slices.Equal(hasher.Hashes, []config.HashFunction{config.HashFunctionSHA2}) {
+		t.Errorf("expected the default to stand; received %v", hasher.Hashes)
 	}
 }
 
@@ -495,12 +700,75 @@ func TestFlagInventory(t *testing.T) {
 		_, code, run := config.ParseHasher(n, a, w)
 		return code, run
 	}, hasherArgs("-help"), map[string]string{
+		"depth-limit":     "128",
+		"dir":             "",
+		"file":            "",
+		"format":          `"hex"`,
+		"hash":            `"sha2"`,
+		"ignore":          `"nothing"`,
+		"output":          `"text"`,
+		"input":           `"graphql"`,
+		"count":           "",
+		"out":             "",
+		"watch":           "",
+		"watch.interval":  "1s",
+		"schema":          "",
+		"sources":         "",
+		"warn-deprecated": "",
+		"version":         "",
+	})
+
+	f(t, func(n string, a []string, w *strings.Builder) (int, bool) {
+		_, code, run := config.ParseLearn(n, a[1:], w)
+		return code, run
+	}, hasherArgs(config.LearnCommand, "-help"), map[string]string{
 		"depth-limit": "128",
 		"file":        "",
-		"format":      `"hex"`,
 		"hash":        `"sha2"`,
 		"ignore":      `"nothing"`,
-		"version":     "",
+		"input":       `"jsonl"`,
+		"out":         "",
+	})
+
+	f(t, func(n string, a []string, w *strings.Builder) (int, bool) {
+		_, code, run := config.ParseLSP(n, a[1:], w)
+		return code, run
+	}, hasherArgs(config.LSPCommand, "-help"), map[string]string{
+		"allowlist":           "",
+		"allowlist.sources":   "",
+		"allowlist.manifests": "",
+		"depth-limit":         "128",
+		"format":              `"hex"`,
+		"hash":                `"sha2"`,
+		"ignore":              `"nothing"`,
+	})
+
+	f(t, func(n string, a []string, w *strings.Builder) (int, bool) {
+		_, code, run := config.ParseGenGo(n, a[1:], w)
+		return code, run
+	}, hasherArgs(config.GenGoCommand, "-help"), map[string]string{
+		"depth-limit":    "128",
+		"dir":            "",
+		"format":         `"hex"`,
+		"hash":           `"sha2"`,
+		"ignore":         `"nothing"`,
+		"out":            `"gqlhash_gen.go"`,
+		"package":        "",
+		"watch":          "",
+		"watch.interval": "1s",
+	})
+
+	f(t, func(n string, a []string, w *strings.Builder) (int, bool) {
+		_, code, run := config.ParseCompileAllowlist(n, a[1:], w)
+		return code, run
+	}, hasherArgs(config.CompileAllowlistCommand, "-help"), map[string]string{
+		"allowlist":           "",
+		"allowlist.sources":   "",
+		"allowlist.manifests": "",
+		"depth-limit":         "128",
+		"hash":                `"sha2"`,
+		"ignore":              `"nothing"`,
+		"out":                 "",
 	})
 
 	f(t, func(n string, a []string, w *strings.Builder) (int, bool) {
@@ -511,6 +779,18 @@ func TestFlagInventory(t *testing.T) {
 		// and the help text says what 0 means, see -server.max-batch.
 		"server.max-batch":           "",
 		"allowlist":                  "",
+		"allowlist.sources":          "",
+		"allowlist.manifests":        "",
+		"allowlist.sunset-window":    "",
+		"allowlist.watch":            "",
+		"allowlist.strict":           "",
+		"allowlist.max-removed":      "",
+		"allowlist.bundle-key":       "",
+		"allowlist.git-ref":          "",
+		"check-allowlist":            "",
+		"allowlist.client":           "",
+		"allowlist.client-header":    "",
+		"allowlist.also-scheme":      "",
 		"depth-limit":                "128",
 		"hash":                       `"sha2"`,
 		"ignore":                     `"nothing"`,
@@ -557,7 +837,7 @@ func TestNames(t *testing.T) {
 		if !ok {
 			t.Fatalf("expected %q to parse", name)
 		}
-		if got := config.IgnoreName(mode); got != name {
+		if got := mode.String(); got != name {
 			t.Errorf("expected %q; received %q", name, got)
 		}
 	}
@@ -684,6 +964,342 @@ func TestParseProxyMaxConnLifetime(t *testing.T) {
 	}
 }
 
+// TestParseProxyClients covers -allowlist.client and -allowlist.client-header:
+// the clients in the order given, repeated or comma-separated, and either flag
+// refused without the other.
+func TestParseProxyClients(t *testing.T) {
+	var errOut strings.Builder
+	cfg, _, run := config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+		"-allowlist.client-header", "apollographql-client-name",
+		"-allowlist.client", "web=./web",
+		"-allowlist.client", "ios=./ios, android=./android",
+	), &errOut)
+	if !run {
+		t.Fatalf("expected the flags to be taken: %s", errOut.String())
+	}
+	expect := []config.ProxyClient{
+		{Name: "web", Dir: "./web"}, {Name: "ios", Dir: "./ios"},
+		{Name: "android", Dir: "./android"},
+	}
+	if cfg.AllowlistClientHeader != "apollographql-client-name" ||
+		!slices.Equal(cfg.AllowlistClients, expect) {
+		t.Errorf("unexpected clients: %q %+v", cfg.AllowlistClientHeader, cfg.AllowlistClients)
+	}
+
+	for _, td := range []struct {
+		name   string
+		args   []string
+		expect string
+	}{
+		{
+			"header alone", []string{"-allowlist.client-header", "x-client"},
+			"-allowlist.client-header needs an -allowlist.client",
+		},
+		{
+			"client alone", []string{"-allowlist.client", "web=./web"},
+			"-allowlist.client needs an -allowlist.client-header",
+		},
+		{
+			"no dir", []string{"-allowlist.client-header", "x-client",
+				"-allowlist.client", "web"}, `"web" is no name=dir`,
+		},
+		{
+			"twice", []string{"-allowlist.client-header", "x-client",
+				"-allowlist.client", "web=./a", "-allowlist.client", "web=./b"},
+			`client "web" given twice`,
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			var errOut strings.Builder
+			_, code, run := config.ParseProxy("gqlhash-proxy", proxyArgs(append([]string{
+				"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+			}, td.args...)...), &errOut)
+			if run || code != 2 {
+				t.Fatalf("expected a refusal; code %d run %t", code, run)
+			}
+			if !strings.Contains(errOut.String(), td.expect) {
+				t.Errorf("expected %q; received %s", td.expect, errOut.String())
+			}
+		})
+	}
+}
+
+// TestParseProxySunsetWindow covers -allowlist.sunset-window, off by default
+// and refused below 0.
+func TestParseProxySunsetWindow(t *testing.T) {
+	var errOut strings.Builder
+	cfg, _, run := config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+		"-allowlist.sunset-window", "336h",
+	), &errOut)
+	if !run || cfg.AllowlistSunsetWindow != 14*24*time.Hour {
+		t.Fatalf("expected 336h; received %v: %s", cfg.AllowlistSunsetWindow, errOut.String())
+	}
+
+	if cfg, _, _ = config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+	), &errOut); cfg.AllowlistSunsetWindow != 0 {
+		t.Errorf("expected no window by default; received %v", cfg.AllowlistSunsetWindow)
+	}
+
+	errOut.Reset()
+	if _, code, run := config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+		"-allowlist.sunset-window", "-1h",
+	), &errOut); run || code != 2 {
+		t.Fatalf("expected a negative window to be refused; code %d run %t", code, run)
+	}
+	if !strings.Contains(errOut.String(), "-allowlist.sunset-window must be 0 or more") {
+		t.Errorf("unexpected message: %s", errOut.String())
+	}
+}
+
+// TestParseProxyWatch covers -allowlist.watch, off by default and refused
+// below 0.
+func TestParseProxyWatch(t *testing.T) {
+	var errOut strings.Builder
+	cfg, _, run := config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+		"-allowlist.watch", "5s",
+	), &errOut)
+	if !run || cfg.AllowlistWatch != 5*time.Second {
+		t.Fatalf("expected 5s; received %v: %s", cfg.AllowlistWatch, errOut.String())
+	}
+
+	if cfg, _, _ = config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+	), &errOut); cfg.AllowlistWatch != 0 {
+		t.Errorf("expected no watch by default; received %v", cfg.AllowlistWatch)
+	}
+
+	errOut.Reset()
+	if _, code, run := config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+		"-allowlist.watch", "-1s",
+	), &errOut); run || code != 2 {
+		t.Fatalf("expected a negative interval to be refused; code %d run %t", code, run)
+	}
+	if !strings.Contains(errOut.String(), "-allowlist.watch must be 0 or more") {
+		t.Errorf("unexpected message: %s", errOut.String())
+	}
+}
+
+// TestParseProxyReloadPolicy covers -allowlist.strict and
+// -allowlist.max-removed, a count or a percentage, or both given twice.
+func TestParseProxyReloadPolicy(t *testing.T) {
+	parse := func(args ...string) (config.Proxy, int, string) {
+		var errOut strings.Builder
+		cfg, code, _ := config.ParseProxy("gqlhash-proxy", proxyArgs(append([]string{
+			"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+		}, args...)...), &errOut)
+		return cfg, code, errOut.String()
+	}
+
+	cfg, code, errOut := parse()
+	if code != 0 || cfg.AllowlistStrict || cfg.AllowlistMaxRemoved != 0 ||
+		cfg.AllowlistMaxRemovedPercent != 0 {
+		t.Fatalf("expected no policy by default; received %+v: %s", cfg, errOut)
+	}
+
+	cfg, code, errOut = parse("-allowlist.strict",
+		"-allowlist.max-removed", "100", "-allowlist.max-removed", "12.5%")
+	if code != 0 || !cfg.AllowlistStrict || cfg.AllowlistMaxRemoved != 100 ||
+		cfg.AllowlistMaxRemovedPercent != 12.5 {
+		t.Fatalf("expected the policy; received %+v: %s", cfg, errOut)
+	}
+
+	for _, bad := range []string{"-1", "ten", "101%", "-5%", "NaN%", "%"} {
+		if _, code, errOut := parse("-allowlist.max-removed", bad); code != 2 ||
+			!strings.Contains(errOut, "-allowlist.max-removed") {
+			t.Errorf("%s: expected a flag error; received %d: %s", bad, code, errOut)
+		}
+	}
+}
+
+// TestParseProxyBundleKey covers -allowlist.bundle-key: PEM as openssl writes
+// one or the base64 of the key, read at startup.
+func TestParseProxyBundleKey(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	pemFile := write("key.pem", string(pem.EncodeToMemory(
+		&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	rawFile := write("key.b64", base64.StdEncoding.EncodeToString(public)+"\n")
+
+	parse := func(args ...string) (config.Proxy, int, string) {
+		var errOut strings.Builder
+		cfg, code, _ := config.ParseProxy("gqlhash-proxy", proxyArgs(append([]string{
+			"-upstream.url", "http://api/graphql", "-allowlist", "./q.tgz",
+		}, args...)...), &errOut)
+		return cfg, code, errOut.String()
+	}
+	cfg, code, errOut := parse(
+		"-allowlist.bundle-key", pemFile, "-allowlist.bundle-key", rawFile)
+	if code != 0 || len(cfg.AllowlistBundleKeys) != 2 ||
+		!cfg.AllowlistBundleKeys[0].Equal(public) || !cfg.AllowlistBundleKeys[1].Equal(public) {
+		t.Fatalf("expected both keys read; received %d: %s", code, errOut)
+	}
+
+	for _, bad := range []string{
+		filepath.Join(dir, "missing"), write("garbage", "not a key"),
+		write("short", base64.StdEncoding.EncodeToString(public[:16])),
+	} {
+		if _, code, errOut := parse("-allowlist.bundle-key", bad); code != 2 ||
+			!strings.Contains(errOut, "-allowlist.bundle-key") {
+			t.Errorf("%s: expected a flag error; received %d: %s", bad, code, errOut)
+		}
+	}
+}
+
+// TestParseProxyGitRef covers -allowlist.git-ref, and what it can't be given
+// with: a watch finds nothing a commit changes, and no key signs a commit.
+func TestParseProxyGitRef(t *testing.T) {
+	parse := func(args ...string) (config.Proxy, int, string) {
+		var errOut strings.Builder
+		cfg, code, _ := config.ParseProxy("gqlhash-proxy", proxyArgs(append([]string{
+			"-upstream.url", "http://api/graphql", "-allowlist", "./repo/queries",
+		}, args...)...), &errOut)
+		return cfg, code, errOut.String()
+	}
+	cfg, code, errOut := parse("-allowlist.git-ref", "v1.4.0")
+	if code != 0 || cfg.AllowlistGitRef != "v1.4.0" {
+		t.Fatalf("expected v1.4.0; received %d: %s", code, errOut)
+	}
+
+	key := filepath.Join(t.TempDir(), "key.b64")
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(key, []byte(base64.StdEncoding.EncodeToString(public)), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-allowlist.git-ref", "v1", "-allowlist.watch", "5s"},
+		{"-allowlist.git-ref", "v1", "-allowlist.bundle-key", key},
+	} {
+		if _, code, errOut := parse(args...); code != 2 ||
+			!strings.Contains(errOut, "-allowlist.git-ref") {
+			t.Errorf("%v: expected a flag error; received %d: %s", args, code, errOut)
+		}
+	}
+}
+
+// TestParseProxyAllowlistURL covers an allowlist fetched from a URL: taken
+// where it names a host, and refused under -allowlist.git-ref.
+func TestParseProxyAllowlistURL(t *testing.T) {
+	parse := func(args ...string) (config.Proxy, int, string) {
+		var errOut strings.Builder
+		cfg, code, _ := config.ParseProxy("gqlhash-proxy", proxyArgs(append([]string{
+			"-upstream.url", "http://api/graphql",
+		}, args...)...), &errOut)
+		return cfg, code, errOut.String()
+	}
+	const u = "https://allowlists.example.com/web/allowlist.snapshot?token=t"
+	cfg, code, errOut := parse("-allowlist", u, "-allowlist.watch", "30s")
+	if code != 0 || cfg.AllowlistDir != u || !config.IsAllowlistURL(cfg.AllowlistDir) {
+		t.Fatalf("expected the URL; received %d: %s", code, errOut)
+	}
+	if config.IsAllowlistURL("./queries") || config.IsAllowlistURL("ftp://host/q") {
+		t.Error("expected no URL of a directory, nor of another scheme")
+	}
+	for _, c := range []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"-allowlist", "https:///q.snapshot"}, "no absolute URL"},
+		{[]string{"-allowlist", u, "-allowlist.git-ref", "v1"}, "-allowlist.git-ref"},
+		{[]string{"-check-allowlist", u, "-allowlist.git-ref", "v1"}, "-allowlist.git-ref"},
+		{[]string{
+			"-allowlist", "./q", "-allowlist.git-ref", "v1",
+			"-allowlist.client-header", "c", "-allowlist.client", "web=" + u,
+		}, "-allowlist.git-ref"},
+	} {
+		if _, code, errOut := parse(c.args...); code != 2 ||
+			!strings.Contains(errOut, c.expect) {
+			t.Errorf("%v: expected %q; received %d: %s", c.args, c.expect, code, errOut)
+		}
+	}
+}
+
+// TestParseProxySchemes covers -allowlist.also-scheme: a hash function and an
+// ignore mode each, neither what -hash and -ignore name already.
+func TestParseProxySchemes(t *testing.T) {
+	parse := func(args ...string) (config.Proxy, int, string) {
+		var errOut strings.Builder
+		cfg, code, _ := config.ParseProxy("gqlhash-proxy", proxyArgs(append([]string{
+			"-upstream.url", "http://api/graphql", "-allowlist", "./q",
+		}, args...)...), &errOut)
+		return cfg, code, errOut.String()
+	}
+	cfg, code, errOut := parse("-ignore", "inputs",
+		"-allowlist.also-scheme", "sha2:nothing, blake3:inputs",
+		"-allowlist.also-scheme", "SHA3:variables")
+	if code != 0 {
+		t.Fatalf("expected the schemes; received %d: %s", code, errOut)
+	}
+	var names []string
+	for _, s := range cfg.AllowlistSchemes {
+		names = append(names, s.String())
+	}
+	if expect := []string{"sha2:nothing", "blake3:inputs", "sha3:variables"}; !slices.Equal(
+		names, expect) {
+		t.Errorf("expected %v; received %v", expect, names)
+	}
+
+	for _, c := range []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"-allowlist.also-scheme", "sha2"}, "no hash:ignore"},
+		{[]string{"-allowlist.also-scheme", "md5:nothing"}, "unsupported hash function"},
+		{[]string{"-allowlist.also-scheme", "sha2:everything"}, "unsupported ignore mode"},
+		{[]string{"-allowlist.also-scheme", "sha3:inputs,sha3:inputs"}, "given twice"},
+		{[]string{"-allowlist.also-scheme", "sha2:nothing"}, "-hash and -ignore key by"},
+	} {
+		if _, code, errOut := parse(c.args...); code != 2 ||
+			!strings.Contains(errOut, c.expect) {
+			t.Errorf("%v: expected %q; received %d: %s", c.args, c.expect, code, errOut)
+		}
+	}
+}
+
+// TestParseProxyCheckAllowlist covers -check-allowlist: it needs none of what
+// serving does, and still refuses a bad value of what hashing does.
+func TestParseProxyCheckAllowlist(t *testing.T) {
+	var errOut strings.Builder
+	cfg, code, run := config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-check-allowlist", "./q", "-hash", "blake3",
+	), &errOut)
+	if !run || code != 0 || cfg.CmdCheckAllowlist != "./q" ||
+		cfg.HashFunc != config.HashFunctionBLAKE3 {
+		t.Fatalf("expected a check of ./q with blake3; code %d run %t: %s",
+			code, run, errOut.String())
+	}
+
+	errOut.Reset()
+	if _, code, run := config.ParseProxy("gqlhash-proxy", proxyArgs(
+		"-check-allowlist", "./q", "-hash", "md5",
+	), &errOut); run || code != 2 {
+		t.Errorf("expected a bad -hash refused; code %d run %t", code, run)
+	}
+}
+
 // TestParseProxyTLSCA covers -upstream.tls.ca: the certificates are read at startup,
 // so a file that can't be used is a start failure and not an upstream
 // that turns out to be unreachable at the first forward.
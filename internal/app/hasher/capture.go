@@ -0,0 +1,190 @@
+package hasher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+	"github.com/romshark/gqlhash/v2/internal/jsonrequest"
+)
+
+// request is one GraphQL-over-HTTP request of a capture: where it is, and its
+// JSON body or, for a GET, the document of its query parameter.
+// Line and column are 0 where there's no such place, as for the one body of
+// -input=json, and the column is 0 where a request is a line of its own.
+// err is why a request that is one can't be read.
+type request struct {
+	line, column int
+	body         []byte
+	document     string
+	err          error
+}
+
+// errDuplicateQuery is a GET naming the query parameter twice, which the proxy
+// refuses rather than choose between.
+var errDuplicateQuery = errors.New("duplicate query parameter")
+
+// documents returns the documents r carries, read as the proxy reads what it
+// forwards, see [jsonrequest]. Every element of a batch is read, however many:
+// the cap of the proxy bounds what one request costs it, and a capture is read
+// to see what was sent.
+func (r request) documents() ([]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.body == nil {
+		return []string{r.document}, nil
+	}
+	spans, err := jsonrequest.Extract(nil, r.body, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]string, len(spans))
+	var scratch, value []byte
+	for i, s := range spans {
+		value, scratch, err = jsonrequest.Unescape(scratch, r.body[s.Start:s.End])
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = string(value)
+	}
+	return docs, nil
+}
+
+// readRequests calls each for every request in, which holds what input names:
+// one request body, a body per line, or a HAR log. A blank line is no request,
+// the last one of a file ending in a newline least of all.
+//
+// A body per line is read a line at a time, so a capture of any size streams
+// through. A HAR log is one JSON value and is read whole.
+func readRequests(in io.Reader, input config.Input, each func(request)) error {
+	switch input {
+	case config.InputJSON:
+		body, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		each(request{body: body})
+		return nil
+	case config.InputHAR:
+		log, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		return readHAR(log, each)
+	}
+
+	r := bufio.NewReader(in)
+	for line := 1; ; line++ {
+		body, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(body)) > 0 {
+			each(request{line: line, body: body})
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// harEntry is what's read of an entry of a HAR log, see
+// http://www.softwareishard.com/blog/har-12-spec/#entries.
+type harEntry struct {
+	Request struct {
+		Method   string `json:"method"`
+		URL      string `json:"url"`
+		PostData *struct {
+			Text string `json:"text"`
+		} `json:"postData"`
+	} `json:"request"`
+}
+
+// readHAR calls each for every GraphQL request of the HAR log src, at the line
+// and the column its entry begins at. A browser records every request of the
+// page, so an entry that carries no GraphQL request is none of them: a GET
+// without the query parameter, and a body without a query member or that isn't
+// JSON at all.
+func readHAR(src []byte, each func(request)) error {
+	d := json.NewDecoder(bytes.NewReader(src))
+	for _, member := range []string{"log", "entries"} {
+		if err := enter(d, member); err != nil {
+			return fmt.Errorf("reading the HAR log: %w", err)
+		}
+	}
+	if t, err := d.Token(); err != nil || t != json.Delim('[') {
+		return errors.New("reading the HAR log: log.entries is no array")
+	}
+
+	for d.More() {
+		// The offset is past the token before, so what separates it from the
+		// entry is skipped to reach the entry's brace.
+		start := int(d.InputOffset())
+		for start < len(src) && bytes.IndexByte([]byte(" \t\r\n,"), src[start]) >= 0 {
+			start++
+		}
+		var e harEntry
+		if err := d.Decode(&e); err != nil {
+			return fmt.Errorf("reading the HAR log: %w", err)
+		}
+		r := request{}
+		r.line, r.column = gqlhash.Position(src, start)
+
+		switch {
+		case e.Request.PostData != nil:
+			r.body = []byte(e.Request.PostData.Text)
+			if _, err := jsonrequest.Extract(nil, r.body, math.MaxInt); errors.Is(err,
+				jsonrequest.ErrNoQuery) || errors.Is(err, jsonrequest.ErrMalformedJSON) {
+				continue
+			}
+		case e.Request.Method == "GET":
+			u, err := url.Parse(e.Request.URL)
+			if err != nil {
+				continue
+			}
+			values := u.Query()["query"]
+			switch len(values) {
+			case 0:
+				continue
+			case 1:
+				r.document = values[0]
+			default:
+				r.err = errDuplicateQuery
+			}
+		default:
+			continue
+		}
+		each(r)
+	}
+	return nil
+}
+
+// enter reads d up to the value of the member named name of the object that
+// begins next, skipping the members before it.
+func enter(d *json.Decoder, name string) error {
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return fmt.Errorf("no object holding %q", name)
+	}
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if t == name {
+			return nil
+		}
+		var skip json.RawMessage
+		if err := d.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("no member %q", name)
+}
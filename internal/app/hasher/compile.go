@@ -0,0 +1,66 @@
+package hasher
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+)
+
+// compileAllowlist runs compile-allowlist: it reads -allowlist as the proxy
+// does, schema check included, and writes its snapshot to -out, see
+// [allowlist.Allowlist.WriteSnapshot], which the proxy loads as -allowlist
+// without parsing a document.
+//
+// Where the proxy leaves a document out and serves the rest, this fails: what a
+// build step compiles is what it means to serve, so a document left out, or a
+// schema that can't be read, is reported on stderr and nothing is written.
+func compileAllowlist(args []string, stderr io.Writer) (exitCode int) {
+	cfg, code, run := config.ParseCompileAllowlist(args[0], args[1:], stderr)
+	if !run {
+		return code
+	}
+	if _, ok := config.NewHasher(cfg.HashFunc); !ok {
+		// config.ParseCompileAllowlist takes no other value, see Run.
+		_, _ = fmt.Fprintf(stderr, "unsupported hash function: %d\n", cfg.HashFunc)
+		return 1
+	}
+	newHash := func() hash.Hash {
+		h, _ := config.NewHasher(cfg.HashFunc)
+		return h
+	}
+
+	list := allowlist.NewWithConfig(newHash,
+		gqlhash.Options{Ignore: cfg.Ignore, DepthLimit: cfg.DepthLimit},
+		allowlist.Config{
+			Sources: cfg.AllowlistSources, Manifests: cfg.AllowlistManifests,
+			Function: config.HashName(cfg.HashFunc),
+		})
+	result, err := list.Reload(cfg.AllowlistDir)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "error reading the allowlist: %v\n", err)
+		return 1
+	}
+	for _, e := range result.Skipped {
+		_, _ = fmt.Fprintln(stderr, e)
+		exitCode = 1
+	}
+	if result.SchemaErr != nil {
+		_, _ = fmt.Fprintf(stderr, "error reading the schema: %v\n", result.SchemaErr)
+		exitCode = 1
+	}
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	var b bytes.Buffer
+	if err := list.WriteSnapshot(&b); err != nil {
+		_, _ = fmt.Fprintf(stderr, "error writing the snapshot: %v\n", err)
+		return 1
+	}
+	return writeOut(cfg.Out, b.Bytes(), stderr)
+}
@@ -0,0 +1,266 @@
+package hasher
+
+import (
+	"context"
+	"fmt"
+	"go/format"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+	"github.com/romshark/gqlhash/v2/internal/operations"
+	"github.com/romshark/gqlhash/v2/internal/watch"
+)
+
+// generated is an operation of gen-go: the Go name its constants take, where
+// it's from, and what they hold.
+//
+// file is where the operation is, for an error, and source the same under
+// -dir, for the comment of its constants.
+type generated struct {
+	ident, file, source string
+	op                  operations.Operation
+	hash                string
+}
+
+// genGo runs gen-go: it writes a Go file of the operations of -dir, each as a
+// client sends it alone, see [operations.Split], with a constant of its
+// document, its hash and its type, and a map of every hash to its document:
+//
+//	const (
+//		GetUserDocument = `query GetUser { … }`
+//		GetUserHash     = "9a0b…"
+//		GetUserType     = "query"
+//	)
+//
+// The hash is computed with -hash, -ignore and -format, the proxy's vocabulary,
+// so it's what the proxy computes for the document a client sends.
+//
+// Nothing is written where a document fails, or two operations take one name
+// or one hash, which a Go file can't hold: a generator run by go:generate
+// leaves the last good file rather than a partial one. The file is replaced
+// whole, see [writeFile], and -watch generates it again whenever a file of -dir
+// changes, until ctx is done.
+func genGo(ctx context.Context, args []string, stderr io.Writer) (exitCode int) {
+	cfg, code, run := config.ParseGenGo(args[0], args[1:], stderr)
+	if !run {
+		return code
+	}
+	h, ok := config.NewHasher(cfg.HashFunc)
+	if !ok {
+		// config.ParseGenGo takes no other value, see Run.
+		_, _ = fmt.Fprintf(stderr, "unsupported hash function: %d\n", cfg.HashFunc)
+		return 1
+	}
+	g := generator{
+		cfg: cfg, hash: h, cache: map[string]genFile{},
+		options: gqlhash.Options{Ignore: cfg.Ignore, DepthLimit: cfg.DepthLimit},
+	}
+	if cfg.Watch {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		watchDir(ctx, cfg.Dir, false, cfg.WatchInterval, func() { _ = g.generate(stderr) })
+		return 0
+	}
+	return g.generate(stderr)
+}
+
+// generator is what gen-go generates with, and cache what it made of every
+// file of the last round, by name, which a round of -watch takes again for a
+// file that hasn't changed since.
+type generator struct {
+	cfg     config.GenGo
+	hash    gqlhash.Hash
+	options gqlhash.Options
+	cache   map[string]genFile
+}
+
+// genFile is what's generated of a file as it was when last read: its
+// operations, or the errors that keep the file from being written.
+type genFile struct {
+	state watch.State
+	ops   []generated
+	errs  []string
+}
+
+// generate writes the file of the operations of -dir, see [genGo].
+func (g *generator) generate(stderr io.Writer) (exitCode int) {
+	files, err := walk(g.cfg.Dir, false)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "error reading directory %q: %v\n", g.cfg.Dir, err)
+		return 1
+	}
+
+	var ops []generated
+	byIdent, byHash := map[string]generated{}, map[string]generated{}
+	listed := make(map[string]bool, len(files))
+	for _, file := range files {
+		listed[file] = true
+		f := g.file(file)
+		for _, e := range f.errs {
+			_, _ = fmt.Fprintln(stderr, e)
+			exitCode = 1
+		}
+		for _, o := range f.ops {
+			if other, ok := byIdent[o.ident]; ok {
+				_, _ = fmt.Fprintf(stderr, "%s: named %s, as %s is\n", o.file, o.ident, other.file)
+				exitCode = 1
+				continue
+			}
+			if other, ok := byHash[o.hash]; ok {
+				_, _ = fmt.Fprintf(stderr, "%s: the same hash as %s\n", o.file, other.file)
+				exitCode = 1
+				continue
+			}
+			byIdent[o.ident], byHash[o.hash] = o, o
+			ops = append(ops, o)
+		}
+	}
+	maps.DeleteFunc(g.cache, func(file string, _ genFile) bool { return !listed[file] })
+	if exitCode != 0 {
+		return exitCode
+	}
+
+	src, err := format.Source(goFile(g.cfg, ops))
+	if err != nil {
+		// What's generated is gofmt's to take, so this is a bug here.
+		_, _ = fmt.Fprintf(stderr, "error formatting the generated file: %v\n", err)
+		return 1
+	}
+	return writeOut(g.cfg.Out, src, stderr)
+}
+
+// file is what's generated of file, read again only where it changed since
+// the last call, see [generator.cache].
+func (g *generator) file(file string) genFile {
+	info, err := os.Stat(file)
+	if err != nil {
+		return genFile{errs: []string{fmt.Sprintf("error reading file %q: %v", file, err)}}
+	}
+	if cached, ok := g.cache[file]; ok && cached.state == watch.StateOf(file, info) {
+		return cached
+	}
+	f := g.read(file)
+	f.state = watch.StateOf(file, info)
+	g.cache[file] = f
+	return f
+}
+
+// read hashes every operation of file.
+func (g *generator) read(file string) (f genFile) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		f.errs = append(f.errs, fmt.Sprintf("error reading file %q: %v", file, err))
+		return f
+	}
+	text := string(src)
+	if _, errHash := gqlhash.AppendHash(nil, g.hash, g.options, text); errHash.IsErr() {
+		line, column := gqlhash.Position(text, errHash.ErrOffset)
+		f.errs = append(f.errs, fmt.Sprintf("%s:%d:%d: syntax error: %v",
+			file, line, column, errHash.Err))
+		return f
+	}
+	split, err := operations.Split(text)
+	if err != nil {
+		f.errs = append(f.errs, fmt.Sprintf("%s: %v", file, err))
+		return f
+	}
+
+	// A file is named under -dir in what's generated, so the output is the
+	// same wherever it's generated from.
+	rel, err := filepath.Rel(g.cfg.Dir, file)
+	if err != nil {
+		rel = file
+	}
+	for _, o := range split {
+		line, column := gqlhash.Position(text, o.Offset)
+		at := fmt.Sprintf("%s:%d:%d", file, line, column)
+		sum, errHash := gqlhash.AppendHash(nil, g.hash, g.options, o.Document)
+		if errHash.IsErr() {
+			f.errs = append(f.errs, fmt.Sprintf("%s: syntax error: %v", at, errHash.Err))
+			continue
+		}
+		// Checked in config.ParseGenGo, so this can't fail.
+		encoded, _ := config.Encode(g.cfg.Format, sum)
+
+		name := o.Name
+		if name == "" {
+			// An anonymous operation is named after its file.
+			name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		f.ops = append(f.ops, generated{
+			ident: identifier(name), file: at, op: o, hash: encoded,
+			source: fmt.Sprintf("%s:%d:%d", filepath.ToSlash(rel), line, column),
+		})
+	}
+	return f
+}
+
+// goFile is the source of the generated file, unformatted.
+func goFile(cfg config.GenGo, ops []generated) []byte {
+	var b strings.Builder
+	b.WriteString("// Code generated by gqlhash gen-go. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", cfg.Package)
+
+	b.WriteString("// The hashes below are computed as the proxy computes them with these.\n")
+	fmt.Fprintf(&b, "const (\n\tHashFunction = %q\n\tHashFormat = %q\n\tHashIgnore = %q\n)\n",
+		config.HashName(cfg.HashFunc), config.FormatName(cfg.Format),
+		cfg.Ignore.String())
+
+	for _, g := range ops {
+		what := g.op.Type
+		if g.op.Name != "" {
+			what += " " + g.op.Name
+		}
+		fmt.Fprintf(&b, "\n// %s is the %s of %s.\n", g.ident, what, g.source)
+		fmt.Fprintf(&b, "const (\n\t%sDocument = %s\n\t%sHash = %q\n\t%sType = %q\n)\n",
+			g.ident, goString(g.op.Document), g.ident, g.hash, g.ident, g.op.Type)
+	}
+
+	b.WriteString("\n// Documents maps the hash of every operation above to its document.\n")
+	b.WriteString("var Documents = map[string]string{\n")
+	for _, g := range ops {
+		fmt.Fprintf(&b, "\t%sHash: %sDocument,\n", g.ident, g.ident)
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// goString is s as a Go string literal: raw, as the document reads, where it
+// holds no backquote and no carriage return, which a raw string drops.
+func goString(s string) string {
+	if strings.ContainsAny(s, "`\r") || !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
+
+// identifier is the exported Go name the constants of the operation name take:
+// the name with its first letter upper case, underscores leading it dropped.
+// A file name may hold what no Go name does, which becomes an underscore.
+func identifier(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	s := strings.TrimLeft(b.String(), "_")
+	first, size := utf8.DecodeRuneInString(s)
+	if first = unicode.ToUpper(first); !unicode.IsUpper(first) {
+		// A digit leads, a letter with no upper case, or nothing: no exported
+		// name begins with any of them.
+		return "Operation" + s
+	}
+	return string(first) + s[size:]
+}
@@ -1,23 +1,47 @@
 // Package hasher is the hashing command line interface of gqlhash.
 //
-// The proxy is a separate binary, so nothing here links an HTTP server,
-// a metrics client or a schema validator.
+// The proxy is a separate binary, so nothing here links an HTTP server or
+// a metrics client. The schema check of -schema is the one the proxy checks its
+// allowlist with, see [schema], so it refuses what the proxy would skip.
 package hasher
 
 import (
-	"encoding/base32"
-	"encoding/base64"
-	"encoding/hex"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/vektah/gqlparser/v2/ast"
 
 	"github.com/romshark/gqlhash/v2"
 	"github.com/romshark/gqlhash/v2/internal/app/config"
+	"github.com/romshark/gqlhash/v2/internal/app/lsp"
 	"github.com/romshark/gqlhash/v2/internal/app/versioninfo"
+	"github.com/romshark/gqlhash/v2/internal/embedded"
+	"github.com/romshark/gqlhash/v2/internal/operations"
+	"github.com/romshark/gqlhash/v2/internal/schema"
+	"github.com/romshark/gqlhash/v2/internal/watch"
 )
 
 // Run hashes the document of stdin or of -file and writes the result to stdout.
+// A source file given as -file, or a -dir, holds several documents, each of which
+// gets a line of its own, see [runDocuments]. -output picks the shape of what's
+// written, see [report]. -out writes it to a file instead, and -watch writes it
+// again whenever a file of -dir changes, see [watch].
+//
+// gqlhash learn writes an allowlist out of a traffic capture instead, see [learn],
+// gqlhash lsp serves the language server, see [lsp.Run], gqlhash gen-go
+// generates Go constants of the operations of a directory, see [genGo], and
+// gqlhash compile-allowlist writes the snapshot the proxy loads an allowlist from,
+// see [compileAllowlist].
 //
 // name and version are what -version reports, so the output names the binary
 // the caller ran. args[0] is the command as invoked, as in [os.Args].
@@ -27,6 +51,29 @@ func Run(
 	stdout, stderr io.Writer,
 	stdin io.Reader,
 ) (exitCode int) {
+	return RunContext(context.Background(), name, version, args, stdout, stderr, stdin)
+}
+
+// RunContext is [Run] with a ctx that ends a -watch, as an interrupt does.
+func RunContext(
+	ctx context.Context,
+	name, version string,
+	args []string,
+	stdout, stderr io.Writer,
+	stdin io.Reader,
+) (exitCode int) {
+	if len(args) > 1 && args[1] == config.LearnCommand {
+		return learn(args, stdout, stderr, stdin)
+	}
+	if len(args) > 1 && args[1] == config.LSPCommand {
+		return lsp.Run(name, version, args, stdout, stderr, stdin)
+	}
+	if len(args) > 1 && args[1] == config.GenGoCommand {
+		return genGo(ctx, args, stderr)
+	}
+	if len(args) > 1 && args[1] == config.CompileAllowlistCommand {
+		return compileAllowlist(args, stderr)
+	}
 	cfg, code, run := config.ParseHasher(args[0], args, stderr)
 	if !run {
 		return code
@@ -35,13 +82,121 @@ func Run(
 		return printVersion(stdout, name, version)
 	}
 
+	hashes := make([]gqlhash.Hash, len(cfg.Hashes))
+	for i, f := range cfg.Hashes {
+		h, ok := config.NewHasher(f)
+		if !ok {
+			// config.ParseHasher takes no other value, so this is a function added
+			// to the vocabulary and not to the table that builds them.
+			_, _ = fmt.Fprintf(stderr, "unsupported hash function: %d\n", f)
+			return 1
+		}
+		hashes[i] = h
+	}
+	for _, f := range cfg.Formats {
+		if _, ok := config.Encode(f, nil); !ok {
+			// The same for a format added to the vocabulary and not to the table
+			// that encodes them.
+			_, _ = fmt.Fprintf(stderr, "unsupported output format: %d\n", f)
+			return 1
+		}
+	}
+
+	var typeSystem *ast.Schema
+	if len(cfg.Schema) > 0 {
+		var err error
+		// Unlike the proxy, which serves unchecked rather than not at all,
+		// a schema asked for and unreadable is a failure: checking against
+		// nothing would pass every document it was asked to check.
+		if typeSystem, err = schema.Load(cfg.Schema...); err != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading the schema: %v\n", err)
+			return 1
+		}
+		if typeSystem == nil {
+			_, _ = fmt.Fprintf(stderr, "no %s file in %s\n",
+				schema.Ext, strings.Join(cfg.Schema, ", "))
+			return 1
+		}
+	}
+
+	requests := cfg.Input != config.InputGraphQL
+	several := requests || cfg.Dir != "" || embedded.IsSource(cfg.File)
+	c := command{
+		cfg: cfg, hashes: hashes, typeSystem: typeSystem,
+		cache: map[string]cachedFile{},
+	}
+	if cfg.Watch {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		// A round that fails is reported and the watch goes on: the next change
+		// is likely the fix.
+		watchDir(ctx, cfg.Dir, cfg.Sources, cfg.WatchInterval, func() {
+			var out bytes.Buffer
+			c.report = newReport(cfg, version, several, &out, stderr)
+			_ = c.runDocuments(stderr)
+			_ = c.report.end()
+			_ = writeOut(cfg.Out, out.Bytes(), stderr)
+		})
+		return 0
+	}
+
+	var out bytes.Buffer
+	if cfg.Out != "" {
+		stdout = &out
+	}
+	c.report = newReport(cfg, version, several, stdout, stderr)
+	switch {
+	case requests:
+		exitCode = c.runRequests(stderr, stdin)
+	case several:
+		exitCode = c.runDocuments(stderr)
+	default:
+		exitCode = c.runDocument(stderr, stdin)
+	}
+	if code := c.report.end(); code != 0 {
+		return code
+	}
+	if cfg.Out != "" {
+		if code := writeOut(cfg.Out, out.Bytes(), stderr); code != 0 {
+			return code
+		}
+	}
+	return exitCode
+}
+
+// command is one run of the hashing command.
+type command struct {
+	cfg config.Hasher
+
+	// hashes are those of -hash, in order, every one of which a document is
+	// written to in the one parse, see [gqlhash.AppendHashes].
+	hashes []gqlhash.Hash
+
+	// typeSystem is the schema of -schema, nil for none.
+	typeSystem *ast.Schema
+
+	report report
+
+	// cache holds the records of every file of the last round, by name, which
+	// a round of -watch reports again for a file that hasn't changed since.
+	cache map[string]cachedFile
+}
+
+// cachedFile is what's reported of a file as it was when last read.
+type cachedFile struct {
+	state   watch.State
+	records []record
+}
+
+// runDocument hashes the document of stdin or of -file.
+func (c *command) runDocument(stderr io.Writer, stdin io.Reader) (exitCode int) {
 	var input []byte
 	var err error
 	source := "<stdin>"
-	if cfg.File != "" {
-		source = cfg.File
-		if input, err = os.ReadFile(cfg.File); err != nil {
-			_, _ = fmt.Fprintf(stderr, "error reading file %q: %v\n", cfg.File, err)
+	if c.cfg.File != "" {
+		source = c.cfg.File
+		if input, err = os.ReadFile(c.cfg.File); err != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading file %q: %v\n", c.cfg.File, err)
 			return 1
 		}
 	} else {
@@ -56,61 +211,291 @@ func Run(
 		_, _ = fmt.Fprintln(stderr, "no input")
 		return 1
 	}
+	return c.emit(c.hash(record{File: source}, string(input), nil))
+}
 
-	h, ok := config.NewHasher(cfg.Hash)
-	if !ok {
-		// config.ParseHasher takes no other value, so this is a function added
-		// to the vocabulary and not to the table that builds them.
-		_, _ = fmt.Fprintf(stderr, "unsupported hash function: %d\n", cfg.Hash)
-		return 1
+// runDocuments hashes every document of -dir, or those a source file given as
+// -file embeds, and reports each: as text that's the hash, two spaces and where
+// the document is, the way shasum lists files. That's the file for a whole one
+// and the file, line and column the document begins at for an embedded one:
+//
+//	4f1c…  queries/user.graphql
+//	9a0b…  src/user.ts:12:28
+//
+// A document that fails is reported and the rest are still hashed, so one
+// syntax error doesn't hide the next. Any failure exits 1.
+func (c *command) runDocuments(stderr io.Writer) (exitCode int) {
+	files := []string{c.cfg.File}
+	if c.cfg.Dir != "" {
+		var err error
+		if files, err = walk(c.cfg.Dir, c.cfg.Sources); err != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading directory %q: %v\n",
+				c.cfg.Dir, err)
+			return 1
+		}
 	}
-	sum, errHash := gqlhash.AppendHash(nil, h,
-		gqlhash.Options{Ignore: cfg.Ignore, DepthLimit: cfg.DepthLimit}, input)
-	if errHash.IsErr() {
-		// A hash never fails a write, so this carries a position.
-		// The format is the one editors and CI annotations parse.
-		line, column := gqlhash.Position(input, errHash.ErrOffset)
-		_, _ = fmt.Fprintf(stderr, "%s:%d:%d: syntax error: %v\n",
-			source, line, column, errHash.Err)
-		return 1
+
+	listed := make(map[string]bool, len(files))
+	for _, file := range files {
+		listed[file] = true
+		records, err := c.records(file)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading file %q: %v\n", file, err)
+			exitCode = 1
+			continue
+		}
+		for _, r := range records {
+			if code := c.emit(r); code != 0 {
+				exitCode = code
+			}
+		}
 	}
+	// A file removed since is no longer reported, nor held.
+	maps.DeleteFunc(c.cache, func(file string, _ cachedFile) bool {
+		return !listed[file]
+	})
+	return exitCode
+}
 
-	var encoded string
-	switch cfg.Format {
-	case config.FormatHex:
-		encoded = hex.EncodeToString(sum)
-	case config.FormatBase32:
-		encoded = base32.StdEncoding.EncodeToString(sum)
-	case config.FormatBase64:
-		encoded = base64.StdEncoding.EncodeToString(sum)
-	case config.FormatBase64URL:
-		encoded = base64.URLEncoding.EncodeToString(sum)
-	default:
-		// config.ParseHasher takes no other value, so this is a format that was
-		// added to the vocabulary and not to this switch.
-		_, _ = fmt.Fprintf(stderr, "unsupported output format: %d\n", cfg.Format)
-		return 1
+// records are those of the documents of file: the one document of a document
+// file, or every one a source file embeds, along with what couldn't be read.
+// A file that hasn't changed since the last call isn't read again, see
+// [command.cache].
+func (c *command) records(file string) ([]record, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
 	}
+	if cached, ok := c.cache[file]; ok && cached.state == watch.StateOf(file, info) {
+		return cached.records, nil
+	}
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	if !embedded.IsSource(file) {
+		records = []record{c.hash(record{File: file}, string(src), nil)}
+	} else {
+		docs, errs := embedded.Extract(file, src)
+		for _, err := range errs {
+			records = append(records, extractionRecord(file, err))
+		}
+		for _, d := range docs {
+			r := record{File: file, Line: d.Line, Column: d.Column, Name: d.Name}
+			// A syntax error is at an offset into the document put together out
+			// of the templates, which Position maps back to the file.
+			records = append(records, c.hash(r, d.Text, d.Position))
+		}
+	}
+	// What's stated is the file as it was before it was read: one written
+	// meanwhile is stated otherwise the next time, and read again.
+	c.cache[file] = cachedFile{state: watch.StateOf(file, info), records: records}
+	return records, nil
+}
 
-	// The hash and a newline, which is what every tool of this kind writes —
-	// shasum, md5, git hash-object, openssl dgst — and what makes the output a
-	// line: without it `read h < hash.txt` hands the hash over and reports
-	// failure, a `while read` over the file runs no iteration at all, and two
-	// hashes appended to one file run together. Command substitution strips it,
-	// so `$(gqlhash …)` reads the same either way.
-	//
-	// One write, so a hash reaches a pipe whole.
-	//
-	// A failed write is what a closed pipe looks like: `gqlhash -file q.graphql
-	// | head -1` leaves nobody to read the answer. An exit code like every other
-	// failure here, rather than a goroutine dump over what the reader did get.
-	if _, err = io.WriteString(stdout, encoded+"\n"); err != nil {
-		_, _ = fmt.Fprintf(stderr, "writing the hash: %v\n", err)
+// emit reports r. exitCode is 1 where r failed or the report couldn't be
+// written, and the caller can't tell which: either way the run fails.
+func (c *command) emit(r record) (exitCode int) {
+	if code := c.report.document(r); code != 0 {
+		return code
+	}
+	if len(r.Errors) > 0 {
 		return 1
 	}
 	return 0
 }
 
+// runRequests hashes the documents of the GraphQL-over-HTTP requests of -file or
+// stdin: the one body of -input=json, a body per line of -input=jsonl, or the
+// requests of the HAR log of -input=har, see [readRequests].
+//
+// A document is reported at the line of its request. A HAR entry has a column
+// too; a line of a capture has none, and a document's place in a JSON string,
+// escapes and all, is no place an editor opens usefully.
+func (c *command) runRequests(stderr io.Writer, stdin io.Reader) (exitCode int) {
+	source, in := "<stdin>", stdin
+	if c.cfg.File != "" {
+		f, err := os.Open(c.cfg.File)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading file %q: %v\n", c.cfg.File, err)
+			return 1
+		}
+		defer func() { _ = f.Close() }()
+		source, in = c.cfg.File, f
+	}
+
+	err := readRequests(in, c.cfg.Input, func(r request) {
+		if code := c.hashRequest(source, r); code != 0 {
+			exitCode = code
+		}
+	})
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "error reading %s: %v\n", source, err)
+		return 1
+	}
+	return exitCode
+}
+
+// hashRequest hashes every document of the request r of file.
+func (c *command) hashRequest(file string, r request) (exitCode int) {
+	at := record{File: file, Line: r.line, Column: r.column}
+	docs, err := r.documents()
+	if err != nil {
+		at.Errors = []diagnostic{{
+			Rule: ruleRequest, Message: err.Error(), Line: r.line, Column: r.column,
+		}}
+		return c.emit(at)
+	}
+	position := func(int) (int, int) { return r.line, r.column }
+	for _, d := range docs {
+		if code := c.emit(c.hash(at, d, position)); code != 0 {
+			exitCode = code
+		}
+	}
+	return exitCode
+}
+
+// hash hashes text into r, which holds the errors it failed with, if any.
+// position maps an offset of text to the file, nil where text is the whole file.
+func (c *command) hash(
+	r record, text string, position func(offset int) (line, column int),
+) record {
+	r.Function = config.HashName(c.cfg.Hashes[0])
+	r.Format = config.FormatName(c.cfg.Formats[0])
+	r.Ignore = c.cfg.Ignore.String()
+
+	if position == nil {
+		position = func(offset int) (int, int) {
+			return gqlhash.Position(text, offset)
+		}
+	}
+
+	sums, errHash := gqlhash.AppendHashes(nil, c.hashes,
+		gqlhash.Options{Ignore: c.cfg.Ignore, DepthLimit: c.cfg.DepthLimit}, text)
+	if errHash.IsErr() {
+		line, column := position(errHash.ErrOffset)
+		rule := ruleSyntax
+		if errors.Is(errHash.Err, gqlhash.ErrTooDeep) {
+			rule = ruleDepthLimit
+		}
+		r.Errors = []diagnostic{{
+			Rule: rule, Message: errHash.Err.Error(), Line: line, Column: column,
+		}}
+		return r
+	}
+
+	// Split by the parser that hashed it, so a document that hashed splits.
+	ops, err := operations.Split(text)
+	if err != nil {
+		line, column := position(0)
+		r.Errors = []diagnostic{{
+			Rule: ruleSyntax, Message: err.Error(), Line: line, Column: column,
+		}}
+		return r
+	}
+
+	if c.typeSystem != nil {
+		// A document the schema refuses has no hash, as the proxy has no entry
+		// for it: it's skipped at reload.
+		doc, errs := schema.Validate(c.typeSystem, text)
+		for _, e := range errs {
+			r.Errors = append(r.Errors, schemaDiagnostic(ruleSchema, e, text, position))
+		}
+		if len(errs) > 0 {
+			return r
+		}
+		if c.cfg.WarnDeprecated {
+			for _, e := range schema.Deprecated(doc) {
+				r.Warnings = append(r.Warnings,
+					schemaDiagnostic(ruleDeprecated, e, text, position))
+			}
+		}
+	}
+
+	// Every function in every format, functions first, so the hashes of one
+	// function are next to each other.
+	for i, h := range c.hashes {
+		sum := sums[:h.Size()]
+		sums = sums[h.Size():]
+		for _, f := range c.cfg.Formats {
+			// Checked in Run, so this can't fail.
+			encoded, _ := config.Encode(f, sum)
+			r.Hashes = append(r.Hashes, labelled{
+				Function: config.HashName(c.cfg.Hashes[i]),
+				Format:   config.FormatName(f), Hash: encoded,
+			})
+		}
+	}
+	r.Hash = r.Hashes[0].Hash
+	if len(r.Hashes) == 1 {
+		// The one hash is the record's own.
+		r.Hashes = nil
+	}
+	for _, o := range ops {
+		r.Operations = append(r.Operations, operation{Type: o.Type, Name: o.Name})
+	}
+	return r
+}
+
+// schemaDiagnostic is e under rule, at the place of the file: e is at a line and a
+// column of text, which position maps where text is embedded in a source file.
+func schemaDiagnostic(
+	rule string, e schema.Error, text string,
+	position func(offset int) (line, column int),
+) diagnostic {
+	d := diagnostic{Rule: rule, Message: e.Message}
+	if e.Line > 0 {
+		d.Line, d.Column = position(schema.OffsetOf([]byte(text), e.Line, e.Column))
+	}
+	return d
+}
+
+// extractionRecord is the record of a source file part of which couldn't be
+// read, at the place that failed where err says where that is.
+func extractionRecord(file string, err error) record {
+	r := record{File: file}
+	d := diagnostic{Rule: ruleExtraction, Message: err.Error()}
+	var e *embedded.Error
+	if errors.As(err, &e) {
+		r.Line, r.Column = e.Line, e.Column
+		d.Message, d.Line, d.Column = e.Err.Error(), e.Line, e.Column
+	}
+	r.Errors = []diagnostic{d}
+	return r
+}
+
+// walk lists the files of dir holding documents, in lexical order. It skips
+// what an allowlist directory skips, dot names and editor backups ending in ~,
+// and node_modules, whose documents are some dependency's and not the caller's.
+func walk(dir string, sources bool) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if path != dir && (strings.HasPrefix(name, ".") ||
+			strings.HasSuffix(name, "~") || name == "node_modules") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		switch ext := filepath.Ext(name); {
+		case ext == ".graphql" || ext == ".gql",
+			sources && embedded.IsSource(name):
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
 // printVersion answers -version, which the proxy command answers the same way,
 // see [versioninfo.Print].
 func printVersion(w io.Writer, name, version string) (exitCode int) {
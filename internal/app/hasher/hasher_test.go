@@ -2,18 +2,24 @@ package hasher_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base32"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
 	"github.com/romshark/gqlhash/v2/internal/app/config"
 	"github.com/romshark/gqlhash/v2/internal/app/hasher"
 	"github.com/romshark/gqlhash/v2/internal/app/versioninfo"
@@ -345,3 +351,691 @@ type brokenPipe struct{}
 func (brokenPipe) Write([]byte) (int, error) {
 	return 0, errors.New("write /dev/stdout: broken pipe")
 }
+
+// TestRunDocuments covers the commands hashing several documents: a source file
+// as -file and a directory as -dir. Each document gets a line naming where it
+// is, and one that fails is reported without keeping the rest from a line.
+func TestRunDocuments(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.graphql", "{foo}")
+	write("src/user.ts", "const Q = gql`{foo}`;\nconst Bad = gql`{ foo(x: 01) }`;\n")
+	write("node_modules/dep/q.graphql", "{ dependency }")
+	write(".hidden.graphql", "{ hidden }")
+
+	const fooSHA2 = `bb73ddf48baecb383eab5085e72eb325` +
+		`adf990b204b3ae84b0fe82ac77d4704d`
+	run := func(a ...string) (int, string, string) {
+		out, errOut := new(IORecorder), new(IORecorder)
+		code := hasher.Run("gqlhash", "dev", args(a...), out, errOut,
+			strings.NewReader(""))
+		return code, strings.Join(*out, ""), strings.Join(*errOut, "")
+	}
+	ts := filepath.Join(dir, "src", "user.ts")
+
+	// A source file: one line per document, the failing one on stderr at the
+	// line and column of the file.
+	code, out, errOut := run("-file", ts)
+	if code != 1 {
+		t.Errorf("expected code 1; received %d", code)
+	}
+	if expect := fooSHA2 + "  " + ts + ":1:15\n"; out != expect {
+		t.Errorf("expected stdout %q; received %q", expect, out)
+	}
+	if !strings.HasPrefix(errOut, ts+":2:27: syntax error:") {
+		t.Errorf("expected the syntax error at 2:27; received %q", errOut)
+	}
+
+	// A directory: .graphql files only, unless -sources asks for more.
+	code, out, errOut = run("-dir", dir)
+	if code != 0 || errOut != "" {
+		t.Errorf("expected success; received %d, %q", code, errOut)
+	}
+	if expect := fooSHA2 + "  " + filepath.Join(dir, "a.graphql") + "\n"; out != expect {
+		t.Errorf("expected stdout %q; received %q", expect, out)
+	}
+
+	code, out, _ = run("-dir", dir, "-sources")
+	if code != 1 {
+		t.Errorf("expected code 1; received %d", code)
+	}
+	if expect := fooSHA2 + "  " + filepath.Join(dir, "a.graphql") + "\n" +
+		fooSHA2 + "  " + ts + ":1:15\n"; out != expect {
+		t.Errorf("expected stdout %q; received %q", expect, out)
+	}
+}
+
+// TestRunOut covers -out: what's written to stdout otherwise is written to the
+// file instead, which is replaced whole.
+func TestRunOut(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "hashes.txt")
+	if err := os.WriteFile(out, []byte("stale\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, errOut := new(IORecorder), new(IORecorder)
+	code := hasher.Run("gqlhash", "dev", args("-out", out), stdout, errOut,
+		strings.NewReader("{foo}"))
+	if code != 0 || len(*stdout) != 0 {
+		t.Errorf("expected success, nothing on stdout; received %d, %v, %v",
+			code, *stdout, *errOut)
+	}
+	const fooSHA2 = `bb73ddf48baecb383eab5085e72eb325` +
+		`adf990b204b3ae84b0fe82ac77d4704d`
+	if written, _ := os.ReadFile(out); string(written) != fooSHA2+"\n" {
+		t.Errorf("expected %q written; received %q", fooSHA2+"\n", written)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected the file alone, no temporary one left; received %v", entries)
+	}
+}
+
+// TestRunWatch covers -watch: -out is written at once and again after every
+// change under -dir, a failure is written to stderr and the watch goes on,
+// and the context ends it.
+func TestRunWatch(t *testing.T) {
+	dir := t.TempDir()
+	queries := filepath.Join(dir, "queries")
+	if err := os.Mkdir(queries, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(queries, name), []byte(content),
+			0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	line := func(document, name string) string {
+		out := new(IORecorder)
+		hasher.Run("gqlhash", "dev", args(), out, new(IORecorder),
+			strings.NewReader(document))
+		return printed(out) + "  " + filepath.Join(queries, name) + "\n"
+	}
+	out := filepath.Join(dir, "hashes.txt")
+	waitFor := func(expect string) {
+		t.Helper()
+		var written []byte
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if written, _ = os.ReadFile(out); string(written) == expect {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("expected %q written; received %q", expect, written)
+	}
+
+	write("a.graphql", "{ a }")
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	errOut := new(IORecorder)
+	done := make(chan int)
+	go func() {
+		done <- hasher.RunContext(ctx, "gqlhash", "dev", args(
+			"-dir", queries, "-out", out, "-watch", "-watch.interval", "10ms",
+		), new(IORecorder), errOut, nil)
+	}()
+
+	waitFor(line("{ a }", "a.graphql"))
+	write("b.graphql", "{ b }")
+	waitFor(line("{ a }", "a.graphql") + line("{ b }", "b.graphql"))
+	// A document that fails is left out and reported, and the watch goes on
+	// to the fix.
+	write("b.graphql", "{ b(")
+	waitFor(line("{ a }", "a.graphql"))
+	write("b.graphql", "{ bb }")
+	waitFor(line("{ a }", "a.graphql") + line("{ bb }", "b.graphql"))
+
+	cancel()
+	if code := <-done; code != 0 {
+		t.Errorf("expected an interrupted watch to exit 0; received %d", code)
+	}
+	if s := strings.Join(*errOut, ""); !strings.Contains(s,
+		filepath.Join(queries, "b.graphql")+":1:5: syntax error") {
+		t.Errorf("expected the syntax error on stderr; received %q", s)
+	}
+}
+
+// TestRunOutputJSON covers -output=json: a record per line carrying the hash,
+// what it was made with and the operations, described or not, and a failure
+// as a record too, its error structured rather than a line to parse.
+func TestRunOutputJSON(t *testing.T) {
+	out, errOut := new(IORecorder), new(IORecorder)
+	code := hasher.Run("gqlhash", "dev", args("-output", "json"), out, errOut,
+		strings.NewReader(`"the user" query A { foo } "the change" mutation B { bar }`))
+	if code != 0 || len(*errOut) != 0 {
+		t.Fatalf("expected success; received %d, %v", code, *errOut)
+	}
+	var r struct {
+		File, Hash, Function, Format, Ignore string
+		Operations                           []struct{ Type, Name string }
+	}
+	if err := json.Unmarshal([]byte(printed(out)), &r); err != nil {
+		t.Fatalf("expected a JSON record; received %q: %v", printed(out), err)
+	}
+	if r.File != "<stdin>" || len(r.Hash) != 64 || r.Function != "sha2" ||
+		r.Format != "hex" || r.Ignore != "nothing" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if len(r.Operations) != 2 || r.Operations[0].Type != "query" ||
+		r.Operations[0].Name != "A" || r.Operations[1].Type != "mutation" {
+		t.Errorf("expected query A and mutation B; received %+v", r.Operations)
+	}
+
+	out, errOut = new(IORecorder), new(IORecorder)
+	code = hasher.Run("gqlhash", "dev", args("-output", "json", "-depth-limit", "2"),
+		out, errOut, strings.NewReader("{ a { b { c } } }"))
+	if code != 1 || len(*errOut) != 0 {
+		t.Fatalf("expected code 1 and nothing on stderr; received %d, %v",
+			code, *errOut)
+	}
+	var failed struct {
+		Hash   string
+		Errors []struct {
+			Rule         string
+			Line, Column int
+		}
+	}
+	if err := json.Unmarshal([]byte(printed(out)), &failed); err != nil {
+		t.Fatalf("expected a JSON record; received %q: %v", printed(out), err)
+	}
+	if failed.Hash != "" || len(failed.Errors) != 1 ||
+		failed.Errors[0].Rule != "depth-limit" || failed.Errors[0].Line != 1 {
+		t.Errorf("expected a depth-limit error and no hash; received %+v", failed)
+	}
+}
+
+// TestRunOutputSARIF covers -output=sarif: every failure is a result at the
+// place of the file, under a rule the log describes, and a document that
+// hashed is none.
+func TestRunOutputSARIF(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"ok.graphql":  "{ foo }",
+		"bad.graphql": "{\n  foo(x: 01)\n}",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content),
+			0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, errOut := new(IORecorder), new(IORecorder)
+	code := hasher.Run("gqlhash", "dev",
+		args("-dir", dir, "-output", "sarif"), out, errOut, strings.NewReader(""))
+	if code != 1 || len(*errOut) != 0 {
+		t.Fatalf("expected code 1 and nothing on stderr; received %d, %v",
+			code, *errOut)
+	}
+
+	var log struct {
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string
+					Rules []struct{ ID string }
+				}
+			}
+			Results []struct {
+				RuleID    string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn int }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(strings.Join(*out, "")), &log); err != nil {
+		t.Fatalf("expected a SARIF log; received %q: %v", *out, err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 ||
+		log.Runs[0].Tool.Driver.Name != "gqlhash" {
+		t.Fatalf("unexpected log: %+v", log)
+	}
+	results := log.Runs[0].Results
+	if len(results) != 1 || results[0].RuleID != "syntax" {
+		t.Fatalf("expected one syntax result; received %+v", results)
+	}
+	loc := results[0].Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != filepath.ToSlash(filepath.Join(dir, "bad.graphql")) ||
+		loc.Region.StartLine != 2 {
+		t.Errorf("expected bad.graphql at line 2; received %+v", loc)
+	}
+}
+
+// TestRunSchema covers -schema: a document the schema refuses has no hash and
+// is reported where the schema objects, and -warn-deprecated warns of a
+// deprecated field without failing the document.
+func TestRunSchema(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "schema.graphqls")
+	if err := os.WriteFile(schemaFile, []byte(
+		"type Query { foo: Int, old: Int @deprecated(reason: \"Use foo.\") }",
+	), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	run := func(stdin string, a ...string) (int, string, string) {
+		out, errOut := new(IORecorder), new(IORecorder)
+		code := hasher.Run("gqlhash", "dev", args(a...), out, errOut,
+			strings.NewReader(stdin))
+		return code, strings.Join(*out, ""), strings.Join(*errOut, "")
+	}
+
+	const fooSHA2 = `bb73ddf48baecb383eab5085e72eb325` +
+		`adf990b204b3ae84b0fe82ac77d4704d`
+	if code, out, errOut := run("{foo}", "-schema", schemaFile); code != 0 ||
+		out != fooSHA2+"\n" || errOut != "" {
+		t.Errorf("expected the hash; received %d, %q, %q", code, out, errOut)
+	}
+
+	code, out, errOut := run("{\n  bar\n}", "-schema", dir)
+	if code != 1 || out != "" ||
+		!strings.HasPrefix(errOut, "<stdin>:2:3: schema error: ") {
+		t.Errorf("expected a schema error at 2:3; received %d, %q, %q",
+			code, out, errOut)
+	}
+
+	code, out, errOut = run("{ old }", "-schema", schemaFile, "-warn-deprecated")
+	if code != 0 || out == "" ||
+		!strings.HasPrefix(errOut, "<stdin>:1:3: warning: ") ||
+		!strings.Contains(errOut, "Use foo.") {
+		t.Errorf("expected the hash and a warning; received %d, %q, %q",
+			code, out, errOut)
+	}
+
+	// No schema to check against is a failure, not a pass.
+	if code, _, errOut := run("{foo}", "-schema", t.TempDir()); code != 1 ||
+		!strings.Contains(errOut, "no .graphqls file") {
+		t.Errorf("expected a missing schema to fail; received %d, %q", code, errOut)
+	}
+}
+
+// TestRunSeveralHashes covers -hash and -format given several values: a line per
+// function and format, labelled, every hash the one a run with that function and
+// format alone prints, and in JSON every one of them in the one record.
+func TestRunSeveralHashes(t *testing.T) {
+	alone := func(hash, format string) string {
+		out := new(IORecorder)
+		if code := hasher.Run("gqlhash", "dev", args("-hash", hash, "-format", format),
+			out, new(IORecorder), strings.NewReader("{foo}")); code != 0 {
+			t.Fatalf("%s/%s: code %d", hash, format, code)
+		}
+		return printed(out)
+	}
+
+	out, errOut := new(IORecorder), new(IORecorder)
+	code := hasher.Run("gqlhash", "dev",
+		args("-hash", "sha2,blake3", "-format", "hex,base64url"),
+		out, errOut, strings.NewReader("{foo}"))
+	if code != 0 || len(*errOut) != 0 {
+		t.Fatalf("expected success; received %d, %v", code, *errOut)
+	}
+	// One write, so the hashes of a document reach a pipe together.
+	if len(*out) != 1 {
+		t.Errorf("expected one write; received %d", len(*out))
+	}
+	expect := "sha2/hex  " + alone("sha2", "hex") + "\n" +
+		"sha2/base64url  " + alone("sha2", "base64url") + "\n" +
+		"blake3/hex  " + alone("blake3", "hex") + "\n" +
+		"blake3/base64url  " + alone("blake3", "base64url") + "\n"
+	if got := strings.Join(*out, ""); got != expect {
+		t.Errorf("expected:\n%s\nreceived:\n%s", expect, got)
+	}
+
+	out = new(IORecorder)
+	hasher.Run("gqlhash", "dev", args("-hash", "sha2,blake3", "-output", "json"),
+		out, new(IORecorder), strings.NewReader("{foo}"))
+	var r struct {
+		Hash   string
+		Hashes []struct{ Function, Format, Hash string }
+	}
+	if err := json.Unmarshal([]byte(printed(out)), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Hash != alone("sha2", "hex") || len(r.Hashes) != 2 ||
+		r.Hashes[1].Function != "blake3" || r.Hashes[1].Hash != alone("blake3", "hex") {
+		t.Errorf("unexpected record: %+v", r)
+	}
+}
+
+// TestRunRequests covers -input=json and -input=jsonl: every document of a body
+// and of a batch hashed as its document alone is, a JSONL line reported at its
+// line, a body without a document failing the run and not the lines after it,
+// and -count folding the lines onto their hashes, most first.
+func TestRunRequests(t *testing.T) {
+	alone := func(document string) string {
+		out := new(IORecorder)
+		if code := hasher.Run("gqlhash", "dev", args(), out, new(IORecorder),
+			strings.NewReader(document)); code != 0 {
+			t.Fatalf("%q: code %d", document, code)
+		}
+		return printed(out)
+	}
+	foo, bar := alone("{foo}"), alone("{bar}")
+
+	out, errOut := new(IORecorder), new(IORecorder)
+	code := hasher.Run("gqlhash", "dev", args("-input", "json"), out, errOut,
+		strings.NewReader(`[{"query":"{foo}"},{"query":"{\n\tbar\n}","variables":{}}]`))
+	if code != 0 || len(*errOut) != 0 {
+		t.Fatalf("expected success; received %d, %v", code, *errOut)
+	}
+	if expect := foo + "  <stdin>\n" + bar + "  <stdin>\n"; strings.Join(*out, "") != expect {
+		t.Errorf("expected:\n%s\nreceived:\n%s", expect, strings.Join(*out, ""))
+	}
+
+	const capture = `{"query":"{foo}"}` + "\n" +
+		`{"variables":{}}` + "\n" +
+		"\n" +
+		`{"query":"{bar}"}` + "\n" +
+		`{"query":"{ foo }"}` + "\n"
+
+	out, errOut = new(IORecorder), new(IORecorder)
+	code = hasher.Run("gqlhash", "dev", args("-input", "jsonl"), out, errOut,
+		strings.NewReader(capture))
+	if code != 1 {
+		t.Errorf("expected a line without a document to fail the run; received %d", code)
+	}
+	expect := foo + "  <stdin>:1\n" + bar + "  <stdin>:4\n" + foo + "  <stdin>:5\n"
+	if got := strings.Join(*out, ""); got != expect {
+		t.Errorf("expected:\n%s\nreceived:\n%s", expect, got)
+	}
+	if got := strings.Join(*errOut, ""); got != "<stdin>:2: no query\n" {
+		t.Errorf("expected the failing line to be reported; received %q", got)
+	}
+
+	out = new(IORecorder)
+	hasher.Run("gqlhash", "dev", args("-input", "jsonl", "-count"), out,
+		new(IORecorder), strings.NewReader(capture))
+	if expect := "2  " + foo + "\n1  " + bar + "\n"; strings.Join(*out, "") != expect {
+		t.Errorf("expected:\n%s\nreceived:\n%s", expect, strings.Join(*out, ""))
+	}
+}
+
+// TestRunHAR covers -input=har: the GraphQL requests of a browser's log hashed
+// at the line and the column of their entry, POSTs and GETs alike, and the
+// entries carrying none skipped.
+func TestRunHAR(t *testing.T) {
+	const log = `{"log": {"version": "1.2", "entries": [
+  {"request": {"method": "GET", "url": "https://app.example/logo.png"}},
+  {"request": {"method": "POST", "url": "https://app.example/graphql",
+    "postData": {"mimeType": "application/json", "text": "{\"query\":\"{foo}\"}"}}},
+  {"request": {"method": "POST", "url": "https://app.example/login",
+    "postData": {"mimeType": "application/x-www-form-urlencoded", "text": "user=a"}}},
+  {"request": {"method": "GET", "url": "https://app.example/graphql?query=%7Bbar%7D"}}
+]}}`
+
+	alone := func(document string) string {
+		out := new(IORecorder)
+		hasher.Run("gqlhash", "dev", args(), out, new(IORecorder),
+			strings.NewReader(document))
+		return printed(out)
+	}
+
+	out, errOut := new(IORecorder), new(IORecorder)
+	code := hasher.Run("gqlhash", "dev", args("-input", "har"), out, errOut,
+		strings.NewReader(log))
+	if code != 0 || len(*errOut) != 0 {
+		t.Fatalf("expected success; received %d, %v", code, *errOut)
+	}
+	expect := alone("{foo}") + "  <stdin>:3:3\n" + alone("{bar}") + "  <stdin>:7:3\n"
+	if got := strings.Join(*out, ""); got != expect {
+		t.Errorf("expected:\n%s\nreceived:\n%s", expect, got)
+	}
+
+	errOut = new(IORecorder)
+	code = hasher.Run("gqlhash", "dev", args("-input", "har"), new(IORecorder), errOut,
+		strings.NewReader(`{"log": {}}`))
+	if code != 1 || !strings.Contains(strings.Join(*errOut, ""), `no member "entries"`) {
+		t.Errorf("expected a log without entries to fail; received %d, %v", code, *errOut)
+	}
+}
+
+// TestLearn covers gqlhash learn: the documents of a capture written a file per
+// hash under -ignore, named by operation and hash, formatted, with the hits
+// reported most first, and the directory one the allowlist serves as it is.
+func TestLearn(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "allowlist")
+	const capture = `{"query":"query User { user(id: 1) { name } }"}` + "\n" +
+		`{"query":"{ ping }"}` + "\n" +
+		`[{"query":"query User { user(id: 2) { name } }"},` +
+		`{"query":"# a comment\nquery User{user(id:3){name}}"}]` + "\n" +
+		`{"query":"{ broken"}` + "\n"
+
+	out, errOut := new(IORecorder), new(IORecorder)
+	code := hasher.Run("gqlhash", "dev",
+		args("learn", "-out", dir, "-ignore", "inputs"), out, errOut,
+		strings.NewReader(capture))
+	if code != 1 {
+		t.Errorf("expected the broken document to fail the run; received %d", code)
+	}
+	if got := strings.Join(*errOut, ""); !strings.HasPrefix(got, "<stdin>:4: syntax error") {
+		t.Errorf("expected the broken document reported at its line; received %q", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected a file per hash; received %d", len(entries))
+	}
+	var user, ping string
+	for _, e := range entries {
+		switch {
+		case strings.HasPrefix(e.Name(), "User."):
+			user = filepath.Join(dir, e.Name())
+		case strings.HasPrefix(e.Name(), "query."):
+			ping = filepath.Join(dir, e.Name())
+		}
+	}
+	if user == "" || ping == "" {
+		t.Fatalf("expected files named by operation; received %v", entries)
+	}
+	if expect := "3  " + user + "\n1  " + ping + "\n"; strings.Join(*out, "") != expect {
+		t.Errorf("expected the report:\n%s\nreceived:\n%s", expect, strings.Join(*out, ""))
+	}
+	// The first one seen, formatted.
+	if src, _ := os.ReadFile(user); string(src) !=
+		"query User {\n  user(id: 1) {\n    name\n  }\n}\n" {
+		t.Errorf("unexpected document:\n%s", src)
+	}
+
+	a := allowlist.New(sha256.New, gqlhash.Options{Ignore: gqlhash.IgnoreInputs})
+	r, err := a.Reload(dir)
+	if err != nil || len(r.Skipped) != 0 || len(r.Files) != 2 {
+		t.Fatalf("expected the allowlist to load whole; received %+v, %v", r, err)
+	}
+	for _, document := range []string{"{ping}", "query User { user(id: 7) { name } }"} {
+		key, _ := gqlhash.AppendHash(nil, sha256.New(),
+			gqlhash.Options{Ignore: gqlhash.IgnoreInputs}, document)
+		if !a.Allowed(key) {
+			t.Errorf("expected %q to be allowed", document)
+		}
+	}
+}
+
+// TestGenGo covers gen-go: a constant of every operation's document, hash and
+// type, the hash the one the hashing command prints for the document, an
+// anonymous operation named after its file, the map of hashes, and nothing
+// written where two operations take one name.
+func TestGenGo(t *testing.T) {
+	dir := t.TempDir()
+	queries := filepath.Join(dir, "queries")
+	write := func(name, src string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(queries, "user.graphql"),
+		"query getUser { user { ...U } }\nfragment U on User { name }\n")
+	write(filepath.Join(queries, "ping-pong.graphql"), "{ ping }\n")
+	write(filepath.Join(queries, "both.graphql"),
+		"mutation Save { save }\nsubscription Saved { saved }\n")
+
+	out := filepath.Join(dir, "queries_gen.go")
+	errOut := new(IORecorder)
+	code := hasher.Run("gqlhash", "dev", args("gen-go", "-dir", queries,
+		"-package", "queries", "-out", out, "-format", "base64url"),
+		new(IORecorder), errOut, nil)
+	if code != 0 {
+		t.Fatalf("expected success; received %d, %v", code, *errOut)
+	}
+	src, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := func(document string) string {
+		out := new(IORecorder)
+		hasher.Run("gqlhash", "dev", args("-format", "base64url"), out,
+			new(IORecorder), strings.NewReader(document))
+		return printed(out)
+	}
+	// gofmt aligns what it can, which is no concern here.
+	spaces := regexp.MustCompile(`[ \t]+`)
+	generated := spaces.ReplaceAllString(string(src), " ")
+	for _, expect := range []string{
+		"// Code generated by gqlhash gen-go. DO NOT EDIT.\n\npackage queries\n",
+		"// GetUser is the query getUser of user.graphql:1:1.\n",
+		"GetUserHash = \"" +
+			hash("query getUser { user { ...U } }\nfragment U on User { name }\n") + "\"",
+		"GetUserType = \"query\"",
+		// Each of several as a client sends it alone.
+		"SaveDocument = `mutation Save { save }\n`",
+		"SaveHash = \"" + hash("mutation Save { save }") + "\"",
+		"SavedType = \"subscription\"",
+		"Ping_pongDocument = `{ ping }\n`",
+		"\n SavedHash: SavedDocument,\n",
+		"HashFormat = \"base64url\"",
+	} {
+		if !strings.Contains(generated, expect) {
+			t.Errorf("expected %q in:\n%s", expect, src)
+		}
+	}
+
+	// A second operation named getUser, in another file, fails the run and
+	// leaves what was generated before.
+	write(filepath.Join(queries, "other.graphql"), "query GetUser { other }\n")
+	errOut = new(IORecorder)
+	code = hasher.Run("gqlhash", "dev", args("gen-go", "-dir", queries,
+		"-package", "queries", "-out", out), new(IORecorder), errOut, nil)
+	if code != 1 || !strings.Contains(strings.Join(*errOut, ""), "named GetUser") {
+		t.Errorf("expected the names to collide; received %d, %v", code, *errOut)
+	}
+	if again, _ := os.ReadFile(out); !bytes.Equal(again, src) {
+		t.Error("expected a failed run to leave the file as it was")
+	}
+}
+
+// TestGenGoWatch covers gen-go -watch: the file is generated at once and
+// again after an operation is added.
+func TestGenGoWatch(t *testing.T) {
+	dir := t.TempDir()
+	queries := filepath.Join(dir, "queries")
+	if err := os.Mkdir(queries, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(queries, "a.graphql"),
+		[]byte("query A { a }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "queries_gen.go")
+	waitFor := func(expect string) {
+		t.Helper()
+		var src []byte
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if src, _ = os.ReadFile(out); bytes.Contains(src, []byte(expect)) {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("expected %q generated; received:\n%s", expect, src)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	done := make(chan int)
+	go func() {
+		done <- hasher.RunContext(ctx, "gqlhash", "dev", args("gen-go",
+			"-dir", queries, "-package", "queries", "-out", out,
+			"-watch", "-watch.interval", "10ms",
+		), new(IORecorder), new(IORecorder), nil)
+	}()
+
+	waitFor("ADocument")
+	if err := os.WriteFile(filepath.Join(queries, "b.graphql"),
+		[]byte("query B { b }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("BDocument")
+
+	cancel()
+	if code := <-done; code != 0 {
+		t.Errorf("expected an interrupted watch to exit 0; received %d", code)
+	}
+}
+
+// TestCompileAllowlist covers compile-allowlist: the snapshot written is what
+// the proxy would read of the directory, and a document the proxy would leave
+// out fails the run and writes nothing.
+func TestCompileAllowlist(t *testing.T) {
+	dir := t.TempDir()
+	queries := filepath.Join(dir, "queries")
+	if err := os.Mkdir(queries, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(queries, "a.graphql"),
+		[]byte("query A { a(x: 1) }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "queries.snapshot")
+	run := func() (int, string) {
+		errOut := new(IORecorder)
+		code := hasher.Run("gqlhash", "dev", args(config.CompileAllowlistCommand,
+			"-allowlist", queries, "-out", out, "-ignore", "inputs"),
+			new(IORecorder), errOut, nil)
+		return code, strings.Join(*errOut, "")
+	}
+	if code, errOut := run(); code != 0 {
+		t.Fatalf("expected success; received %d, %s", code, errOut)
+	}
+
+	options := gqlhash.Options{Ignore: gqlhash.IgnoreInputs}
+	a := allowlist.NewWithConfig(sha256.New, options, allowlist.Config{Function: "sha2"})
+	if r, err := a.Reload(out); err != nil || len(r.Added) != 1 {
+		t.Fatalf("expected the snapshot to load; received %+v, %v", r, err)
+	}
+	sum, _ := gqlhash.AppendHash(nil, sha256.New(), options, "query A { a(x: 2) }")
+	if !a.Allowed(sum) {
+		t.Error("expected the document to be allowed as the proxy hashes it")
+	}
+
+	src, _ := os.ReadFile(out)
+	if err := os.WriteFile(filepath.Join(queries, "b.graphql"),
+		[]byte("{ broken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code, errOut := run(); code != 1 ||
+		!strings.Contains(errOut, filepath.Join(queries, "b.graphql")) {
+		t.Errorf("expected the broken document to fail the run; received %d, %s",
+			code, errOut)
+	}
+	if again, _ := os.ReadFile(out); !bytes.Equal(again, src) {
+		t.Error("expected a failed run to leave the snapshot as it was")
+	}
+}
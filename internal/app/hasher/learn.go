@@ -0,0 +1,154 @@
+package hasher
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+	gqlparser "github.com/vektah/gqlparser/v2/parser"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+	"github.com/romshark/gqlhash/v2/internal/operations"
+)
+
+// learned is a document of a capture, one per hash: the first one seen of
+// those that hash alike, and how many did.
+type learned struct {
+	sum  []byte
+	text string
+	hits int
+}
+
+// learn runs the learn command: it reads the requests of a capture, gathers
+// their documents by hash under -hash and -ignore, and writes one .graphql file
+// per hash to -out, which [allowlist.Allowlist.Reload] then reads as it is.
+// stdout gets a line per file, with how many documents of the capture it
+// stands for, most first:
+//
+//	1204  allowlist/GetUser.9a0b….graphql
+//
+// A request or a document that can't be read is reported on stderr and the rest
+// are still learned; any exits 1, as the hashing command does.
+func learn(args []string, stdout, stderr io.Writer, stdin io.Reader) (exitCode int) {
+	cfg, code, run := config.ParseLearn(args[0], args[1:], stderr)
+	if !run {
+		return code
+	}
+	h, ok := config.NewHasher(cfg.HashFunc)
+	if !ok {
+		// config.ParseLearn takes no other value, see Run.
+		_, _ = fmt.Fprintf(stderr, "unsupported hash function: %d\n", cfg.HashFunc)
+		return 1
+	}
+	options := gqlhash.Options{Ignore: cfg.Ignore, DepthLimit: cfg.DepthLimit}
+
+	source, in := "<stdin>", stdin
+	if cfg.File != "" {
+		f, err := os.Open(cfg.File)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading file %q: %v\n", cfg.File, err)
+			return 1
+		}
+		defer func() { _ = f.Close() }()
+		source, in = cfg.File, f
+	}
+
+	byHash := map[string]*learned{}
+	var order []*learned
+	err := readRequests(in, cfg.Input, func(r request) {
+		at := where(source, r.line, r.column)
+		docs, err := r.documents()
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "%s: %v\n", at, err)
+			exitCode = 1
+			return
+		}
+		for _, d := range docs {
+			sum, errHash := gqlhash.AppendHash(nil, h, options, d)
+			if errHash.IsErr() {
+				_, _ = fmt.Fprintf(stderr, "%s: syntax error: %v\n", at, errHash.Err)
+				exitCode = 1
+				continue
+			}
+			if l, ok := byHash[string(sum)]; ok {
+				l.hits++
+				continue
+			}
+			l := &learned{sum: sum, text: d, hits: 1}
+			byHash[string(sum)] = l
+			order = append(order, l)
+		}
+	})
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "error reading %s: %v\n", source, err)
+		return 1
+	}
+
+	if err := os.MkdirAll(cfg.Out, 0o755); err != nil {
+		_, _ = fmt.Fprintf(stderr, "error creating directory %q: %v\n", cfg.Out, err)
+		return 1
+	}
+	// Stable, so documents seen alike often keep the order they were first seen in.
+	slices.SortStableFunc(order, func(a, b *learned) int { return b.hits - a.hits })
+	var report strings.Builder
+	for _, l := range order {
+		name, text := canonical(l.text, func(text string) bool {
+			sum, errHash := gqlhash.AppendHash(nil, h, options, text)
+			return !errHash.IsErr() && string(sum) == string(l.sum)
+		})
+		file := filepath.Join(cfg.Out, name+"."+hex.EncodeToString(l.sum)+".graphql")
+		if err := os.WriteFile(file, []byte(text), 0o644); err != nil {
+			_, _ = fmt.Fprintf(stderr, "error writing file %q: %v\n", file, err)
+			return 1
+		}
+		_, _ = fmt.Fprintf(&report, "%d  %s\n", l.hits, file)
+	}
+	if _, err := io.WriteString(stdout, report.String()); err != nil {
+		_, _ = fmt.Fprintf(stderr, "error writing the report: %v\n", err)
+		return 1
+	}
+	return exitCode
+}
+
+// canonical returns what a learned document is named after and the text it's
+// written as: its operation names, an anonymous one by its type, joined by '-',
+// which no name holds; and the document formatted, comments dropped, as a
+// reviewer reads it best. same reports whether a text hashes as the document
+// does, which the formatted one has to or the document is written as it came:
+// a file the proxy hashes to another entry would allow what nobody sent.
+//
+// A document of no operation is named "document", and one the formatter
+// can't read is written as it came.
+func canonical(text string, same func(text string) bool) (name, written string) {
+	ops, err := operations.Split(text)
+	if err != nil || len(ops) == 0 {
+		return "document", text
+	}
+	names := make([]string, len(ops))
+	for i, o := range ops {
+		names[i] = o.Name
+		if o.Name == "" {
+			names[i] = o.Type
+		}
+	}
+	name = strings.Join(names, "-")
+
+	doc, err := gqlparser.ParseQuery(&ast.Source{Input: text})
+	if err != nil {
+		return name, text
+	}
+
+	var b strings.Builder
+	formatter.NewFormatter(&b, formatter.WithIndent("  ")).FormatQueryDocument(doc)
+	if formatted := b.String(); same(formatted) {
+		return name, formatted
+	}
+	return name, text
+}
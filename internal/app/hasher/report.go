@@ -0,0 +1,382 @@
+package hasher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+)
+
+// record is what's reported of one document: its hash and what it holds, or
+// why it has no hash. Its fields are the JSON record of -output=json.
+type record struct {
+	// File is where the document is read from, "<stdin>" for stdin, and empty
+	// for a count of -count, which is of every document with the hash.
+	// Line and Column are where in it the document begins, 0 for a whole file.
+	// A request body of -input=jsonl has a line and no column.
+	// Name is the identifier an embedded document is bound to, if any.
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Name   string `json:"name,omitempty"`
+
+	// Hash is empty where Errors isn't. Function, Format and Ignore are the
+	// flags it was made with, so a record read on its own says what it's a hash of.
+	// With several functions or formats those are the first of each, and Hashes
+	// holds every one, so a reader of one hash reads the same record either way.
+	Hash     string     `json:"hash,omitempty"`
+	Function string     `json:"function"`
+	Format   string     `json:"format"`
+	Ignore   string     `json:"ignore"`
+	Hashes   []labelled `json:"hashes,omitempty"`
+
+	Operations []operation `json:"operations,omitempty"`
+
+	// Errors are why there's no hash. Warnings come with one, see -warn-deprecated.
+	Errors   []diagnostic `json:"errors,omitempty"`
+	Warnings []diagnostic `json:"warnings,omitempty"`
+
+	// Count is how many documents have the hash, see [countReport].
+	Count int `json:"count,omitempty"`
+}
+
+// labelled is a hash with the function and the format it's in.
+type labelled struct {
+	Function string `json:"function"`
+	Format   string `json:"format"`
+	Hash     string `json:"hash"`
+}
+
+// label is how text names the function and the format of l, sha2/hex.
+func (l labelled) label() string { return l.Function + "/" + l.Format }
+
+// operation is an operation a document defines.
+type operation struct {
+	// Type is query, mutation or subscription. Name is empty for an
+	// anonymous operation.
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// diagnostic is why a document has no hash, at the line and column of the
+// file it's at, 0 where that's unknown.
+type diagnostic struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+// The rules a diagnostic is reported under, the ruleId of a SARIF result.
+// A code scanning tool groups and suppresses by these, so they don't change.
+const (
+	ruleSyntax     = "syntax"
+	ruleDepthLimit = "depth-limit"
+	ruleExtraction = "extraction"
+	ruleSchema     = "schema"
+	ruleDeprecated = "deprecated"
+	ruleRequest    = "request"
+)
+
+// rules describes every rule, in the order a SARIF log lists them.
+var rules = []struct{ id, description string }{
+	{ruleSyntax, "The document is no valid GraphQL executable document."},
+	{ruleDepthLimit, "The document nests deeper than -depth-limit allows."},
+	{ruleExtraction, "A document embedded in a source file can't be read " +
+		"without running the code."},
+	{ruleSchema, "The schema of -schema doesn't take the document."},
+	{ruleDeprecated, "The document selects a field the schema marks @deprecated."},
+	{ruleRequest, "The request body carries no document that can be read."},
+}
+
+// report writes the records of a run in the shape -output asks for.
+//
+// document reports one, and end finishes: a SARIF log is one JSON document
+// and is written once every record is in. Either returns 1 where writing failed,
+// having said so on stderr. A record with errors doesn't fail a report,
+// the caller counts those.
+type report interface {
+	document(r record) (exitCode int)
+	end() (exitCode int)
+}
+
+func newReport(
+	cfg config.Hasher, version string, several bool, stdout, stderr io.Writer,
+) report {
+	var r report
+	switch cfg.Output {
+	case config.OutputJSON:
+		r = &jsonReport{stdout: stdout, stderr: stderr}
+	case config.OutputSARIF:
+		r = &sarifReport{
+			version: version, stdout: stdout, stderr: stderr,
+			results: []sarifResult{},
+		}
+	default:
+		r = &textReport{several: several, stdout: stdout, stderr: stderr}
+	}
+	if cfg.Count {
+		r = &countReport{next: r, counts: map[string]*record{}}
+	}
+	return r
+}
+
+// countReport gathers the documents of a run by hash and hands every hash on
+// once at the end, with how many documents have it, most first, as
+// `sort | uniq -c | sort -rn` would. Over a traffic capture that's a fingerprint
+// of what the clients send. A failure is handed on as it comes.
+//
+// A count is of the hash and not of a place, so it names no file and carries no
+// warning: those are a run without -count's to report.
+type countReport struct {
+	next   report
+	counts map[string]*record
+	order  []*record
+}
+
+func (c *countReport) document(r record) (exitCode int) {
+	if len(r.Errors) > 0 {
+		return c.next.document(r)
+	}
+	key := r.Hash
+	for _, l := range r.Hashes {
+		key += " " + l.Hash
+	}
+	if counted, ok := c.counts[key]; ok {
+		counted.Count++
+		return 0
+	}
+	r.File, r.Line, r.Column, r.Name, r.Warnings = "", 0, 0, "", nil
+	r.Count = 1
+	c.counts[key] = &r
+	c.order = append(c.order, &r)
+	return 0
+}
+
+func (c *countReport) end() (exitCode int) {
+	// Stable, so hashes counted alike keep the order they were first seen in.
+	slices.SortStableFunc(c.order, func(a, b *record) int { return b.Count - a.Count })
+	for _, r := range c.order {
+		if code := c.next.document(*r); code != 0 {
+			return code
+		}
+	}
+	return c.next.end()
+}
+
+// textReport writes a hash per line to stdout and what failed to stderr,
+// in the file:line:column: form editors and CI annotations parse.
+type textReport struct {
+	// several writes where each document is after its hash, see [runDocuments].
+	// A single document is the hash alone.
+	several        bool
+	stdout, stderr io.Writer
+}
+
+func (t *textReport) document(r record) (exitCode int) {
+	for _, d := range r.Errors {
+		switch d.Rule {
+		case ruleExtraction, ruleRequest:
+			_, _ = fmt.Fprintf(t.stderr, "%s: %s\n", where(r.File, d.Line, d.Column), d.Message)
+		case ruleSchema:
+			_, _ = fmt.Fprintf(t.stderr, "%s: schema error: %s\n",
+				where(r.File, d.Line, d.Column), d.Message)
+		default:
+			// Too deep reads as a syntax error as it always has: it's one
+			// the parser reports, and a script may be matching on it.
+			_, _ = fmt.Fprintf(t.stderr, "%s: syntax error: %s\n",
+				where(r.File, d.Line, d.Column), d.Message)
+		}
+	}
+	if len(r.Errors) > 0 {
+		return 0
+	}
+	for _, d := range r.Warnings {
+		_, _ = fmt.Fprintf(t.stderr, "%s: warning: %s\n", where(r.File, d.Line, d.Column), d.Message)
+	}
+
+	var prefix, suffix string
+	if r.Count > 0 {
+		prefix = strconv.Itoa(r.Count) + "  "
+	}
+	if t.several && r.File != "" {
+		suffix = "  " + where(r.File, r.Line, r.Column)
+	}
+	lines := prefix + r.Hash + suffix + "\n"
+	if len(r.Hashes) > 0 {
+		// A line per hash, each labelled with what it is.
+		lines = ""
+		for _, l := range r.Hashes {
+			lines += prefix + l.label() + "  " + l.Hash + suffix + "\n"
+		}
+	}
+
+	// The hash and a newline, which is what every tool of this kind writes —
+	// shasum, md5, git hash-object, openssl dgst — and what makes the output a
+	// line: without it `read h < hash.txt` hands the hash over and reports
+	// failure, a `while read` over the file runs no iteration at all, and two
+	// hashes appended to one file run together. Command substitution strips it,
+	// so `$(gqlhash …)` reads the same either way.
+	//
+	// One write, so a hash reaches a pipe whole, and the several hashes of
+	// one document together.
+	//
+	// A failed write is what a closed pipe looks like: `gqlhash -file q.graphql
+	// | head -1` leaves nobody to read the answer. An exit code like every other
+	// failure here, rather than a goroutine dump over what the reader did get.
+	if _, err := io.WriteString(t.stdout, lines); err != nil {
+		_, _ = fmt.Fprintf(t.stderr, "writing the hash: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (*textReport) end() (exitCode int) { return 0 }
+
+// where is file:line:column, file:line where there's no column, and file where
+// there's no line either.
+func where(file string, line, column int) string {
+	switch {
+	case line == 0:
+		return file
+	case column == 0:
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return fmt.Sprintf("%s:%d:%d", file, line, column)
+}
+
+// jsonReport writes a record per line, JSON Lines, so a run over a directory
+// streams and `jq` reads it a record at a time. A failure is a record too,
+// and stderr is left for what fails the run as a whole.
+type jsonReport struct{ stdout, stderr io.Writer }
+
+func (j *jsonReport) document(r record) (exitCode int) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		// A record is strings and numbers, which always marshal.
+		panic(fmt.Errorf("marshaling a record: %w", err))
+	}
+	if _, err := j.stdout.Write(append(b, '\n')); err != nil {
+		_, _ = fmt.Fprintf(j.stderr, "writing the record: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (*jsonReport) end() (exitCode int) { return 0 }
+
+// sarifReport writes a SARIF 2.1.0 log of the documents that failed, which is
+// what GitHub code scanning and other CI tools annotate a pull request from.
+// A document with a hash and no warning is no result: SARIF reports problems, and a log
+// listing every document would be one a reviewer scrolls past.
+//
+// Reference:
+//
+//   - https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type sarifReport struct {
+	version        string
+	stdout, stderr io.Writer
+	results        []sarifResult
+}
+
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool struct {
+			Driver sarifDriver `json:"driver"`
+		} `json:"tool"`
+		// ColumnKind says a column counts characters, as [gqlhash.Position]
+		// does, rather than the UTF-16 code units SARIF assumes.
+		ColumnKind string        `json:"columnKind"`
+		Results    []sarifResult `json:"results"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		Version        string      `json:"version,omitempty"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifLocation struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region *sarifRegion `json:"region,omitempty"`
+		} `json:"physicalLocation"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+)
+
+func (s *sarifReport) document(r record) (exitCode int) {
+	s.add(r, "error", r.Errors)
+	s.add(r, "warning", r.Warnings)
+	return 0
+}
+
+// add adds a result at level for each of the diagnostics of r.
+func (s *sarifReport) add(r record, level string, diagnostics []diagnostic) {
+	for _, d := range diagnostics {
+		result := sarifResult{
+			RuleID: d.Rule, Level: level, Message: sarifMessage{d.Message},
+		}
+		// stdin is no artifact a scanning tool can point at.
+		if r.File != "<stdin>" {
+			var l sarifLocation
+			l.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(r.File)
+			if d.Line > 0 {
+				l.PhysicalLocation.Region = &sarifRegion{d.Line, d.Column}
+			}
+			result.Locations = []sarifLocation{l}
+		}
+		s.results = append(s.results, result)
+	}
+}
+
+func (s *sarifReport) end() (exitCode int) {
+	run := sarifRun{ColumnKind: "unicodeCodePoints", Results: s.results}
+	run.Tool.Driver = sarifDriver{
+		Name: "gqlhash", Version: s.version,
+		InformationURI: "https://github.com/romshark/gqlhash",
+	}
+	for _, r := range rules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules,
+			sarifRule{ID: r.id, ShortDescription: sarifMessage{r.description}})
+	}
+	b, err := json.MarshalIndent(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		panic(fmt.Errorf("marshaling the SARIF log: %w", err))
+	}
+	if _, err := s.stdout.Write(append(b, '\n')); err != nil {
+		_, _ = fmt.Fprintf(s.stderr, "writing the SARIF log: %v\n", err)
+		return 1
+	}
+	return 0
+}
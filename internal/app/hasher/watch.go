@@ -0,0 +1,74 @@
+package hasher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/romshark/gqlhash/v2/internal/watch"
+)
+
+// watchDir calls round, and again whenever a file walk lists under dir is
+// added, removed or changed, until ctx is done, polling dir every interval,
+// see [watch.Files.Poll]. What round reads is up to it: a file that changes
+// while it's read is read again by the next round.
+func watchDir(
+	ctx context.Context, dir string, sources bool, interval time.Duration,
+	round func(),
+) {
+	// A directory that can't be walked is a change of its own, which round
+	// reports as it fails to walk it too, once, and again only after it could
+	// be walked meanwhile.
+	files := watch.New(func() ([]string, error) { return walk(dir, sources) })
+	round()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if files.Poll() {
+			round()
+		}
+	}
+}
+
+// writeFile replaces the file name with data at once: data is written to a
+// file beside it first, which is renamed over it, so a reader finds the old
+// file or the new one and never one half-written.
+func writeFile(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		// A temporary file is private, what's written in its place isn't.
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// writeOut writes data to name, reporting a failure to stderr.
+func writeOut(name string, data []byte, stderr io.Writer) (exitCode int) {
+	if err := writeFile(name, data); err != nil {
+		_, _ = fmt.Fprintf(stderr, "error writing file %q: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package lsp
+
+import (
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/operations"
+)
+
+// analysis is what's shown of a document: its syntax error, or the hash of
+// every operation in it.
+type analysis struct {
+	// err is the syntax error at the byte offset errOffset, nil for none,
+	// in which case operations is never empty.
+	err       error
+	errOffset int
+
+	operations []operationHash
+}
+
+// operationHash is the hash of an operation as a client sends it, and the byte
+// range of the document it's shown at: from where the operation begins to the
+// end of that line, so its name and variables and not its selections.
+type operationHash struct {
+	name       string
+	start, end int
+	sum        []byte
+}
+
+// analyze hashes the document text under h and options, per operation as a
+// client sends it alone, see [operations.Split].
+//
+// A document of fragments alone, which holds no operation, is hashed whole,
+// at its start.
+func analyze(h gqlhash.Hash, options gqlhash.Options, text string) analysis {
+	sum, errHash := gqlhash.AppendHash(nil, h, options, text)
+	if errHash.IsErr() {
+		return analysis{err: errHash.Err, errOffset: errHash.ErrOffset}
+	}
+
+	ops, err := operations.Split(text)
+	if err != nil || len(ops) == 0 {
+		return analysis{operations: []operationHash{
+			{start: 0, end: lineEnd(text, 0), sum: sum},
+		}}
+	}
+
+	a := analysis{operations: make([]operationHash, len(ops))}
+	for i, o := range ops {
+		a.operations[i] = operationHash{
+			name: o.Name, start: o.Offset, end: lineEnd(text, o.Offset),
+		}
+		if len(ops) == 1 {
+			a.operations[i].sum = sum
+			continue
+		}
+		a.operations[i].sum, errHash = gqlhash.AppendHash(nil, h, options, o.Document)
+		if errHash.IsErr() {
+			// Whole, the document hashed, so a part of it failing is the split
+			// cutting it wrong: shown as what it is rather than as a hash it isn't.
+			return analysis{err: errHash.Err, errOffset: o.Offset}
+		}
+	}
+	return a
+}
@@ -0,0 +1,312 @@
+// Package lsp is the language server of gqlhash, gqlhash lsp: it speaks the
+// Language Server Protocol over stdio, showing above every operation an editor
+// opens the hash the proxy computes for it, and warning of an operation that
+// isn't on the allowlist.
+//
+// It reads GraphQL documents, as the proxy reads the allowlist: a syntax error
+// is the diagnostic the hash fails with, see [gqlhash.Result], at the place it
+// points at. Nothing past what the protocol's base needs is implemented,
+// so there's no dependency on a protocol library.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+)
+
+// Run serves the language server on stdin and stdout until the client sends
+// exit, or closes stdin. stderr is the server's log, which an editor shows as
+// the output of the server. exitCode is 0 where exit follows shutdown, as the
+// protocol asks, and 1 otherwise.
+//
+// args are those of the hashing command, the subcommand being args[1].
+func Run(
+	name, version string,
+	args []string,
+	stdout, stderr io.Writer,
+	stdin io.Reader,
+) (exitCode int) {
+	cfg, code, run := config.ParseLSP(args[0], args[1:], stderr)
+	if !run {
+		return code
+	}
+	h, ok := config.NewHasher(cfg.HashFunc)
+	if !ok {
+		// config.ParseLSP takes no other value.
+		_, _ = fmt.Fprintf(stderr, "unsupported hash function: %d\n", cfg.HashFunc)
+		return 1
+	}
+
+	s := &server{
+		name: name, version: version, cfg: cfg, hash: h,
+		options: gqlhash.Options{Ignore: cfg.Ignore, DepthLimit: cfg.DepthLimit},
+		docs:    map[string]string{},
+		stdout:  stdout, stderr: stderr,
+	}
+	if cfg.AllowlistDir != "" {
+		newHash := func() hash.Hash {
+			h, _ := config.NewHasher(cfg.HashFunc)
+			return h
+		}
+		s.allowlist = allowlist.NewWithConfig(newHash, s.options,
+			allowlist.Config{
+				Sources: cfg.AllowlistSources, Manifests: cfg.AllowlistManifests,
+				Function: config.HashName(cfg.HashFunc),
+			})
+		var err error
+		if s.allowlistDir, err = filepath.Abs(cfg.AllowlistDir); err != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading the allowlist: %v\n", err)
+			return 1
+		}
+		if err := s.reload(); err != nil {
+			// The editor shows no server at all otherwise, which says less.
+			_, _ = fmt.Fprintf(stderr, "error reading the allowlist: %v\n", err)
+			return 1
+		}
+	}
+
+	r := bufio.NewReader(stdin)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				_, _ = fmt.Fprintf(stderr, "error reading a message: %v\n", err)
+			}
+			return 1
+		}
+		var m message
+		if err := json.Unmarshal(body, &m); err != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading a message: %v\n", err)
+			continue
+		}
+		if m.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		if err := s.handle(m); err != nil {
+			// A write that fails is a client gone, which nothing is left to serve.
+			_, _ = fmt.Fprintf(stderr, "error writing a message: %v\n", err)
+			return 1
+		}
+	}
+}
+
+// server is the state of one session: the documents the editor has open,
+// by URI, and the allowlist they're checked against.
+type server struct {
+	name, version string
+	cfg           config.LSP
+	hash          gqlhash.Hash
+	options       gqlhash.Options
+
+	// allowlist is nil for no -allowlist, and allowlistDir its directory,
+	// absolute, which a saved file is compared with.
+	allowlist    *allowlist.Allowlist
+	allowlistDir string
+
+	docs     map[string]string
+	shutdown bool
+
+	stdout, stderr io.Writer
+}
+
+// handle answers m where it's a request, and acts on it where it's a
+// notification. The error is one writing to the client.
+func (s *server) handle(m message) error {
+	switch m.Method {
+	case "initialize":
+		return s.respond(m.ID, map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": map[string]any{
+					"openClose": true, "change": textDocumentSyncFull, "save": true,
+				},
+				"codeLensProvider": map[string]any{"resolveProvider": false},
+			},
+			"serverInfo": map[string]any{"name": s.name, "version": s.version},
+		})
+	case "shutdown":
+		s.shutdown = true
+		return s.respond(m.ID, nil)
+
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if json.Unmarshal(m.Params, &p) != nil {
+			return nil
+		}
+		s.docs[p.TextDocument.URI] = p.TextDocument.Text
+		return s.publish(p.TextDocument.URI)
+	case "textDocument/didChange":
+		var p didChangeParams
+		if json.Unmarshal(m.Params, &p) != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		// Full sync: the last change is the document.
+		s.docs[p.TextDocument.URI] = p.ContentChanges[len(p.ContentChanges)-1].Text
+		return s.publish(p.TextDocument.URI)
+	case "textDocument/didSave":
+		var p documentParams
+		if json.Unmarshal(m.Params, &p) != nil || !s.inAllowlist(p.TextDocument.URI) {
+			return nil
+		}
+		// A document added to the allowlist, or taken off it, changes what's
+		// warned of in every open one.
+		if err := s.reload(); err != nil {
+			_, _ = fmt.Fprintf(s.stderr, "error reading the allowlist: %v\n", err)
+			return nil
+		}
+		for uri := range s.docs {
+			if err := s.publish(uri); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "textDocument/didClose":
+		var p documentParams
+		if json.Unmarshal(m.Params, &p) != nil {
+			return nil
+		}
+		delete(s.docs, p.TextDocument.URI)
+		// What was published stays in the editor's problems until replaced.
+		return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI: p.TextDocument.URI, Diagnostics: []diagnostic{},
+		})
+
+	case "textDocument/codeLens":
+		var p documentParams
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			return s.respondError(m.ID, codeInvalidParams, err.Error())
+		}
+		text, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return s.respond(m.ID, []codeLens{})
+		}
+		return s.respond(m.ID, s.lenses(text))
+	}
+
+	if m.ID != nil {
+		return s.respondError(m.ID, codeMethodNotFound, "method not found: "+m.Method)
+	}
+	// A notification this doesn't act on, initialized among them, is ignored,
+	// as the protocol has it.
+	return nil
+}
+
+// lenses are the hash of every operation of text, each above the operation,
+// and whether it's allowed where there's an allowlist.
+func (s *server) lenses(text string) []codeLens {
+	a := analyze(s.hash, s.options, text)
+	lenses := make([]codeLens, len(a.operations))
+	for i, o := range a.operations {
+		// Checked in config.ParseLSP, so this can't fail.
+		encoded, _ := config.Encode(s.cfg.Format, o.sum)
+		title := config.HashName(s.cfg.HashFunc) + " " + encoded
+		if s.allowlist != nil {
+			if s.allowlist.Allowed(o.sum) {
+				title += " (allowed)"
+			} else {
+				title += " (not on the allowlist)"
+			}
+		}
+		lenses[i] = codeLens{
+			Range:   span{positionOf(text, o.start), positionOf(text, o.end)},
+			Command: lensCommand{Title: title},
+		}
+	}
+	return lenses
+}
+
+// publish sends the diagnostics of the document at uri: its syntax error, or a
+// warning per operation that isn't on the allowlist.
+func (s *server) publish(uri string) error {
+	text := s.docs[uri]
+	a := analyze(s.hash, s.options, text)
+	diagnostics := []diagnostic{}
+	if a.err != nil {
+		// The error is at a character, which is the range shown: where the
+		// offset is past the last one, or at a line's end, the range is empty.
+		end := a.errOffset
+		if end < len(text) && text[end] != '\n' && text[end] != '\r' {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    span{positionOf(text, a.errOffset), positionOf(text, end)},
+			Severity: severityError, Source: "gqlhash", Message: a.err.Error(),
+		})
+	}
+	for _, o := range a.operations {
+		if s.allowlist == nil || s.allowlist.Allowed(o.sum) {
+			continue
+		}
+		what := "the document"
+		if o.name != "" {
+			what = "operation " + o.name
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    span{positionOf(text, o.start), positionOf(text, o.end)},
+			Severity: severityWarning, Source: "gqlhash",
+			Message: what + " is not on the allowlist " + s.cfg.AllowlistDir +
+				", so the proxy refuses it",
+		})
+	}
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI: uri, Diagnostics: diagnostics,
+	})
+}
+
+// reload rereads the allowlist, logging what it left out: the proxy leaves it
+// out too, so an operation matching it is refused.
+func (s *server) reload() error {
+	result, err := s.allowlist.Reload(s.cfg.AllowlistDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range result.Skipped {
+		_, _ = fmt.Fprintf(s.stderr, "allowlist: skipped %v\n", e)
+	}
+	if result.SchemaErr != nil {
+		_, _ = fmt.Fprintf(s.stderr, "allowlist: %v\n", result.SchemaErr)
+	}
+	return nil
+}
+
+// inAllowlist reports whether uri names a file under the allowlist directory.
+func (s *server) inAllowlist(uri string) bool {
+	if s.allowlist == nil {
+		return false
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return false
+	}
+	rel, err := filepath.Rel(s.allowlistDir, filepath.FromSlash(u.Path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (s *server) respond(id json.RawMessage, result any) error {
+	return writeMessage(s.stdout, response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *server) respondError(id json.RawMessage, code int, msg string) error {
+	return writeMessage(s.stdout, errorResponse{
+		JSONRPC: "2.0", ID: id, Error: responseError{Code: code, Message: msg},
+	})
+}
+
+func (s *server) notify(method string, params any) error {
+	return writeMessage(s.stdout, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
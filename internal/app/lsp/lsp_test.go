@@ -0,0 +1,198 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/app/lsp"
+)
+
+// session frames every message as a client sends it over stdio.
+func session(t *testing.T, messages ...any) io.Reader {
+	t.Helper()
+	var b bytes.Buffer
+	for _, m := range messages {
+		body, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	return &b
+}
+
+func request(id int, method string, params any) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func notification(method string, params any) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+// reply is what's read of a message of the server.
+type reply struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+	Params struct {
+		URI         string `json:"uri"`
+		Diagnostics []struct {
+			Range struct {
+				Start struct{ Line, Character int }
+			}
+			Severity int
+			Message  string
+		}
+	} `json:"params"`
+}
+
+// replies reads every message the server wrote, checking the framing.
+func replies(t *testing.T, out []byte) []reply {
+	t.Helper()
+	var rs []reply
+	r := bufio.NewReader(bytes.NewReader(out))
+	for {
+		header, err := r.ReadString('\n')
+		if err == io.EOF {
+			return rs
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(
+			strings.TrimPrefix(header, "Content-Length:")))
+		if err != nil {
+			t.Fatalf("unexpected header %q", header)
+		}
+		if blank, _ := r.ReadString('\n'); blank != "\r\n" {
+			t.Fatalf("expected a blank line after the header; received %q", blank)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		var m reply
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+		rs = append(rs, m)
+	}
+}
+
+func sha2(t *testing.T, document string) string {
+	t.Helper()
+	sum, err := gqlhash.AppendHash(nil, sha256.New(), gqlhash.Options{}, document)
+	if err.IsErr() {
+		t.Fatal(err.Err)
+	}
+	return hex.EncodeToString(sum)
+}
+
+// TestRun covers a session: the capabilities, the lens of every operation with
+// the hash a client sending it alone gets, the warning of one that isn't on the
+// allowlist, a syntax error at its place, and exit after shutdown exiting 0.
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	const allowed = "query A { a ...F }"
+	if err := os.WriteFile(filepath.Join(dir, "a.graphql"),
+		[]byte(allowed+"\nfragment F on Query { f }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const uri = "file:///queries/ops.graphql"
+	const text = "query A { a ...F }\n" +
+		"fragment F on Query { f }\n" +
+		"query B { b }\n"
+	in := session(t,
+		request(1, "initialize", map[string]any{"capabilities": map[string]any{}}),
+		notification("initialized", map[string]any{}),
+		notification("textDocument/didOpen", map[string]any{"textDocument": map[string]any{
+			"uri": uri, "languageId": "graphql", "version": 1, "text": text,
+		}}),
+		request(2, "textDocument/codeLens",
+			map[string]any{"textDocument": map[string]any{"uri": uri}}),
+		notification("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2},
+			"contentChanges": []any{map[string]any{"text": "query A {\n  a(\n}"}},
+		}),
+		request(3, "textDocument/hover", map[string]any{}),
+		request(4, "shutdown", nil),
+		notification("exit", nil),
+	)
+
+	var out, errOut bytes.Buffer
+	code := lsp.Run("gqlhash", "dev",
+		[]string{"gqlhash", "lsp", "-allowlist", dir}, &out, &errOut, in)
+	if code != 0 {
+		t.Errorf("expected exit after shutdown to exit 0; received %d, %s", code, &errOut)
+	}
+	rs := replies(t, out.Bytes())
+	if len(rs) != 6 {
+		t.Fatalf("expected 6 messages; received %d:\n%s", len(rs), out.String())
+	}
+
+	if !strings.Contains(string(rs[0].Result), `"codeLensProvider"`) {
+		t.Errorf("expected code lenses among the capabilities: %s", rs[0].Result)
+	}
+
+	// B isn't allowed, and is warned of at its line.
+	if d := rs[1].Params.Diagnostics; rs[1].Method != "textDocument/publishDiagnostics" ||
+		len(d) != 1 || d[0].Severity != 2 || d[0].Range.Start.Line != 2 ||
+		!strings.Contains(d[0].Message, "operation B is not on the allowlist") {
+		t.Errorf("unexpected diagnostics: %+v", rs[1])
+	}
+
+	var lenses []struct {
+		Range   struct{ Start struct{ Line, Character int } }
+		Command struct{ Title string }
+	}
+	if err := json.Unmarshal(rs[2].Result, &lenses); err != nil {
+		t.Fatal(err)
+	}
+	// A with the fragment it spreads, as a client sends it, B alone.
+	expect := []string{
+		"sha2 " + sha2(t, "query A { a ...F }\nfragment F on Query { f }") + " (allowed)",
+		"sha2 " + sha2(t, "query B { b }") + " (not on the allowlist)",
+	}
+	if len(lenses) != 2 || lenses[0].Command.Title != expect[0] ||
+		lenses[1].Command.Title != expect[1] || lenses[1].Range.Start.Line != 2 {
+		t.Errorf("expected lenses %q; received %+v", expect, lenses)
+	}
+
+	// The syntax error of the change, where the hash fails.
+	if d := rs[3].Params.Diagnostics; len(d) != 1 || d[0].Severity != 1 ||
+		d[0].Range.Start.Line != 2 || d[0].Range.Start.Character != 0 {
+		t.Errorf("unexpected diagnostics: %+v", rs[3])
+	}
+
+	if rs[4].Error == nil || rs[4].Error.Code != -32601 {
+		t.Errorf("expected an unknown method to be refused: %+v", rs[4])
+	}
+	if *rs[5].ID != 4 || string(rs[5].Result) != "null" {
+		t.Errorf("expected shutdown to answer null: %+v", rs[5])
+	}
+}
+
+// TestRunExitWithoutShutdown covers the exit code the protocol asks for when
+// the client skips shutdown, or just goes away.
+func TestRunExitWithoutShutdown(t *testing.T) {
+	if code := lsp.Run("gqlhash", "dev", []string{"gqlhash", "lsp"},
+		io.Discard, io.Discard, session(t, notification("exit", nil))); code != 1 {
+		t.Errorf("expected exit without shutdown to exit 1; received %d", code)
+	}
+	if code := lsp.Run("gqlhash", "dev", []string{"gqlhash", "lsp"},
+		io.Discard, io.Discard, strings.NewReader("")); code != 1 {
+		t.Errorf("expected a closed stdin to exit 1; received %d", code)
+	}
+}
@@ -0,0 +1,198 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// The JSON-RPC 2.0 messages the protocol is made of, and what of the protocol's
+// types this server reads and writes, see
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/.
+
+// message is what arrives: a request where it has an ID, a notification
+// where it has none.
+type message struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// response answers a request. Result is written as it is, null included,
+// which is the answer to shutdown.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+}
+
+// errorResponse answers a request that failed, which carries no result.
+type errorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   responseError   `json:"error"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// The JSON-RPC error codes this server answers with.
+const (
+	codeInvalidParams  = -32602
+	codeMethodNotFound = -32601
+)
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type (
+	// position is 0-based, and character counts UTF-16 code units, as the
+	// protocol counts them unless a client negotiates otherwise.
+	position struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+	span struct {
+		Start position `json:"start"`
+		End   position `json:"end"`
+	}
+
+	diagnostic struct {
+		Range    span   `json:"range"`
+		Severity int    `json:"severity"`
+		Source   string `json:"source"`
+		Message  string `json:"message"`
+	}
+	publishDiagnosticsParams struct {
+		URI         string       `json:"uri"`
+		Diagnostics []diagnostic `json:"diagnostics"`
+	}
+
+	// codeLens carries a command with no command ID, which an editor shows as
+	// its title and runs nothing for.
+	codeLens struct {
+		Range   span        `json:"range"`
+		Command lensCommand `json:"command"`
+	}
+	lensCommand struct {
+		Title   string `json:"title"`
+		Command string `json:"command"`
+	}
+
+	textDocumentIdentifier struct {
+		URI string `json:"uri"`
+	}
+	didOpenParams struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+	}
+	didChangeParams struct {
+		TextDocument   textDocumentIdentifier `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	documentParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+)
+
+// The diagnostic severities this server publishes.
+const (
+	severityError   = 1
+	severityWarning = 2
+)
+
+// textDocumentSyncFull has every change carry the whole document: a GraphQL
+// document is small enough to resend whole, and nothing here applies edits.
+const textDocumentSyncFull = 1
+
+// readMessage reads the next message of r: headers, a blank line, and
+// Content-Length bytes of JSON.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("a message without a Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// writeMessage writes v as one message, in one write, so a message is never
+// interleaved with another.
+func writeMessage(w io.Writer, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w,
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body))
+	return err
+}
+
+// positionOf is the protocol's position of the byte offset of text.
+// Lines end where a GraphQL LineTerminator does, CRLF being one.
+func positionOf(text string, offset int) position {
+	offset = min(max(offset, 0), len(text))
+	var p position
+	lineStart := 0
+	for i := 0; i < offset; i++ {
+		switch text[i] {
+		case '\r':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				continue
+			}
+			p.Line++
+			lineStart = i + 1
+		case '\n':
+			p.Line++
+			lineStart = i + 1
+		}
+	}
+	// An invalid byte ranges as U+FFFD, one unit, as an editor decoding it
+	// shows one replacement character.
+	for _, r := range text[lineStart:offset] {
+		p.Character += utf16.RuneLen(r)
+	}
+	return p
+}
+
+// lineEnd is the byte offset at which the line holding offset ends,
+// before its LineTerminator.
+func lineEnd(text string, offset int) int {
+	if i := strings.IndexAny(text[offset:], "\r\n"); i >= 0 {
+		return offset + i
+	}
+	return len(text)
+}
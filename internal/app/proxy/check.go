@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+)
+
+// checkAllowlist runs -check-allowlist: it reads the allowlist as a reload of
+// a proxy run with the same flags would, see [allowlist.Allowlist.Check],
+// and says what it holds on stdout.
+//
+// Where a reload leaves a document out and serves the rest, this fails: it's
+// asked before an allowlist is rolled out, so a file that would be skipped, or
+// a schema that can't be read, is reported on stderr and exits with 1.
+func checkAllowlist(cfg config.Proxy, stdout, stderr io.Writer) (exitCode int) {
+	newHash, err := hashOf(cfg)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	dir := cfg.CmdCheckAllowlist
+	list := newAllowlistOf(cfg, newHash, nil)
+	var result allowlist.Result
+	if config.IsAllowlistURL(dir) {
+		// Fetched once, as the start of a proxy run fetches it.
+		r, errRemote := newRemote("", dir, list, len(cfg.AllowlistBundleKeys) > 0)
+		if errRemote != nil {
+			_, _ = fmt.Fprintf(stderr, "error reading the allowlist: %v\n", errRemote)
+			return 1
+		}
+		result, err = r.reload(context.Background(), true)
+		dir = r.name
+	} else {
+		result, err = list.Check(dir)
+	}
+	switch {
+	case errors.Is(err, allowlist.ErrRefused):
+		// What -allowlist.strict refuses fails the check anyway, and is
+		// reported file by file below.
+	case err != nil:
+		_, _ = fmt.Fprintf(stderr, "error reading the allowlist: %v\n", err)
+		return 1
+	}
+	for _, e := range result.Skipped {
+		_, _ = fmt.Fprintln(stderr, e)
+		exitCode = 1
+	}
+	if result.SchemaErr != nil {
+		_, _ = fmt.Fprintf(stderr, "error reading the schema: %v\n", result.SchemaErr)
+		exitCode = 1
+	}
+	name := dir
+	if result.Commit != "" {
+		name = fmt.Sprintf("%s at %s (%s)", dir, result.Ref, result.Commit)
+	}
+	_, _ = fmt.Fprintf(stdout, "%s: %d documents, %d skipped, %d expired\n",
+		name, len(result.Files), len(result.Skipped), len(result.Expired))
+	return exitCode
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckAllowlist covers -check-allowlist: what a reload would hold is said
+// on stdout and nothing is served, and a file a reload would skip, or a schema
+// that can't be read, fails the run.
+func TestCheckAllowlist(t *testing.T) {
+	check := func(t *testing.T, args ...string) (code int, stdout, stderr string) {
+		t.Helper()
+		var out, errOut strings.Builder
+		code = Run(context.Background(), "gqlhash-proxy", "dev",
+			append([]string{"gqlhash-proxy"}, args...), &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	writeDoc(t, dir, "b.graphql", "{ b }")
+
+	// No -upstream.url nor -allowlist: nothing is served.
+	code, out, errOut := check(t, "-check-allowlist", dir)
+	if code != 0 || !strings.Contains(out, ": 2 documents, 0 skipped") {
+		t.Fatalf("expected a pass; received %d: %q %q", code, out, errOut)
+	}
+
+	writeDoc(t, dir, "c.graphql", "{ broken")
+	code, out, errOut = check(t, "-check-allowlist", dir)
+	if code != 1 || !strings.Contains(out, ": 2 documents, 1 skipped") ||
+		!strings.Contains(errOut, "c.graphql:1:") {
+		t.Errorf("expected the skip to fail the check; received %d: %q %q",
+			code, out, errOut)
+	}
+
+	schema := t.TempDir()
+	writeDoc(t, schema, "a.graphql", "{ a }")
+	writeDoc(t, schema, "schema.graphqls", "type Query {")
+	code, _, errOut = check(t, "-check-allowlist", schema)
+	if code != 1 || !strings.Contains(errOut, "error reading the schema") {
+		t.Errorf("expected the schema to fail the check; received %d: %q", code, errOut)
+	}
+
+	// A URL is fetched once, and named without its query.
+	srv := httptest.NewServer(&fileServer{files: map[string][]byte{
+		"/q.jsonl": []byte(`{"query":"{ a }"}` + "\n"),
+	}})
+	defer srv.Close()
+	code, out, errOut = check(t, "-allowlist.manifests",
+		"-check-allowlist", srv.URL+"/q.jsonl?token=s3cret")
+	if expect := srv.URL + "/q.jsonl: 1 documents"; code != 0 ||
+		!strings.HasPrefix(out, expect) {
+		t.Errorf("expected %q; received %d: %q %q", expect, code, out, errOut)
+	}
+
+	if code, _, _ := check(t, "-check-allowlist", filepath.Join(dir, "nope")); code != 1 {
+		t.Errorf("expected a missing directory to fail the check; received %d", code)
+	}
+	if code, _, _ := check(t, "-check-allowlist", dir, "-hash", "md5"); code != 2 {
+		t.Errorf("expected a bad -hash to be a flag error; received %d", code)
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+)
+
+// clients picks the allowlist a request is checked against by the client it
+// names in a header, see -allowlist.client-header, so one client can't send
+// the documents of another.
+//
+// A request naming no client, or one without an allowlist of its own, is
+// checked against -allowlist, the fallback: naming nobody is something any
+// client can do, so the fallback is what every client may send.
+type clients struct {
+	header string
+
+	// named are the allowlists of -allowlist.client, in the order given,
+	// and byName the same by the name of their client.
+	named  []*clientList
+	byName map[string]*clientList
+
+	fallback *clientList
+}
+
+// clientList is the allowlist of one client and the requests decided against it.
+type clientList struct {
+	// name is the value of the header that picks it, empty for the fallback.
+	name string
+
+	// dir is what a reload reads, empty for the fallback, which is -allowlist.
+	dir string
+
+	allowlist         *allowlist.Allowlist
+	allowed, rejected paddedCounter
+}
+
+// newClients returns the clients of header, fallback being -allowlist.
+// Nil where there's no header: every request is checked against -allowlist.
+func newClients(
+	header string, named []*clientList, fallback *allowlist.Allowlist,
+) *clients {
+	if header == "" {
+		return nil
+	}
+	c := &clients{
+		header: header, named: named,
+		byName:   make(map[string]*clientList, len(named)),
+		fallback: &clientList{allowlist: fallback},
+	}
+	for _, l := range named {
+		c.byName[l.name] = l
+	}
+	return c
+}
+
+// pick is the allowlist of the client called name. Allocates nothing: the
+// conversion of a map key is one the compiler makes without a copy.
+func (c *clients) pick(name []byte) *clientList {
+	if l, ok := c.byName[string(name)]; ok {
+		return l
+	}
+	return c.fallback
+}
+
+// all is every allowlist, the fallback first.
+func (c *clients) all() []*clientList {
+	return append([]*clientList{c.fallback}, c.named...)
+}
+
+// count counts a request that reached the allowlist of l. A nil l is a proxy
+// without -allowlist.client-header, which counts nothing here: [counters]
+// has the totals.
+func (l *clientList) count(allowed bool) {
+	switch {
+	case l == nil:
+	case allowed:
+		l.allowed.Add(1)
+	default:
+		l.rejected.Add(1)
+	}
+}
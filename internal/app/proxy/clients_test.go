@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestProxyClients covers the allowlist of a request picked by its client:
+// a client sends its own documents and no other's, and a request naming no
+// client, or one with no allowlist, is checked against -allowlist.
+func TestProxyClients(t *testing.T) {
+	webDir, iosDir := t.TempDir(), t.TempDir()
+	writeDoc(t, webDir, "web.graphql", "{ web }")
+	writeDoc(t, iosDir, "ios.graphql", "{ ios }")
+
+	const header = "Apollographql-Client-Name"
+	p, spy := testProxyWith(t, func(p *proxy) {
+		p.clients = newClients(header, []*clientList{
+			{name: "web", dir: webDir, allowlist: newAllowlist(t, webDir)},
+			{name: "ios", dir: iosDir, allowlist: newAllowlist(t, iosDir)},
+		}, p.allowlist)
+		p.metrics = newMetrics(&p.counters, p.allowlist, p.clients)
+	}, "{ shared }")
+
+	for _, td := range []struct {
+		client, query string
+		expect        int
+	}{
+		{"web", "{ web }", http.StatusOK},
+		{"web", "{ ios }", http.StatusForbidden},
+		{"web", "{ shared }", http.StatusForbidden},
+		{"ios", "{ ios }", http.StatusOK},
+		{"", "{ shared }", http.StatusOK},
+		{"", "{ web }", http.StatusForbidden},
+		{"tv", "{ shared }", http.StatusOK},
+	} {
+		r := postJSON(`{"query":` + strconv.Quote(td.query) + `}`)
+		if td.client != "" {
+			r.Header.Set(header, td.client)
+		}
+		if w := do(t, p, r); w.Code != td.expect {
+			t.Errorf("client %q sending %s: expected %d; received %d: %s",
+				td.client, td.query, td.expect, w.Code, w.Body)
+		}
+	}
+	if spy.requests != 4 {
+		t.Errorf("expected 4 requests forwarded; received %d", spy.requests)
+	}
+
+	rec := httptest.NewRecorder()
+	p.metrics.Handler(testLogger()).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`gqlhash_proxy_client_requests_total{client="",decision="allowed"} 2`,
+		`gqlhash_proxy_client_requests_total{client="",decision="rejected"} 1`,
+		`gqlhash_proxy_client_requests_total{client="web",decision="allowed"} 1`,
+		`gqlhash_proxy_client_requests_total{client="web",decision="rejected"} 2`,
+		`gqlhash_proxy_client_requests_total{client="ios",decision="allowed"} 1`,
+		`gqlhash_proxy_client_allowlist_documents{client="ios"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected %s in the metrics; received %s", want, rec.Body)
+		}
+	}
+
+	mux := http.NewServeMux()
+	(&control{
+		allowlist: p.allowlist, dir: t.TempDir(), proxy: p, log: testLogger(),
+	}).routes(mux)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	want := `"clients":[` +
+		`{"client":"","documents":1,"allowed":2,"rejected":1},` +
+		`{"client":"web","documents":1,"allowed":1,"rejected":2},` +
+		`{"client":"ios","documents":1,"allowed":1,"rejected":0}]}`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("expected %s in the status; received %s", want, rec.Body)
+	}
+
+	// A reload rereads every client's allowlist and answers for each.
+	writeDoc(t, webDir, "more.graphql", "{ more }")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK ||
+		!strings.Contains(rec.Body.String(), `"clients":{"ios":{"documents":{"total":1`) ||
+		!strings.Contains(rec.Body.String(), `"web":{"documents":{"total":2`) {
+		t.Errorf("expected every client reloaded; received %d: %s", rec.Code, rec.Body)
+	}
+	r := postJSON(`{"query":"{ more }"}`)
+	r.Header.Set(header, "web")
+	if w := do(t, p, r); w.Code != http.StatusOK {
+		t.Errorf("expected the reloaded document allowed; received %d", w.Code)
+	}
+}
@@ -3,9 +3,11 @@ package proxy
 import (
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,7 +23,16 @@ type control struct {
 	allowlist *allowlist.Allowlist
 
 	// dir is what a reload reads, and what the log of one names.
-	dir   string
+	dir string
+
+	// git is set where every allowlist is read from Git, which a reload
+	// may then move to another ref, see -allowlist.git-ref.
+	git bool
+
+	// remotes are the allowlists fetched over HTTP, which a reload fetches
+	// afresh rather than reading dir, see [remote].
+	remotes []*remote
+
 	proxy *proxy
 	token string
 	log   zerolog.Logger
@@ -58,18 +69,105 @@ func (c *control) healthz(w http.ResponseWriter, r *http.Request) {
 func (c *control) status(w http.ResponseWriter, _ *http.Request) {
 	documents, loadedAt := c.allowlist.Stats()
 	d := c.proxy.snapshot()
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	_, _ = fmt.Fprintf(w,
 		`{"documents":%d,"loaded_at":%q,"allowed":%d,"rejected":%d,`+
 			`"malformed":%d,"too_large":%d,"ambiguous":%d,"too_deep":%d,`+
 			`"batch_too_large":%d,"method_not_allowed":%d,`+
-			`"upstream_errors":%d}`+"\n",
+			`"upstream_errors":%d,"hits":%s,"sunset":%s,"expired":%s%s%s%s%s}`+"\n",
 		documents, loadedAt.Format(time.RFC3339), d.allowed, d.rejected,
 		d.malformed, d.tooLarge, d.ambiguous, d.tooDeep, d.batchBig, d.methodBad,
-		d.upstream)
+		d.upstream, hitsJSON(&c.proxy.counters.hits),
+		hitsJSON(&c.proxy.counters.sunset), hitsJSON(&c.proxy.counters.expired),
+		schemesJSON(&c.proxy.counters.schemes), revisionJSON(c.allowlist),
+		clientsJSON(c.proxy.clients), fetchesJSON(c.remotes))
+}
+
+// revisionJSON is the "ref" and "commit" members of /status: the revision the
+// allowlist was read at, see -allowlist.git-ref. Nothing where it isn't read
+// from Git, so the answer stays what it was.
+func revisionJSON(list *allowlist.Allowlist) []byte {
+	ref, commit := list.Revision()
+	if commit == "" {
+		return nil
+	}
+	encoded, _ := json.Marshal(ref) // A string, which can't fail.
+	return fmt.Appendf(nil, `,"ref":%s,"commit":%q`, encoded, commit)
 }
 
-// reload rereads the allowlist and answers with what it holds afterwards.
+// clientsJSON is the "clients" member of /status: the allowlist of every client
+// and what was decided against it, the fallback named "". Nothing without
+// -allowlist.client-header, so the answer stays what it was.
+func clientsJSON(c *clients) []byte {
+	if c == nil {
+		return nil
+	}
+	type client struct {
+		Client    string `json:"client"`
+		Documents int    `json:"documents"`
+		Allowed   uint64 `json:"allowed"`
+		Rejected  uint64 `json:"rejected"`
+		Ref       string `json:"ref,omitempty"`
+		Commit    string `json:"commit,omitempty"`
+	}
+	var all []client
+	for _, l := range c.all() {
+		ref, commit := l.allowlist.Revision()
+		all = append(all, client{
+			Client: l.name, Documents: l.allowlist.Len(),
+			Allowed: l.allowed.Load(), Rejected: l.rejected.Load(),
+			Ref: ref, Commit: commit,
+		})
+	}
+	encoded, _ := json.Marshal(all) // Strings and numbers alone, which can't fail.
+	return append([]byte(`,"clients":`), encoded...)
+}
+
+// hitsJSON is the counts of h as /status answers them. The owners and clients
+// are what the allowlist says, so they're encoded rather than formatted.
+// Never null: nothing counted yet answers [].
+func hitsJSON(h *hits) []byte {
+	type count struct {
+		Owner  string `json:"owner"`
+		Client string `json:"client"`
+		Count  uint64 `json:"count"`
+	}
+	counts := []count{}
+	for _, c := range h.snapshot() {
+		counts = append(counts, count{Owner: c.owner, Client: c.client, Count: c.count})
+	}
+	encoded, _ := json.Marshal(counts) // Strings and numbers alone, which can't fail.
+	return encoded
+}
+
+// schemesJSON is the "schemes" member of /status: the documents allowed by
+// each scheme, see [schemeHits]. Nothing without -allowlist.also-scheme, so
+// the answer stays what it was.
+func schemesJSON(s *schemeHits) []byte {
+	if len(s.names) == 0 {
+		return nil
+	}
+	type count struct {
+		Scheme string `json:"scheme"`
+		Count  uint64 `json:"count"`
+	}
+	counts := make([]count, 0, len(s.names))
+	for i, name := range s.names {
+		counts = append(counts, count{Scheme: name, Count: s.counts[i].Load()})
+	}
+	encoded, _ := json.Marshal(counts) // Strings and numbers alone, which can't fail.
+	return append([]byte(`,"schemes":`), encoded...)
+}
+
+// reload rereads the allowlist and answers with what it holds afterwards and
+// what changed. With ?dry_run=1 it publishes nothing and answers with what a
+// reload would have, see [allowlist.Allowlist.Check].
+//
+// Under -allowlist.git-ref, ?ref= reads every allowlist at another ref and
+// moves it there, see [allowlist.Allowlist.ReloadAt]; without it a reload
+// rereads the ref each is at. A ref naming no commit is answered 400 and
+// moves nothing.
 //
 // Only POST does it, so a browser or a scraper that wanders onto the address
 // can't spend the work of a reload.
@@ -84,42 +182,110 @@ func (c *control) reload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "dry_run: expected a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	ref := r.URL.Query().Get("ref")
+	if ref != "" && !c.git {
+		http.Error(w, "ref: the allowlist isn't read from Git, see -allowlist.git-ref",
+			http.StatusBadRequest)
+		return
+	}
+	load := func(list *allowlist.Allowlist, dir string) (allowlist.Result, error) {
+		if remote := c.remoteOf(list); remote != nil {
+			return remote.reload(r.Context(), dryRun)
+		}
+		if dryRun {
+			return list.CheckAt(dir, ref)
+		}
+		return list.ReloadAt(dir, ref)
+	}
 
 	// Load serializes its callers, so concurrent requests queue instead of
 	// parsing the same directory at once.
-	result, err := c.allowlist.Reload(c.dir)
+	answer, err := c.reloadOne(load, c.allowlist, c.dir, "", dryRun)
+	if errors.Is(err, allowlist.ErrNoRevision) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		c.log.Error().Err(err).Msg("reloading the allowlist")
 		http.Error(w, "reloading the allowlist failed", http.StatusInternalServerError)
 		return
 	}
-	logReload(c.log, c.dir, result)
+	refused := answer.Error != ""
 
-	// A skipped file is no failure of the reload — the rest is published — but
-	// it's answered, so a deployment can fail on it without reading the log.
-	// Files is never nil: a load that took nothing answers [] and not null.
-	var answer reloadAnswer
-	answer.Documents.Total = len(result.Files)
-	answer.Documents.Files = result.Files
-	skipped := result.Skipped
-	if result.SchemaErr != nil {
-		skipped = append([]error{result.SchemaErr}, skipped...)
-	}
-	answer.Skipped.Total = len(skipped)
-	answer.Skipped.Errors = make([]string, len(skipped))
-	for i, e := range skipped {
-		answer.Skipped.Errors[i] = e.Error()
+	// The allowlist of every client is reread too, each on its own: one that
+	// fails keeps what it held, as -allowlist does, and fails the answer,
+	// though the ones before it were published. One that is refused is
+	// answered, and the rest are reread.
+	if clients := c.proxy.clients; clients != nil {
+		answer.Clients = make(map[string]*reloadAnswer, len(clients.named))
+		for _, l := range clients.named {
+			a, err := c.reloadOne(load, l.allowlist, l.dir, l.name, dryRun)
+			if errors.Is(err, allowlist.ErrNoRevision) {
+				http.Error(w, fmt.Sprintf("client %q: %v", l.name, err),
+					http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				c.log.Error().Err(err).Str("client", l.name).
+					Msg("reloading the allowlist of a client")
+				http.Error(w, fmt.Sprintf("reloading the allowlist of client %q failed",
+					l.name), http.StatusInternalServerError)
+				return
+			}
+			refused = refused || a.Error != ""
+			answer.Clients[l.name] = a
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if refused {
+		// Nothing failed, the proxy serves what it did, and the answer is
+		// still what was read; but a deployment has to see that what it
+		// rolled out isn't served without reading the body.
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
 	if err := json.NewEncoder(w).Encode(answer); err != nil {
 		// On its way out, so there's nothing left to say to the client.
 		c.log.Debug().Err(err).Msg("writing the reload answer")
 	}
 }
 
-// reloadAnswer is what a reload replies: what the allowlist holds now and what
-// didn't make it. Every error names the file, the line and the column.
+// reloadOne reloads list from dir with load, logs it and answers it. A reload
+// the policy refused is answered with its Error; any other failure is returned
+// and answered by the caller. client names the client of list, empty for
+// -allowlist.
+func (c *control) reloadOne(
+	load func(*allowlist.Allowlist, string) (allowlist.Result, error),
+	list *allowlist.Allowlist, dir, client string, dryRun bool,
+) (*reloadAnswer, error) {
+	result, err := load(list, dir)
+	if err != nil && !errors.Is(err, allowlist.ErrRefused) {
+		return nil, err
+	}
+	log := c.log
+	if client != "" {
+		log = log.With().Str("client", client).Logger()
+	}
+	logReload(log, dir, result, dryRun, err)
+	answer := answerOf(result)
+	answer.DryRun = dryRun
+	if err != nil {
+		answer.Error = err.Error()
+	}
+	return &answer, nil
+}
+
+// reloadAnswer is what a reload replies: what the allowlist holds now, what
+// didn't make it and what changed. Every error names the file, the line and
+// the column.
 //
 // Exported fields on an unexported type: encoding/json marshals no other kind,
 // and lowercasing them answers {} to every reload.
@@ -132,6 +298,83 @@ type reloadAnswer struct {
 		Total  int      `json:"total"`
 		Errors []string `json:"errors"`
 	} `json:"skipped"`
+
+	// Added and Removed are the documents the allowlist holds that the one
+	// before didn't, and the other way around. Renamed are those both hold,
+	// the same hash under a new name.
+	Added   []changed `json:"added"`
+	Removed []changed `json:"removed"`
+	Renamed []renamed `json:"renamed"`
+
+	// DryRun is set where nothing was published: the answer is what a reload
+	// would have.
+	DryRun bool `json:"dry_run"`
+
+	// Ref and Commit are the revision read, see -allowlist.git-ref: the ref
+	// as given and the commit it named. Left out where it isn't read from Git.
+	Ref    string `json:"ref,omitempty"`
+	Commit string `json:"commit,omitempty"`
+
+	// Error is why -allowlist.strict or -allowlist.max-removed refused the
+	// reload, which published nothing. Left out where none refused it.
+	Error string `json:"error,omitempty"`
+
+	// Clients is the answer of the allowlist of every client by its name,
+	// see -allowlist.client. Left out without any.
+	Clients map[string]*reloadAnswer `json:"clients,omitempty"`
+}
+
+// changed is a document a reload added or removed: its name and the names of
+// its operations, which are what tells apart the entries of one file.
+type changed struct {
+	Name       string   `json:"name"`
+	Operations []string `json:"operations,omitempty"`
+}
+
+// renamed is a document a reload found under a new name.
+type renamed struct {
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Operations []string `json:"operations,omitempty"`
+}
+
+// answerOf is what a reload answers of result.
+//
+// A skipped file is no failure of the reload — the rest is published — but
+// it's answered, so a deployment can fail on it without reading the log.
+// No list is nil: a load that took or changed nothing answers [] and not null.
+func answerOf(result allowlist.Result) reloadAnswer {
+	var answer reloadAnswer
+	answer.Documents.Total = len(result.Files)
+	answer.Documents.Files = result.Files
+	answer.Ref, answer.Commit = result.Ref, result.Commit
+	answer.Added = changesOf(result.Added)
+	answer.Removed = changesOf(result.Removed)
+	answer.Renamed = make([]renamed, len(result.Renamed))
+	for i, r := range result.Renamed {
+		answer.Renamed[i] = renamed{
+			From: r.From.Name, To: r.To.Name, Operations: r.To.Operations,
+		}
+	}
+	skipped := result.Skipped
+	if result.SchemaErr != nil {
+		skipped = append([]error{result.SchemaErr}, skipped...)
+	}
+	answer.Skipped.Total = len(skipped)
+	answer.Skipped.Errors = make([]string, len(skipped))
+	for i, e := range skipped {
+		answer.Skipped.Errors[i] = e.Error()
+	}
+	return answer
+}
+
+// changesOf is what a reload answers of entries.
+func changesOf(entries []*allowlist.Entry) []changed {
+	c := make([]changed, len(entries))
+	for i, e := range entries {
+		c[i] = changed{Name: e.Name, Operations: e.Operations}
+	}
+	return c
 }
 
 // authorized reports whether r carries the token.
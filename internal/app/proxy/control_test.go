@@ -1,13 +1,22 @@
 package proxy
 
 import (
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/rs/zerolog"
 
+	"github.com/romshark/gqlhash/v2"
 	"github.com/romshark/gqlhash/v2/internal/allowlist"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
 )
 
 // TestLogReload covers what the proxy makes of a reload. The allowlist reports
@@ -17,12 +26,14 @@ func TestLogReload(t *testing.T) {
 	logOf := func(t *testing.T, r allowlist.Result) string {
 		t.Helper()
 		logs := new(strings.Builder)
-		logReload(zerolog.New(logs), "/srv/queries", r)
+		logReload(zerolog.New(logs), "/srv/queries", r, false, nil)
 		return logs.String()
 	}
 
 	// A load that took everything is one line, at info: nothing needs attention.
-	full := logOf(t, allowlist.Result{Files: []string{"a.graphql"}, Added: 1})
+	full := logOf(t, allowlist.Result{
+		Files: []string{"a.graphql"}, Added: []*allowlist.Entry{{Name: "a.graphql"}},
+	})
 	for _, want := range []string{
 		`"message":"allowlist loaded"`, `"documents":1`, `"added":1`,
 		`"removed":0`, `"skipped":0`, `"dir":"/srv/queries"`,
@@ -35,6 +46,40 @@ func TestLogReload(t *testing.T) {
 		t.Errorf("expected nothing at error level; received %s", full)
 	}
 
+	// What changed is named, and a dry run says it published nothing.
+	changed := logOf(t, allowlist.Result{
+		Files: []string{"b.graphql"},
+		Added: []*allowlist.Entry{{Name: "b.graphql"}},
+		Renamed: []allowlist.Rename{
+			{From: &allowlist.Entry{Name: "a.graphql"}, To: &allowlist.Entry{Name: "c.graphql"}},
+		},
+	})
+	for _, want := range []string{
+		`"added_files":["b.graphql"]`, `"removed_files":[]`,
+		`"renamed_files":["a.graphql -> c.graphql"]`, `"renamed":1`,
+	} {
+		if !strings.Contains(changed, want) {
+			t.Errorf("expected %s; received %s", want, changed)
+		}
+	}
+	dry := new(strings.Builder)
+	logReload(zerolog.New(dry), "/srv/queries", allowlist.Result{}, true, nil)
+	if !strings.Contains(dry.String(), `"message":"allowlist checked, nothing published"`) ||
+		!strings.Contains(dry.String(), `"dry_run":true`) {
+		t.Errorf("expected the dry run reported as one; received %s", dry)
+	}
+
+	// A refused reload is an error, and no empty allowlist: nothing was
+	// published.
+	refused := new(strings.Builder)
+	logReload(zerolog.New(refused), "/srv/queries", allowlist.Result{}, false,
+		fmt.Errorf("%w: 1 skipped", allowlist.ErrRefused))
+	if !strings.Contains(refused.String(), `"message":"allowlist refused, serving the one before"`) ||
+		!strings.Contains(refused.String(), `"level":"error"`) ||
+		strings.Contains(refused.String(), "every request is rejected") {
+		t.Errorf("expected the refusal reported; received %s", refused)
+	}
+
 	// A document left out is an error: it was meant to be served and isn't.
 	skipped := logOf(t, allowlist.Result{
 		Files:   []string{"a.graphql"},
@@ -57,6 +102,12 @@ func TestLogReload(t *testing.T) {
 		t.Errorf("expected the schema to be reported; received %s", schema)
 	}
 
+	// Read from Git, the line names the revision read.
+	revision := logOf(t, allowlist.Result{Files: []string{"a.graphql"}, Ref: "v1", Commit: "0a6b63c"})
+	if !strings.Contains(revision, `"ref":"v1","commit":"0a6b63c"`) {
+		t.Errorf("expected the revision; received %s", revision)
+	}
+
 	// An empty allowlist rejects every request, which is loud in the counters
 	// and silent otherwise, so it says so once.
 	empty := logOf(t, allowlist.Result{})
@@ -65,3 +116,185 @@ func TestLogReload(t *testing.T) {
 		t.Errorf("expected the empty allowlist to be reported; received %s", empty)
 	}
 }
+
+// TestControlReload covers the answer of POST /reload: the documents added,
+// removed and renamed, and with ?dry_run=1 the same answer and nothing
+// published.
+func TestControlReload(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	writeDoc(t, dir, "b.graphql", "query B { b }")
+	list := newAllowlist(t, dir)
+	mux := http.NewServeMux()
+	(&control{allowlist: list, dir: dir, proxy: &proxy{}, log: testLogger()}).routes(mux)
+	post := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		return rec
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.graphql")); err != nil {
+		t.Fatal(err)
+	}
+	err := os.Rename(filepath.Join(dir, "b.graphql"), filepath.Join(dir, "c.graphql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDoc(t, dir, "d.graphql", "{ d }")
+	want := fmt.Sprintf(`"added":[{"name":%q}],"removed":[{"name":%q}],`+
+		`"renamed":[{"from":%q,"to":%q,"operations":["B"]}]`,
+		filepath.Join(dir, "d.graphql"), filepath.Join(dir, "a.graphql"),
+		filepath.Join(dir, "b.graphql"), filepath.Join(dir, "c.graphql"))
+
+	rec := post("/reload?dry_run=1")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) ||
+		!strings.Contains(rec.Body.String(), `"dry_run":true`) {
+		t.Fatalf("expected %s in a dry run; received %d: %s", want, rec.Code, rec.Body)
+	}
+	allowedA := func() bool {
+		sum, err := gqlhash.AppendHash(nil, sha256.New(), gqlhash.Options{}, "{ a }")
+		if err.IsErr() {
+			t.Fatal(err)
+		}
+		return list.Allowed(sum)
+	}
+	if !allowedA() {
+		t.Fatal("expected a dry run to publish nothing")
+	}
+
+	rec = post("/reload")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) ||
+		!strings.Contains(rec.Body.String(), `"dry_run":false`) {
+		t.Fatalf("expected %s in the reload; received %d: %s", want, rec.Code, rec.Body)
+	}
+	if allowedA() {
+		t.Fatal("expected the reload published")
+	}
+
+	// Nothing changed answers empty lists rather than null.
+	rec = post("/reload")
+	if !strings.Contains(rec.Body.String(), `"added":[],"removed":[],"renamed":[]`) {
+		t.Errorf("expected empty lists; received %s", rec.Body)
+	}
+
+	if rec := post("/reload?dry_run=maybe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a dry_run that isn't a boolean; received %d", rec.Code)
+	}
+}
+
+// TestControlReloadRefused covers a reload the policy refuses: answered with
+// 422 and why, and the allowlist serves what it did.
+func TestControlReloadRefused(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	list := newAllowlistOf(config.Proxy{
+		HashFunc: config.HashFunctionSHA2, AllowlistStrict: true,
+	}, sha256.New, nil)
+	if _, err := list.Reload(dir); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	(&control{allowlist: list, dir: dir, proxy: &proxy{}, log: testLogger()}).routes(mux)
+
+	writeDoc(t, dir, "b.graphql", "{ b }")
+	writeDoc(t, dir, "c.graphql", "{ broken")
+	for _, target := range []string{"/reload?dry_run=1", "/reload"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		if rec.Code != http.StatusUnprocessableEntity ||
+			!strings.Contains(rec.Body.String(),
+				`"error":"refused by the reload policy: 1 skipped"`) ||
+			!strings.Contains(rec.Body.String(), `"total":2`) {
+			t.Errorf("%s: expected the refusal answered; received %d: %s",
+				target, rec.Code, rec.Body)
+		}
+	}
+	if list.Len() != 1 {
+		t.Errorf("expected the allowlist to serve what it did; %d documents", list.Len())
+	}
+}
+
+// TestControlReloadRef covers -allowlist.git-ref: ?ref= moves the allowlist
+// to another commit, which /status and the metrics name, and a ref naming no
+// commit is answered 400 and moves nothing.
+func TestControlReloadRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git on PATH")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test",
+			"GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test",
+			"GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	writeDoc(t, repo, "a.graphql", "{ a }")
+	git("add", "-A")
+	git("commit", "-q", "-m", "v1")
+	git("tag", "v1")
+	writeDoc(t, repo, "b.graphql", "{ b }")
+	git("add", "-A")
+	git("commit", "-q", "-m", "v2")
+	git("tag", "v2")
+	v2 := git("rev-parse", "HEAD")
+
+	list := newAllowlistOf(config.Proxy{
+		HashFunc: config.HashFunctionSHA2, AllowlistGitRef: "v1",
+	}, sha256.New, nil)
+	if _, err := list.Reload(repo); err != nil {
+		t.Fatal(err)
+	}
+	p := newProxy(list, mustURL(t, "http://upstream/graphql"), sha256.New,
+		proxyConfig{maxBody: 1 << 20}, http.DefaultTransport, testLogger())
+	mux := http.NewServeMux()
+	(&control{allowlist: list, dir: repo, git: true, proxy: p, log: testLogger()}).routes(mux)
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/reload?ref=v2")
+	if want := fmt.Sprintf(`"ref":"v2","commit":%q`, v2); rec.Code != http.StatusOK ||
+		!strings.Contains(rec.Body.String(), want) {
+		t.Fatalf("expected %s; received %d: %s", want, rec.Code, rec.Body)
+	}
+	if list.Len() != 2 {
+		t.Errorf("expected the documents of v2; %d documents", list.Len())
+	}
+
+	rec = serve(http.MethodPost, "/reload?ref=v3")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "no such commit") {
+		t.Errorf("expected 400 for a ref naming nothing; received %d: %s", rec.Code, rec.Body)
+	}
+	if ref, commit := list.Revision(); ref != "v2" || commit != v2 {
+		t.Errorf("expected the allowlist to stay at v2; received %s %s", ref, commit)
+	}
+
+	if want := fmt.Sprintf(`"ref":"v2","commit":%q`, v2); !strings.Contains(
+		serve(http.MethodGet, "/status").Body.String(), want) {
+		t.Errorf("expected %s in the status", want)
+	}
+	rec = httptest.NewRecorder()
+	p.metrics.Handler(testLogger()).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := fmt.Sprintf(
+		`gqlhash_proxy_allowlist_revision_info{client="",commit=%q,ref="v2"} 1`, v2)
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("expected %s in the metrics; received %s", want, rec.Body)
+	}
+
+	// An allowlist not read from Git has no ref to move to.
+	mux = http.NewServeMux()
+	(&control{allowlist: list, dir: repo, proxy: p, log: testLogger()}).routes(mux)
+	if rec := serve(http.MethodPost, "/reload?ref=v1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without -allowlist.git-ref; received %d", rec.Code)
+	}
+}
@@ -60,6 +60,15 @@ type Request struct {
 
 	// HasBody is asked only of a GET: one carrying a body names its document twice.
 	HasBody bool
+
+	// Client is the value of the header of -allowlist.client-header, which picks
+	// the allowlist the request is checked against. Needed only where
+	// [Core.ClientHeader] names one. Read and not kept, as Body is.
+	Client []byte
+
+	// RemoteAddr is the client, which the log of a forward names. Needed only
+	// where [Core.LogRequests], so an implementation formats it only then.
+	RemoteAddr string
 }
 
 // Verdict is what the proxy decided.
@@ -102,6 +111,9 @@ type Answer struct {
 
 // Decide reports what to do with req, counts it, and returns the answer to
 // write where it isn't forwarded. Nothing of req is retained.
+//
+// A request forwarded is logged here under [Core.LogRequests], with the
+// entries of the allowlist it matched, which are gone once this returns.
 func (c *Core) Decide(req Request) (Verdict, Answer) {
 	p := c.p
 	st := p.states.Get().(*state)
@@ -152,10 +164,15 @@ func (c *Core) Decide(req Request) (Verdict, Answer) {
 			err.Error(), "BAD_REQUEST")
 	case !allowed:
 		p.counters.rejected.Add(1)
+		st.client.count(false)
 		return VerdictRejected, c.answer(http.StatusForbidden,
 			"operation not allowed", "OPERATION_NOT_ALLOWED")
 	}
 	p.counters.allowed.Add(1)
+	st.client.count(true)
+	if p.logRequests {
+		p.logForwarding(req.RemoteAddr, st.matched)
+	}
 	return VerdictAllowed, Answer{Code: http.StatusOK}
 }
 
@@ -222,6 +239,15 @@ func (c *Core) Debug() bool          { return c.p.debug }
 func (c *Core) LogRequests() bool    { return c.p.logRequests }
 func (c *Core) TrustForwarded() bool { return c.p.trustForwarded }
 
+// ClientHeader is the header [Request.Client] is read from, empty where it's
+// read from none.
+func (c *Core) ClientHeader() string {
+	if c.p.clients == nil {
+		return ""
+	}
+	return c.p.clients.header
+}
+
 // ContentType is the media type an [Answer] is written with,
 // given the Accept header of the request it answers, see [AcceptsGraphQLResponseJSON].
 // It takes one so that an implementation can't answer a client in a media type
@@ -191,7 +191,7 @@ func FuzzExtractJSONAgainstEncodingJSON(f *testing.F) {
 			}
 			documents := make([]string, 0, len(spans))
 			for _, s := range spans {
-				value, _, err := unescapeJSON(nil, []byte(body)[s.start:s.end])
+				value, _, err := unescapeJSON(nil, []byte(body)[s.Start:s.End])
 				if err != nil {
 					return nil, false
 				}
@@ -1,40 +1,30 @@
 package proxy
 
 import (
-	"bytes"
 	"errors"
 	"strings"
-	"unicode/utf8"
 	"unsafe"
 
-	"github.com/romshark/jscan/v2"
-
+	"github.com/romshark/gqlhash/v2/internal/jsonrequest"
 	"github.com/romshark/gqlhash/v2/internal/unicodeesc"
 )
 
+// The JSON body is read by [jsonrequest], which the hashing command shares.
+// These keep the names the rest of this package reads it by.
 var (
-	errNoQuery       = errors.New("no query")
-	errMalformedJSON = errors.New("malformed JSON")
-
-	// errBatch is a batch of requests where none is expected.
-	errBatch = errors.New("batched request")
-
-	// errBatchTooLarge is a batch carrying more documents than -server.max-batch allows.
-	errBatchTooLarge = errors.New("too many documents in the batch")
-
-	// errBatchElementNoDocument is an element of a batch that carries no document.
-	// The cap counts documents, so an element without one is neither counted
-	// against it nor looked up in the allowlist: a batch of one allowed document
-	// and twenty thousand numbers would otherwise reach the API whole.
-	//
-	// Document rather than query in the message: the member is named query,
-	// but it carries the document whatever operation that runs, so a client
-	// told it carried no query would read it as one told to send no mutation.
-	errBatchElementNoDocument = errors.New("a batch element carries no document")
+	errNoQuery                = jsonrequest.ErrNoQuery
+	errMalformedJSON          = jsonrequest.ErrMalformedJSON
+	errBatch                  = jsonrequest.ErrBatch
+	errBatchTooLarge          = jsonrequest.ErrBatchTooLarge
+	errBatchElementNoDocument = jsonrequest.ErrBatchElementNoDocument
+	errInvalidEscape          = jsonrequest.ErrInvalidEscape
+	errQueryCollision         = jsonrequest.ErrQueryCollision
+	errOperationNameCollision = jsonrequest.ErrOperationNameCollision
+)
 
-	errInvalidEscape  = errors.New("invalid escape sequence in query")
-	errDuplicateQuery = errors.New("duplicate query parameter")
-	errQueryCollision = errors.New(`naming collision on field "query"`)
+var (
+	errDuplicateQuery         = errors.New("duplicate query parameter")
+	errDuplicateOperationName = errors.New("duplicate operationName parameter")
 
 	// errBodyOnGET is a GET carrying a body beside the query parameter its
 	// document is read from.
@@ -47,242 +37,17 @@ var (
 	errMethodNotAllowed = errors.New("method not allowed")
 )
 
-// span is the range of a document within the request body.
-type span struct{ start, end int }
+// span is the range of a document within the request body, see [jsonrequest.Span].
+type span = jsonrequest.Span
 
-// extractJSON finds the query member of every request in body and appends its
-// span to dst. body is one request object, or an array of them where maxBatch is
-// 1 or more. A span points at the raw JSON string contents, escapes included.
-//
-// maxBatch is -server.max-batch: how many documents one request may carry,
-// 0 for no batching at all, which makes an array [errBatch]. A batch past it is
-// [errBatchTooLarge] and the scan stops there, so a body holding tens of
-// thousands of documents costs the cap and not the body.
-//
-// Every element of a batch has to carry a document of its own,
-// or it's [errBatchElementNoDocument] and the scan stops there too.
-// The cap counts documents, so an element carrying none is one the cap never sees,
-// see that error.
-//
-// A request object naming the query member twice is [errQueryCollision] rather
-// than two documents: which one an API runs is the API's business,
-// and checking one while the API runs the other allows a document nobody read.
-// See [isQueryKey] for what counts as the same name.
-//
-// Nothing is copied: a span is a range within body.
+// extractJSON is [jsonrequest.Extract].
 func extractJSON(dst []span, body []byte, maxBatch int) ([]span, error) {
-	// The member level of a lone request object, and one deeper within an array.
-	level := 1
-
-	// One word, since the callback runs per member and every variable it closes
-	// over is a load and a store there.
-	const (
-		flagFound        = 1 << iota // A document has been found.
-		flagSeen                     // This request object names the member.
-		flagCollision                // It names it twice.
-		flagTooMany                  // The batch carries more than maxBatch.
-		flagInElement                // An element of a batch has been entered.
-		flagElementFound             // That element carries a document.
-		flagElementEmpty             // One carried none.
-	)
-	var flags uint8
-
-	errScan := jscan.Scan(body, func(i *jscan.Iterator[[]byte]) (err bool) {
-		if i.Level() == 0 {
-			// The outermost value decides whether this is a batch.
-			if i.ValueType() == jscan.ValueTypeArray {
-				if maxBatch < 1 {
-					return true
-				}
-				level = 2
-			}
-			return false
-		}
-		// Each request of a batch is named once of its own, and carries one of its own:
-		// the element that just ended is checked as the next begins,
-		// and the last one after the scan.
-		if level == 2 && i.Level() == 1 {
-			if flags&flagInElement != 0 && flags&flagElementFound == 0 {
-				flags |= flagElementEmpty
-				return true
-			}
-			flags = flags&^(flagSeen|flagElementFound) | flagInElement
-			return false
-		}
-		if i.Level() != level || !isQueryKey(i.Key()) {
-			return false
-		}
-		if flags&flagSeen != 0 {
-			flags |= flagCollision
-			return true
-		}
-		flags |= flagSeen
-		// Only a string is a document, but the name counts either way:
-		// an API may still run something out of a member this reads nothing from,
-		// so a second one beside it is a collision.
-		if i.ValueType() != jscan.ValueTypeString {
-			return false
-		}
-		// ValueIndex and ValueIndexEnd include the quotes.
-		dst = append(dst, span{i.ValueIndex() + 1, i.ValueIndexEnd() - 1})
-		flags |= flagFound | flagElementFound
-		// One past the cap is enough to refuse, so the rest of the array is never
-		// read: a megabyte of documents costs what the cap allows plus one.
-		// Only within an array — a lone request object is one document whatever
-		// -server.max-batch says.
-		if level == 2 && len(dst) > maxBatch {
-			flags |= flagTooMany
-			return true
-		}
-		return false
-	})
-
-	if errScan.IsErr() {
-		if errScan.Code == jscan.ErrorCodeCallback {
-			// The callback breaks for an unexpected batch, for a collision,
-			// for a batch past the cap, and for an element carrying no document.
-			switch {
-			case flags&flagCollision != 0:
-				return dst, errQueryCollision
-			case flags&flagTooMany != 0:
-				return dst, errBatchTooLarge
-			case flags&flagElementEmpty != 0:
-				return dst, errBatchElementNoDocument
-			}
-			return dst, errBatch
-		}
-		return dst, errMalformedJSON
-	}
-	// The last element of a batch has no element after it to be checked by,
-	// so it's checked here. An empty array is no element at all and carries no
-	// document either, which the next line answers.
-	if flags&flagInElement != 0 && flags&flagElementFound == 0 {
-		return dst, errBatchElementNoDocument
-	}
-	if flags&flagFound == 0 {
-		return dst, errNoQuery
-	}
-	return dst, nil
+	return jsonrequest.Extract(dst, body, maxBatch)
 }
 
-// unescapeJSON returns the value of the JSON string contents s.
-// Without an escape sequence it returns s itself,
-// otherwise it appends the value to scratch and returns that.
+// unescapeJSON is [jsonrequest.Unescape].
 func unescapeJSON(scratch, s []byte) (value, newScratch []byte, err error) {
-	i := bytes.IndexByte(s, '\\')
-	if i < 0 {
-		return s, scratch, nil
-	}
-
-	scratch = append(scratch[:0], s[:i]...)
-	for i < len(s) {
-		if s[i] != '\\' {
-			scratch = append(scratch, s[i])
-			i++
-			continue
-		}
-		if i+1 >= len(s) {
-			return nil, scratch, errInvalidEscape
-		}
-		switch s[i+1] {
-		case '"', '\\', '/':
-			scratch = append(scratch, s[i+1])
-			i += 2
-		case 'b':
-			scratch = append(scratch, '\b')
-			i += 2
-		case 'f':
-			scratch = append(scratch, '\f')
-			i += 2
-		case 'n':
-			scratch = append(scratch, '\n')
-			i += 2
-		case 'r':
-			scratch = append(scratch, '\r')
-			i += 2
-		case 't':
-			scratch = append(scratch, '\t')
-			i += 2
-		case 'u':
-			if i+6 > len(s) {
-				return nil, scratch, errInvalidEscape
-			}
-			v, ok := unicodeesc.Hex4(s[i+2:])
-			if !ok {
-				return nil, scratch, errInvalidEscape
-			}
-			i += 6
-			if unicodeesc.IsLeadingSurrogate(v) {
-				// A leading surrogate takes a trailing one to make a rune.
-				if i+6 > len(s) || s[i] != '\\' || s[i+1] != 'u' {
-					return nil, scratch, errInvalidEscape
-				}
-				t, ok := unicodeesc.Hex4(s[i+2:])
-				if !ok || !unicodeesc.IsTrailingSurrogate(t) {
-					return nil, scratch, errInvalidEscape
-				}
-				v = unicodeesc.Pair(v, t)
-				i += 6
-			}
-			scratch = utf8.AppendRune(scratch, rune(v))
-		default:
-			return nil, scratch, errInvalidEscape
-		}
-	}
-	return scratch, scratch, nil
-}
-
-// isQueryKey reports whether key names the query member of a request object.
-// key carries the quotes around it, which this drops.
-//
-// The name is read the way a JSON decoder reads it, which makes the answer the
-// same as the API's. [encoding/json] matches a struct field without case,
-// so an API reading the body into one takes "queRY" for the query; and every decoder
-// unescapes a key before matching, so "quer\u0079" is that member spelled
-// another way. Reading only the exact spelling would leave
-//
-//	{"query":"<allowed>","quer\u0079":"<anything>"}
-//
-// checked against the first and executed as the second. Taking every spelling
-// makes the second a collision, which [extractJSON] refuses — and an API that
-// matches exactly runs nothing of that member, so refusing is conservative
-// either way.
-func isQueryKey(key []byte) bool {
-	const want = "query"
-	// Only \uXXXX spells a letter, so a name unescaping to want is five units
-	// of one byte or six: every length from 5 to 30 in steps of five.
-	// Any other length keeps an ordinary member off the path below.
-	const escapedMax = len(`\u0071\u0075\u0065\u0072\u0079`)
-
-	if len(key) < 2 {
-		return false
-	}
-	name := key[1 : len(key)-1] // Without the quotes.
-	if len(name) != len(want) {
-		if len(name) < len(want) || len(name) > escapedMax ||
-			(len(name)-len(want))%len(want) != 0 {
-			return false
-		}
-		if bytes.IndexByte(name, '\\') < 0 {
-			return false
-		}
-		// An escape costs a buffer, paid only by a name that could still be it.
-		value, _, err := unescapeJSON(make([]byte, 0, escapedMax), name)
-		if err != nil || len(value) != len(want) {
-			return false
-		}
-		name = value
-	}
-
-	for i := range want {
-		// Compared without case, the way a struct field is matched.
-		// Only a letter of want lowercases onto one, so a backslash left in a name of
-		// the plain length fails here rather than earlier.
-		if name[i]|0x20 != want[i] {
-			return false
-		}
-	}
-	return true
+	return jsonrequest.Unescape(scratch, s)
 }
 
 // extractQueryParam returns the percent-decoded value of the query parameter
@@ -299,8 +64,32 @@ func isQueryKey(key []byte) bool {
 // it runs the document. The wider reading is the conservative half: a document
 // refused here reaches nothing, one allowed here is what those APIs would run.
 func extractQueryParam(scratch []byte, rawQuery string) (value, newScratch []byte, err error) {
-	var raw string
-	var found bool
+	raw, found, err := findParam(rawQuery, "query", errDuplicateQuery)
+	if err != nil {
+		return nil, scratch, err
+	}
+	if !found {
+		return nil, scratch, errNoQuery
+	}
+	return urlDecode(scratch, raw)
+}
+
+// extractOperationParam is [extractQueryParam] of the operationName
+// parameter, which picks the operation of the document to run. None is no
+// error: it's nil, as is an empty one, which names no operation either.
+func extractOperationParam(
+	scratch []byte, rawQuery string,
+) (value, newScratch []byte, err error) {
+	raw, _, err := findParam(rawQuery, "operationName", errDuplicateOperationName)
+	if err != nil || raw == "" {
+		return nil, scratch, err
+	}
+	return urlDecode(scratch, raw)
+}
+
+// findParam returns the raw value of the parameter name in rawQuery, and
+// duplicate where it's there twice, see [extractQueryParam].
+func findParam(rawQuery, name string, duplicate error) (raw string, found bool, err error) {
 	for i := 0; i < len(rawQuery); {
 		end := i
 		for end < len(rawQuery) && rawQuery[end] != '&' && rawQuery[end] != ';' {
@@ -319,18 +108,20 @@ func extractQueryParam(scratch []byte, rawQuery string) (value, newScratch []byt
 		if eq < len(pair) {
 			key, val = pair[:eq], pair[eq+1:]
 		}
-		if !isQueryParam(key) {
+		if !isParam(key, name) {
 			continue
 		}
 		if found {
-			return nil, scratch, errDuplicateQuery
+			return "", false, duplicate
 		}
 		raw, found = val, true
 	}
-	if !found {
-		return nil, scratch, errNoQuery
-	}
+	return raw, found, nil
+}
 
+// urlDecode returns the percent-decoded raw, a view of it where there's
+// nothing to decode and appended to scratch otherwise.
+func urlDecode(scratch []byte, raw string) (value, newScratch []byte, err error) {
 	if !needsURLDecode(raw) {
 		return unsafeBytes(raw), scratch, nil
 	}
@@ -384,7 +175,10 @@ func mergeQuery(upstream, client string) string {
 // hasQueryParam reports whether rawQuery names the query parameter at all,
 // which makes a request reading its document elsewhere ambiguous.
 // It splits pairs the way [extractQueryParam] does, ';' included.
-func hasQueryParam(rawQuery string) bool {
+func hasQueryParam(rawQuery string) bool { return hasParam(rawQuery, "query") }
+
+// hasParam is [hasQueryParam] of the parameter name.
+func hasParam(rawQuery, name string) bool {
 	for i := 0; i < len(rawQuery); {
 		end := i
 		for end < len(rawQuery) && rawQuery[end] != '&' && rawQuery[end] != ';' {
@@ -397,20 +191,19 @@ func hasQueryParam(rawQuery string) bool {
 		if before, _, ok := strings.Cut(pair, "="); ok {
 			key = before
 		}
-		if isQueryParam(key) {
+		if isParam(key, name) {
 			return true
 		}
 	}
 	return false
 }
 
-// isQueryParam reports whether key names the query parameter, percent-decoded
+// isParam reports whether key names the parameter name, percent-decoded
 // first. [net/url.ParseQuery] decodes a name before matching, so quer%79 is the
 // query to the API behind this and has to be one here: read raw it would be
 // another parameter, carrying a document this never checked.
 // A broken escape is no name at all, as net/url reads it.
-func isQueryParam(key string) bool {
-	const name = "query"
+func isParam(key, name string) bool {
 	decoded := 0
 	for i := 0; i < len(key); i++ {
 		if decoded == len(name) {
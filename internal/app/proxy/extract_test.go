@@ -28,7 +28,7 @@ func TestExtractJSON(t *testing.T) {
 			t.Fatalf("expected %d documents; received %d", len(expect), len(spans))
 		}
 		for i, s := range spans {
-			if got := body[s.start:s.end]; got != expect[i] {
+			if got := body[s.Start:s.End]; got != expect[i] {
 				t.Errorf("document %d: expected %q; received %q", i, expect[i], got)
 			}
 		}
@@ -191,7 +191,7 @@ func TestExtractJSONEscapedQueryKey(t *testing.T) {
 				len(expect), len(spans), body)
 		}
 		for i, s := range spans {
-			if got := body[s.start:s.end]; got != expect[i] {
+			if got := body[s.Start:s.End]; got != expect[i] {
 				t.Errorf("document %d: expected %q; received %q", i, expect[i], got)
 			}
 		}
@@ -199,7 +199,7 @@ func TestExtractJSONEscapedQueryKey(t *testing.T) {
 
 	// The escaped spelling on its own is the document of the request.
 	// The escape unescapes to another case of the name, \u0051uery, the same way:
-	// isQueryKey takes every case of the plain spelling.
+	// isKey takes every case of the plain spelling.
 	f(t, nil, []string{"{a}"}, `{"quer\u0079":"{a}"}`)
 	f(t, nil, []string{"{a}"}, `{"\u0051uery":"{a}"}`)
 	f(t, nil, []string{"{a}"}, `{"qu\u0065ry":"{a}"}`)
@@ -252,6 +252,79 @@ func TestExtractJSONQueryCollision(t *testing.T) {
 	f(t, errQueryCollision, `[{"query":"{a}"},{"query":"{b}","queRY":"{c}"}]`, inBatch)
 }
 
+// TestExtractJSONOperationName covers the operationName member read into the
+// span of its document: before it or after it, in every element of a batch,
+// spelled as the query member may be, and refused named twice.
+func TestExtractJSONOperationName(t *testing.T) {
+	f := func(t *testing.T, expectErr error, expect []string, body string, maxBatch int) {
+		t.Helper()
+		spans, err := extractJSON(nil, []byte(body), maxBatch)
+		if !errors.Is(err, expectErr) {
+			t.Fatalf("expected err %v; received: %v; body: %s", expectErr, err, body)
+		}
+		if err != nil {
+			return
+		}
+		if len(spans) != len(expect) {
+			t.Fatalf("expected %d documents; received %d", len(expect), len(spans))
+		}
+		for i, s := range spans {
+			got := body[s.OperationStart:s.OperationEnd]
+			if got != expect[i] || s.HasOperation() != (expect[i] != "") {
+				t.Errorf("document %d: expected %q; received %q", i, expect[i], got)
+			}
+		}
+	}
+
+	f(t, nil, []string{"Q"}, `{"operationName":"Q","query":"{f}"}`, noBatch)
+	f(t, nil, []string{"Q"}, `{"query":"{f}","operationName":"Q"}`, noBatch)
+	f(t, nil, []string{"Q"}, `{"query":"{f}","operati\u006fnName":"Q"}`, noBatch)
+	f(t, nil, []string{"Q"}, `{"query":"{f}","OPERATIONNAME":"Q"}`, noBatch)
+	f(t, nil, []string{`Q\u0031`}, `{"query":"{f}","operationName":"Q\u0031"}`, noBatch)
+
+	// Null, an empty string or no string at all names no operation.
+	f(t, nil, []string{""}, `{"query":"{f}"}`, noBatch)
+	f(t, nil, []string{""}, `{"query":"{f}","operationName":null}`, noBatch)
+	f(t, nil, []string{""}, `{"query":"{f}","operationName":""}`, noBatch)
+	f(t, nil, []string{""}, `{"query":"{f}","operationName":1}`, noBatch)
+
+	// A member of another object names nothing.
+	f(t, nil, []string{""}, `{"query":"{f}","variables":{"operationName":"Q"}}`, noBatch)
+
+	// Each element of a batch names its own.
+	f(t, nil, []string{"A", "", "C"}, `[{"operationName":"A","query":"{a}"},`+
+		`{"query":"{b}"},{"query":"{c}","operationName":"C"}]`, inBatch)
+
+	f(t, errOperationNameCollision, nil,
+		`{"operationName":"A","query":"{f}","operationName":"B"}`, noBatch)
+	f(t, errOperationNameCollision, nil,
+		`{"query":"{f}","operationName":null,"operationname":"B"}`, noBatch)
+	f(t, errOperationNameCollision, nil,
+		`[{"query":"{a}"},{"query":"{b}","operationName":"B","operationName":"C"}]`, inBatch)
+}
+
+// TestExtractOperationParam covers the operationName query parameter: none and
+// an empty one name nothing, one is decoded, and two are refused.
+func TestExtractOperationParam(t *testing.T) {
+	f := func(t *testing.T, expectErr error, expect, rawQuery string) {
+		t.Helper()
+		value, _, err := extractOperationParam(make([]byte, 0, 64), rawQuery)
+		if !errors.Is(err, expectErr) {
+			t.Fatalf("expected err %v; received %v; query: %q", expectErr, err, rawQuery)
+		}
+		if err == nil && string(value) != expect {
+			t.Errorf("expected %q; received %q", expect, value)
+		}
+	}
+
+	f(t, nil, "", "query={f}")
+	f(t, nil, "", "query={f}&operationName=")
+	f(t, nil, "Q", "query={f}&operationName=Q")
+	f(t, nil, "Q", "operati%6fnName=%51;query={f}")
+	f(t, errDuplicateOperationName, "", "operationName=A&query={f}&operationName=B")
+	f(t, errInvalidEscape, "", "operationName=%5")
+}
+
 // TestExtractQueryParamEncodedName is the GET half of TestExtractJSONEscapedQueryKey.
 // extractQueryParam compares the raw parameter name, so quer%79 is no query to it,
 // while [net/url.ParseQuery] decodes the name first and puts both under query.
@@ -368,7 +441,7 @@ func TestExtractZeroCopy(t *testing.T) {
 		if err != nil || len(s) != 1 {
 			t.Fatal("extraction failed")
 		}
-		value, _, err := unescapeJSON(scratch, body[s[0].start:s[0].end])
+		value, _, err := unescapeJSON(scratch, body[s[0].Start:s[0].End])
 		if err != nil || len(value) == 0 {
 			t.Fatal("unescaping failed")
 		}
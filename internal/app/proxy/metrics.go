@@ -58,10 +58,13 @@ type metrics struct {
 	observers [decisionCount]prometheus.Observer
 }
 
-// newMetrics returns metrics over counters and the documents in use in list.
-// It takes the counters rather than the [proxy] holding them, so a proxy can
-// build its own: the two would otherwise each need the other first.
-func newMetrics(counters *counters, list *allowlist.Allowlist) *metrics {
+// newMetrics returns metrics over counters and the documents in use in list,
+// and over clients, which may be nil. It takes the counters rather than the
+// [proxy] holding them, so a proxy can build its own: the two would otherwise
+// each need the other first.
+func newMetrics(
+	counters *counters, list *allowlist.Allowlist, clients *clients,
+) *metrics {
 	m := &metrics{
 		registry: prometheus.NewRegistry(),
 		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
@@ -86,7 +89,7 @@ func newMetrics(counters *counters, list *allowlist.Allowlist) *metrics {
 
 	m.registry.MustRegister(
 		m.duration,
-		&proxyCollector{counters: counters, allowlist: list},
+		&proxyCollector{counters: counters, allowlist: list, clients: clients},
 		collectors.NewGoCollector(),
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
@@ -121,6 +124,7 @@ func (l *promLogger) Println(v ...any) {
 type proxyCollector struct {
 	counters  *counters
 	allowlist *allowlist.Allowlist
+	clients   *clients
 }
 
 var (
@@ -140,6 +144,39 @@ var (
 		"gqlhash_proxy_allowlist_loaded_timestamp_seconds",
 		"When the allowlist in use was loaded.",
 		nil, nil)
+	descHits = prometheus.NewDesc(
+		"gqlhash_proxy_allowlist_hits_total",
+		"Documents found on the allowlist, by the owner and client of their entry.",
+		[]string{"owner", "client"}, nil)
+	descSunset = prometheus.NewDesc(
+		"gqlhash_proxy_allowlist_sunset_total",
+		"Documents allowed in their sunset, the window before they expire, "+
+			"by the owner and client of their entry.",
+		[]string{"owner", "client"}, nil)
+	descClientRequests = prometheus.NewDesc(
+		"gqlhash_proxy_client_requests_total",
+		"Requests checked against the allowlist of a client, by the client and "+
+			"what the proxy decided. The client is empty for -allowlist.",
+		[]string{"client", "decision"}, nil)
+	descClientDocuments = prometheus.NewDesc(
+		"gqlhash_proxy_client_allowlist_documents",
+		"Documents on the allowlist of a client. The client is empty for -allowlist.",
+		[]string{"client"}, nil)
+	descRevision = prometheus.NewDesc(
+		"gqlhash_proxy_allowlist_revision_info",
+		"The revision an allowlist read from Git was read at, always 1: the ref "+
+			"and the commit it named. The client is empty for -allowlist.",
+		[]string{"client", "ref", "commit"}, nil)
+	descExpired = prometheus.NewDesc(
+		"gqlhash_proxy_allowlist_expired_total",
+		"Documents refused past their expiry, by the owner and client of their entry.",
+		[]string{"owner", "client"}, nil)
+	descSchemeHits = prometheus.NewDesc(
+		"gqlhash_proxy_allowlist_scheme_hits_total",
+		"Documents allowed by the scheme, hash:ignore, that found them: "+
+			"-hash and -ignore where they did, and otherwise an "+
+			"-allowlist.also-scheme.",
+		[]string{"scheme"}, nil)
 )
 
 func (c *proxyCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -147,6 +184,13 @@ func (c *proxyCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- descUpstreamErrors
 	ch <- descDocuments
 	ch <- descLoadedAt
+	ch <- descHits
+	ch <- descSunset
+	ch <- descExpired
+	ch <- descClientRequests
+	ch <- descClientDocuments
+	ch <- descRevision
+	ch <- descSchemeHits
 }
 
 func (c *proxyCollector) Collect(ch chan<- prometheus.Metric) {
@@ -165,6 +209,50 @@ func (c *proxyCollector) Collect(ch chan<- prometheus.Metric) {
 	count(made.methodBad, decisionMethodNotAllowed)
 	ch <- prometheus.MustNewConstMetric(descUpstreamErrors,
 		prometheus.CounterValue, float64(made.upstream))
+	// A series appears with the first hit of its labels, as an entry's
+	// metadata isn't known before a request finds it.
+	byEntry := func(desc *prometheus.Desc, h *hits) {
+		for _, c := range h.snapshot() {
+			ch <- prometheus.MustNewConstMetric(desc,
+				prometheus.CounterValue, float64(c.count), c.owner, c.client)
+		}
+	}
+	byEntry(descHits, &c.counters.hits)
+	byEntry(descSunset, &c.counters.sunset)
+	byEntry(descExpired, &c.counters.expired)
+
+	// Under -allowlist.also-scheme alone, every scheme from the start.
+	for i, name := range c.counters.schemes.names {
+		ch <- prometheus.MustNewConstMetric(descSchemeHits,
+			prometheus.CounterValue, float64(c.counters.schemes.counts[i].Load()), name)
+	}
+
+	if c.clients != nil {
+		for _, l := range c.clients.all() {
+			ch <- prometheus.MustNewConstMetric(descClientRequests,
+				prometheus.CounterValue, float64(l.allowed.Load()),
+				l.name, decisionAllowed.String())
+			ch <- prometheus.MustNewConstMetric(descClientRequests,
+				prometheus.CounterValue, float64(l.rejected.Load()),
+				l.name, decisionRejected.String())
+			ch <- prometheus.MustNewConstMetric(descClientDocuments,
+				prometheus.GaugeValue, float64(l.allowlist.Len()), l.name)
+		}
+	}
+
+	// Under -allowlist.git-ref alone, as an info metric: its labels are what
+	// it says, so a dashboard joins on it, and an alert fires where replicas
+	// disagree.
+	revisions := []*clientList{{allowlist: c.allowlist}}
+	if c.clients != nil {
+		revisions = c.clients.all()
+	}
+	for _, l := range revisions {
+		if ref, commit := l.allowlist.Revision(); commit != "" {
+			ch <- prometheus.MustNewConstMetric(descRevision,
+				prometheus.GaugeValue, 1, l.name, ref, commit)
+		}
+	}
 
 	// One call, so a reload between them can't pair one load's count with another's time.
 	documents, loadedAt := c.allowlist.Stats()
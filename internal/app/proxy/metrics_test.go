@@ -59,7 +59,7 @@ func TestPromLoggerPrintln(t *testing.T) {
 // allowlist would fire on it. Nothing is the honest answer until there's a load.
 func TestCollectBeforeFirstReload(t *testing.T) {
 	list := allowlist.New(sha256.New, gqlhash.Options{})
-	m := newMetrics(new(counters), list)
+	m := newMetrics(new(counters), list, nil)
 
 	rec := httptest.NewRecorder()
 	m.Handler(testLogger()).ServeHTTP(rec,
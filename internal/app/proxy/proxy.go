@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -45,6 +47,119 @@ type counters struct {
 	batchBig  paddedCounter
 	methodBad paddedCounter
 	upstream  paddedCounter
+
+	// hits are the documents found on the allowlist, sunset those of them in
+	// their sunset, and expired the documents refused past their expiry,
+	// see [allowlist.Status].
+	hits, sunset, expired hits
+
+	// schemes are the documents allowed by the scheme that found them,
+	// see -allowlist.also-scheme.
+	schemes schemeHits
+}
+
+// schemeHits counts the documents allowed by each scheme an allowlist is keyed
+// by, its own first, and then each of [allowlist.Config.Schemes]: traffic
+// moving from one to another is the count of one falling as the other's
+// rises. Empty where there's no scheme but the allowlist's own, which has
+// nothing to break down.
+type schemeHits struct {
+	names  []string
+	counts []paddedCounter
+}
+
+func newSchemeHits(own string, others []allowlist.Scheme) schemeHits {
+	if len(others) == 0 {
+		return schemeHits{}
+	}
+	names := []string{own}
+	for _, s := range others {
+		names = append(names, s.Name)
+	}
+	return schemeHits{names: names, counts: make([]paddedCounter, len(names))}
+}
+
+// add counts a document the i-th scheme found, 0 for the allowlist's own.
+func (s *schemeHits) add(i int) {
+	if i < len(s.counts) {
+		s.counts[i].Add(1)
+	}
+}
+
+// hits counts documents by the owner and the client their entry names, see
+// [allowlist.Entry]. Those are labels a team owns an alert on, as many as the
+// allowlist names, where a label per document would grow a series with every
+// file.
+type hits struct {
+	// slots are the counts by [allowlist.Entry.Label], nil for a label not
+	// counted yet. Replaced whole under mu where one is added: a slot is a
+	// pointer, so the copy loses no count made meanwhile.
+	slots atomic.Pointer[[]*hitSlot]
+	mu    sync.Mutex
+	// byPair is every slot by its owner and client. A label goes to another
+	// pair once no allowlist names its own, which keeps its slot here, so a
+	// pair named again counts on.
+	byPair map[[2]string]*hitSlot
+}
+
+// hitSlot is the count of the labels of one [allowlist.Entry.Label].
+type hitSlot struct {
+	paddedCounter
+	owner, client string
+}
+
+// add counts a hit of e. A label counted before for the pair of e takes one
+// atomic add, and no lock.
+func (h *hits) add(e *allowlist.Entry) {
+	if slots := h.slots.Load(); slots != nil && e.Label < len(*slots) {
+		if s := (*slots)[e.Label]; s != nil && s.owner == e.Owner && s.client == e.Client {
+			s.Add(1)
+			return
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var slots []*hitSlot
+	if p := h.slots.Load(); p != nil {
+		slots = *p
+	}
+	pair := [2]string{e.Owner, e.Client}
+	slot := h.byPair[pair]
+	if slot == nil {
+		slot = &hitSlot{owner: e.Owner, client: e.Client}
+		if h.byPair == nil {
+			h.byPair = make(map[[2]string]*hitSlot)
+		}
+		h.byPair[pair] = slot
+	}
+	if e.Label >= len(slots) || slots[e.Label] != slot {
+		grown := make([]*hitSlot, max(len(slots), e.Label+1))
+		copy(grown, slots)
+		grown[e.Label] = slot
+		slots = grown
+		h.slots.Store(&slots)
+	}
+	slot.Add(1)
+}
+
+// hitCount is the count of a pair of labels of [hits], read at one moment.
+type hitCount struct {
+	owner, client string
+	count         uint64
+}
+
+// snapshot is every count of h, by owner and then client.
+func (h *hits) snapshot() []hitCount {
+	h.mu.Lock()
+	counts := make([]hitCount, 0, len(h.byPair))
+	for _, s := range h.byPair {
+		counts = append(counts, hitCount{owner: s.owner, client: s.client, count: s.Load()})
+	}
+	h.mu.Unlock()
+	slices.SortFunc(counts, func(a, b hitCount) int {
+		return cmp.Or(strings.Compare(a.owner, b.owner), strings.Compare(a.client, b.client))
+	})
+	return counts
 }
 
 // paddedCounter is a counter on a cache line of its own.
@@ -85,13 +200,22 @@ func (c *counters) snapshot() decisions {
 // forwards it upstream or rejects it.
 type proxy struct {
 	allowlist *allowlist.Allowlist
-	upstream  *httputil.ReverseProxy
-	log       zerolog.Logger
-	counters  counters
+
+	// clients picks the allowlist of a request by its client, nil where
+	// allowlist is every request's, see -allowlist.client-header.
+	clients  *clients
+	upstream *httputil.ReverseProxy
+	log      zerolog.Logger
+	counters counters
 
 	options gqlhash.Options
 	maxBody int64
 
+	// schemes are what else the allowlist is keyed by, which a document it
+	// doesn't find by its own hash is hashed under in turn, see
+	// -allowlist.also-scheme.
+	schemes []allowlist.Scheme
+
 	// upstreamTimeout bounds a forward whole, see -upstream.timeout.
 	upstreamTimeout time.Duration
 
@@ -106,6 +230,11 @@ type proxy struct {
 	// debug is the level, read once at startup instead of per event.
 	debug bool
 
+	// sunsetLogged holds the name and the expiry of every entry found in its
+	// sunset that was warned of, so a client sending it is named once rather
+	// than per request. Cleared by every reload that publishes.
+	sunsetLogged *sync.Map
+
 	// metrics are always kept: every run has a control server exposing them,
 	// so the hot path pays the clock read either way and has nothing to decide.
 	metrics *metrics
@@ -154,15 +283,29 @@ type state struct {
 	// body holds the request body. The document is a subslice of it.
 	body []byte
 
-	// scratch takes the document only when it needs unescaping or decoding.
+	// scratch takes the document only when it needs unescaping or decoding,
+	// and name the same of the operationName beside it.
 	scratch []byte
+	name    []byte
 
 	sum []byte
 
+	// schemeHashes hash under each of [proxy.schemes], in its order.
+	schemeHashes []hash.Hash
+
 	spans  []span
 	hash   hash.Hash
 	parser *parser.Parser[[]byte]
 
+	// matched are the entries of the allowlist the documents of the request
+	// were found under, in its order, for the log of -log.requests.
+	matched []allowlist.Entry
+
+	// list is the allowlist the request is checked against, and client what
+	// it's counted under, nil without -allowlist.client-header.
+	list   *allowlist.Allowlist
+	client *clientList
+
 	// writer relays the answer, releasing the exchange bounds where that answer
 	// turns out to be an event stream. Inline, so forwarding allocates nothing.
 	writer streamWriter
@@ -196,6 +339,21 @@ type proxyConfig struct {
 
 	// upstreamTimeout is -upstream.timeout, which bounds a forward whole.
 	upstreamTimeout time.Duration
+
+	// clientHeader picks the allowlist of a request among clients,
+	// see -allowlist.client-header. Empty takes the allowlist for every request.
+	clientHeader string
+	clients      []*clientList
+
+	// scheme names what -hash and -ignore key the allowlist by, and schemes
+	// are what else it's keyed by, see [allowlist.Config.Schemes]. Every
+	// allowlist of the proxy is keyed alike.
+	scheme  string
+	schemes []allowlist.Scheme
+
+	// sunsetLogged is [proxy.sunsetLogged], which the allowlists clear as
+	// they're reloaded. Nil for one of the proxy's own.
+	sunsetLogged *sync.Map
 }
 
 func newProxy(
@@ -215,14 +373,21 @@ func newProxy(
 		trustForwarded: config.trustForwarded,
 		debug:          log.GetLevel() <= zerolog.DebugLevel,
 		newHash:        newHash,
+		schemes:        config.schemes,
+		sunsetLogged:   config.sunsetLogged,
 		draining:       make(chan struct{}),
 	}
+	if p.sunsetLogged == nil {
+		p.sunsetLogged = new(sync.Map)
+	}
+	p.counters.schemes = newSchemeHits(config.scheme, config.schemes)
 	// Built here rather than handed in: the metrics read this proxy's counters,
 	// so a caller would need each of the two before the other.
-	p.metrics = newMetrics(&p.counters, allowlist)
+	p.clients = newClients(config.clientHeader, config.clients, allowlist)
+	p.metrics = newMetrics(&p.counters, allowlist, p.clients)
 	p.logRequests = config.logRequests && p.debug
 	p.states.New = func() any {
-		return &state{
+		st := &state{
 			body:    make([]byte, 0, defaultBodyBuffer),
 			scratch: make([]byte, 0, defaultScratchBuffer),
 			sum:     make([]byte, 0, defaultSumBuffer),
@@ -230,6 +395,10 @@ func newProxy(
 			hash:    newHash(),
 			parser:  parser.NewParser[[]byte](0),
 		}
+		for _, s := range p.schemes {
+			st.schemeHashes = append(st.schemeHashes, s.NewHash())
+		}
+		return st
 	}
 	p.upstream = &httputil.ReverseProxy{
 		// Without a pool ReverseProxy allocates 32KiB per answer,
@@ -381,6 +550,7 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	case !allowed:
 		p.counters.rejected.Add(1)
+		st.client.count(false)
 		// Debug and behind a condition: a rejection is the path a flood takes,
 		// so one event each is log volume the caller controls.
 		// [counters] carry the totals.
@@ -397,8 +567,9 @@ func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	p.counters.allowed.Add(1)
+	st.client.count(true)
 	if p.logRequests {
-		p.log.Debug().Str("remote", r.RemoteAddr).Msg("forwarding")
+		p.logForwarding(r.RemoteAddr, st.matched)
 	}
 
 	// The body was read to find the document, so the same bytes go upstream.
@@ -468,12 +639,19 @@ func (st *state) release() {
 	if cap(st.scratch) > maxRetainedBuffer {
 		st.scratch = make([]byte, 0, defaultScratchBuffer)
 	}
+	if cap(st.name) > maxRetainedBuffer {
+		st.name = nil
+	}
 	if cap(st.spans) > maxRetainedSpans {
 		st.spans = make([]span, 0, defaultSpans)
 	}
 	// Drops the finished request's connection, which the pool has no business
-	// holding until the state is taken again.
+	// holding until the state is taken again, and the entries, which would
+	// keep an allowlist reloaded since from being collected.
 	st.writer = streamWriter{}
+	clear(st.matched)
+	st.matched = st.matched[:0]
+	st.list, st.client = nil, nil
 }
 
 var errTooLarge = errors.New("request body too large")
@@ -487,6 +665,8 @@ var errTooDeep = errors.New("operation not allowed")
 func isAmbiguous(err error) bool {
 	return errors.Is(err, errQueryCollision) ||
 		errors.Is(err, errDuplicateQuery) ||
+		errors.Is(err, errOperationNameCollision) ||
+		errors.Is(err, errDuplicateOperationName) ||
 		errors.Is(err, errBodyOnGET) ||
 		errors.Is(err, errQueryBesideBody)
 }
@@ -524,6 +704,7 @@ func (p *proxy) check(st *state, r *http.Request) (allowed bool, err error) {
 		}
 		return p.decide(st, Request{
 			Method: MethodGET, RawQuery: r.URL.RawQuery, HasBody: len(st.body) > 0,
+			Client: p.clientOf(r),
 		})
 	}
 	if err = p.readBody(st, r); err != nil {
@@ -534,9 +715,18 @@ func (p *proxy) check(st *state, r *http.Request) (allowed bool, err error) {
 		RawQuery:       r.URL.RawQuery,
 		BodyIsDocument: isGraphQLContentType(r.Header.Get("Content-Type")),
 		Body:           st.body,
+		Client:         p.clientOf(r),
 	})
 }
 
+// clientOf is the client r names, see [Request.Client].
+func (p *proxy) clientOf(r *http.Request) []byte {
+	if p.clients == nil {
+		return nil
+	}
+	return unsafeBytes(r.Header.Get(p.clients.header))
+}
+
 // decide is the whole decision and the only copy of it. It takes what a request
 // carries rather than a request, so every implementation reaches the same answer.
 //
@@ -545,6 +735,12 @@ func (p *proxy) check(st *state, r *http.Request) (allowed bool, err error) {
 // and the allowlist is looked up by that subslice.
 func (p *proxy) decide(st *state, req Request) (allowed bool, err error) {
 	var value []byte
+	st.matched = st.matched[:0]
+	st.list, st.client = p.allowlist, nil
+	if p.clients != nil {
+		st.client = p.clients.pick(req.Client)
+		st.list = st.client.allowlist
+	}
 
 	switch req.Method {
 	case MethodGET, MethodPOST:
@@ -566,7 +762,12 @@ func (p *proxy) decide(st *state, req Request) (allowed bool, err error) {
 		if err != nil {
 			return false, err
 		}
-		return p.allow(st, value)
+		var operation []byte
+		operation, st.name, err = extractOperationParam(st.name, req.RawQuery)
+		if err != nil {
+			return false, err
+		}
+		return p.allow(st, value, operation)
 	}
 
 	// The document is the body from here on, so a query parameter is a second
@@ -577,9 +778,15 @@ func (p *proxy) decide(st *state, req Request) (allowed bool, err error) {
 	}
 
 	if req.BodyIsDocument {
-		return p.allow(st, req.Body)
+		// The body is the document alone, so the query string is where its
+		// operation is named.
+		var operation []byte
+		operation, st.name, err = extractOperationParam(st.name, req.RawQuery)
+		if err != nil {
+			return false, err
+		}
+		return p.allow(st, req.Body, operation)
 	}
-
 	docs, err := extractJSON(st.spans[:0], req.Body, p.maxBatch)
 	// Kept whatever happened, so the room extractJSON grew for a batch is there
 	// for the next request. docs shares the array and keeps its length.
@@ -588,21 +795,30 @@ func (p *proxy) decide(st *state, req Request) (allowed bool, err error) {
 		return false, err
 	}
 	for _, s := range docs {
-		value, st.scratch, err = unescapeJSON(st.scratch, req.Body[s.start:s.end])
+		value, st.scratch, err = unescapeJSON(st.scratch, req.Body[s.Start:s.End])
 		if err != nil {
 			return false, err
 		}
-		if allowed, err := p.allow(st, value); err != nil || !allowed {
+		var operation []byte
+		if s.HasOperation() {
+			operation, st.name, err = unescapeJSON(st.name,
+				req.Body[s.OperationStart:s.OperationEnd])
+			if err != nil {
+				return false, err
+			}
+		}
+		if allowed, err := p.allow(st, value, operation); err != nil || !allowed {
 			return false, err
 		}
 	}
 	return true, nil
 }
 
-// allow reports whether document is on the allowlist.
+// allow reports whether document is on the allowlist and may run operation,
+// the operation the request names, empty for none, see [allowlist.Entry.Runs].
 // A document that doesn't parse isn't.
-func (p *proxy) allow(st *state, document []byte) (allowed bool, err error) {
-	if p.allowlist.Len() == 0 {
+func (p *proxy) allow(st *state, document, operation []byte) (allowed bool, err error) {
+	if st.list.Len() == 0 {
 		return false, nil
 	}
 
@@ -618,7 +834,106 @@ func (p *proxy) allow(st *state, document []byte) (allowed bool, err error) {
 	}
 	key := st.hash.Sum(st.sum[:0])
 	st.sum = key
-	return p.allowlist.Allowed(key), nil
+	e, status := st.list.Find(key)
+	// What the allowlist's own hash doesn't find is hashed under the others in
+	// turn, which costs a request on its way over a hash of each.
+	scheme := 0
+	for i := 0; status == allowlist.StatusUnknown && i < len(p.schemes); i++ {
+		e, status = p.checkScheme(st, i, document)
+		scheme = i + 1
+	}
+	switch {
+	case status == allowlist.StatusUnknown:
+		return false, nil
+	case !e.Runs(operation):
+		// An operation nobody listed, or none named of a document of several,
+		// which leaves the API to pick: the document is listed, what would run
+		// of it isn't.
+		if p.debug {
+			p.log.Debug().Str("file", e.Name).Str("operation", string(operation)).
+				Msg("the operation is not on the allowlist")
+		}
+		return false, nil
+	}
+	switch status {
+	case allowlist.StatusExpired:
+		p.counters.expired.add(&e)
+		if p.debug {
+			p.log.Debug().Str("file", e.Name).Time("expires", e.Expires).
+				Msg("the document has expired")
+		}
+		return false, nil
+	case allowlist.StatusSunset:
+		p.counters.sunset.add(&e)
+		p.warnSunset(&e)
+	}
+	p.counters.hits.add(&e)
+	p.counters.schemes.add(scheme)
+	st.matched = append(st.matched, e)
+	return true, nil
+}
+
+// checkScheme is what st.list makes of document hashed under the i-th of
+// p.schemes. A document that doesn't hash under it isn't found by it.
+func (p *proxy) checkScheme(
+	st *state, i int, document []byte,
+) (allowlist.Entry, allowlist.Status) {
+	h := st.schemeHashes[i]
+	h.Reset()
+	if st.parser.Parse(h, p.schemes[i].Options, document).IsErr() {
+		return allowlist.Entry{}, allowlist.StatusUnknown
+	}
+	key := h.Sum(st.sum[:0])
+	st.sum = key
+	return st.list.FindScheme(i, key)
+}
+
+// sunsetKey is what [proxy.sunsetLogged] holds of an entry: a document whose
+// expiry moved is warned of again.
+type sunsetKey struct {
+	name    string
+	expires int64
+}
+
+// warnSunset logs that e was found in its sunset, the first time it is: the
+// clients still sending it break when it expires, and a warning per request
+// would be a flood the sunset metric counts anyway.
+func (p *proxy) warnSunset(e *allowlist.Entry) {
+	key := sunsetKey{name: e.Name, expires: e.Expires.UnixNano()}
+	if _, logged := p.sunsetLogged.LoadOrStore(key, struct{}{}); logged {
+		return
+	}
+	event := p.log.Warn().Str("file", e.Name).Time("expires", e.Expires)
+	if e.Owner != "" {
+		event.Str("owner", e.Owner)
+	}
+	if e.Client != "" {
+		event.Str("client", e.Client)
+	}
+	event.Msg("a document in its sunset is still requested")
+}
+
+// logForwarding logs the request of remote being forwarded, under
+// -log.requests, with the entries its documents were found under.
+func (p *proxy) logForwarding(remote string, matched []allowlist.Entry) {
+	documents := zerolog.Arr()
+	for _, e := range matched {
+		d := zerolog.Dict().Str("file", e.Name)
+		if len(e.Operations) > 0 {
+			d.Strs("operations", e.Operations)
+		}
+		if e.Owner != "" {
+			d.Str("owner", e.Owner)
+		}
+		if e.Client != "" {
+			d.Str("client", e.Client)
+		}
+		if !e.Expires.IsZero() {
+			d.Time("expires", e.Expires)
+		}
+		documents.Dict(d)
+	}
+	p.log.Debug().Str("remote", remote).Array("documents", documents).Msg("forwarding")
 }
 
 func (p *proxy) readBody(st *state, r *http.Request) error {
@@ -3,16 +3,29 @@ package proxy
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+	"github.com/romshark/gqlhash/v2/internal/app/hasher"
 )
 
 // testProxy returns a proxy allowing the given documents and forwarding to an
@@ -170,6 +183,326 @@ func TestProxyLogsDecisions(t *testing.T) {
 	}
 }
 
+// TestProxyAllowlistHits covers the metadata of an entry where an operator
+// reads it: the hits by owner and client in the metrics and /status, and the
+// entry a forward matched in its log.
+func TestProxyAllowlistHits(t *testing.T) {
+	logs := new(syncBuffer)
+	p, _ := testProxyWith(t, func(p *proxy) {
+		p.log = zerolog.New(logs).Level(zerolog.DebugLevel)
+		p.logRequests = true
+	},
+		"# gqlhash: owner: payments\n# gqlhash: client: ios\n"+
+			"# gqlhash: expires: 2126-06-30\nquery Checkout { a }",
+		"{ b }")
+
+	checkout := "query Checkout { a }"
+	for _, query := range []string{checkout, checkout, "{ b }"} {
+		w := do(t, p, postJSON(`{"query":`+strconv.Quote(query)+`}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %q to be allowed; %d: %s", query, w.Code, w.Body)
+		}
+	}
+	if w := do(t, p, postJSON(`{"query":"{ evil }"}`)); w.Code != http.StatusForbidden {
+		t.Fatalf("expected the rejection; %d: %s", w.Code, w.Body)
+	}
+
+	for _, want := range []string{
+		`"operations":["Checkout"]`, `"owner":"payments"`, `"client":"ios"`,
+		`"expires":"2126-06-30T00:00:00Z"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected %s in the log; received %s", want, logs.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	p.metrics.Handler(testLogger()).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`gqlhash_proxy_allowlist_hits_total{client="",owner=""} 1`,
+		`gqlhash_proxy_allowlist_hits_total{client="ios",owner="payments"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected %s in the metrics; received %s", want, rec.Body)
+		}
+	}
+
+	mux := http.NewServeMux()
+	(&control{allowlist: p.allowlist, proxy: p, log: testLogger()}).routes(mux)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	want := `"hits":[{"owner":"","client":"","count":1},` +
+		`{"owner":"payments","client":"ios","count":2}],`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("expected %s in the status; received %s", want, rec.Body)
+	}
+}
+
+// TestHitsLabelReused covers a label a reload gave to another pair: each pair
+// counts apart, and the first counts on where it's named again.
+func TestHitsLabelReused(t *testing.T) {
+	var h hits
+	h.add(&allowlist.Entry{Owner: "a", Label: 1})
+	h.add(&allowlist.Entry{Owner: "b", Label: 1})
+	h.add(&allowlist.Entry{Owner: "b", Label: 1})
+	h.add(&allowlist.Entry{Owner: "a", Label: 2})
+	expect := []hitCount{{owner: "a", count: 2}, {owner: "b", count: 2}}
+	if received := h.snapshot(); !slices.Equal(received, expect) {
+		t.Errorf("expected %+v; received %+v", expect, received)
+	}
+}
+
+// TestProxyExpiry covers a document in its sunset, forwarded, counted and
+// warned of once until a reload moves its expiry, and one past its expiry,
+// refused and counted apart.
+func TestProxyExpiry(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "sunset.graphql",
+		"# gqlhash: owner: payments\n# gqlhash: expires: 2030-01-10\n{ sunset }")
+	writeDoc(t, dir, "expired.graphql",
+		"# gqlhash: client: ios\n# gqlhash: expires: 2030-01-01\n{ expired }")
+
+	logs := new(syncBuffer)
+	p, spy := testProxyWith(t, func(p *proxy) {
+		p.log = zerolog.New(logs).Level(zerolog.InfoLevel)
+		p.allowlist = allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+			allowlist.Config{
+				SunsetWindow: 30 * 24 * time.Hour,
+				Now: func() time.Time {
+					return time.Date(2030, 1, 5, 0, 0, 0, 0, time.UTC)
+				},
+				Published: p.sunsetLogged.Clear,
+			})
+		if _, err := p.allowlist.Reload(dir); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for range 2 {
+		if w := do(t, p, postJSON(`{"query":"{ sunset }"}`)); w.Code != http.StatusOK {
+			t.Fatalf("expected the document in its sunset allowed; %d: %s", w.Code, w.Body)
+		}
+	}
+	if w := do(t, p, postJSON(`{"query":"{ expired }"}`)); w.Code != http.StatusForbidden {
+		t.Fatalf("expected the expired document refused; %d: %s", w.Code, w.Body)
+	}
+	if spy.requests != 2 {
+		t.Errorf("expected 2 requests forwarded; received %d", spy.requests)
+	}
+	if d := p.snapshot(); d.allowed != 2 || d.rejected != 1 {
+		t.Errorf("expected 2 allowed and 1 rejected; received %+v", d)
+	}
+
+	// Warned of once, however often it's requested.
+	if n := strings.Count(logs.String(), "a document in its sunset"); n != 1 {
+		t.Errorf("expected one warning; received %d: %s", n, logs.String())
+	}
+
+	rec := httptest.NewRecorder()
+	p.metrics.Handler(testLogger()).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`gqlhash_proxy_allowlist_sunset_total{client="",owner="payments"} 2`,
+		`gqlhash_proxy_allowlist_expired_total{client="ios",owner=""} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("expected %s in the metrics; received %s", want, rec.Body)
+		}
+	}
+
+	mux := http.NewServeMux()
+	(&control{allowlist: p.allowlist, proxy: p, log: testLogger()}).routes(mux)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	want := `"sunset":[{"owner":"payments","client":"","count":2}],` +
+		`"expired":[{"owner":"","client":"ios","count":1}]}`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("expected %s in the status; received %s", want, rec.Body)
+	}
+
+	// Its expiry moved, it's warned of again.
+	writeDoc(t, dir, "sunset.graphql",
+		"# gqlhash: owner: payments\n# gqlhash: expires: 2030-01-20\n{ sunset }")
+	if _, err := p.allowlist.Reload(dir); err != nil {
+		t.Fatal(err)
+	}
+	_ = do(t, p, postJSON(`{"query":"{ sunset }"}`))
+	if n := strings.Count(logs.String(), "a document in its sunset"); n != 2 {
+		t.Errorf("expected a warning after the reload; received %d: %s", n, logs.String())
+	}
+}
+
+// TestProxyOperationName covers the operation a request names: one of those its
+// document holds, whichever way it's sent, and none where it holds several.
+func TestProxyOperationName(t *testing.T) {
+	const doc = "query A { a } query B { b }"
+	p, spy := testProxyWith(t, func(p *proxy) { p.maxBatch = 2 }, doc)
+
+	get := func(query string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/graphql?"+query, nil)
+	}
+	postGraphQL := func(query string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/graphql?"+query,
+			strings.NewReader(doc))
+		r.Header.Set("Content-Type", "application/graphql")
+		return r
+	}
+	for _, td := range []struct {
+		name   string
+		r      *http.Request
+		expect int
+	}{
+		{"named", postJSON(`{"query":"` + doc + `","operationName":"B"}`),
+			http.StatusOK},
+		{"named first", postJSON(`{"operationName":"A","query":"` + doc + `"}`),
+			http.StatusOK},
+		{"unknown name", postJSON(`{"query":"` + doc + `","operationName":"C"}`),
+			http.StatusForbidden},
+		{"unnamed", postJSON(`{"query":"` + doc + `"}`), http.StatusForbidden},
+		{"null name", postJSON(`{"query":"` + doc + `","operationName":null}`),
+			http.StatusForbidden},
+		{"operation alone", postJSON(`{"query":"query B { b }"}`), http.StatusOK},
+		{"operation alone named", postJSON(`{"query":"query B { b }",` +
+			`"operationName":"B"}`), http.StatusOK},
+		{"operation alone misnamed", postJSON(`{"query":"query B { b }",` +
+			`"operationName":"A"}`), http.StatusForbidden},
+		{"in batch", postJSON(`[{"query":"` + doc + `","operationName":"A"},` +
+			`{"query":"` + doc + `","operationName":"B"}]`), http.StatusOK},
+		{"in batch unnamed", postJSON(`[{"query":"` + doc + `","operationName":"A"},` +
+			`{"query":"` + doc + `"}]`), http.StatusForbidden},
+		{"named twice", postJSON(`{"query":"` + doc + `","operationName":"A",` +
+			`"operationName":"B"}`), http.StatusBadRequest},
+		// The body of a JSON request names its operation, and a parameter
+		// beside it is no part of what's checked.
+		{"parameter beside a JSON body",
+			func() *http.Request {
+				r := postJSON(`{"query":"` + doc + `","operationName":"B"}`)
+				r.URL.RawQuery = "operationName=C"
+				return r
+			}(), http.StatusOK},
+		{"parameter beside an unnamed JSON body",
+			func() *http.Request {
+				r := postJSON(`{"query":"` + doc + `"}`)
+				r.URL.RawQuery = "operationName=A"
+				return r
+			}(), http.StatusForbidden},
+		{"GET", get("query=" + url.QueryEscape(doc) + "&operationName=B"),
+			http.StatusOK},
+		{"GET unnamed", get("query=" + url.QueryEscape(doc)), http.StatusForbidden},
+		{"GET named twice", get("query=" + url.QueryEscape(doc) +
+			"&operationName=A&operationName=B"), http.StatusBadRequest},
+		{"application/graphql", postGraphQL("operationName=A"), http.StatusOK},
+		{"application/graphql unnamed", postGraphQL(""), http.StatusForbidden},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			before := spy.requests
+			w := do(t, p, td.r)
+			if w.Code != td.expect {
+				t.Fatalf("expected %d; received %d: %s", td.expect, w.Code, w.Body)
+			}
+			forwarded := spy.requests != before
+			if forwarded != (td.expect == http.StatusOK) {
+				t.Errorf("expected forwarded: %t", !forwarded)
+			}
+		})
+	}
+}
+
+// TestProxyHashOnly covers an entry a manifest gives as a hash alone: the
+// document hashing to it is allowed, whatever operation a request names.
+func TestProxyHashOnly(t *testing.T) {
+	const doc = "query A { a } query B { b }"
+	sum, err := gqlhash.AppendHash(nil, sha256.New(), gqlhash.Options{}, doc)
+	if err.IsErr() {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeDoc(t, dir, "q.jsonl", `{"id":"`+hex.EncodeToString(sum)+`"}`+"\n")
+	p, spy := testProxyWith(t, func(p *proxy) {
+		p.allowlist = allowlist.NewWithConfig(sha256.New, gqlhash.Options{},
+			allowlist.Config{Manifests: true})
+		if _, err := p.allowlist.Reload(dir); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, body := range []string{
+		`{"query":"` + doc + `","operationName":"B"}`,
+		`{"query":"` + doc + `"}`,
+	} {
+		if w := do(t, p, postJSON(body)); w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200; received %d: %s", body, w.Code, w.Body)
+		}
+	}
+	if w := do(t, p, postJSON(`{"query":"query A { a }"}`)); w.Code != http.StatusForbidden {
+		t.Errorf("expected another document refused; received %d: %s", w.Code, w.Body)
+	}
+	if spy.requests != 2 {
+		t.Errorf("expected 2 requests forwarded; received %d", spy.requests)
+	}
+}
+
+// TestProxyGenGo covers what gen-go generates of the files of an allowlist:
+// each operation a client sends as gen-go generates it is allowed, under the
+// hash gen-go generates for it, several to a file and described or not.
+func TestProxyGenGo(t *testing.T) {
+	documents := []string{
+		"\"Who is who\"\nquery GetUser { user { ...U } }\n" +
+			"\"The posts\"\nquery GetPosts { posts { title } }\n" +
+			"fragment U on User { name }\n",
+		"mutation Save { save }\n",
+	}
+	p, _ := testProxy(t, documents...)
+
+	dir := t.TempDir()
+	for i, d := range documents {
+		writeDoc(t, dir, string(rune('a'+i))+".graphql", d)
+	}
+	out := filepath.Join(dir, "queries_gen.go")
+	var stderr strings.Builder
+	if code := hasher.Run("gqlhash", "dev", []string{"gqlhash", "gen-go",
+		"-dir", dir, "-package", "queries", "-out", out, "-format", "hex"},
+		io.Discard, &stderr, nil); code != 0 {
+		t.Fatalf("expected gen-go to succeed; received %d: %s", code, stderr.String())
+	}
+	generated := constantsOf(t, out)
+
+	for _, name := range []string{"GetUser", "GetPosts", "Save"} {
+		document, hash := generated[name+"Document"], generated[name+"Hash"]
+		sum, errHash := gqlhash.AppendHash(nil, sha256.New(), gqlhash.Options{}, document)
+		if errHash.IsErr() || hex.EncodeToString(sum) != hash {
+			t.Errorf("%s: expected the hash the proxy computes; received %q", name, hash)
+		}
+		body, _ := json.Marshal(map[string]string{"query": document, "operationName": name})
+		if w := do(t, p, postJSON(string(body))); w.Code != http.StatusOK {
+			t.Errorf("%s: expected it allowed; received %d: %s", name, w.Code, w.Body)
+		}
+	}
+}
+
+// constantsOf is every string constant of the Go file name, by name.
+func constantsOf(t *testing.T, name string) map[string]string {
+	t.Helper()
+	f, err := goparser.ParseFile(token.NewFileSet(), name, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constants := map[string]string{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, ident := range spec.Names {
+			if lit, ok := spec.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				constants[ident.Name], _ = strconv.Unquote(lit.Value)
+			}
+		}
+		return false
+	})
+	return constants
+}
+
 // bodyReader is a request body that can be rewound without allocating,
 // so a benchmark or an allocation count measures the checking path alone.
 type bodyReader struct{ *strings.Reader }
@@ -179,7 +512,8 @@ func (bodyReader) Close() error { return nil }
 // TestProxyCheckZeroAlloc asserts that deciding on a request allocates nothing.
 // Everything the decision needs comes out of the pooled state.
 func TestProxyCheckZeroAlloc(t *testing.T) {
-	p, _ := testProxy(t, "{ user(id: 1) { name email } }")
+	p, _ := testProxy(t, "{ user(id: 1) { name email } }",
+		"query Q { user(id: 1) { name email } }")
 	st := p.states.Get().(*state)
 	defer p.states.Put(st)
 
@@ -206,20 +540,43 @@ func TestProxyCheckZeroAlloc(t *testing.T) {
 	}
 
 	f(t, "plain", `{"operationName":"Q",`+
-		`"query":"{ user(id: 1) { name email } }","variables":{"a":1}}`)
+		`"query":"query Q { user(id: 1) { name email } }","variables":{"a":1}}`)
 	// A document with escape sequences takes the scratch buffer of the state.
 	f(t, "escaped", `{"query":"{\n  user(id: 1) { name email }\n}"}`)
+
+	// A client naming its allowlist in a header, as the bytes the fasthttp
+	// server peeks at it.
+	iosDir := t.TempDir()
+	writeDoc(t, iosDir, "ios.graphql", "{ ios }")
+	p.clients = newClients("Apollographql-Client-Name", []*clientList{
+		{name: "ios", dir: iosDir, allowlist: newAllowlist(t, iosDir)},
+	}, p.allowlist)
+	req := Request{
+		Method: MethodPOST, Body: []byte(`{"query":"{ ios }"}`), Client: []byte("ios"),
+	}
+	run := func() {
+		if allowed, err := p.decide(st, req); err != nil || !allowed {
+			t.Fatalf("client: expected the document to be allowed: %v", err)
+		}
+	}
+	for range 3 {
+		run()
+	}
+	if n := testing.AllocsPerRun(200, run); n != 0 {
+		t.Errorf("client: expected no allocations; received %v", n)
+	}
 }
 
 // BenchmarkProxyCheck measures the decision alone, without the HTTP machinery.
 func BenchmarkProxyCheck(b *testing.B) {
-	p, _ := testProxy(&testing.T{}, "{ user(id: 1) { name email } }")
+	p, _ := testProxy(&testing.T{}, "{ user(id: 1) { name email } }",
+		"query Q { user(id: 1) { name email } }")
 	st := p.states.Get().(*state)
 	defer p.states.Put(st)
 
 	for _, c := range []struct{ name, body string }{
 		{"plain", `{"operationName":"Q",` +
-			`"query":"{ user(id: 1) { name email } }","variables":{"a":1}}`},
+			`"query":"query Q { user(id: 1) { name email } }","variables":{"a":1}}`},
 		{"escaped", `{"query":"{\n  user(id: 1) { name email }\n}"}`},
 		{"rejected", `{"query":"{ user(id: 1) { name secret } }"}`},
 	} {
@@ -432,3 +789,68 @@ func TestProxyReleasesOversizedBuffers(t *testing.T) {
 			maxRetainedBuffer, cap(pooled.body))
 	}
 }
+
+// TestProxySchemes covers -allowlist.also-scheme: a document the allowlist's
+// own hash doesn't find is allowed where another scheme does, and every
+// document allowed is counted by the scheme that found it, in /status and the
+// metrics.
+func TestProxySchemes(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "query A { a(x: 1) }")
+	spy := new(upstreamSpy)
+	upstream := httptest.NewServer(spy)
+	defer upstream.Close()
+
+	cfg := config.Proxy{
+		AllowlistDir: dir, HashFunc: config.HashFunctionSHA2,
+		AllowlistSchemes: []config.ProxyScheme{
+			{HashFunc: config.HashFunctionSHA3, Ignore: gqlhash.IgnoreInputs},
+		},
+		Upstream: config.ProxyUpstream{URL: mustURL(t, upstream.URL+"/graphql")},
+		Control:  config.ProxyControl{Address: "127.0.0.1:0"},
+		Server:   config.ProxyServer{MaxBody: 1 << 20},
+	}
+	c, err := build(cfg, testLogger(), ServerImpl{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for document, expect := range map[string]int{
+		"query A { a(x: 1) }": http.StatusOK,
+		// Its inputs differ, which sha3:inputs leaves out.
+		"query A { a(x: 2) }": http.StatusOK,
+		"query B { a(x: 1) }": http.StatusForbidden,
+	} {
+		w := do(t, c.proxy, postJSON(`{"query":`+strconv.Quote(document)+`}`))
+		if w.Code != expect {
+			t.Errorf("%s: expected %d; received %d: %s", document, expect, w.Code, w.Body)
+		}
+	}
+
+	get := func(target string) string {
+		rec := httptest.NewRecorder()
+		c.control.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Body.String()
+	}
+	status := get("/status")
+	expect := `"schemes":[` +
+		`{"scheme":"sha2:nothing","count":1},{"scheme":"sha3:inputs","count":1}]`
+	if !strings.Contains(status, expect) {
+		t.Errorf("expected %s; received %s", expect, status)
+	}
+	metrics := get("/metrics")
+	for _, expect := range []string{
+		`gqlhash_proxy_allowlist_scheme_hits_total{scheme="sha2:nothing"} 1`,
+		`gqlhash_proxy_allowlist_scheme_hits_total{scheme="sha3:inputs"} 1`,
+	} {
+		if !strings.Contains(metrics, expect) {
+			t.Errorf("expected %s; received %s", expect, metrics)
+		}
+	}
+
+	// Without another scheme there's nothing to break down.
+	p, _ := testProxy(t, "{ a }")
+	do(t, p, postJSON(`{"query":"{ a }"}`))
+	if len(p.counters.schemes.names) != 0 {
+		t.Errorf("expected no scheme counted; received %v", p.counters.schemes.names)
+	}
+}
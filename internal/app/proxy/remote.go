@@ -0,0 +1,370 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+)
+
+const (
+	// fetchTimeout bounds a fetch, the signature of a bundle included.
+	fetchTimeout = time.Minute
+
+	// maxFetched is the most a fetch reads. A snapshot of millions of
+	// documents is some hundreds of megabytes, and a server answering
+	// without end isn't read until memory runs out.
+	maxFetched = 1 << 30
+)
+
+// remote is an allowlist fetched over HTTP, -allowlist or the allowlist of a
+// client being a URL, and what its last fetch found.
+//
+// What it fetched last is kept, so a server answering 304 Not Modified to
+// If-None-Match is read as having answered it again, and a fetch that fails
+// leaves the allowlist serving what it did.
+type remote struct {
+	// client is the client the allowlist is for, empty for -allowlist.
+	client    string
+	url       *url.URL
+	allowlist *allowlist.Allowlist
+	http      *http.Client
+
+	// name is the URL without its query and user, which may carry a secret:
+	// what the documents, the log and /status name it by.
+	name string
+
+	// signed fetches the signature beside a bundle, see -allowlist.bundle-key.
+	signed bool
+
+	// mu serializes the fetches and guards fetched, loaded and etag.
+	mu sync.Mutex
+
+	// fetched is what the last fetch that succeeded found, by etag where
+	// the server named one, and loaded what was last reloaded from. A poll
+	// reloads where the two differ.
+	fetched, loaded allowlist.Fetched
+	etag            string
+
+	// stateMu guards what follows apart from mu, so /status and the metrics
+	// read it while a fetch is under way.
+	stateMu sync.Mutex
+
+	// attemptedAt is when the last fetch began, and err why it failed, nil
+	// where it didn't. succeededAt is when the last one that didn't began,
+	// the zero time before one did.
+	attemptedAt, succeededAt time.Time
+	err                      error
+}
+
+// newRemote returns the remote allowlist list fetched from rawURL, which
+// [config.IsAllowlistURL] takes. It fetches nothing yet.
+func newRemote(client, rawURL string, list *allowlist.Allowlist, keys bool) (*remote, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	named := *u
+	named.User, named.RawQuery, named.Fragment = nil, "", ""
+	return &remote{
+		client: client, url: u, allowlist: list, name: named.String(),
+		http:   &http.Client{Timeout: fetchTimeout},
+		signed: keys && allowlist.IsBundle(u.Path),
+	}, nil
+}
+
+// allowlistName is what dir is named by in a log: a URL without its query
+// and user, see [remote], and anything else as it is.
+func allowlistName(dir string) string {
+	if !config.IsAllowlistURL(dir) {
+		return dir
+	}
+	u, err := url.Parse(dir)
+	if err != nil {
+		return dir
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
+
+// reload fetches the allowlist and reloads it from what was fetched, whether
+// it changed or not, as a POST /reload asks. A dry run publishes nothing, and
+// leaves a change for the next poll to reload.
+func (r *remote) reload(ctx context.Context, dryRun bool) (allowlist.Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.fetch(ctx); err != nil {
+		return allowlist.Result{}, err
+	}
+	if dryRun {
+		return r.allowlist.CheckFetched(r.fetched)
+	}
+	r.loaded = r.fetched
+	return r.allowlist.ReloadFetched(r.fetched)
+}
+
+// poll fetches the allowlist and reloads it where it changed, logging what
+// happened. A fetch that fails is logged and keeps what the allowlist held,
+// as a reload that fails or is refused does, and the next poll tries again.
+func (r *remote) poll(ctx context.Context, log zerolog.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client != "" {
+		log = log.With().Str("client", r.client).Logger()
+	}
+	if err := r.fetch(ctx); err != nil {
+		log.Error().Err(err).Str("url", r.name).
+			Msg("fetching the allowlist, serving the one before")
+		return
+	}
+	if sameFetched(r.fetched, r.loaded) {
+		return
+	}
+	log.Info().Str("url", r.name).Msg("the allowlist changed, reloading it")
+	// A reload that fails isn't tried again until the allowlist changes again:
+	// the same bytes fail the same way.
+	r.loaded = r.fetched
+	result, err := r.allowlist.ReloadFetched(r.fetched)
+	switch {
+	case errors.Is(err, allowlist.ErrRefused):
+		logReload(log, r.name, result, false, err)
+	case err != nil:
+		log.Error().Err(err).Msg("reloading the allowlist")
+	default:
+		logReload(log, r.name, result, false, nil)
+	}
+}
+
+func sameFetched(a, b allowlist.Fetched) bool {
+	return bytes.Equal(a.Data, b.Data) && bytes.Equal(a.Signature, b.Signature)
+}
+
+// fetch fetches the allowlist into r.fetched, asking for it only where it
+// changed since the last fetch, by If-None-Match. r.mu is held.
+func (r *remote) fetch(ctx context.Context) (err error) {
+	attemptedAt := time.Now()
+	r.stateMu.Lock()
+	r.attemptedAt = attemptedAt
+	r.stateMu.Unlock()
+	defer func() {
+		r.stateMu.Lock()
+		defer r.stateMu.Unlock()
+		if r.err = err; err == nil {
+			r.succeededAt = attemptedAt
+		}
+	}()
+
+	etag := ""
+	if r.fetched.Data != nil {
+		etag = r.etag
+	}
+	data, etag, err := r.get(ctx, r.url, etag)
+	switch {
+	case err != nil:
+		return err
+	case data == nil:
+		// Not modified: what was fetched last is what the server holds.
+		return nil
+	}
+	var signature []byte
+	if r.signed {
+		// Fetched whole every time the bundle is: it changes with it, and a
+		// server may name it by no etag of its own. None at all is an
+		// unsigned bundle, which reading it reports.
+		signed := *r.url
+		signed.Path += allowlist.SignatureExt
+		signed.RawPath = ""
+		signature, _, err = r.get(ctx, &signed, "")
+		if err != nil && !errors.Is(err, errNotFound) {
+			return err
+		}
+	}
+	r.fetched = allowlist.Fetched{Name: r.name, Data: data, Signature: signature}
+	r.etag = etag
+	return nil
+}
+
+// errNotFound is a fetch the server answered 404 Not Found.
+var errNotFound = errors.New("404 Not Found")
+
+// get fetches u, conditional on etag where it's set, and returns what it
+// holds and its etag: nil and etag where the server answered 304 Not Modified.
+// The errors name u by what's safe to log, see [remote].
+func (r *remote) get(ctx context.Context, u *url.URL, etag string) ([]byte, string, error) {
+	name := allowlistName(u.String())
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("GET %s: %w", name, err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		// A *url.Error names the URL as it was requested, query and all.
+		var e *url.Error
+		if errors.As(err, &e) {
+			err = e.Err
+		}
+		return nil, "", fmt.Errorf("GET %s: %w", name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, etag, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, "", fmt.Errorf("GET %s: %w", name, errNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("GET %s: %s", name, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFetched+1))
+	switch {
+	case err != nil:
+		return nil, "", fmt.Errorf("GET %s: %w", name, err)
+	case len(data) > maxFetched:
+		return nil, "", fmt.Errorf("GET %s: more than %d bytes", name, maxFetched)
+	}
+	if data == nil {
+		// An empty answer is something fetched, which nil isn't.
+		data = []byte{}
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// fetchState is what /status and the metrics report of a remote.
+type fetchState struct {
+	client, name             string
+	attemptedAt, succeededAt time.Time
+	err                      error
+}
+
+func (r *remote) state() fetchState {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	return fetchState{
+		client: r.client, name: r.name,
+		attemptedAt: r.attemptedAt, succeededAt: r.succeededAt, err: r.err,
+	}
+}
+
+// fetchAllowlists polls every remote allowlist every -allowlist.watch, until
+// ctx is done. Returns at once where that's off: a remote allowlist is then
+// fetched at the start and on POST /reload alone.
+func (c *components) fetchAllowlists(ctx context.Context, log zerolog.Logger) {
+	if c.watchEvery <= 0 || len(c.remotes) == 0 {
+		return
+	}
+	ticker := time.NewTicker(c.watchEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, r := range c.remotes {
+			r.poll(ctx, log)
+		}
+	}
+}
+
+// remoteOf is the remote list is fetched by, nil where it's read from disk.
+func (c *control) remoteOf(list *allowlist.Allowlist) *remote {
+	for _, r := range c.remotes {
+		if r.allowlist == list {
+			return r
+		}
+	}
+	return nil
+}
+
+// fetchesJSON is the "fetches" member of /status: the last fetch of every
+// remote allowlist. Nothing without one, so the answer stays what it was.
+func fetchesJSON(remotes []*remote) []byte {
+	if len(remotes) == 0 {
+		return nil
+	}
+	type fetch struct {
+		Client      string  `json:"client"`
+		URL         string  `json:"url"`
+		OK          bool    `json:"ok"`
+		Error       string  `json:"error,omitempty"`
+		AttemptedAt string  `json:"attempted_at"`
+		SucceededAt string  `json:"succeeded_at,omitempty"`
+		AgeSeconds  float64 `json:"age_seconds"`
+	}
+	all := make([]fetch, 0, len(remotes))
+	for _, r := range remotes {
+		s := r.state()
+		f := fetch{
+			Client: s.client, URL: s.name, OK: s.err == nil,
+			AttemptedAt: s.attemptedAt.Format(time.RFC3339),
+		}
+		// Neither before a fetch succeeded: the age of nothing is no age.
+		if !s.succeededAt.IsZero() {
+			f.SucceededAt = s.succeededAt.Format(time.RFC3339)
+			f.AgeSeconds = time.Since(s.succeededAt).Seconds()
+		}
+		if s.err != nil {
+			f.Error = s.err.Error()
+		}
+		all = append(all, f)
+	}
+	encoded, _ := json.Marshal(all) // Strings and numbers alone, which can't fail.
+	return append([]byte(`,"fetches":`), encoded...)
+}
+
+// fetchCollector reports the fetches of the remote allowlists, registered
+// where there are any.
+type fetchCollector struct {
+	remotes []*remote
+}
+
+var (
+	descFetchOK = prometheus.NewDesc(
+		"gqlhash_proxy_allowlist_fetch_ok",
+		"Whether the last fetch of a remote allowlist succeeded, 304 included. "+
+			"The client is empty for -allowlist.",
+		[]string{"client"}, nil)
+	descFetchAge = prometheus.NewDesc(
+		"gqlhash_proxy_allowlist_fetch_age_seconds",
+		"Time since a fetch of a remote allowlist last succeeded: how stale "+
+			"what it serves may be, absent before one did. "+
+			"The client is empty for -allowlist.",
+		[]string{"client"}, nil)
+)
+
+func (c *fetchCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descFetchOK
+	ch <- descFetchAge
+}
+
+func (c *fetchCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, r := range c.remotes {
+		s := r.state()
+		ok := 0.0
+		if s.err == nil {
+			ok = 1
+		}
+		ch <- prometheus.MustNewConstMetric(descFetchOK, prometheus.GaugeValue, ok, s.client)
+		if s.succeededAt.IsZero() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(descFetchAge,
+			prometheus.GaugeValue, time.Since(s.succeededAt).Seconds(), s.client)
+	}
+}
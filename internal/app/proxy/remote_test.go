@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/app/config"
+)
+
+// fileServer serves files by path, each with an ETag of its contents, and
+// answers 503 to everything while failing is set.
+type fileServer struct {
+	mu          sync.Mutex
+	files       map[string][]byte
+	failing     bool
+	notModified int
+}
+
+func (s *fileServer) set(file string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[file] = data
+}
+
+func (s *fileServer) fail(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[r.URL.Path]
+	switch {
+	case s.failing:
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	case !ok:
+		http.NotFound(w, r)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(data))
+	if r.Header.Get("If-None-Match") == etag {
+		s.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	_, _ = w.Write(data)
+}
+
+// TestRemoteAllowlist covers an allowlist fetched from a URL: reloaded where
+// what the server holds changed, not where it answers 304, and serving what it
+// did where a fetch fails, which /status and the metrics report. The query of
+// the URL, which may carry a token, is reported nowhere.
+func TestRemoteAllowlist(t *testing.T) {
+	files := &fileServer{files: map[string][]byte{
+		"/q.jsonl": []byte(`{"query":"{ a }"}` + "\n"),
+	}}
+	srv := httptest.NewServer(files)
+	defer srv.Close()
+
+	cfg := config.Proxy{
+		AllowlistDir:       srv.URL + "/q.jsonl?token=s3cret",
+		AllowlistManifests: true,
+		HashFunc:           config.HashFunctionSHA2,
+		Upstream:           config.ProxyUpstream{URL: mustURL(t, "http://upstream/graphql")},
+		Control:            config.ProxyControl{Address: "127.0.0.1:0"},
+	}
+	c, err := build(cfg, testLogger(), ServerImpl{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := func(document string) bool {
+		sum, err := gqlhash.AppendHash(nil, sha256.New(), gqlhash.Options{}, document)
+		if err.IsErr() {
+			t.Fatal(err)
+		}
+		return c.allowlist.Allowed(sum)
+	}
+	get := func(target string) string {
+		rec := httptest.NewRecorder()
+		c.control.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Body.String()
+	}
+	if len(c.remotes) != 1 || len(c.watched) != 0 || !allowed("{ a }") {
+		t.Fatalf("expected the allowlist fetched; %d remotes", len(c.remotes))
+	}
+	r := c.remotes[0]
+	_, loadedAt := c.allowlist.Stats()
+
+	// Unchanged: the server answers 304, and nothing is reloaded.
+	r.poll(context.Background(), testLogger())
+	if _, at := c.allowlist.Stats(); files.notModified != 1 || !at.Equal(loadedAt) {
+		t.Errorf("expected a 304 and no reload; %d 304s", files.notModified)
+	}
+
+	files.set("/q.jsonl", []byte(`{"query":"{ b }"}`+"\n"))
+	r.poll(context.Background(), testLogger())
+	if !allowed("{ b }") || allowed("{ a }") {
+		t.Error("expected the changed allowlist reloaded")
+	}
+
+	// Failing, the allowlist serves what it did.
+	files.fail(true)
+	r.poll(context.Background(), testLogger())
+	if !allowed("{ b }") {
+		t.Error("expected the allowlist kept where the fetch failed")
+	}
+	status := get("/status")
+	fetch := `"fetches":[{"client":"","url":"` + srv.URL + `/q.jsonl","ok":false,`
+	if !strings.Contains(status, fetch) ||
+		!strings.Contains(status, "503 Service Unavailable") {
+		t.Errorf("expected the failed fetch in /status; received %s", status)
+	}
+	metrics := get("/metrics")
+	if !strings.Contains(metrics, `gqlhash_proxy_allowlist_fetch_ok{client=""} 0`) ||
+		!strings.Contains(metrics, `gqlhash_proxy_allowlist_fetch_age_seconds{client=""}`) {
+		t.Errorf("expected the fetch gauges; received %s", metrics)
+	}
+	if strings.Contains(status+metrics, "s3cret") {
+		t.Error("expected the query of the URL reported nowhere")
+	}
+
+	// POST /reload fetches too, and answers a failed fetch as the failure
+	// it is.
+	rec := httptest.NewRecorder()
+	c.control.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for a failed fetch; received %d", rec.Code)
+	}
+	files.fail(false)
+	files.set("/q.jsonl", []byte(`{"query":"{ c }"}`+"\n"))
+	rec = httptest.NewRecorder()
+	c.control.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK || !allowed("{ c }") {
+		t.Errorf("expected the reload to fetch; received %d: %s", rec.Code, rec.Body)
+	}
+	if status := get("/status"); !strings.Contains(status, `"ok":true`) {
+		t.Errorf("expected the fetch ok again; received %s", status)
+	}
+
+	// Failing at the start, there's nothing to serve.
+	files.fail(true)
+	if _, err := build(cfg, testLogger(), ServerImpl{}); err == nil ||
+		strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("expected the start to fail, naming no token; received %v", err)
+	}
+}
+
+// TestRemoteFetchState covers what /status and the metrics report of a
+// remote allowlist before a fetch of it succeeded: no age, not the age of the
+// zero time. Nor does reading it wait for a fetch under way.
+func TestRemoteFetchState(t *testing.T) {
+	files := &fileServer{files: map[string][]byte{}, failing: true}
+	srv := httptest.NewServer(files)
+	defer srv.Close()
+
+	r, err := newRemote("", srv.URL+"/q.jsonl", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	if err := r.fetch(context.Background()); err == nil {
+		t.Fatal("expected the fetch to fail")
+	}
+	// Read while mu is held, as it is through a fetch.
+	status := string(fetchesJSON([]*remote{r}))
+	r.mu.Unlock()
+	if !strings.Contains(status, `"ok":false`) ||
+		strings.Contains(status, "succeeded_at") ||
+		!strings.Contains(status, `"age_seconds":0`) {
+		t.Errorf("expected no success in /status; received %s", status)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&fetchCollector{remotes: []*remote{r}})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+	if !slices.Equal(names, []string{"gqlhash_proxy_allowlist_fetch_ok"}) {
+		t.Errorf("expected no age before a fetch succeeded; received %v", names)
+	}
+}
+
+// TestRemoteAllowlistSigned covers a bundle fetched under
+// -allowlist.bundle-key: its signature is fetched beside it.
+func TestRemoteAllowlistSigned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	doc := []byte("{ a }")
+	if err := tw.WriteHeader(&tar.Header{
+		Name: "a.graphql", Mode: 0o644, Size: int64(len(doc)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = tw.Write(doc)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bundle := buf.Bytes()
+
+	files := &fileServer{files: map[string][]byte{"/q.tgz": bundle}}
+	srv := httptest.NewServer(files)
+	defer srv.Close()
+	cfg := config.Proxy{
+		AllowlistDir: srv.URL + "/q.tgz", HashFunc: config.HashFunctionSHA2,
+		AllowlistBundleKeys: []ed25519.PublicKey{public},
+		Upstream:            config.ProxyUpstream{URL: mustURL(t, "http://upstream/graphql")},
+		Control:             config.ProxyControl{Address: "127.0.0.1:0"},
+	}
+	if _, err := build(cfg, testLogger(), ServerImpl{}); err == nil ||
+		!strings.Contains(err.Error(), "unsigned") {
+		t.Errorf("expected an unsigned bundle refused; received %v", err)
+	}
+	files.set("/q.tgz.sig", ed25519.Sign(private, bundle))
+	c, err := build(cfg, testLogger(), ServerImpl{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.allowlist.Len() != 1 {
+		t.Errorf("expected the bundle read; %d documents", c.allowlist.Len())
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -65,17 +66,23 @@ func RunWith(
 		versioninfo.Print(stdout, name, version)
 		return 0
 	}
+	if cfg.CmdCheckAllowlist != "" {
+		return checkAllowlist(cfg, stdout, stderr)
+	}
 	// The query of -upstream.url is merged into every forwarded request,
 	// see [mergeQuery], so one naming the document is refused here: it would reach the
 	// API beside the document the client sent, and which of the two an API reads is
-	// the API's business. The allowlist hashed only one of them.
+	// the API's business. The allowlist hashed only one of them. An operationName
+	// is refused alike: it picks what of the document runs, which is checked too.
 	//
 	// Checked here rather than in config, which owns no reading rule:
 	// this is the same [hasQueryParam] a request goes through.
-	if hasQueryParam(cfg.Upstream.URL.RawQuery) {
+	if hasQueryParam(cfg.Upstream.URL.RawQuery) ||
+		hasParam(cfg.Upstream.URL.RawQuery, "operationName") {
 		_, _ = fmt.Fprintf(stderr,
-			"-upstream.url %q must name no query parameter: it is merged into "+
-				"every forwarded request, and the document is the client's to carry\n",
+			"-upstream.url %q must name no query parameter and no operationName: "+
+				"it is merged into every forwarded request, and the document is the "+
+				"client's to carry\n",
 			cfg.Upstream.URL)
 		return 2
 	}
@@ -119,6 +126,15 @@ type components struct {
 	recycle      func()
 	recycleEvery time.Duration
 
+	// watched are the allowlists -allowlist.watch polls every watchEvery,
+	// see [components.watchAllowlists]. Empty where it's off.
+	watched    []*watched
+	watchEvery time.Duration
+
+	// remotes are the allowlists fetched over HTTP, polled every watchEvery
+	// instead, see [components.fetchAllowlists].
+	remotes []*remote
+
 	// dataPlane serves the requests the proxy exists for; control answers
 	// /metrics, /status and /reload. Each has a listener of its own and a run
 	// has both, since the control server has no off switch.
@@ -132,26 +148,120 @@ type components struct {
 	httpImpl string
 }
 
-// build assembles the components and loads the allowlist.
-func build(cfg config.Proxy, log zerolog.Logger, impl ServerImpl) (*components, error) {
-	options := gqlhash.Options{Ignore: cfg.Ignore, DepthLimit: cfg.DepthLimit}
-	// Checked once here rather than per request: a proxy that can't hash serves nothing,
-	// so it's a start failure and not a nil at the first request.
+// hashOf is the hash function of cfg, and an error where there's none.
+func hashOf(cfg config.Proxy) (func() hash.Hash, error) {
 	if _, ok := config.NewHasher(cfg.HashFunc); !ok {
 		return nil, fmt.Errorf("unsupported hash function: %s",
 			config.HashName(cfg.HashFunc))
 	}
-	newHash := func() hash.Hash {
+	return func() hash.Hash {
 		h, _ := config.NewHasher(cfg.HashFunc)
 		return h
+	}, nil
+}
+
+// newAllowlistOf returns an empty allowlist reading as cfg says, which is how
+// every allowlist of a run is read, and the one -check-allowlist reads.
+// published is [allowlist.Config.Published].
+func newAllowlistOf(
+	cfg config.Proxy, newHash func() hash.Hash, published func(),
+) *allowlist.Allowlist {
+	options := gqlhash.Options{Ignore: cfg.Ignore, DepthLimit: cfg.DepthLimit}
+	return allowlist.NewWithConfig(newHash, options, allowlist.Config{
+		Sources: cfg.AllowlistSources, Manifests: cfg.AllowlistManifests,
+		Function: config.HashName(cfg.HashFunc), SunsetWindow: cfg.AllowlistSunsetWindow,
+		Policy: allowlist.Policy{
+			Strict:            cfg.AllowlistStrict,
+			MaxRemoved:        cfg.AllowlistMaxRemoved,
+			MaxRemovedPercent: cfg.AllowlistMaxRemovedPercent,
+		},
+		BundleKeys: cfg.AllowlistBundleKeys,
+		GitRef:     cfg.AllowlistGitRef,
+		Schemes:    schemesOf(cfg),
+		Published:  published,
+	})
+}
+
+// schemesOf is what every allowlist of cfg is keyed by besides -hash and
+// -ignore, see -allowlist.also-scheme. Its hash functions are all the proxy's,
+// which [config.ParseProxy] checked.
+func schemesOf(cfg config.Proxy) []allowlist.Scheme {
+	var schemes []allowlist.Scheme
+	for _, s := range cfg.AllowlistSchemes {
+		schemes = append(schemes, allowlist.Scheme{
+			Name: s.String(),
+			NewHash: func() hash.Hash {
+				h, _ := config.NewHasher(s.HashFunc)
+				return h
+			},
+			Options: gqlhash.Options{Ignore: s.Ignore, DepthLimit: cfg.DepthLimit},
+		})
 	}
+	return schemes
+}
 
-	list := allowlist.New(newHash, options)
-	result, err := list.Reload(cfg.AllowlistDir)
+// build assembles the components and loads the allowlist.
+func build(cfg config.Proxy, log zerolog.Logger, impl ServerImpl) (*components, error) {
+	options := gqlhash.Options{Ignore: cfg.Ignore, DepthLimit: cfg.DepthLimit}
+	// Checked once here rather than per request: a proxy that can't hash serves nothing,
+	// so it's a start failure and not a nil at the first request.
+	newHash, err := hashOf(cfg)
 	if err != nil {
 		return nil, err
 	}
-	logReload(log, cfg.AllowlistDir, result)
+
+	// Every allowlist is read alike, whichever client it's for, and watched
+	// alike under -allowlist.watch: one at a URL is fetched, and polled
+	// rather than watched.
+	var watching []*watched
+	var remotes []*remote
+	// A reload of any of them may move an entry the proxy warned of, see
+	// [proxy.warnSunset].
+	sunsetLogged := new(sync.Map)
+	load := func(client, dir string) (*allowlist.Allowlist, error) {
+		list := newAllowlistOf(cfg, newHash, sunsetLogged.Clear)
+		var result allowlist.Result
+		var err error
+		if config.IsAllowlistURL(dir) {
+			r, errRemote := newRemote(client, dir, list, len(cfg.AllowlistBundleKeys) > 0)
+			if errRemote != nil {
+				return nil, errRemote
+			}
+			remotes = append(remotes, r)
+			// A fetch that fails at the start fails it: there's nothing before
+			// to serve.
+			result, err = r.reload(context.Background(), false)
+		} else {
+			if cfg.AllowlistWatch > 0 {
+				watching = append(watching, newWatched(client, dir, list))
+			}
+			result, err = list.Reload(dir)
+		}
+		// Refused at the start, there's nothing before to serve, so it's a
+		// start failure like any other, logged as a refused reload is.
+		if errors.Is(err, allowlist.ErrRefused) {
+			logReload(log, allowlistName(dir), result, false, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		logReload(log, allowlistName(dir), result, false, nil)
+		return list, nil
+	}
+	list, err := load("", cfg.AllowlistDir)
+	if err != nil {
+		return nil, err
+	}
+	var clients []*clientList
+	for _, c := range cfg.AllowlistClients {
+		l, err := load(c.Name, c.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("the allowlist of client %q: %w", c.Name, err)
+		}
+		clients = append(clients, &clientList{
+			name: c.Name, dir: allowlistName(c.Dir), allowlist: l,
+		})
+	}
 
 	transport := &http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
@@ -176,15 +286,26 @@ func build(cfg config.Proxy, log zerolog.Logger, impl ServerImpl) (*components,
 		logRequests:     cfg.Log.Requests,
 		trustForwarded:  cfg.TrustForwarded,
 		maxBody:         cfg.Server.MaxBody,
+		clientHeader:    cfg.AllowlistClientHeader,
+		clients:         clients,
+		scheme: config.ProxyScheme{
+			HashFunc: cfg.HashFunc, Ignore: cfg.Ignore,
+		}.String(),
+		schemes:      schemesOf(cfg),
+		sunsetLogged: sunsetLogged,
 	}, transport, log)
 
 	// The metrics and the control endpoints share an address of their own,
 	// so neither is exposed on the data-plane port.
 	controlMux := http.NewServeMux()
+	if remotes != nil {
+		p.metrics.registry.MustRegister(&fetchCollector{remotes: remotes})
+	}
 	controlMux.Handle("/metrics", p.metrics.Handler(log))
 	(&control{
-		allowlist: list, dir: cfg.AllowlistDir, proxy: p,
-		token: cfg.Control.Token, log: log,
+		allowlist: list, dir: allowlistName(cfg.AllowlistDir),
+		git: cfg.AllowlistGitRef != "", remotes: remotes,
+		proxy: p, token: cfg.Control.Token, log: log,
 	}).routes(controlMux)
 	controlServer := &http.Server{
 		Addr:              cfg.Control.Address,
@@ -227,6 +348,7 @@ func build(cfg config.Proxy, log zerolog.Logger, impl ServerImpl) (*components,
 	return &components{
 		allowlist: list, proxy: p,
 		recycle: recycle, recycleEvery: cfg.Upstream.MaxConnLifetime,
+		watched: watching, remotes: remotes, watchEvery: cfg.AllowlistWatch,
 		dataPlane: server, control: controlServer, httpImpl: implName,
 	}, nil
 }
@@ -258,6 +380,8 @@ func (c *components) serveOn(
 	errServe := make(chan error, 1)
 	go func() { errServe <- server.Serve(listener) }()
 	go c.recycleConns(ctx)
+	go c.watchAllowlists(ctx, log)
+	go c.fetchAllowlists(ctx, log)
 
 	// The address in use, the only way to learn the port behind a :0.
 	controlListener, err := net.Listen("tcp", c.control.Addr)
@@ -279,13 +403,23 @@ func (c *components) serveOn(
 	}()
 	log.Info().Str("address", controlAddress).
 		Msg("serving /metrics, /status, /healthz and /reload")
+	var alsoSchemes []string
+	for _, s := range cfg.AllowlistSchemes {
+		alsoSchemes = append(alsoSchemes, s.String())
+	}
 	listening := log.Info().
 		Str("address", listener.Addr().String()).
 		Str("upstream", cfg.Upstream.URL.String()).
-		Str("allowlist", cfg.AllowlistDir).
+		Str("allowlist", allowlistName(cfg.AllowlistDir)).
 		Int("documents", list.Len()).
+		Dur("allowlist_sunset_window", cfg.AllowlistSunsetWindow).
+		Dur("allowlist_watch", cfg.AllowlistWatch).
+		Str("allowlist_git_ref", cfg.AllowlistGitRef).
+		Str("allowlist_client_header", cfg.AllowlistClientHeader).
+		Int("allowlist_clients", len(cfg.AllowlistClients)).
+		Strs("allowlist_also_schemes", alsoSchemes).
 		Str("hash", config.HashName(cfg.HashFunc)).
-		Str("ignore", config.IgnoreName(cfg.Ignore)).
+		Str("ignore", cfg.Ignore.String()).
 		Int("depth_limit", cfg.DepthLimit).
 		Bool("trust_forwarded", cfg.TrustForwarded).
 		// The environment can set any of these, so the effective values are
@@ -362,8 +496,21 @@ func (c *components) recycleConns(ctx context.Context) {
 //
 // Every file left out is an error — a document meant to be served and isn't is
 // a deployment mistake — and the summary is one line whatever the outcome,
-// so a reload always leaves a trace.
-func logReload(log zerolog.Logger, dir string, r allowlist.Result) {
+// so a reload always leaves a trace. It names the documents added, removed and
+// renamed, the first [loggedChanges] of each, and counts them all.
+//
+// A dry run logs what the reload would have, marked dry_run: a document it
+// would leave out is as much an error, only not yet served to anyone.
+// refused is the [allowlist.ErrRefused] of a reload -allowlist.strict or
+// -allowlist.max-removed kept from being published, which makes the summary
+// an error, nil where nothing was refused. An allowlist read from Git names
+// the ref and the commit it was read at.
+func logReload(
+	log zerolog.Logger, dir string, r allowlist.Result, dryRun bool, refused error,
+) {
+	if dryRun {
+		log = log.With().Bool("dry_run", true).Logger()
+	}
 	if r.SchemaErr != nil {
 		log.Error().Err(r.SchemaErr).
 			Msg("reading the schema, the documents are checked against none")
@@ -371,17 +518,64 @@ func logReload(log zerolog.Logger, dir string, r allowlist.Result) {
 	for _, err := range r.Skipped {
 		log.Error().Err(err).Msg("skipping a document")
 	}
-	if len(r.Files) == 0 {
+	// Neither is a mistake of the deployment: they're what a schedule asked for.
+	// But a client still sending one breaks, so each is a warning.
+	for _, e := range r.Expiring {
+		log.Warn().Str("file", e.Name).Time("expires", e.Expires).
+			Msg("a document expires soon")
+	}
+	for _, e := range r.Expired {
+		log.Warn().Str("file", e.Name).Time("expires", e.Expires).
+			Msg("a document has expired and is refused")
+	}
+	if len(r.Files) == 0 && refused == nil {
 		// An empty allowlist rejects every request, loud in the counters and
 		// silent otherwise. This is the one line that says why.
 		log.Error().Str("dir", dir).Int("skipped", len(r.Skipped)).
 			Msg("no documents on the allowlist, every request is rejected")
 	}
-	log.Info().
+	renamed := make([]string, 0, min(len(r.Renamed), loggedChanges))
+	for _, e := range r.Renamed[:min(len(r.Renamed), loggedChanges)] {
+		renamed = append(renamed, e.From.Name+" -> "+e.To.Name)
+	}
+	event, msg := log.Info(), "allowlist loaded"
+	switch {
+	case refused != nil && dryRun:
+		event, msg = log.Warn().Err(refused), "allowlist checked, a reload would be refused"
+	case refused != nil:
+		event, msg = log.Error().Err(refused), "allowlist refused, serving the one before"
+	case dryRun:
+		msg = "allowlist checked, nothing published"
+	}
+	if r.Commit != "" {
+		// Read from Git: what the ref named when it was read.
+		event = event.Str("ref", r.Ref).Str("commit", r.Commit)
+	}
+	event.
 		Int("documents", len(r.Files)).
-		Int("added", r.Added).
-		Int("removed", r.Removed).
+		Int("added", len(r.Added)).
+		Int("removed", len(r.Removed)).
+		Int("renamed", len(r.Renamed)).
+		Strs("added_files", names(r.Added, loggedChanges)).
+		Strs("removed_files", names(r.Removed, loggedChanges)).
+		Strs("renamed_files", renamed).
 		Int("skipped", len(r.Skipped)).
+		Int("expiring", len(r.Expiring)).
+		Int("expired", len(r.Expired)).
 		Str("dir", dir).
-		Msg("allowlist loaded")
+		Msg(msg)
+}
+
+// loggedChanges is how many of the documents added, removed and renamed the
+// line of a reload names, each. The first load adds every one, and a line
+// naming a million files is read by no one: POST /reload answers them all.
+const loggedChanges = 100
+
+// names are the names of the first n of entries.
+func names(entries []*allowlist.Entry, n int) []string {
+	s := make([]string, 0, min(len(entries), n))
+	for _, e := range entries[:min(len(entries), n)] {
+		s = append(s, e.Name)
+	}
+	return s
 }
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"io"
 	"net"
@@ -267,6 +268,35 @@ func TestBuildWiring(t *testing.T) {
 	}
 }
 
+// TestBuildBundleKeys covers -allowlist.bundle-key at startup: an allowlist
+// that isn't a bundle signed with one of the keys stops the start, so a proxy
+// never serves one it can't trust.
+func TestBuildBundleKeys(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	writeDoc(t, dir, "unsigned.tgz", "not even an archive")
+
+	for allowlistDir, expect := range map[string]string{
+		dir:                                "not a signed bundle",
+		filepath.Join(dir, "unsigned.tgz"): "unsigned",
+	} {
+		cfg := config.Proxy{
+			AllowlistDir: allowlistDir, HashFunc: config.HashFunctionSHA2,
+			AllowlistBundleKeys: []ed25519.PublicKey{public},
+			Upstream:            config.ProxyUpstream{URL: mustURL(t, "http://upstream/graphql")},
+			Control:             config.ProxyControl{Address: "127.0.0.1:0"},
+		}
+		_, err := build(cfg, testLogger(), ServerImpl{})
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected %q; received %v", allowlistDir, expect, err)
+		}
+	}
+}
+
 // TestNewLogger covers the flags the logger is built from.
 func TestNewLogger(t *testing.T) {
 	var out strings.Builder
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/romshark/gqlhash/v2/internal/allowlist"
+	"github.com/romshark/gqlhash/v2/internal/watch"
+)
+
+// watched is an allowlist -allowlist.watch reloads, and the watch of its
+// files.
+type watched struct {
+	// client is the client the allowlist is for, empty for -allowlist.
+	client    string
+	dir       string
+	allowlist *allowlist.Allowlist
+	files     *watch.Files
+}
+
+// newWatched returns the watch of the allowlist of dir, its state taken now,
+// before the allowlist is read, see [watch.New].
+func newWatched(client, dir string, list *allowlist.Allowlist) *watched {
+	return &watched{
+		client: client, dir: dir, allowlist: list,
+		files: watch.New(func() ([]string, error) { return list.Files(dir) }),
+	}
+}
+
+// watchAllowlists reloads every allowlist whose files changed, polling them
+// every -allowlist.watch, until ctx is done, see [watch.Files.Poll]. Returns
+// at once where that's off.
+//
+// A reload that fails or is refused keeps what the allowlist held, as a POST
+// /reload does, and the next change tries again.
+func (c *components) watchAllowlists(ctx context.Context, log zerolog.Logger) {
+	if c.watchEvery <= 0 || len(c.watched) == 0 {
+		return
+	}
+	ticker := time.NewTicker(c.watchEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, w := range c.watched {
+			if !w.files.Poll() {
+				continue
+			}
+			log := log
+			if w.client != "" {
+				log = log.With().Str("client", w.client).Logger()
+			}
+			log.Info().Str("dir", w.dir).Msg("the allowlist changed, reloading it")
+			result, err := w.allowlist.Reload(w.dir)
+			switch {
+			case errors.Is(err, allowlist.ErrRefused):
+				logReload(log, w.dir, result, false, err)
+			case err != nil:
+				log.Error().Err(err).Msg("reloading the allowlist")
+			default:
+				logReload(log, w.dir, result, false, nil)
+			}
+		}
+	}
+}
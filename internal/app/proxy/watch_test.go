@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/romshark/gqlhash/v2"
+)
+
+// watchedAllowlist starts a watch of the allowlist of dir, polling every
+// millisecond, and returns whether it allows a document and the watch's log.
+func watchedAllowlist(
+	t *testing.T, dir string,
+) (allowed func(document string) bool, logs *syncBuffer) {
+	t.Helper()
+	list := newAllowlist(t, dir)
+	c := &components{
+		watched:    []*watched{newWatched("", dir, list)},
+		watchEvery: time.Millisecond,
+	}
+	logs = new(syncBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.watchAllowlists(ctx, zerolog.New(logs))
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+
+	return func(document string) bool {
+		sum, err := gqlhash.AppendHash(nil, sha256.New(), gqlhash.Options{}, document)
+		if err.IsErr() {
+			t.Fatal(err)
+		}
+		return list.Allowed(sum)
+	}, logs
+}
+
+// TestWatchAllowlist covers a file added, changed and removed, each reloading
+// the allowlist.
+func TestWatchAllowlist(t *testing.T) {
+	dir := t.TempDir()
+	writeDoc(t, dir, "a.graphql", "{ a }")
+	allowed, logs := watchedAllowlist(t, dir)
+
+	writeDoc(t, dir, "b.graphql", "{ b }")
+	waitFor(t, func() bool { return allowed("{ b }") }, "the added file")
+
+	writeDoc(t, dir, "b.graphql", "{ bb }")
+	waitFor(t, func() bool { return allowed("{ bb }") && !allowed("{ b }") },
+		"the changed file")
+
+	if err := os.Remove(filepath.Join(dir, "a.graphql")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !allowed("{ a }") }, "the removed file")
+
+	if !strings.Contains(logs.String(), "the allowlist changed, reloading it") ||
+		!strings.Contains(logs.String(), `"documents":1`) {
+		t.Errorf("expected every reload logged; received %s", logs.String())
+	}
+}
+
+// TestWatchAllowlistSymlinkSwap covers the shape of a ConfigMap mount: every
+// file a link through ..data, which an update points at a new directory. The
+// new file has the time and the size of the old, so the link is what changed.
+func TestWatchAllowlistSymlinkSwap(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	version := func(name, document string) {
+		t.Helper()
+		writeDoc(t, dir, filepath.Join(name, "a.graphql"), document)
+		if err := os.Chtimes(filepath.Join(dir, name, "a.graphql"), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version("..v1", "{ a }")
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	err := os.Symlink(filepath.Join("..data", "a.graphql"), filepath.Join(dir, "a.graphql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, _ := watchedAllowlist(t, dir)
+	if !allowed("{ a }") {
+		t.Fatal("expected the linked document allowed")
+	}
+
+	// What kubelet does: the new version beside the old, then a link to it
+	// renamed over ..data.
+	version("..v2", "{ b }")
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return allowed("{ b }") && !allowed("{ a }") },
+		"the swapped version")
+}
@@ -291,6 +291,12 @@ func (s *server) handle(ctx *fasthttp.RequestCtx) {
 			string(ctx.Request.Header.ContentType()))
 	}
 
+	if header := s.core.ClientHeader(); header != "" {
+		req.Client = ctx.Request.Header.Peek(header)
+	}
+	if s.core.LogRequests() {
+		req.RemoteAddr = ctx.RemoteAddr().String()
+	}
 	verdict, answer := s.core.Decide(req)
 	if verdict != proxy.VerdictAllowed {
 		if s.core.Debug() && verdict == proxy.VerdictRejected {
@@ -306,9 +312,7 @@ func (s *server) handle(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	if s.core.LogRequests() {
-		s.log.Debug().Str("remote", ctx.RemoteAddr().String()).Msg("forwarding")
-	}
+	// Logged by Decide, which has the entries the request matched.
 	s.forward(ctx, req.Body, method == proxy.MethodGET)
 	// Includes the upstream answer,
 	// so a dashboard can tell the proxy apart from the API behind it.
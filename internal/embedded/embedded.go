@@ -0,0 +1,255 @@
+// Package embedded finds the GraphQL documents a source file carries:
+// the gql and graphql tagged template literals of TypeScript and JavaScript,
+// and the string constants of Go. It reads the persisted-query manifests a
+// client build writes as well, which carry documents as JSON strings.
+//
+// A client codebase holds its documents there rather than in .graphql files,
+// so this is what lets an allowlist be built from that codebase as it is.
+// It reads a file and nothing else: an interpolation resolves only against
+// a template of the same file, see [Extract].
+package embedded
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/romshark/gqlhash/v2/parser"
+)
+
+var (
+	// ErrUnterminated is a template literal or a string that the file ends inside.
+	ErrUnterminated = errors.New("unterminated template literal")
+
+	// ErrInterpolation is an interpolation other than ${Name}: an expression
+	// names no document this package can read without running the code.
+	ErrInterpolation = errors.New("interpolation is no ${Name} of a template")
+
+	// ErrUnresolved is an ${Name} naming no gql or graphql template of the file.
+	ErrUnresolved = errors.New("interpolation names no template of this file")
+
+	// ErrCycle is a template interpolating itself, directly or through others.
+	ErrCycle = errors.New("interpolation cycle")
+)
+
+// Document is one GraphQL document of a source file.
+type Document struct {
+	// Text is the document with every interpolation resolved.
+	Text string
+
+	// Name is the identifier the document is bound to, or its id in a
+	// manifest, and empty where it's bound to none.
+	Name string
+
+	// Line and Column are where Text begins in the file, 1-based, or Hash
+	// where Text is empty.
+	Line, Column int
+
+	// Hash is, for a manifest entry carrying a hash and no document, the
+	// hash as the manifest writes it, and Text is empty. A hash is as good
+	// as the function and the encoding it was computed with, which only its
+	// reader knows.
+	Hash string
+
+	// source is the whole file, which [Document.Position] counts lines in.
+	source string
+
+	// segments map Text back onto source, in the order of Text.
+	segments []segment
+}
+
+// segment is a run of Text that begins at offset text and comes from offset
+// source of the file. An exact run is the file's bytes as they are,
+// so an offset within it maps one to one; any other maps onto its start —
+// an escape sequence, or a Go string that had to be unquoted.
+type segment struct {
+	text, source int
+	exact        bool
+}
+
+// Position returns the 1-based line and column in the file of offset in
+// [Document.Text], which is what a [parser.Result] of the document carries.
+// An offset within an interpolated template maps into that template.
+// A negative offset returns 0, 0, as [parser.Position] does.
+func (d Document) Position(offset int) (line, column int) {
+	if offset < 0 {
+		return 0, 0
+	}
+	i := sort.Search(len(d.segments), func(i int) bool {
+		return d.segments[i].text > offset
+	}) - 1
+	if i < 0 {
+		return parser.Position(d.source, 0)
+	}
+	s := d.segments[i]
+	at := s.source
+	if s.exact {
+		at += offset - s.text
+	}
+	return parser.Position(d.source, at)
+}
+
+// IsSource reports whether name is a source file [Extract] reads, by its extension.
+//
+// A Go test file is none: the constants there are fixtures of the tests,
+// and a document that only a test sends is no document a client sends.
+func IsSource(name string) bool {
+	if strings.HasSuffix(name, "_test.go") {
+		return false
+	}
+	switch filepath.Ext(name) {
+	case ".go",
+		".ts", ".tsx", ".mts", ".cts",
+		".js", ".jsx", ".mjs", ".cjs":
+		return true
+	}
+	return false
+}
+
+// Extract returns the documents the file name holds, in the order they appear,
+// and an [*Error] for each one it can't read.
+//
+// In TypeScript and JavaScript a document is a template literal tagged gql or
+// graphql. An interpolation in one is resolved where it's ${Name} and Name is
+// bound to another such template of the same file, which is how a fragment is
+// shared: its text takes the place of the interpolation, once per document
+// however often it's named, as graphql-tag does. A template interpolated into
+// another is a part of that one and no document of its own.
+//
+// In Go a document is a string constant whose value begins with an operation,
+// a fragment or a selection set, comments and whitespace aside.
+//
+// In a .json or a .jsonl file, see [IsManifest], a document is an entry of a
+// persisted-query manifest, named by its id.
+func Extract(name string, src []byte) ([]Document, []error) {
+	switch {
+	case IsManifest(name):
+		return extractManifest(name, src)
+	case filepath.Ext(name) == ".go":
+		return extractGo(name, src)
+	}
+	return extractScript(name, src)
+}
+
+// builder assembles a [Document.Text] and the segments that map it back.
+type builder struct {
+	text     []byte
+	segments []segment
+}
+
+// exact appends s, which is the file's bytes from offset source on.
+func (b *builder) exact(source int, s []byte) {
+	if len(s) == 0 {
+		return
+	}
+	b.segments = append(b.segments, segment{len(b.text), source, true})
+	b.text = append(b.text, s...)
+}
+
+// at appends s, which stands for what the file holds at offset source.
+func (b *builder) at(source int, s []byte) {
+	if len(s) == 0 {
+		return
+	}
+	b.segments = append(b.segments, segment{len(b.text), source, false})
+	b.text = append(b.text, s...)
+}
+
+// insert appends what another builder holds, keeping where it came from.
+func (b *builder) insert(o *builder) {
+	shift := len(b.text)
+	for _, s := range o.segments {
+		s.text += shift
+		b.segments = append(b.segments, s)
+	}
+	b.text = append(b.text, o.text...)
+}
+
+// Error is an error of [Extract] and the place of the file it's at, which reads
+// file:line:column: message, the form editors and CI annotations parse.
+// Its fields are that place for a caller reporting it some other way.
+type Error struct {
+	File         string
+	Line, Column int
+	Err          error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// locate is the error err at offset of src.
+func locate(name, src string, offset int, err error) error {
+	line, column := parser.Position(src, offset)
+	return &Error{File: name, Line: line, Column: column, Err: err}
+}
+
+// looksLikeDocument reports whether s begins the way only an executable document
+// begins, ignoring what GraphQL ignores: whitespace, commas and comments.
+// A Go string constant is any text, so this is what picks the documents out:
+// a selection set, an operation with a name or without, or a fragment. A sentence
+// that happens to start with "query" has no selection set where one would follow.
+func looksLikeDocument(s string) bool {
+	s = skipIgnored(strings.TrimPrefix(s, "\uFEFF"))
+	if strings.HasPrefix(s, "{") {
+		return true
+	}
+	keyword, s := name(s)
+	switch keyword {
+	case "query", "mutation", "subscription":
+		s = skipIgnored(s)
+		if n, rest := name(s); n != "" {
+			s = skipIgnored(rest)
+		}
+		return s != "" && strings.IndexByte("{(@", s[0]) >= 0
+	case "fragment":
+		n, rest := name(skipIgnored(s))
+		if n == "" {
+			return false
+		}
+		on, _ := name(skipIgnored(rest))
+		return on == "on"
+	}
+	return false
+}
+
+// skipIgnored drops the whitespace, commas and comments s begins with.
+func skipIgnored(s string) string {
+	for len(s) > 0 {
+		switch s[0] {
+		case ' ', '\t', '\n', '\r', ',':
+			s = s[1:]
+		case '#':
+			i := strings.IndexAny(s, "\n\r")
+			if i < 0 {
+				return ""
+			}
+			s = s[i:]
+		default:
+			return s
+		}
+	}
+	return s
+}
+
+// name splits the Name s begins with off the rest, "" where it begins with none.
+func name(s string) (n, rest string) {
+	i := 0
+	for i < len(s) && isNameContinue(s[i]) {
+		i++
+	}
+	if i == 0 || s[0] >= '0' && s[0] <= '9' {
+		return "", s
+	}
+	return s[:i], s[i:]
+}
+
+// isNameContinue reports whether b continues a GraphQL Name,
+// which is also what continues an identifier of ASCII.
+func isNameContinue(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
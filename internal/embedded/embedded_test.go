@@ -0,0 +1,362 @@
+package embedded_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2/internal/embedded"
+)
+
+// TestExtractScript covers the shapes a client codebase holds its documents in:
+// a bound template, one interpolating a fragment, and one bound to nothing.
+func TestExtractScript(t *testing.T) {
+	const src = "import { gql } from '@apollo/client';\n" +
+		"\n" +
+		"// gql`{ commented }` is no document.\n" +
+		"const s = 'gql`{ quoted }`';\n" +
+		"export const UserFields = gql`\n" +
+		"  fragment UserFields on User { id name }\n" +
+		"`;\n" +
+		"export const GetUser: DocumentNode = gql`\n" +
+		"  query GetUser { user { ...UserFields } }\n" +
+		"  ${UserFields}\n" +
+		"`;\n" +
+		"client.query({ query: graphql`{ viewer { id } }` });\n" +
+		"const notGraphQL = html`<p>${name}</p>`;\n"
+
+	docs, errs := embedded.Extract("app.ts", []byte(src))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	// The fragment is a part of GetUser and no document of its own.
+	if len(docs) != 2 {
+		t.Fatalf("expected 2 documents; received %d: %+v", len(docs), docs)
+	}
+
+	if docs[0].Name != "GetUser" {
+		t.Errorf("expected GetUser; received %q", docs[0].Name)
+	}
+	if !strings.Contains(docs[0].Text, "query GetUser") ||
+		!strings.Contains(docs[0].Text, "fragment UserFields on User") {
+		t.Errorf("expected the query and the fragment it names; received %q",
+			docs[0].Text)
+	}
+	if docs[0].Line != 8 || docs[0].Column != 42 {
+		t.Errorf("expected GetUser at 8:42; received %d:%d",
+			docs[0].Line, docs[0].Column)
+	}
+
+	if docs[1].Name != "" || docs[1].Text != "{ viewer { id } }" {
+		t.Errorf("expected the unbound document; received %+v", docs[1])
+	}
+}
+
+// TestExtractScriptFragmentOnce covers a fragment named twice in one document,
+// once directly and once through another: it's in the document once, as
+// graphql-tag puts it there, and a document defining it twice wouldn't parse.
+func TestExtractScriptFragmentOnce(t *testing.T) {
+	const src = "const A = gql`fragment A on T { a }`\n" +
+		"const B = gql`fragment B on T { ...A } ${A}`\n" +
+		"const Q = gql`{ t { ...A ...B } } ${A} ${B}`\n"
+
+	docs, errs := embedded.Extract("q.js", []byte(src))
+	if len(errs) > 0 || len(docs) != 1 {
+		t.Fatalf("expected one document; received %+v, %v", docs, errs)
+	}
+	if n := strings.Count(docs[0].Text, "fragment A "); n != 1 {
+		t.Errorf("expected fragment A once; received %d times: %q", n, docs[0].Text)
+	}
+}
+
+// TestExtractScriptErrors covers what an interpolation can be and this can't
+// resolve. Each one is reported at the interpolation rather than dropped,
+// since a document silently missing from an allowlist is a rejection nobody
+// can explain.
+func TestExtractScriptErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, src string
+		expect    error
+		at        string
+	}{
+		{
+			"expression", "const Q = gql`{ a } ${fragments.all}`",
+			embedded.ErrInterpolation, "q.ts:1:21",
+		},
+		{
+			"unresolved", "const Q = gql`{ a }\n${Missing}`",
+			embedded.ErrUnresolved, "q.ts:2:1",
+		},
+		{
+			"cycle", "const A = gql`${B}`\nconst B = gql`${A}`\nconst Q = gql`{ a } ${A}`",
+			embedded.ErrCycle, "q.ts:2:15",
+		},
+		{
+			"unterminated", "const Q = gql`{ a }",
+			embedded.ErrUnterminated, "q.ts:1:14",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errs := embedded.Extract("q.ts", []byte(tc.src))
+			if len(errs) != 1 {
+				t.Fatalf("expected one error; received %v", errs)
+			}
+			if !errors.Is(errs[0], tc.expect) {
+				t.Errorf("expected %v; received %v", tc.expect, errs[0])
+			}
+			if !strings.HasPrefix(errs[0].Error(), tc.at+": ") {
+				t.Errorf("expected the error at %s; received %v", tc.at, errs[0])
+			}
+		})
+	}
+}
+
+// TestExtractGo covers the string constants of a Go file: those holding a
+// document are taken, whatever they're named, and every other one is left alone.
+func TestExtractGo(t *testing.T) {
+	const src = "package queries\n" +
+		"\n" +
+		"const (\n" +
+		"\tGetUser = `\n" +
+		"# Reads a user.\n" +
+		"query GetUser { user { id } }`\n" +
+		"\tShort = \"{ viewer { id } }\"\n" +
+		"\tGreeting = \"query the docs\"\n" +
+		"\tQueryTimeout = 5\n" +
+		")\n" +
+		"\n" +
+		"var NotAConstant = `{ a }`\n"
+
+	docs, errs := embedded.Extract("queries.go", []byte(src))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(docs) != 2 || docs[0].Name != "GetUser" || docs[1].Name != "Short" {
+		t.Fatalf("expected GetUser and Short; received %+v", docs)
+	}
+	if docs[1].Line != 7 || docs[1].Column != 11 {
+		t.Errorf("expected Short at 7:11; received %d:%d", docs[1].Line, docs[1].Column)
+	}
+}
+
+// TestDocumentPosition covers mapping an offset of a document back into the
+// file, which is what makes a syntax error point at the line an editor opens.
+func TestDocumentPosition(t *testing.T) {
+	const src = "const F = gql`\nfragment F on T {\n  a(x: 01)\n}`\n" +
+		"const Q = gql`{\n  t { ...F }\n}\n${F}`\n"
+
+	docs, errs := embedded.Extract("q.ts", []byte(src))
+	if len(errs) > 0 || len(docs) != 1 {
+		t.Fatalf("expected one document; received %+v, %v", docs, errs)
+	}
+	d := docs[0]
+
+	// The query's own text maps one to one.
+	if line, column := d.Position(strings.Index(d.Text, "...F")); line != 6 ||
+		column != 7 {
+		t.Errorf("expected ...F at 6:7; received %d:%d", line, column)
+	}
+	// The fragment's text maps into the template it was written in.
+	if line, column := d.Position(strings.Index(d.Text, "01")); line != 3 ||
+		column != 8 {
+		t.Errorf("expected 01 at 3:8; received %d:%d", line, column)
+	}
+}
+
+// TestExtractManifest covers the manifests a client build writes: every entry
+// a document named by its id, where it begins in the file, unescaped.
+func TestExtractManifest(t *testing.T) {
+	type doc struct {
+		name, text string
+		line, col  int
+	}
+	for _, tc := range []struct {
+		name, file, src string
+		expect          []doc
+	}{
+		{
+			"apollo", "manifest.json", "{\n" +
+				`  "format": "apollo-persisted-query-manifest",` + "\n" +
+				`  "version": 1,` + "\n" +
+				`  "operations": [` + "\n" +
+				`    {"id": "a1", "name": "A", "type": "query", "body": "query A { a }"},` +
+				"\n" +
+				`    {"body": "query B {\n  b\n}", "id": "b2", "name": "B"}` + "\n" +
+				"  ]\n}\n",
+			[]doc{{"a1", "query A { a }", 5, 57}, {"b2", "query B {\n  b\n}", 6, 15}},
+		},
+		{
+			"relay", "persisted_queries.json",
+			`{"f00":"query A { a }","ba\u0072":"query B { b \u0022x\u0022 }"}`,
+			[]doc{{"f00", "query A { a }", 1, 9}, {"bar", `query B { b "x" }`, 1, 36}},
+		},
+		{
+			"jsonl", "queries.jsonl", `{"id":"a","query":"{ a }"}` + "\n" +
+				"\n" +
+				`{"query":"{ b }"}` + "\r\n",
+			[]doc{{"a", "{ a }", 1, 20}, {"", "{ b }", 3, 11}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			docs, errs := embedded.Extract(tc.file, []byte(tc.src))
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			if len(docs) != len(tc.expect) {
+				t.Fatalf("expected %d documents; received %+v", len(tc.expect), docs)
+			}
+			for i, d := range docs {
+				e := tc.expect[i]
+				if d.Name != e.name || d.Text != e.text || d.Line != e.line ||
+					d.Column != e.col {
+					t.Errorf("expected %s %q at %d:%d; received %s %q at %d:%d",
+						e.name, e.text, e.line, e.col, d.Name, d.Text, d.Line, d.Column)
+				}
+			}
+		})
+	}
+
+	// An offset past an escape maps onto the file past it.
+	docs, _ := embedded.Extract("m.json", []byte(`{"x":"{\n  a(x: 01)\n}"}`))
+	if len(docs) != 1 {
+		t.Fatalf("expected one document; received %+v", docs)
+	}
+	if line, column := docs[0].Position(strings.Index(docs[0].Text, "01")); line != 1 ||
+		column != 17 {
+		t.Errorf("expected 01 at 1:17; received %d:%d", line, column)
+	}
+}
+
+// TestExtractManifestErrors covers a manifest that can't be read: a whole file
+// that isn't one fails alone, and an entry carrying neither a document nor a
+// hash fails itself and leaves the rest read.
+func TestExtractManifestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, file, src string
+		expect          error
+		at              string
+		documents       int
+	}{
+		{
+			"no JSON", "m.json", "{\n  \"a\": }",
+			nil, "m.json:2:8", 0,
+		},
+		{
+			"no object", "m.json", "[]",
+			embedded.ErrNotManifest, "m.json:1:1", 0,
+		},
+		{
+			"no manifest", "tsconfig.json", `{"compilerOptions": {"strict": true}}`,
+			embedded.ErrNotManifest, "tsconfig.json:1:21", 0,
+		},
+		{
+			"other format", "m.json", `{"format": "openapi", "operations": []}`,
+			nil, "m.json:1:12", 0,
+		},
+		{
+			"other version", "m.json",
+			`{"format": "apollo-persisted-query-manifest", "version": 2, "operations": []}`,
+			nil, "m.json:1:58", 0,
+		},
+		{
+			"nothing", "m.json", `{"format": "apollo-persisted-query-manifest", ` +
+				`"operations": [{"id": "a", "body": "{ a }"}, {"name": "B"}]}`,
+			embedded.ErrNoDocument, "m.json:1:92", 1,
+		},
+		{
+			"hash no string", "m.json", `{"format": "apollo-persisted-query-manifest", ` +
+				`"operations": [{"id": 5}]}`,
+			nil, "m.json:1:69", 0,
+		},
+		{
+			"body twice", "m.json", `{"format": "apollo-persisted-query-manifest", ` +
+				`"operations": [{"body": "{ a }", "body": "{ b }"}]}`,
+			nil, "m.json:1:80", 0,
+		},
+		{
+			"jsonl nothing", "q.jsonl", `{"query":"{ a }"}` + "\n" +
+				`{"extensions":{"persistedQuery":{"version":1}}}` + "\n",
+			embedded.ErrNoDocument, "q.jsonl:2:1", 1,
+		},
+		{
+			"jsonl broken line", "q.jsonl", `{"query":"{ a }"` + "\n" + `{"query":"{ b }"}`,
+			nil, "q.jsonl:1:17", 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			docs, errs := embedded.Extract(tc.file, []byte(tc.src))
+			if len(errs) != 1 {
+				t.Fatalf("expected one error; received %v", errs)
+			}
+			if tc.expect != nil && !errors.Is(errs[0], tc.expect) {
+				t.Errorf("expected %v; received %v", tc.expect, errs[0])
+			}
+			if !strings.HasPrefix(errs[0].Error(), tc.at+": ") {
+				t.Errorf("expected the error at %s; received %v", tc.at, errs[0])
+			}
+			if len(docs) != tc.documents {
+				t.Errorf("expected %d documents; received %+v", tc.documents, docs)
+			}
+		})
+	}
+}
+
+// TestExtractManifestHashes covers entries carrying a hash and no document:
+// each is read as its hash, where the hash is written, and named by its id.
+func TestExtractManifestHashes(t *testing.T) {
+	for _, tc := range []struct {
+		name, file, src string
+		expect          []embedded.Document
+	}{
+		{
+			"apollo", "m.json", `{"format": "apollo-persisted-query-manifest", ` +
+				`"operations": [{"id": "a", "body": "{ a }"}, {"id": "ab\u0063"}]}`,
+			[]embedded.Document{
+				{Text: "{ a }", Name: "a", Line: 1, Column: 83},
+				{Name: "abc", Line: 1, Column: 100, Hash: "abc"},
+			},
+		},
+		{
+			"jsonl", "q.jsonl", `{"id":"x","query":"{ a }"}` + "\n" +
+				`{"id":"y","extensions":{"persistedQuery":{"sha256Hash":"ab"}}}` + "\n" +
+				`{"id":"z"}` + "\n",
+			[]embedded.Document{
+				{Text: "{ a }", Name: "x", Line: 1, Column: 20},
+				{Name: "y", Line: 2, Column: 57, Hash: "ab"},
+				{Name: "z", Line: 3, Column: 8, Hash: "z"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			docs, errs := embedded.Extract(tc.file, []byte(tc.src))
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			if len(docs) != len(tc.expect) {
+				t.Fatalf("expected %d documents; received %+v", len(tc.expect), docs)
+			}
+			for i, d := range docs {
+				e := tc.expect[i]
+				if d.Name != e.Name || d.Text != e.Text || d.Hash != e.Hash ||
+					d.Line != e.Line || d.Column != e.Column {
+					t.Errorf("expected %s %q %q at %d:%d; received %s %q %q at %d:%d",
+						e.Name, e.Text, e.Hash, e.Line, e.Column,
+						d.Name, d.Text, d.Hash, d.Line, d.Column)
+				}
+			}
+		})
+	}
+}
+
+func TestIsSource(t *testing.T) {
+	for name, expect := range map[string]bool{
+		"a.ts": true, "a.tsx": true, "a.js": true, "a.jsx": true,
+		"a.mjs": true, "a.cjs": true, "a.mts": true, "a.cts": true,
+		"a.go": true, "a_test.go": false,
+		"a.graphql": false, "a.json": false, "go": false,
+	} {
+		if got := embedded.IsSource(name); got != expect {
+			t.Errorf("%s: expected %t; received %t", name, expect, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package embedded
+
+import (
+	"errors"
+	"go/ast"
+	goparser "go/parser"
+	goscanner "go/scanner"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/romshark/gqlhash/v2/parser"
+)
+
+// extractGo reads the string constants of a Go file that hold a document.
+//
+// A constant is read where its value is a string literal. One built from others,
+// `Query + Fields`, is left alone: its value is whatever the compiler makes of it,
+// and that's a second implementation of Go to keep in step with the first.
+func extractGo(name string, src []byte) ([]Document, []error) {
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, name, src, goparser.SkipObjectResolution)
+	if err != nil {
+		// The first error is the one worth reading: the parser recovers poorly,
+		// and those after it tend to follow from it.
+		var list goscanner.ErrorList
+		if errors.As(err, &list) && len(list) > 0 {
+			err = &Error{
+				File: name, Line: list[0].Pos.Line, Column: list[0].Pos.Column,
+				Err: errors.New(list[0].Msg),
+			}
+		}
+		return nil, []error{err}
+	}
+	source := string(src)
+
+	var docs []Document
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			for i, expr := range value.Values {
+				lit, ok := expr.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING || i >= len(value.Names) {
+					continue
+				}
+				text, err := strconv.Unquote(lit.Value)
+				if err != nil || !looksLikeDocument(text) {
+					continue
+				}
+				start := fset.Position(lit.Pos()).Offset
+				var b builder
+				if lit.Value[0] == '`' || !strings.Contains(lit.Value, `\`) {
+					// The value is the bytes between the quotes, so every
+					// offset maps one to one. A raw string drops a carriage return,
+					// which moves what follows one column at worst.
+					b.exact(start+1, []byte(text))
+				} else {
+					b.at(start, []byte(text))
+				}
+				line, column := parser.Position(source, start+1)
+				docs = append(docs, Document{
+					Text: string(b.text), Name: value.Names[i].Name,
+					Line: line, Column: column,
+					source: source, segments: b.segments,
+				})
+			}
+		}
+	}
+	return docs, nil
+}
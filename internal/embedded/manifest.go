@@ -0,0 +1,404 @@
+package embedded
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/romshark/jscan/v2"
+
+	"github.com/romshark/gqlhash/v2/internal/unicodeesc"
+	"github.com/romshark/gqlhash/v2/parser"
+)
+
+var (
+	// ErrNotManifest is a JSON file of no shape [Extract] reads a manifest in.
+	ErrNotManifest = errors.New("not a persisted-query manifest: expected an Apollo " +
+		"manifest, or an object mapping ids to documents as Relay writes one")
+
+	// ErrNoDocument is a manifest entry carrying neither a document nor a
+	// hash, which leaves nothing to allow.
+	ErrNoDocument = errors.New("no document and no hash")
+)
+
+// apolloFormat is the format an Apollo persisted-query manifest names itself.
+const apolloFormat = "apollo-persisted-query-manifest"
+
+// IsManifest reports whether name is a persisted-query manifest [Extract]
+// reads, by its extension.
+func IsManifest(name string) bool {
+	switch filepath.Ext(name) {
+	case ".json", ".jsonl":
+		return true
+	}
+	return false
+}
+
+// member is a value of a JSON object, or an element of an array where key is
+// empty, and where it's at: start and end enclose a string's quotes, end is -1
+// for an object or an array.
+type member struct {
+	key        string
+	keyStart   int
+	valueType  jscan.ValueType
+	start, end int
+}
+
+// element is an element of the operations of an Apollo manifest.
+type element struct {
+	member
+	members []member
+}
+
+// extractManifest reads a persisted-query manifest, every entry one document
+// named by its id:
+//
+//   - An Apollo manifest: {"format": "apollo-persisted-query-manifest",
+//     "version": 1, "operations": [{"id": …, "body": …}, …]}.
+//   - A Relay one, an object mapping each id to its document.
+//   - A .jsonl file, an object per line whose query member is the document,
+//     named by its id member where it has one.
+//
+// An entry carrying a hash and no document, an Apollo operation of an id
+// alone or a .jsonl line of an id or an extensions.persistedQuery.sha256Hash,
+// is read as its [Document.Hash]. One carrying neither is an [ErrNoDocument]
+// of its own, and the rest are read.
+func extractManifest(name string, src []byte) ([]Document, []error) {
+	source := string(src)
+	if filepath.Ext(name) != ".jsonl" {
+		top, members, ops, err := scanManifest(src, 0)
+		switch {
+		case err != nil:
+			return nil, []error{locate(name, source, top.start, err)}
+		case top.valueType != jscan.ValueTypeObject:
+			return nil, []error{locate(name, source, top.start, ErrNotManifest)}
+		}
+		m := manifest{name: name, src: src, source: source}
+		if format, ok := find(members, "format"); ok {
+			return m.apollo(top.start, format, members, ops)
+		}
+		return m.relay(members)
+	}
+
+	m := manifest{name: name, src: src, source: source}
+	var docs []Document
+	var errs []error
+	for offset := 0; offset < len(src); {
+		line := src[offset:]
+		next := len(src)
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, next = line[:i], offset+i+1
+		}
+		at := offset
+		offset = next
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		d, err := m.line(at, len(line))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		docs = append(docs, d)
+	}
+	return docs, errs
+}
+
+// manifest is a manifest file being read.
+type manifest struct {
+	name   string
+	src    []byte
+	source string
+}
+
+func (m manifest) errorAt(offset int, err error) error {
+	return locate(m.name, m.source, offset, err)
+}
+
+// apollo reads the operations of an Apollo manifest.
+func (m manifest) apollo(
+	top int, format member, members []member, ops []element,
+) ([]Document, []error) {
+	for _, k := range []string{"format", "version", "operations"} {
+		if at, twice := duplicate(members, k); twice {
+			return nil, []error{m.errorAt(at, fmt.Errorf("%s given twice", k))}
+		}
+	}
+	if format.valueType != jscan.ValueTypeString ||
+		string(unquote(m.src, format).text) != apolloFormat {
+		return nil, []error{m.errorAt(format.start,
+			fmt.Errorf("format: expected %q", apolloFormat))}
+	}
+	if version, ok := find(members, "version"); ok &&
+		(version.valueType != jscan.ValueTypeNumber ||
+			string(m.src[version.start:version.end]) != "1") {
+		return nil, []error{m.errorAt(version.start,
+			errors.New("version: this reads version 1"))}
+	}
+	switch operations, ok := find(members, "operations"); {
+	case !ok:
+		return nil, []error{m.errorAt(top, errors.New("no operations"))}
+	case operations.valueType != jscan.ValueTypeArray:
+		return nil, []error{m.errorAt(operations.start,
+			errors.New("operations: expected an array"))}
+	}
+
+	var docs []Document
+	var errs []error
+	for _, op := range ops {
+		d, err := m.entry(op.member, op.members, "body")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		docs = append(docs, d)
+	}
+	return docs, errs
+}
+
+// relay reads a manifest mapping each id to a document. A value of another
+// type makes the file no such manifest, and one read as such anyway could be
+// something else entirely: a package.json maps names to strings too, but
+// not only that.
+func (m manifest) relay(members []member) ([]Document, []error) {
+	for _, v := range members {
+		if v.valueType != jscan.ValueTypeString {
+			return nil, []error{m.errorAt(v.start, ErrNotManifest)}
+		}
+	}
+	docs := make([]Document, 0, len(members))
+	for _, v := range members {
+		docs = append(docs, m.document(v, v.key))
+	}
+	return docs, nil
+}
+
+// line reads the line of a .jsonl manifest at offset, size bytes long.
+func (m manifest) line(offset, size int) (Document, error) {
+	top, members, _, err := scanManifest(m.src[offset:offset+size], offset)
+	if err != nil {
+		return Document{}, m.errorAt(top.start, err)
+	}
+	if v, ok := persistedHash(m.src[offset:offset+size], offset); ok {
+		members = append(members, v)
+	}
+	return m.entry(top, members, "query")
+}
+
+// entry is the document of an object of a manifest, the string under key,
+// named by the string under id where there is one. Without one, it's the hash
+// the object carries: the sha256Hash of its persisted query, or its id.
+func (m manifest) entry(object member, members []member, key string) (Document, error) {
+	if object.valueType != jscan.ValueTypeObject {
+		return Document{}, m.errorAt(object.start, errors.New("expected an object"))
+	}
+	for _, k := range []string{key, "id"} {
+		if at, twice := duplicate(members, k); twice {
+			return Document{}, m.errorAt(at, fmt.Errorf("%s given twice", k))
+		}
+	}
+	var id string
+	if v, ok := find(members, "id"); ok && v.valueType == jscan.ValueTypeString {
+		id = string(unquote(m.src, v).text)
+	}
+	v, ok := find(members, key)
+	switch {
+	case !ok:
+		return m.hashOnly(object, members, id)
+	case v.valueType != jscan.ValueTypeString:
+		return Document{}, m.errorAt(v.start, fmt.Errorf("%s: expected a string", key))
+	}
+	return m.document(v, id), nil
+}
+
+// hashOnly is the entry of the object of a manifest that carries no document,
+// named id, by the hash it carries instead.
+func (m manifest) hashOnly(object member, members []member, id string) (Document, error) {
+	v, ok := find(members, persistedHashKey)
+	if !ok {
+		v, ok = find(members, "id")
+	}
+	switch {
+	case !ok:
+		return Document{}, m.errorAt(object.start, ErrNoDocument)
+	case v.valueType != jscan.ValueTypeString:
+		return Document{}, m.errorAt(v.start, fmt.Errorf("%s: expected a string", v.key))
+	}
+	d := m.document(v, id)
+	d.Hash, d.Text, d.segments = d.Text, "", nil
+	return d, nil
+}
+
+// persistedHashKey is the member [persistedHash] adds to a line's.
+const persistedHashKey = "extensions.persistedQuery.sha256Hash"
+
+// persistedHash is the hash an automatic persisted query carries, the
+// extensions.persistedQuery.sha256Hash member of the object src, which begins
+// at offset base of its file, if it has one.
+func persistedHash(src []byte, base int) (v member, ok bool) {
+	jscan.Scan(src, func(i *jscan.Iterator[[]byte]) bool {
+		if i.Level() != 3 {
+			return false
+		}
+		i.ViewPointer(func(p []byte) {
+			ok = string(p) == "/extensions/persistedQuery/sha256Hash"
+		})
+		if ok {
+			v = member{
+				key: persistedHashKey, keyStart: base + i.KeyIndex(),
+				valueType: i.ValueType(), start: base + i.ValueIndex(), end: -1,
+			}
+			if end := i.ValueIndexEnd(); end >= 0 {
+				v.end = base + end
+			}
+		}
+		return ok
+	})
+	return v, ok
+}
+
+// document is the document the string v holds, named name.
+func (m manifest) document(v member, name string) Document {
+	b := unquote(m.src, v)
+	line, column := parser.Position(m.source, v.start+1)
+	return Document{
+		Text: string(b.text), Name: name, Line: line, Column: column,
+		source: m.source, segments: b.segments,
+	}
+}
+
+// unquote unescapes the JSON string v of src, every escape mapped onto where
+// it's written and every other run onto itself.
+func unquote(src []byte, v member) *builder {
+	b := new(builder)
+	run := v.start + 1
+	for i := run; i < v.end-1; {
+		if src[i] != '\\' {
+			i++
+			continue
+		}
+		b.exact(run, src[run:i])
+		escape, size := unescape(src[i : v.end-1])
+		b.at(i, escape)
+		i += size
+		run = i
+	}
+	b.exact(run, src[run:v.end-1])
+	return b
+}
+
+// unescape decodes the escape s begins with, which jscan has validated, and
+// returns it and how many bytes of s it took.
+func unescape(s []byte) (decoded []byte, size int) {
+	switch s[1] {
+	case 'b':
+		return []byte{'\b'}, 2
+	case 'f':
+		return []byte{'\f'}, 2
+	case 'n':
+		return []byte{'\n'}, 2
+	case 'r':
+		return []byte{'\r'}, 2
+	case 't':
+		return []byte{'\t'}, 2
+	case 'u':
+	default:
+		// \" \\ \/
+		return s[1:2], 2
+	}
+	r, size := unicodeesc.Value(s[2:6]), 6
+	if unicodeesc.IsLeadingSurrogate(r) && len(s) >= 12 && s[6] == '\\' && s[7] == 'u' {
+		if t := unicodeesc.Value(s[8:12]); unicodeesc.IsTrailingSurrogate(t) {
+			r, size = unicodeesc.Pair(r, t), 12
+		}
+	}
+	if !unicodeesc.IsScalarValue(r) {
+		// A lone surrogate, which encoding/json decodes the same way.
+		r = utf8.RuneError
+	}
+	return utf8.AppendRune(nil, rune(r)), size
+}
+
+// scanManifest reads the JSON value src, which begins at offset base of its
+// file: top, the members of top where it's an object, and the elements of its
+// operations member, each with its own members. Where src isn't JSON, top
+// begins where it fails.
+func scanManifest(
+	src []byte, base int,
+) (top member, members []member, ops []element, err error) {
+	var within string
+	errScan := jscan.Scan(src, func(i *jscan.Iterator[[]byte]) bool {
+		m := member{
+			keyStart: base + i.KeyIndex(), valueType: i.ValueType(),
+			start: base + i.ValueIndex(), end: i.ValueIndexEnd(),
+		}
+		if m.end >= 0 {
+			m.end += base
+		}
+		if i.KeyIndex() >= 0 {
+			key := member{start: i.KeyIndex(), end: i.KeyIndexEnd()}
+			m.key = string(unquote(src, key).text)
+		}
+		switch i.Level() {
+		case 0:
+			top = m
+		case 1:
+			members, within = append(members, m), m.key
+		case 2:
+			if within == "operations" && i.ArrayIndex() >= 0 {
+				ops = append(ops, element{member: m})
+			}
+		case 3:
+			if within == "operations" && len(ops) > 0 && i.KeyIndex() >= 0 {
+				ops[len(ops)-1].members = append(ops[len(ops)-1].members, m)
+			}
+		}
+		return false
+	})
+	if errScan.IsErr() {
+		return member{start: base + errScan.Index}, nil, nil, jsonError(errScan.Code)
+	}
+	return top, members, ops, nil
+}
+
+// jsonError is the JSON syntax error code says, the offset left to its caller.
+func jsonError(code jscan.ErrorCode) error {
+	switch code {
+	case jscan.ErrorCodeInvalidEscape:
+		return errors.New("invalid JSON: invalid escape")
+	case jscan.ErrorCodeIllegalControlChar:
+		return errors.New("invalid JSON: illegal control character")
+	case jscan.ErrorCodeUnexpectedEOF:
+		return errors.New("invalid JSON: unexpected end")
+	case jscan.ErrorCodeMalformedNumber:
+		return errors.New("invalid JSON: malformed number")
+	}
+	return errors.New("invalid JSON: unexpected token")
+}
+
+// find is the first of members under key.
+func find(members []member, key string) (member, bool) {
+	for _, m := range members {
+		if m.key == key {
+			return m, true
+		}
+	}
+	return member{}, false
+}
+
+// duplicate reports where key is given a second time among members, if it is.
+func duplicate(members []member, key string) (at int, twice bool) {
+	seen := false
+	for _, m := range members {
+		if m.key != key {
+			continue
+		}
+		if seen {
+			return m.keyStart, true
+		}
+		seen = true
+	}
+	return 0, false
+}
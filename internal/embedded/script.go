@@ -0,0 +1,379 @@
+package embedded
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/romshark/gqlhash/v2/internal/unicodeesc"
+	"github.com/romshark/gqlhash/v2/parser"
+)
+
+// template is one gql or graphql tagged template literal.
+type template struct {
+	// name is the identifier it's bound to, empty for none.
+	name string
+
+	// start is the offset of its first byte of text, past the backtick.
+	start int
+
+	parts []part
+}
+
+// part is a run of text of a template, or an interpolation where expr is set.
+type part struct {
+	text builder
+
+	// expr is the interpolation as written, trimmed, and at is the offset of
+	// its ${. Empty for a run of text.
+	expr string
+	at   int
+}
+
+// scanner reads a TypeScript or JavaScript file far enough to find its template
+// literals: it skips comments and strings, and follows the interpolations of a
+// template into the code they hold, which may hold templates in turn.
+//
+// It's no parser. A regular expression literal holding a quote or a backtick
+// throws it off, which a file holding documents is unlikely to carry.
+type scanner struct {
+	src       []byte
+	templates []*template
+}
+
+// extractScript reads the tagged templates of a TypeScript or JavaScript file.
+func extractScript(name string, src []byte) ([]Document, []error) {
+	s := scanner{src: src}
+	source := string(src)
+	if at, err := s.code(0, false); err != nil {
+		return nil, []error{locate(name, source, at, err)}
+	}
+
+	byName := make(map[string]*template, len(s.templates))
+	// interpolated are the templates another one names, which are parts of that
+	// one rather than documents of their own.
+	interpolated := make(map[string]bool)
+	for _, t := range s.templates {
+		if t.name != "" {
+			byName[t.name] = t
+		}
+		for _, p := range t.parts {
+			if p.expr != "" {
+				interpolated[p.expr] = true
+			}
+		}
+	}
+
+	var docs []Document
+	var errs []error
+	for _, t := range s.templates {
+		if t.name != "" && interpolated[t.name] {
+			continue
+		}
+		r := resolver{name: name, source: source, byName: byName,
+			seen: map[string]bool{}, open: map[string]bool{}}
+		b, err := r.resolve(t)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		line, column := parser.Position(source, t.start)
+		docs = append(docs, Document{
+			Text: string(b.text), Name: t.name, Line: line, Column: column,
+			source: source, segments: b.segments,
+		})
+	}
+	return docs, errs
+}
+
+// resolver puts one document together out of its template and what that names.
+type resolver struct {
+	name, source string
+	byName       map[string]*template
+
+	// seen are the templates already in the document, which a second
+	// interpolation adds nothing of. open are the ones being resolved,
+	// which an interpolation of is a cycle.
+	seen, open map[string]bool
+}
+
+func (r *resolver) resolve(t *template) (*builder, error) {
+	r.open[t.name] = true
+	defer delete(r.open, t.name)
+
+	var b builder
+	for _, p := range t.parts {
+		if p.expr == "" {
+			b.insert(&p.text)
+			continue
+		}
+		if !isIdentifier(p.expr) {
+			return nil, locate(r.name, r.source, p.at, ErrInterpolation)
+		}
+		if r.open[p.expr] {
+			return nil, locate(r.name, r.source, p.at, ErrCycle)
+		}
+		named, ok := r.byName[p.expr]
+		if !ok {
+			return nil, locate(r.name, r.source, p.at, ErrUnresolved)
+		}
+		if r.seen[p.expr] {
+			continue
+		}
+		r.seen[p.expr] = true
+		inner, err := r.resolve(named)
+		if err != nil {
+			return nil, err
+		}
+		b.insert(inner)
+	}
+	return &b, nil
+}
+
+// code skips code from offset i on. Nested, it's the expression of an
+// interpolation and ends at the brace closing it, whose offset it returns;
+// otherwise it ends with the file. The error comes with the offset it's at.
+func (s *scanner) code(i int, nested bool) (int, error) {
+	depth := 0
+	for i < len(s.src) {
+		switch c := s.src[i]; {
+		case c == '/' && i+1 < len(s.src) && s.src[i+1] == '/':
+			for i < len(s.src) && s.src[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(s.src) && s.src[i+1] == '*':
+			end := strings.Index(string(s.src[i+2:]), "*/")
+			if end < 0 {
+				return len(s.src), nil
+			}
+			i += 2 + end + 2
+		case c == '\'' || c == '"':
+			i = s.quoted(i)
+		case c == '`':
+			end, err := s.template(i)
+			if err != nil {
+				return end, err
+			}
+			i = end
+		case c == '{':
+			depth++
+			i++
+		case c == '}':
+			if nested && depth == 0 {
+				return i, nil
+			}
+			depth--
+			i++
+		default:
+			i++
+		}
+	}
+	if nested {
+		return len(s.src), ErrUnterminated
+	}
+	return i, nil
+}
+
+// quoted skips the string literal whose quote is at i. A string ends at its
+// line where it isn't closed, which is a syntax error of the file and
+// no reason to read the rest of it as a string.
+func (s *scanner) quoted(i int) int {
+	quote := s.src[i]
+	for i++; i < len(s.src); i++ {
+		switch s.src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			return i
+		}
+	}
+	return i
+}
+
+// template reads the template literal whose backtick is at i and returns the
+// offset past its closing one. It's kept where it's tagged gql or graphql.
+func (s *scanner) template(i int) (int, error) {
+	open := i
+	t := &template{start: i + 1}
+	var text builder
+	flush := func() {
+		if len(text.text) > 0 {
+			t.parts = append(t.parts, part{text: text})
+			text = builder{}
+		}
+	}
+
+	i++
+	run := i // The start of the bytes to take as they are.
+	for i < len(s.src) {
+		switch c := s.src[i]; {
+		case c == '`':
+			text.exact(run, s.src[run:i])
+			flush()
+			if tag, name := s.tag(open); tag {
+				t.name = name
+				s.templates = append(s.templates, t)
+			}
+			return i + 1, nil
+		case c == '\\':
+			text.exact(run, s.src[run:i])
+			value, next := cook(s.src, i)
+			text.at(i, value)
+			i, run = next, next
+		case c == '$' && i+1 < len(s.src) && s.src[i+1] == '{':
+			text.exact(run, s.src[run:i])
+			flush()
+			end, err := s.code(i+2, true)
+			if err != nil {
+				return end, err
+			}
+			t.parts = append(t.parts, part{
+				expr: strings.TrimSpace(string(s.src[i+2 : end])), at: i,
+			})
+			i = end + 1
+			run = i
+		default:
+			i++
+		}
+	}
+	return open, ErrUnterminated
+}
+
+// tag reports whether the template whose backtick is at i is tagged gql or
+// graphql, and the identifier it's bound to, if any:
+//
+//	const Name = gql`…`
+//	export const Name: DocumentNode = graphql`…`
+func (s *scanner) tag(i int) (tagged bool, name string) {
+	j := skipSpaceBack(s.src, i)
+	tag, j := identifierBack(s.src, j)
+	if tag != "gql" && tag != "graphql" {
+		return false, ""
+	}
+	// A member such as client.gql is some other function of that name.
+	if j > 0 && s.src[j-1] == '.' {
+		return false, ""
+	}
+
+	j = skipSpaceBack(s.src, j)
+	if j == 0 || s.src[j-1] != '=' {
+		return true, ""
+	}
+	j--
+	// Not the second half of ==, <=, >=, !=, => and their kin.
+	if j > 0 && strings.IndexByte("=!<>+-*/%&|^?", s.src[j-1]) >= 0 {
+		return true, ""
+	}
+	j = skipSpaceBack(s.src, j)
+	name, j = identifierBack(s.src, j)
+	if name == "" {
+		return true, ""
+	}
+	// A type annotation: the identifier is the type, and the one before the
+	// colon is what's bound.
+	if k := skipSpaceBack(s.src, j); k > 0 && s.src[k-1] == ':' {
+		name, _ = identifierBack(s.src, skipSpaceBack(s.src, k-1))
+	}
+	return true, name
+}
+
+// skipSpaceBack returns the offset after the last byte before i that isn't
+// whitespace.
+func skipSpaceBack(src []byte, i int) int {
+	for i > 0 {
+		switch src[i-1] {
+		case ' ', '\t', '\n', '\r':
+			i--
+			continue
+		}
+		break
+	}
+	return i
+}
+
+// identifierBack returns the identifier ending right before i and its offset,
+// or "" and i where there's none.
+func identifierBack(src []byte, i int) (string, int) {
+	end := i
+	for i > 0 && (isNameContinue(src[i-1]) || src[i-1] == '$') {
+		i--
+	}
+	if i == end || src[i] >= '0' && src[i] <= '9' {
+		return "", end
+	}
+	return string(src[i:end]), i
+}
+
+// isIdentifier reports whether s is an identifier of ASCII, which is all
+// an ${Name} this resolves can be.
+func isIdentifier(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for i := range len(s) {
+		if !isNameContinue(s[i]) && s[i] != '$' {
+			return false
+		}
+	}
+	return true
+}
+
+// cook returns the value of the escape sequence at i of a template literal and
+// the offset past it, as a template tag reads its strings. An escape that is
+// none is the character itself, which is what the cooked string holds too.
+func cook(src []byte, i int) (value []byte, next int) {
+	if i+1 >= len(src) {
+		return nil, len(src)
+	}
+	switch c := src[i+1]; c {
+	case 'n':
+		return []byte{'\n'}, i + 2
+	case 'r':
+		return []byte{'\r'}, i + 2
+	case 't':
+		return []byte{'\t'}, i + 2
+	case 'b':
+		return []byte{'\b'}, i + 2
+	case 'f':
+		return []byte{'\f'}, i + 2
+	case 'v':
+		return []byte{'\v'}, i + 2
+	case '0':
+		return []byte{0}, i + 2
+	case '\r':
+		// A line continuation is no character at all, CRLF included.
+		if i+2 < len(src) && src[i+2] == '\n' {
+			return nil, i + 3
+		}
+		return nil, i + 2
+	case '\n':
+		return nil, i + 2
+	case 'x':
+		if i+3 < len(src) && unicodeesc.IsHexDigit(src[i+2]) &&
+			unicodeesc.IsHexDigit(src[i+3]) {
+			v := unicodeesc.DigitValue(src[i+2])<<4 | unicodeesc.DigitValue(src[i+3])
+			return utf8.AppendRune(nil, rune(v)), i + 4
+		}
+	case 'u':
+		if i+2 < len(src) && src[i+2] == '{' {
+			end := i + 3
+			var v uint32
+			for end < len(src) && unicodeesc.IsHexDigit(src[end]) && v <= utf8.MaxRune {
+				v = v<<4 | unicodeesc.DigitValue(src[end])
+				end++
+			}
+			if end < len(src) && src[end] == '}' && end > i+3 {
+				return utf8.AppendRune(nil, rune(v)), end + 1
+			}
+			break
+		}
+		if i+6 <= len(src) {
+			if v, ok := unicodeesc.Hex4(src[i+2:]); ok {
+				return utf8.AppendRune(nil, rune(v)), i + 6
+			}
+		}
+	}
+	_, size := utf8.DecodeRune(src[i+1:])
+	return src[i+1 : i+1+size], i + 1 + size
+}
@@ -0,0 +1,333 @@
+// Package jsonrequest reads the documents of GraphQL-over-HTTP request bodies:
+// the query member of a request object, or of every one of a batch, and the
+// operationName member beside it.
+//
+// The proxy reads the bodies it forwards through here, and the hashing command
+// its -input=json and -input=jsonl, so the two find the same documents
+// in the same body.
+package jsonrequest
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/romshark/jscan/v2"
+
+	"github.com/romshark/gqlhash/v2/internal/unicodeesc"
+)
+
+var (
+	ErrNoQuery       = errors.New("no query")
+	ErrMalformedJSON = errors.New("malformed JSON")
+
+	// ErrBatch is a batch of requests where none is expected.
+	ErrBatch = errors.New("batched request")
+
+	// ErrBatchTooLarge is a batch carrying more documents than maxBatch allows,
+	// see [Extract].
+	ErrBatchTooLarge = errors.New("too many documents in the batch")
+
+	// ErrBatchElementNoDocument is an element of a batch that carries no document.
+	// The cap counts documents, so an element without one is neither counted
+	// against it nor looked up in the allowlist: a batch of one allowed document
+	// and twenty thousand numbers would otherwise reach the API whole.
+	//
+	// Document rather than query in the message: the member is named query,
+	// but it carries the document whatever operation that runs, so a client
+	// told it carried no query would read it as one told to send no mutation.
+	ErrBatchElementNoDocument = errors.New("a batch element carries no document")
+
+	ErrInvalidEscape  = errors.New("invalid escape sequence in query")
+	ErrQueryCollision = errors.New(`naming collision on field "query"`)
+
+	// ErrOperationNameCollision is [ErrQueryCollision] of the operationName
+	// member, which picks what of the document runs.
+	ErrOperationNameCollision = errors.New(`naming collision on field "operationName"`)
+)
+
+// Span is the range of a document within a request body, and of the
+// operationName member of its request.
+type Span struct {
+	Start, End int
+
+	// OperationStart and OperationEnd are the range of the raw JSON string
+	// contents of operationName, escapes included. Empty where the request
+	// names no operation: no member, null, an empty string, or no string at all.
+	OperationStart, OperationEnd int
+}
+
+// HasOperation reports whether the request of s names an operation.
+func (s Span) HasOperation() bool { return s.OperationEnd > s.OperationStart }
+
+// Extract finds the query member of every request in body and appends its
+// [Span] to dst. body is one request object, or an array of them where maxBatch is
+// 1 or more. A span points at the raw JSON string contents, escapes included.
+//
+// maxBatch is how many documents one body may carry, the proxy's
+// -server.max-batch, 0 for no batching at all, which makes an array [ErrBatch]. A batch past it is
+// [ErrBatchTooLarge] and the scan stops there, so a body holding tens of
+// thousands of documents costs the cap and not the body.
+//
+// The operationName member of a request is read into the Span of its document,
+// wherever it stands in the object. Named twice, it's [ErrOperationNameCollision]
+// for the reason the query member is.
+//
+// Every element of a batch has to carry a document of its own,
+// or it's [ErrBatchElementNoDocument] and the scan stops there too.
+// The cap counts documents, so an element carrying none is one the cap never sees,
+// see that error.
+//
+// A request object naming the query member twice is [ErrQueryCollision] rather
+// than two documents: which one an API runs is the API's business,
+// and checking one while the API runs the other allows a document nobody read.
+// See [isKey] for what counts as the same name.
+//
+// Nothing is copied: a Span is a range within body.
+func Extract(dst []Span, body []byte, maxBatch int) ([]Span, error) {
+	// The member level of a lone request object, and one deeper within an array.
+	level := 1
+
+	// One word, since the callback runs per member and every variable it closes
+	// over is a load and a store there.
+	const (
+		flagFound         = 1 << iota // A document has been found.
+		flagSeen                      // This request object names the member.
+		flagCollision                 // It names it twice.
+		flagTooMany                   // The batch carries more than maxBatch.
+		flagInElement                 // An element of a batch has been entered.
+		flagElementFound              // That element carries a document.
+		flagElementEmpty              // One carried none.
+		flagNameSeen                  // This request object names operationName.
+		flagNameCollision             // It names it twice.
+	)
+	var flags uint16
+
+	// The operationName of the request object being read, kept until its
+	// document is found where it comes first.
+	var nameStart, nameEnd int
+
+	errScan := jscan.Scan(body, func(i *jscan.Iterator[[]byte]) (err bool) {
+		if i.Level() == 0 {
+			// The outermost value decides whether this is a batch.
+			if i.ValueType() == jscan.ValueTypeArray {
+				if maxBatch < 1 {
+					return true
+				}
+				level = 2
+			}
+			return false
+		}
+		// Each request of a batch is named once of its own, and carries one of its own:
+		// the element that just ended is checked as the next begins,
+		// and the last one after the scan.
+		if level == 2 && i.Level() == 1 {
+			if flags&flagInElement != 0 && flags&flagElementFound == 0 {
+				flags |= flagElementEmpty
+				return true
+			}
+			flags = flags&^(flagSeen|flagElementFound|flagNameSeen) | flagInElement
+			nameStart, nameEnd = 0, 0
+			return false
+		}
+		if i.Level() != level {
+			return false
+		}
+		if isKey(i.Key(), "operationname") {
+			if flags&flagNameSeen != 0 {
+				flags |= flagNameCollision
+				return true
+			}
+			flags |= flagNameSeen
+			if i.ValueType() != jscan.ValueTypeString {
+				return false
+			}
+			nameStart, nameEnd = i.ValueIndex()+1, i.ValueIndexEnd()-1
+			if flags&flagSeen != 0 && flags&flagElementFound != 0 {
+				// The document came first, so it's the last span.
+				dst[len(dst)-1].OperationStart = nameStart
+				dst[len(dst)-1].OperationEnd = nameEnd
+			}
+			return false
+		}
+		if !isKey(i.Key(), "query") {
+			return false
+		}
+		if flags&flagSeen != 0 {
+			flags |= flagCollision
+			return true
+		}
+		flags |= flagSeen
+		// Only a string is a document, but the name counts either way:
+		// an API may still run something out of a member this reads nothing from,
+		// so a second one beside it is a collision.
+		if i.ValueType() != jscan.ValueTypeString {
+			return false
+		}
+		// ValueIndex and ValueIndexEnd include the quotes.
+		dst = append(dst, Span{
+			Start: i.ValueIndex() + 1, End: i.ValueIndexEnd() - 1,
+			OperationStart: nameStart, OperationEnd: nameEnd,
+		})
+		flags |= flagFound | flagElementFound
+		// One past the cap is enough to refuse, so the rest of the array is never
+		// read: a megabyte of documents costs what the cap allows plus one.
+		// Only within an array — a lone request object is one document whatever
+		// maxBatch says.
+		if level == 2 && len(dst) > maxBatch {
+			flags |= flagTooMany
+			return true
+		}
+		return false
+	})
+
+	if errScan.IsErr() {
+		if errScan.Code == jscan.ErrorCodeCallback {
+			// The callback breaks for an unexpected batch, for a collision,
+			// for a batch past the cap, and for an element carrying no document.
+			switch {
+			case flags&flagCollision != 0:
+				return dst, ErrQueryCollision
+			case flags&flagNameCollision != 0:
+				return dst, ErrOperationNameCollision
+			case flags&flagTooMany != 0:
+				return dst, ErrBatchTooLarge
+			case flags&flagElementEmpty != 0:
+				return dst, ErrBatchElementNoDocument
+			}
+			return dst, ErrBatch
+		}
+		return dst, ErrMalformedJSON
+	}
+	// The last element of a batch has no element after it to be checked by,
+	// so it's checked here. An empty array is no element at all and carries no
+	// document either, which the next line answers.
+	if flags&flagInElement != 0 && flags&flagElementFound == 0 {
+		return dst, ErrBatchElementNoDocument
+	}
+	if flags&flagFound == 0 {
+		return dst, ErrNoQuery
+	}
+	return dst, nil
+}
+
+// Unescape returns the value of the JSON string contents s.
+// Without an escape sequence it returns s itself,
+// otherwise it appends the value to scratch and returns that.
+func Unescape(scratch, s []byte) (value, newScratch []byte, err error) {
+	i := bytes.IndexByte(s, '\\')
+	if i < 0 {
+		return s, scratch, nil
+	}
+
+	scratch = append(scratch[:0], s[:i]...)
+	for i < len(s) {
+		if s[i] != '\\' {
+			scratch = append(scratch, s[i])
+			i++
+			continue
+		}
+		if i+1 >= len(s) {
+			return nil, scratch, ErrInvalidEscape
+		}
+		switch s[i+1] {
+		case '"', '\\', '/':
+			scratch = append(scratch, s[i+1])
+			i += 2
+		case 'b':
+			scratch = append(scratch, '\b')
+			i += 2
+		case 'f':
+			scratch = append(scratch, '\f')
+			i += 2
+		case 'n':
+			scratch = append(scratch, '\n')
+			i += 2
+		case 'r':
+			scratch = append(scratch, '\r')
+			i += 2
+		case 't':
+			scratch = append(scratch, '\t')
+			i += 2
+		case 'u':
+			if i+6 > len(s) {
+				return nil, scratch, ErrInvalidEscape
+			}
+			v, ok := unicodeesc.Hex4(s[i+2:])
+			if !ok {
+				return nil, scratch, ErrInvalidEscape
+			}
+			i += 6
+			if unicodeesc.IsLeadingSurrogate(v) {
+				// A leading surrogate takes a trailing one to make a rune.
+				if i+6 > len(s) || s[i] != '\\' || s[i+1] != 'u' {
+					return nil, scratch, ErrInvalidEscape
+				}
+				t, ok := unicodeesc.Hex4(s[i+2:])
+				if !ok || !unicodeesc.IsTrailingSurrogate(t) {
+					return nil, scratch, ErrInvalidEscape
+				}
+				v = unicodeesc.Pair(v, t)
+				i += 6
+			}
+			scratch = utf8.AppendRune(scratch, rune(v))
+		default:
+			return nil, scratch, ErrInvalidEscape
+		}
+	}
+	return scratch, scratch, nil
+}
+
+// isKey reports whether key names the member want of a request object, which
+// is spelled in lower case. key carries the quotes around it, which this drops.
+//
+// The name is read the way a JSON decoder reads it, which makes the answer the
+// same as the API's. [encoding/json] matches a struct field without case,
+// so an API reading the body into one takes "queRY" for the query; and every decoder
+// unescapes a key before matching, so "quer\u0079" is that member spelled
+// another way. Reading only the exact spelling would leave
+//
+//	{"query":"<allowed>","quer\u0079":"<anything>"}
+//
+// checked against the first and executed as the second. Taking every spelling
+// makes the second a collision, which [Extract] refuses — and an API that
+// matches exactly runs nothing of that member, so refusing is conservative
+// either way. The same holds of operationName.
+func isKey(key []byte, want string) bool {
+	// Only \uXXXX spells a letter, so a name unescaping to want is units of
+	// one byte or six, each escape five bytes longer: for query every length
+	// from 5 to 30 in steps of five. Any other length keeps an ordinary member
+	// off the path below.
+	const escape = len(`\u0071`)
+	escapedMax := escape * len(want)
+
+	if len(key) < 2 {
+		return false
+	}
+	name := key[1 : len(key)-1] // Without the quotes.
+	if len(name) != len(want) {
+		if len(name) < len(want) || len(name) > escapedMax ||
+			(len(name)-len(want))%(escape-1) != 0 {
+			return false
+		}
+		if bytes.IndexByte(name, '\\') < 0 {
+			return false
+		}
+		// An escape costs a buffer, paid only by a name that could still be it.
+		value, _, err := Unescape(make([]byte, 0, escapedMax), name)
+		if err != nil || len(value) != len(want) {
+			return false
+		}
+		name = value
+	}
+
+	for i := range want {
+		// Compared without case, the way a struct field is matched.
+		// Only a letter of want lowercases onto one, so a backslash left in a name of
+		// the plain length fails here rather than earlier.
+		if name[i]|0x20 != want[i] {
+			return false
+		}
+	}
+	return true
+}
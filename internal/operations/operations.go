@@ -0,0 +1,259 @@
+// Package operations splits a GraphQL document into its operations, each as
+// a client sends it alone.
+//
+// A document holding one operation is that operation's whole: it's what a
+// client sends, and what the proxy hashes as a file of the allowlist.
+// One holding several is no request any client sends, so each is taken as a
+// client sends it alone: the operation, then every fragment it spreads,
+// directly or not, in the order of the document. The language server shows
+// the hash of each, and gen-go generates it.
+//
+// A document is split where the hash takes it and nowhere else: it's read by
+// gqlhash's own parser, descriptions and all, not by one that refuses some of
+// what the hash takes.
+package operations
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/romshark/gqlhash/v2/parser"
+)
+
+// Operation is an operation of a document.
+type Operation struct {
+	// Name is empty for an anonymous operation. Type is query, mutation or
+	// subscription.
+	Name string
+	Type string
+
+	// Offset is the byte offset of the document the operation begins at, its
+	// keyword or, for the query shorthand, its selection set.
+	Offset int
+
+	// Document is what a client sends for the operation alone, cut out of the
+	// document as it's written, so formatting, comments and descriptions are
+	// kept.
+	Document string
+}
+
+// Split returns the operations of document, in its order, and the error of
+// the parser where it refuses the document, which carries the offset it
+// stopped at.
+func Split(document string) ([]Operation, error) {
+	// Nothing nests deeper than a document is long, so no depth refuses one
+	// the hash took under whatever limit it was given.
+	options := parser.Options{DepthLimit: len(document) + 1}
+	if r := parser.Parse(io.Discard, options, document); r.IsErr() {
+		return nil, fmt.Errorf("%w (offset %d)", r.Err, r.ErrOffset)
+	}
+
+	defs := definitions(document)
+	var ops []Operation
+	for _, d := range defs {
+		if d.kind != "fragment" {
+			ops = append(ops, Operation{
+				Name: d.name, Type: d.kind, Offset: d.offset, Document: document,
+			})
+		}
+	}
+	if len(ops) < 2 {
+		return ops, nil
+	}
+
+	fragments := map[string]*definition{}
+	for i, d := range defs {
+		if _, seen := fragments[d.name]; d.kind == "fragment" && !seen {
+			fragments[d.name] = &defs[i]
+		}
+	}
+	i := 0
+	for _, d := range defs {
+		if d.kind == "fragment" {
+			continue
+		}
+		used := map[string]bool{}
+		spreads(fragments, d.spreads, used)
+		var b strings.Builder
+		b.WriteString(d.text)
+		// A fragment defined twice, which validation refuses, is taken once.
+		for j := range defs {
+			if f := &defs[j]; f.kind == "fragment" && used[f.name] &&
+				fragments[f.name] == f {
+				b.WriteString(f.text)
+			}
+		}
+		ops[i].Document = b.String()
+		i++
+	}
+	return ops, nil
+}
+
+// spreads adds every name of names to used, and the names the fragments of
+// fragments by those spread in turn. A fragment is followed once, so a cycle,
+// which validation refuses and parsing doesn't, ends.
+func spreads(fragments map[string]*definition, names []string, used map[string]bool) {
+	for _, name := range names {
+		if used[name] {
+			continue
+		}
+		used[name] = true
+		if f := fragments[name]; f != nil {
+			spreads(fragments, f.spreads, used)
+		}
+	}
+}
+
+// definition is an ExecutableDefinition of a document.
+type definition struct {
+	// kind is query, mutation, subscription or fragment, and name its name,
+	// empty for an anonymous operation.
+	kind, name string
+
+	// offset is where its keyword is, see [Operation.Offset], and text the
+	// document from its description, where it has one, to where the next
+	// definition begins.
+	offset int
+	text   string
+
+	// spreads are the names of the fragments it spreads, in its order.
+	spreads []string
+}
+
+// definitions reads the definitions of document, which the parser took: a
+// definition begins at a keyword or a selection set outside any other, and a
+// description before it, and what it spreads is the name following a `...`
+// that isn't `on`. Strings and comments are skipped whole, so nothing in them
+// is read as either.
+func definitions(document string) []definition {
+	var (
+		defs []definition
+
+		// depth counts the braces, parentheses and brackets open, which only
+		// a definition's own are at none of.
+		depth int
+
+		// described is where the description of the definition to come
+		// begins, -1 where it has none.
+		described = -1
+
+		// open is a definition whose selection set isn't read yet, and named
+		// one whose name may come next.
+		open, named bool
+
+		// spread is a `...` whose fragment name may come next.
+		spread bool
+	)
+	begin := func(kind string, offset int) {
+		start := offset
+		if described >= 0 {
+			start = described
+		}
+		if len(defs) > 0 {
+			last := &defs[len(defs)-1]
+			last.text = document[len(document)-len(last.text) : start]
+		}
+		defs = append(defs, definition{kind: kind, offset: offset, text: document[start:]})
+		described = -1
+	}
+
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case c == '#':
+			for i < len(document) && document[i] != '\n' && document[i] != '\r' {
+				i++
+			}
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' ||
+			c == 0xEF && strings.HasPrefix(document[i:], bom):
+			// Ignored tokens, see https://spec.graphql.org/September2025/#Ignored.
+			if c == 0xEF {
+				i += len(bom)
+			} else {
+				i++
+			}
+			continue
+		case c == '"':
+			if depth == 0 && described < 0 {
+				described = i
+			}
+			i = stringEnd(document, i)
+		case isNameStart(c):
+			end := i + 1
+			for end < len(document) && (isNameStart(document[end]) ||
+				'0' <= document[end] && document[end] <= '9') {
+				end++
+			}
+			name := document[i:end]
+			switch {
+			case spread && name != "on":
+				defs[len(defs)-1].spreads = append(defs[len(defs)-1].spreads, name)
+			case depth > 0:
+			case named:
+				defs[len(defs)-1].name = name
+			case !open:
+				begin(name, i)
+				open = true
+				spread, named = false, true
+				i = end
+				continue
+			}
+			i = end
+		case c == '.' && strings.HasPrefix(document[i:], "..."):
+			i += len("...")
+			spread, named = true, false
+			continue
+		case c == '{' && depth == 0:
+			if !open {
+				begin("query", i)
+			}
+			open = false
+			depth++
+			i++
+		case c == '{' || c == '(' || c == '[':
+			depth++
+			i++
+		case c == '}' || c == ')' || c == ']':
+			depth--
+			i++
+		default:
+			i++
+		}
+		spread, named = false, false
+	}
+	return defs
+}
+
+// stringEnd is the index right after the string value or the description
+// that begins at document[i], a block string or not.
+func stringEnd(document string, i int) int {
+	if strings.HasPrefix(document[i:], `"""`) {
+		for j := i + 3; j < len(document); j++ {
+			switch {
+			case strings.HasPrefix(document[j:], `\"""`):
+				j += 3
+			case strings.HasPrefix(document[j:], `"""`):
+				return j + 3
+			}
+		}
+		return len(document)
+	}
+	for j := i + 1; j < len(document); j++ {
+		switch document[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	return len(document)
+}
+
+// bom is the UnicodeBOM, an ignored token.
+const bom = "\uFEFF"
+
+func isNameStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
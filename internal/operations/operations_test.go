@@ -0,0 +1,78 @@
+package operations_test
+
+import (
+	"testing"
+
+	"github.com/romshark/gqlhash/v2/internal/operations"
+)
+
+func TestSplit(t *testing.T) {
+	// One operation is the whole document, comments and unused fragments too.
+	const one = "# header\nquery A { a ...F }\nfragment F on Q { f }\nfragment G on Q { g }\n"
+	ops, err := operations.Split(one)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].Name != "A" || ops[0].Type != "query" ||
+		ops[0].Offset != len("# header\n") || ops[0].Document != one {
+		t.Errorf("unexpected operations: %+v", ops)
+	}
+
+	// Several are each the operation and what it spreads, directly or through a
+	// fragment, in the order of the document, whatever order they're spread in.
+	const several = "fragment H on Q { h }\n" +
+		"query A { a ...G }\n" +
+		"fragment G on Q { g ...H }\n" +
+		"mutation { m }\n" +
+		"fragment Cycle on Q { ...Cycle }\n" +
+		"subscription S { ...Cycle }\n"
+	ops, err = operations.Split(several)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []operations.Operation{
+		{Name: "A", Type: "query", Offset: 22, Document: "query A { a ...G }\n" +
+			"fragment H on Q { h }\n" + "fragment G on Q { g ...H }\n"},
+		{Type: "mutation", Offset: 68, Document: "mutation { m }\n"},
+		{Name: "S", Type: "subscription", Offset: 116, Document: "subscription S { ...Cycle }\n" +
+			"fragment Cycle on Q { ...Cycle }\n"},
+	}
+	if len(ops) != len(expect) {
+		t.Fatalf("expected %d operations; received %+v", len(expect), ops)
+	}
+	for i := range expect {
+		if ops[i] != expect[i] {
+			t.Errorf("operation %d: expected %+v; received %+v", i, expect[i], ops[i])
+		}
+	}
+
+	// Offsets are in bytes.
+	ops, err = operations.Split("# ümlaut\nquery A { a }\nquery B { b }")
+	if err != nil || len(ops) != 2 || ops[0].Offset != len("# ümlaut\n") ||
+		ops[1].Document != "query B { b }" {
+		t.Errorf("unexpected operations: %+v, %v", ops, err)
+	}
+
+	// A description goes with the operation it describes, and what looks like
+	// a definition or a spread in a string or a comment is neither.
+	const described = "\"desc\"\nquery A { a }\n" +
+		"\"\"\"d2 { ...F }\"\"\"\nquery B($s: String = \"} ...G\") { b(s: $s) }\n" +
+		"# query C { ...H }\n"
+	ops, err = operations.Split(described)
+	expect = []operations.Operation{
+		{Name: "A", Type: "query", Offset: 7, Document: "\"desc\"\nquery A { a }\n"},
+		{Name: "B", Type: "query", Offset: 39, Document: "\"\"\"d2 { ...F }\"\"\"\n" +
+			"query B($s: String = \"} ...G\") { b(s: $s) }\n# query C { ...H }\n"},
+	}
+	if err != nil || len(ops) != len(expect) || ops[0] != expect[0] || ops[1] != expect[1] {
+		t.Errorf("expected %+v; received %+v, %v", expect, ops, err)
+	}
+
+	// gqlhash's parser reads the document, so what the hash refuses is refused.
+	if _, err := operations.Split("query {"); err == nil {
+		t.Error("expected a syntax error")
+	}
+	if _, err := operations.Split("\"desc\" { a }"); err == nil {
+		t.Error("expected a described query shorthand refused")
+	}
+}
@@ -0,0 +1,193 @@
+// Package schema checks documents against a GraphQL schema with the rules of
+// gqlparser. The proxy's allowlist and the hashing command's -schema both check
+// through here, so a document one refuses is a document the other refuses.
+package schema
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	gqlparser "github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/validator/rules"
+)
+
+// Ext names a file holding a schema, or a part of one.
+const Ext = ".graphqls"
+
+// Load reads the files of paths as one schema, which lets a schema be split
+// over several: one may name a type another defines. A directory stands for
+// every Ext file under it, in lexical order, dot names skipped.
+// Load returns nil where paths hold no file, and an error where they hold no
+// valid schema.
+func Load(paths ...string) (*ast.Schema, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		found, err := scan(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		files = append(files, found...)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	sources := make([]*ast.Source, 0, len(files))
+	for _, name := range files {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sources = append(sources, &ast.Source{Name: name, Input: string(src)})
+	}
+	return LoadSources(sources...)
+}
+
+// LoadSources is [Load] of files already read, each named by its Name.
+// It returns nil where there are none.
+func LoadSources(sources ...*ast.Source) (*ast.Schema, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	schema, err := gqlparser.LoadSchema(sources...)
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// scan returns the Ext files under dir, sorted.
+func scan(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), Ext) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	slices.Sort(files)
+	return files, err
+}
+
+// Error is what a schema makes of a document, at the 1-based line and column of
+// the document, both 0 where the rule names no place. Rule is the gqlparser rule
+// that objected, empty for a warning of [Deprecated].
+type Error struct {
+	Rule    string
+	Message string
+	Line    int
+	Column  int
+}
+
+// Validate checks src against schema and returns what it objects to, nothing
+// where it takes src. doc is src as gqlparser reads it, with every field bound
+// to its definition, for [Deprecated]; nil where src didn't validate.
+func Validate(schema *ast.Schema, src string) (doc *ast.QueryDocument, errs []Error) {
+	doc, list := gqlparser.LoadQueryWithRules(schema, src, rules.NewDefaultRules())
+	if len(list) == 0 {
+		return doc, nil
+	}
+	errs = make([]Error, len(list))
+	for i, e := range list {
+		errs[i] = Error{Rule: e.Rule, Message: e.Message}
+		if len(e.Locations) > 0 {
+			errs[i].Line, errs[i].Column = e.Locations[0].Line, e.Locations[0].Column
+		}
+	}
+	return nil, errs
+}
+
+// Deprecated returns an error for every field of doc whose definition is marked
+// @deprecated, at the field, with the reason the schema gives. A document
+// using one is valid still, so these are warnings: the field is on its way out,
+// and the client asking for it should hear of that before the schema drops it.
+//
+// A fragment is walked where it's defined rather than where it's spread,
+// so a field it selects is reported once however often it's spread.
+func Deprecated(doc *ast.QueryDocument) []Error {
+	var found []Error
+	var walk func(set ast.SelectionSet)
+	walk = func(set ast.SelectionSet) {
+		for _, s := range set {
+			switch s := s.(type) {
+			case *ast.Field:
+				if s.Definition != nil {
+					if d := s.Definition.Directives.ForName("deprecated"); d != nil {
+						found = append(found, deprecation(s, d))
+					}
+				}
+				walk(s.SelectionSet)
+			case *ast.InlineFragment:
+				walk(s.SelectionSet)
+			}
+		}
+	}
+	for _, o := range doc.Operations {
+		walk(o.SelectionSet)
+	}
+	for _, f := range doc.Fragments {
+		walk(f.SelectionSet)
+	}
+	return found
+}
+
+// deprecation is the warning of [Deprecated] for a field.
+func deprecation(f *ast.Field, d *ast.Directive) Error {
+	message := fmt.Sprintf("field %q of type %q is deprecated",
+		f.Name, f.ObjectDefinition.Name)
+	if reason := d.Arguments.ForName("reason"); reason != nil && reason.Value != nil {
+		message += ": " + reason.Value.Raw
+	}
+	e := Error{Message: message}
+	if f.Position != nil {
+		e.Line, e.Column = f.Position.Line, f.Position.Column
+	}
+	return e
+}
+
+// OffsetOf is the offset of the 1-based line and column in src, counting lines
+// and characters the way [gqlhash.Position] does, so the two undo each other.
+// An [Error] has a line and a column, and a document embedded in a source file
+// maps an offset.
+func OffsetOf(src []byte, line, column int) int {
+	i := 0
+	for l := 1; l < line && i < len(src); i++ {
+		switch src[i] {
+		case '\n':
+			l++
+		case '\r':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				i++
+			}
+			l++
+		}
+	}
+	for c := 1; c < column && i < len(src); c++ {
+		_, size := utf8.DecodeRune(src[i:])
+		i += size
+	}
+	return i
+}
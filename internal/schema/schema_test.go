@@ -0,0 +1,120 @@
+package schema_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2"
+	"github.com/romshark/gqlhash/v2/internal/schema"
+)
+
+const testSchema = `
+type Query {
+	user: User
+	viewer: User @deprecated(reason: "Use user.")
+}
+type User {
+	id: ID!
+	name: String @deprecated
+}
+`
+
+func load(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestLoadDirectory covers a directory given for a schema: every .graphqls file
+// under it is read as one, however deep, and what is no schema file is left alone.
+func TestLoadDirectory(t *testing.T) {
+	dir := load(t, map[string]string{
+		"query.graphqls":      "type Query { user: User }",
+		"types/user.graphqls": "type User { id: ID! }",
+		"notes.md":            "type Broken {",
+		".hidden.graphqls":    "type Broken {",
+	})
+	s, err := schema.Load(dir)
+	if err != nil || s == nil {
+		t.Fatalf("expected a schema; received %v, %v", s, err)
+	}
+	if s.Types["User"] == nil {
+		t.Error("expected User from the nested file")
+	}
+
+	if s, err := schema.Load(t.TempDir()); s != nil || err != nil {
+		t.Errorf("expected no schema and no error; received %v, %v", s, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := load(t, map[string]string{"schema.graphqls": testSchema})
+	s, err := schema.Load(filepath.Join(dir, "schema.graphqls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, errs := schema.Validate(s, "{ user { id } }"); len(errs) != 0 {
+		t.Errorf("expected the document to validate; received %+v", errs)
+	}
+	_, errs := schema.Validate(s, "{\n  user { email }\n}")
+	if len(errs) != 1 || errs[0].Line != 2 || errs[0].Column != 10 ||
+		!strings.Contains(errs[0].Message, "email") {
+		t.Errorf("expected email refused at 2:10; received %+v", errs)
+	}
+}
+
+// TestDeprecated covers the warnings of a valid document: every deprecated field
+// it selects, with the reason where the schema gives one, and a field of a
+// fragment once however often the fragment is spread.
+func TestDeprecated(t *testing.T) {
+	dir := load(t, map[string]string{"schema.graphqls": testSchema})
+	s, err := schema.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, errs := schema.Validate(s,
+		"query Q { viewer { ...F } user { ...F id } }\nfragment F on User { name }")
+	if len(errs) != 0 {
+		t.Fatalf("expected the document to validate; received %+v", errs)
+	}
+
+	found := schema.Deprecated(doc)
+	if len(found) != 2 {
+		t.Fatalf("expected viewer and name; received %+v", found)
+	}
+	if found[0].Line != 1 || found[0].Column != 11 ||
+		!strings.HasSuffix(found[0].Message, ": Use user.") {
+		t.Errorf("expected viewer at 1:11 with its reason; received %+v", found[0])
+	}
+	if found[1].Line != 2 || found[1].Column != 22 ||
+		!strings.Contains(found[1].Message, `"name"`) {
+		t.Errorf("expected name at 2:22; received %+v", found[1])
+	}
+}
+
+// TestOffsetOf covers OffsetOf undoing gqlhash.Position, line terminators and
+// characters wider than a byte included.
+func TestOffsetOf(t *testing.T) {
+	const src = "a\r\nbé\rc\nxyz"
+	for offset := range len(src) {
+		line, column := gqlhash.Position(src, offset)
+		got := schema.OffsetOf([]byte(src), line, column)
+		// A position inside a character or a CRLF is its start.
+		if l, c := gqlhash.Position(src, got); l != line || c != column {
+			t.Errorf("offset %d is %d:%d, which maps to %d, which is %d:%d",
+				offset, line, column, got, l, c)
+		}
+	}
+}
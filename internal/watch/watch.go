@@ -0,0 +1,90 @@
+// Package watch tells a change of a set of files, for a command that acts on
+// a directory again whenever it changes.
+//
+// The files are polled rather than subscribed to: inotify misses a write of
+// the host to a bind mount of a container, which is where a watch is commonly
+// run, and reports a ConfigMap update as events on a hidden directory. A poll
+// of a directory of documents is cheap.
+package watch
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+// State is what a poll tells a changed file by. A write changes the time or
+// the size, the size where the file system keeps coarse times, and a swapped
+// symlink the file it resolves to: a ConfigMap mount links every file through
+// ..data, which an update points elsewhere.
+type State struct {
+	modTime int64
+	size    int64
+	target  string
+}
+
+// StateOf is the state of the file name, info being what [os.Stat] returns
+// of it.
+func StateOf(name string, info os.FileInfo) State {
+	target, _ := filepath.EvalSymlinks(name)
+	return State{modTime: info.ModTime().UnixNano(), size: info.Size(), target: target}
+}
+
+// Files is a watch of the files list lists, see [Files.Poll].
+type Files struct {
+	list func() ([]string, error)
+
+	// loaded is the state the files were last acted on in, and pending the
+	// one a poll found them changed to, set where changed is.
+	loaded, pending map[string]State
+	changed         bool
+}
+
+// New returns the watch of the files list lists, their state taken now.
+// Taken before the files are read, a change during the read is one the first
+// poll finds rather than one missed.
+func New(list func() ([]string, error)) *Files {
+	f := &Files{list: list}
+	f.loaded = f.state()
+	return f
+}
+
+// Poll reports whether the files are to be acted on again: they changed, and
+// have held still since the poll before, so a burst of writes is one change,
+// of the files as the burst left them. A file added or removed is a change,
+// and so is a list that fails or no longer does, which the caller reading
+// the files reports, once.
+func (f *Files) Poll() bool {
+	s := f.state()
+	switch {
+	case same(s, f.loaded):
+		f.pending, f.changed = nil, false
+		return false
+	case !f.changed || !same(s, f.pending):
+		f.pending, f.changed = s, true
+		return false
+	}
+	f.loaded, f.pending, f.changed = s, nil, false
+	return true
+}
+
+// state is the state of every file f lists, nil where the list fails.
+func (f *Files) state() map[string]State {
+	files, err := f.list()
+	if err != nil {
+		return nil
+	}
+	states := make(map[string]State, len(files))
+	for _, file := range files {
+		// A file removed since it was listed is left out, which the next poll
+		// finds it to be.
+		if info, err := os.Stat(file); err == nil {
+			states[file] = StateOf(file, info)
+		}
+	}
+	return states
+}
+
+func same(a, b map[string]State) bool {
+	return maps.Equal(a, b) && (a == nil) == (b == nil)
+}
@@ -0,0 +1,68 @@
+package watch_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/romshark/gqlhash/v2/internal/watch"
+)
+
+// TestPollSettles covers the debounce: a change is reported once a poll finds
+// the files as the one before it did, and not while they still change.
+func TestPollSettles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a")
+	f := watch.New(func() ([]string, error) {
+		return filepath.Glob(filepath.Join(dir, "*"))
+	})
+
+	if f.Poll() {
+		t.Fatal("expected no change")
+	}
+	write("b")
+	if f.Poll() {
+		t.Fatal("expected no change reported at the poll finding it")
+	}
+	write("c")
+	if f.Poll() {
+		t.Fatal("expected no change reported while the files still change")
+	}
+	if !f.Poll() {
+		t.Fatal("expected the change once the files held still")
+	}
+	if f.Poll() {
+		t.Fatal("expected the same change reported once")
+	}
+
+	// A change undone before it settled is none.
+	write("d")
+	_ = f.Poll()
+	if err := os.Remove(filepath.Join(dir, "d")); err != nil {
+		t.Fatal(err)
+	}
+	if f.Poll() || f.Poll() {
+		t.Error("expected a change undone to be none")
+	}
+}
+
+// TestPollListFails covers a list that fails: it's a change, and so is one
+// that no longer does.
+func TestPollListFails(t *testing.T) {
+	var err error
+	f := watch.New(func() ([]string, error) { return nil, err })
+	err = os.ErrNotExist
+	if f.Poll() || !f.Poll() {
+		t.Fatal("expected a list that fails to be a change")
+	}
+	err = nil
+	if f.Poll() || !f.Poll() {
+		t.Error("expected a list that no longer fails to be a change")
+	}
+}
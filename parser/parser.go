@@ -206,6 +206,20 @@ const (
 	IgnoreVariables
 )
 
+// String is the name of i as the -ignore flag of the commands takes it:
+// nothing, inputs or variables, and Ignore(n) for a value that is none.
+func (i Ignore) String() string {
+	switch i {
+	case IgnoreNothing:
+		return "nothing"
+	case IgnoreInputs:
+		return "inputs"
+	case IgnoreVariables:
+		return "variables"
+	}
+	return fmt.Sprintf("Ignore(%d)", uint8(i))
+}
+
 type Options struct {
 	// Ignore is how much of the input to leave out. The zero value is [IgnoreNothing].
 	Ignore Ignore
@@ -1501,6 +1501,19 @@ func TestIgnoreDocExamples(t *testing.T) {
 	differ(t, parser.IgnoreVariables, `{ f(x: 1) }`, `{ f }`)
 }
 
+func TestIgnoreString(t *testing.T) {
+	for ignore, expect := range map[parser.Ignore]string{
+		parser.IgnoreNothing:   "nothing",
+		parser.IgnoreInputs:    "inputs",
+		parser.IgnoreVariables: "variables",
+		parser.Ignore(9):       "Ignore(9)",
+	} {
+		if got := ignore.String(); got != expect {
+			t.Errorf("expected %q; received %q", expect, got)
+		}
+	}
+}
+
 // TestResultSemantics pins what [parser.Result] is: a value saying whether
 // parsing failed and where, and deliberately not an error.
 //